@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+func GetAuditLogs(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetAuditLogsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetAuditLogsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	logs, err := ctx.DB.GetAuditLogs(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting audit logs")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, logs, nil, "")
+}
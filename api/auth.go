@@ -2,18 +2,25 @@ package api
 
 import (
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"time"
 
 	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
 	"bitbucket.org/parqueoasis/backend/helpers"
 	"bitbucket.org/parqueoasis/backend/middlewares"
 	"bitbucket.org/parqueoasis/backend/models"
-	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
 	"github.com/thedevsaddam/govalidator"
 )
 
 func Login(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
 	w.GetRequestLanguage(r)
+	w.StartLogger("Login")
 
 	var opts models.LoginOpts
 	validatorOpts := govalidator.Options{
@@ -28,6 +35,18 @@ func Login(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Reques
 		return
 	}
 
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:     opts.Email,
+		IP:        helpers.GetRequestIP(ctx, r),
+		UserAgent: r.UserAgent(),
+	}
+
+	summary, ok := checkLoginThrottle(ctx, w, &attempt, now)
+	if !ok {
+		return
+	}
+
 	user, err := ctx.DB.GetUserLoginByEmail(opts.Email)
 	if err != nil {
 		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
@@ -35,23 +54,242 @@ func Login(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Reques
 	}
 
 	if user == nil {
+		insertLoginAttempt(ctx, w, &attempt, db.ConstLoginAttemptReasons.UserNotFound)
 		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
 		return
 	}
 
+	attempt.User = user
+
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		insertLoginAttempt(ctx, w, &attempt, db.ConstLoginAttemptReasons.Locked)
+		w.Write(http.StatusLocked, nil, nil, middlewares.Responses.AccountLocked)
+		return
+	}
+
 	if !helpers.AuthenticateHashedPassword(user.Password, opts.Password) {
+		insertLoginAttempt(ctx, w, &attempt, db.ConstLoginAttemptReasons.InvalidPassword)
+		if summary.EmailFailures+1 >= ctx.Config.Login.MaxAttemptsEmail {
+			lockUser(ctx, w, user, now)
+		}
 		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
 		return
 	}
 
-	user.Token, err = helpers.GenerateToken(user, ctx.Config.JWTSecret)
+	if user.TwoFactorEnabled || twoFactorRequired(ctx, user.HasRole(db.ConstRoles.Admin), user.HasRole(db.ConstRoles.Cashier)) {
+		insertLoginAttempt(ctx, w, &attempt, db.ConstLoginAttemptReasons.TwoFactorRequired)
+
+		token, err := helpers.GenerateRandomToken()
+		if err != nil {
+			w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+			return
+		}
+
+		expires := now.Add(time.Duration(ctx.Config.TwoFactor.ChallengeMinutes) * time.Minute)
+		if err := ctx.DB.UpdateUserTwoFactorChallenge(user.ID, helpers.HashToken(token), expires); err != nil {
+			w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+			return
+		}
+
+		w.WriteJSON(http.StatusOK, models.TwoFactorChallenge{
+			TwoFactorRequired:  true,
+			EnrollmentRequired: !user.TwoFactorEnabled,
+			Token:              token,
+			Expires:            expires,
+		}, nil, "")
+		return
+	}
+
+	completeLogin(ctx, w, &attempt, user)
+}
+
+// checkLoginThrottle rejects the request when the IP or the email have failed
+// too many times recently. It returns the failures summary so the caller can
+// lock the account on the next failure.
+func checkLoginThrottle(ctx *config.AppContext, w *middlewares.ResponseWriter, attempt *models.LoginAttempt, now time.Time) (*models.LoginAttemptsSummary, bool) {
+	window := time.Duration(ctx.Config.Login.WindowMinutes) * time.Minute
+	summary, err := ctx.DB.GetLoginAttemptsSummary(attempt.Email, attempt.IP, now.Add(-window))
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return nil, false
+	}
+
+	if summary.IPFailures >= ctx.Config.Login.MaxAttemptsIP {
+		insertLoginAttempt(ctx, w, attempt, db.ConstLoginAttemptReasons.Throttled)
+		w.Writer.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
+		w.Write(http.StatusTooManyRequests, nil, nil, middlewares.Responses.TooManyLoginAttempts)
+		return nil, false
+	}
+
+	if summary.LastFailure != nil {
+		retryAt := summary.LastFailure.Add(loginDelay(ctx, summary.EmailFailures))
+		if now.Before(retryAt) {
+			insertLoginAttempt(ctx, w, attempt, db.ConstLoginAttemptReasons.Throttled)
+			w.Writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAt.Sub(now).Seconds()))))
+			w.Write(http.StatusTooManyRequests, nil, nil, middlewares.Responses.TooManyLoginAttempts)
+			return nil, false
+		}
+	}
+
+	return summary, true
+}
+
+func completeLogin(ctx *config.AppContext, w *middlewares.ResponseWriter, attempt *models.LoginAttempt, user *models.User) {
+	insertLoginAttempt(ctx, w, attempt, db.ConstLoginAttemptReasons.Success)
+
+	if err := ctx.DB.ClearUserRememberToken(user.ID); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	token, err := helpers.GenerateToken(user, ctx.Config.JWTSecret)
 	if err != nil {
 		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
 		return
 	}
+	user.Token = token
 
 	w.WriteJSON(http.StatusOK, user, nil, "")
-	return
+}
+
+// loginDelay returns how long an email has to wait after its last failed
+// attempt, doubling with every failure up to the configured maximum.
+func loginDelay(ctx *config.AppContext, failures int) time.Duration {
+	if failures == 0 {
+		return 0
+	}
+	base := time.Duration(ctx.Config.Login.DelayBaseSeconds) * time.Second
+	max := time.Duration(ctx.Config.Login.DelayMaxSeconds) * time.Second
+	delay := base << uint(failures-1)
+	if delay > max || delay <= 0 {
+		return max
+	}
+	return delay
+}
+
+func insertLoginAttempt(ctx *config.AppContext, w *middlewares.ResponseWriter, attempt *models.LoginAttempt, reason string) {
+	attempt.Reason = reason
+	attempt.Success = reason == db.ConstLoginAttemptReasons.Success
+	if err := ctx.DB.InsertLoginAttempt(attempt); err != nil {
+		w.LogError(err, "failed inserting login attempt")
+	}
+}
+
+func lockUser(ctx *config.AppContext, w *middlewares.ResponseWriter, user *models.User, now time.Time) {
+	token, err := helpers.GenerateRandomToken()
+	if err != nil {
+		w.LogError(err, "failed generating unlock token")
+		return
+	}
+
+	lockedUntil := now.Add(time.Duration(ctx.Config.Login.LockoutMinutes) * time.Minute)
+	if err := ctx.DB.LockUser(user.ID, lockedUntil, helpers.HashToken(token)); err != nil {
+		w.LogError(err, "failed locking user")
+		return
+	}
+
+	go func(ctx *config.AppContext, user *models.User, token string) {
+		timeLocation, err := time.LoadLocation("America/Santiago")
+		if err != nil {
+			w.LogError(err, "failed loading time location")
+			return
+		}
+
+		ed := &helpers.EmailData{
+			EmailTo:      user.Email,
+			NameTo:       user.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.AccountLocked.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.AccountLocked.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err = ed.SendEmail(models.AccountLockedHTML{
+			Firstname:   user.Firstname,
+			Lastname:    user.Lastname,
+			LockedUntil: lockedUntil.In(timeLocation).Format("02-01-2006 15:04"),
+			URL:         fmt.Sprintf("%s%s/%s", ctx.Config.BackofficeBaseURL, ctx.Config.BackofficeUnlockPath, token),
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, user, token)
+}
+
+func UnlockUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+	w.StartLogger("UnlockUser")
+
+	var opts models.UnlockUserOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UnlockUserRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	user, err := ctx.DB.UnlockUserByToken(helpers.HashToken(opts.Token))
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidUnlockToken)
+		return
+	}
+
+	insertLoginAttempt(ctx, w, &models.LoginAttempt{
+		Email:     user.Email,
+		IP:        helpers.GetRequestIP(ctx, r),
+		UserAgent: r.UserAgent(),
+		User:      user,
+	}, db.ConstLoginAttemptReasons.Unlocked)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+func GetLoginAttempts(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.Write(http.StatusForbidden, nil, nil, middlewares.Responses.InvalidRoles)
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetLoginAttemptsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	var opts models.GetLoginAttemptsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	attempts, err := ctx.DB.GetLoginAttempts(&opts)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, attempts, nil, "")
 }
 
 func UpdateUserPassword(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
@@ -70,14 +308,14 @@ func UpdateUserPassword(ctx *config.AppContext, w *middlewares.ResponseWriter, r
 		return
 	}
 
-	user, err := ctx.DB.GetUserByRememberToken(opts.Token)
+	user, err := ctx.DB.GetUserByRememberToken(helpers.HashToken(opts.Token))
 	if err != nil {
 		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
 		return
 	}
 
 	if user == nil {
-		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.UserNotFound)
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidRememberToken)
 		return
 	}
 
@@ -98,8 +336,11 @@ func UpdateUserPassword(ctx *config.AppContext, w *middlewares.ResponseWriter, r
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 }
 
+// SendRememberToken always answers with the same response so it can't be used
+// to find out which emails are registered.
 func SendRememberToken(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
 	w.GetRequestLanguage(r)
+	w.StartLogger("SendRememberToken")
 
 	var opts models.SendRememberTokenOpts
 	validatorOpts := govalidator.Options{
@@ -114,6 +355,31 @@ func SendRememberToken(ctx *config.AppContext, w *middlewares.ResponseWriter, r
 		return
 	}
 
+	ip := helpers.GetRequestIP(ctx, r)
+	window := time.Duration(ctx.Config.PasswordReset.WindowMinutes) * time.Minute
+	emailCounter, ipCounter, err := ctx.DB.CountPasswordResetRequests(opts.Email, ip, time.Now().Add(-window))
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if ipCounter >= ctx.Config.PasswordReset.MaxRequestsIP {
+		w.Writer.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
+		w.Write(http.StatusTooManyRequests, nil, nil, middlewares.Responses.TooManyRequests)
+		return
+	}
+
+	if err := ctx.DB.InsertPasswordResetRequest(opts.Email, ip); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if emailCounter >= ctx.Config.PasswordReset.MaxRequestsEmail {
+		w.LogInfo(opts.Email, "password reset requests limit reached")
+		w.WriteJSON(http.StatusNoContent, nil, nil, "")
+		return
+	}
+
 	user, err := ctx.DB.GetUserLoginByEmail(opts.Email)
 	if err != nil {
 		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
@@ -121,16 +387,17 @@ func SendRememberToken(ctx *config.AppContext, w *middlewares.ResponseWriter, r
 	}
 
 	if user == nil {
-		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.UserNotFound)
+		w.WriteJSON(http.StatusNoContent, nil, nil, "")
 		return
 	}
 
-	token, err := uuid.NewUUID()
+	token, err := helpers.GenerateRandomToken()
 	if err != nil {
 		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
 		return
 	}
-	err = ctx.DB.UpdateUserRememberToken(user.ID, token.String())
+	expires := time.Now().Add(time.Duration(ctx.Config.PasswordReset.TokenMinutes) * time.Minute)
+	err = ctx.DB.UpdateUserRememberToken(user.ID, helpers.HashToken(token), expires)
 	if err != nil {
 		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
 		return
@@ -157,8 +424,469 @@ func SendRememberToken(ctx *config.AppContext, w *middlewares.ResponseWriter, r
 			return
 		}
 		w.LogInfo(nil, "success sending email")
-	}(ctx, user, token.String())
+	}(ctx, user, token)
 
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 	return
 }
+
+// twoFactorRequired tells whether the configured policy forces two-factor
+// authentication on any of the given roles.
+func twoFactorRequired(ctx *config.AppContext, isAdmin bool, isCashier bool) bool {
+	return (isAdmin && ctx.Config.TwoFactor.RequiredAdmin) || (isCashier && ctx.Config.TwoFactor.RequiredCashier)
+}
+
+func newTwoFactorRecoveryCodes(ctx *config.AppContext) ([]string, []string, error) {
+	codes, err := helpers.GenerateRecoveryCodes(ctx.Config.TwoFactor.RecoveryCodes)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hashes = append(hashes, helpers.HashToken(code))
+	}
+
+	return codes, hashes, nil
+}
+
+// useTwoFactorCode validates an authenticator code and marks its time step as
+// used, so the same code is rejected if it's sent again.
+func useTwoFactorCode(ctx *config.AppContext, user *models.User, code string, now time.Time) (bool, error) {
+	step, ok := helpers.ValidateTOTP(user.TwoFactorSecret, code, now)
+	if !ok {
+		return false, nil
+	}
+
+	return ctx.DB.UseTwoFactorStep(user.ID, step)
+}
+
+// LoginTwoFactor is the second login step. It takes the token returned by
+// Login together with a code from the authenticator app or a recovery code,
+// and issues the JWT. When the user is enrolling, the first valid code also
+// enables two-factor authentication and the recovery codes are returned.
+func LoginTwoFactor(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+	w.StartLogger("LoginTwoFactor")
+
+	var opts models.LoginTwoFactorOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.LoginTwoFactorRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	if opts.Code == "" && opts.RecoveryCode == "" {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorCode)
+		return
+	}
+
+	user, err := ctx.DB.GetUserLoginByTwoFactorChallenge(helpers.HashToken(opts.Token))
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorToken)
+		return
+	}
+
+	now := time.Now()
+	attempt := models.LoginAttempt{
+		Email:     user.Email,
+		IP:        helpers.GetRequestIP(ctx, r),
+		UserAgent: r.UserAgent(),
+		User:      user,
+	}
+
+	summary, ok := checkLoginThrottle(ctx, w, &attempt, now)
+	if !ok {
+		return
+	}
+
+	if user.LockedUntil != nil && now.Before(*user.LockedUntil) {
+		insertLoginAttempt(ctx, w, &attempt, db.ConstLoginAttemptReasons.Locked)
+		w.Write(http.StatusLocked, nil, nil, middlewares.Responses.AccountLocked)
+		return
+	}
+
+	if !user.TwoFactorEnabled && user.TwoFactorSecret == "" {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.TwoFactorNotSetUp)
+		return
+	}
+
+	valid := false
+	if opts.Code != "" {
+		valid, err = useTwoFactorCode(ctx, user, opts.Code, now)
+		if err != nil {
+			w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+			return
+		}
+	}
+
+	if !valid && opts.RecoveryCode != "" && user.TwoFactorEnabled {
+		valid, err = ctx.DB.UseTwoFactorRecoveryCode(user.ID, helpers.HashToken(helpers.NormalizeRecoveryCode(opts.RecoveryCode)))
+		if err != nil {
+			w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+			return
+		}
+	}
+
+	if !valid {
+		insertLoginAttempt(ctx, w, &attempt, db.ConstLoginAttemptReasons.InvalidTwoFactor)
+		if summary.EmailFailures+1 >= ctx.Config.Login.MaxAttemptsEmail {
+			if err := ctx.DB.ClearUserTwoFactorChallenge(user.ID); err != nil {
+				w.LogError(err, "failed clearing two factor challenge")
+			}
+			lockUser(ctx, w, user, now)
+		}
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorCode)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		codes, hashes, err := newTwoFactorRecoveryCodes(ctx)
+		if err != nil {
+			w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+			return
+		}
+
+		if err := ctx.DB.EnableUserTwoFactor(user.ID, hashes); err != nil {
+			w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+			return
+		}
+
+		user.TwoFactorEnabled = true
+		user.TwoFactorRecoveryCodes = codes
+	}
+
+	if err := ctx.DB.ClearUserTwoFactorChallenge(user.ID); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	completeLogin(ctx, w, &attempt, user)
+}
+
+// SetupTwoFactorLogin generates the secret for users who must enroll before
+// they can finish logging in, authenticated with the token returned by Login.
+func SetupTwoFactorLogin(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	var opts models.SetupTwoFactorOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.SetupTwoFactorRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	user, err := ctx.DB.GetUserLoginByTwoFactorChallenge(helpers.HashToken(opts.Token))
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorToken)
+		return
+	}
+
+	setupTwoFactor(ctx, w, user)
+}
+
+func SetupTwoFactor(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	user, err := ctx.DB.GetUserTwoFactor(userInfo.ID)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
+		return
+	}
+
+	setupTwoFactor(ctx, w, user)
+}
+
+func setupTwoFactor(ctx *config.AppContext, w *middlewares.ResponseWriter, user *models.User) {
+	if user.TwoFactorEnabled {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.TwoFactorEnabled)
+		return
+	}
+
+	secret, err := helpers.GenerateTOTPSecret()
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if err := ctx.DB.UpdateUserTwoFactorSecret(user.ID, secret); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	otpURL := helpers.TOTPURL(ctx.Config.TwoFactor.Issuer, user.Email, secret)
+	qrCode, err := helpers.TOTPQRCode(otpURL)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, models.TwoFactorSetup{
+		Secret: secret,
+		URL:    otpURL,
+		QRCode: qrCode,
+	}, nil, "")
+}
+
+func EnableTwoFactor(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	var opts models.EnableTwoFactorOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.EnableTwoFactorRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	user, err := ctx.DB.GetUserTwoFactor(userInfo.ID)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
+		return
+	}
+
+	if user.TwoFactorEnabled {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.TwoFactorEnabled)
+		return
+	}
+
+	if user.TwoFactorSecret == "" {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.TwoFactorNotSetUp)
+		return
+	}
+
+	valid, err := useTwoFactorCode(ctx, user, opts.Code, time.Now())
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if !valid {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorCode)
+		return
+	}
+
+	codes, hashes, err := newTwoFactorRecoveryCodes(ctx)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if err := ctx.DB.EnableUserTwoFactor(user.ID, hashes); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, models.TwoFactorRecoveryCodes{Codes: codes}, nil, "")
+}
+
+func DisableTwoFactor(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if twoFactorRequired(ctx, userInfo.IsAdmin, userInfo.IsCashier) {
+		w.Write(http.StatusForbidden, nil, nil, middlewares.Responses.TwoFactorRequired)
+		return
+	}
+
+	var opts models.DisableTwoFactorOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.DisableTwoFactorRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	user, err := ctx.DB.GetUserTwoFactor(userInfo.ID)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.TwoFactorNotEnabled)
+		return
+	}
+
+	if !helpers.AuthenticateHashedPassword(user.Password, opts.Password) {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidPassword)
+		return
+	}
+
+	valid, err := useTwoFactorCode(ctx, user, opts.Code, time.Now())
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if !valid {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorCode)
+		return
+	}
+
+	if err := ctx.DB.DisableUserTwoFactor(user.ID); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+func UpdateTwoFactorRecoveryCodes(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	var opts models.EnableTwoFactorOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.EnableTwoFactorRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.Write(http.StatusBadRequest, errs, nil, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	user, err := ctx.DB.GetUserTwoFactor(userInfo.ID)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
+		return
+	}
+
+	if !user.TwoFactorEnabled {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.TwoFactorNotEnabled)
+		return
+	}
+
+	valid, err := useTwoFactorCode(ctx, user, opts.Code, time.Now())
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if !valid {
+		w.Write(http.StatusBadRequest, nil, nil, middlewares.Responses.InvalidTwoFactorCode)
+		return
+	}
+
+	codes, hashes, err := newTwoFactorRecoveryCodes(ctx)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if err := ctx.DB.UpdateTwoFactorRecoveryCodes(user.ID, hashes); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, models.TwoFactorRecoveryCodes{Codes: codes}, nil, "")
+}
+
+// ResetUserTwoFactor lets an admin turn off two-factor authentication for a
+// user who lost their device. If the policy requires it for the user's role,
+// they will be asked to enroll again on their next login.
+func ResetUserTwoFactor(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.GetRequestLanguage(r)
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.Write(http.StatusForbidden, nil, nil, middlewares.Responses.InvalidRoles)
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.Write(http.StatusBadRequest, nil, err, middlewares.Responses.FailedValidations)
+		return
+	}
+
+	user, err := ctx.DB.GetUserTwoFactor(userID)
+	if err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	if user == nil {
+		w.Write(http.StatusNotFound, nil, nil, middlewares.Responses.UserNotFound)
+		return
+	}
+
+	if err := ctx.DB.DisableUserTwoFactor(user.ID); err != nil {
+		w.Write(http.StatusInternalServerError, nil, err, middlewares.Responses.InternalServerError)
+		return
+	}
+
+	w.Audit(db.ConstAuditActions.UserTwoFactorReset, db.ConstAuditEntities.User, user.ID,
+		models.User{ID: user.ID, TwoFactorEnabled: user.TwoFactorEnabled},
+		models.User{ID: user.ID, TwoFactorEnabled: false})
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
@@ -0,0 +1,366 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// OpenCashierShift starts a shift for the cashier. Only one shift can be open
+// at a time.
+func OpenCashierShift(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	shift, err := ctx.DB.InsertCashierShift(userInfo.ID)
+	if err == db.ErrCashierShiftOpen {
+		w.WriteJSON(http.StatusConflict, nil, err, "cashier shift already open")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed opening cashier shift")
+		return
+	}
+
+	w.Audit(db.ConstAuditActions.CashierShiftOpen, db.ConstAuditEntities.CashierShift, shift.ID, nil, shift)
+
+	w.WriteJSON(http.StatusCreated, shift, nil, "")
+}
+
+// GetCurrentCashierShift returns the open shift of the cashier with what was
+// done so far.
+func GetCurrentCashierShift(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	shift, err := ctx.DB.GetOpenCashierShift(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting cashier shift")
+		return
+	}
+
+	if shift == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "cashier shift not found")
+		return
+	}
+
+	report, err := getCashierShiftReport(ctx, shift, time.Now().UTC())
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting cashier shift report")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, report, nil, "")
+}
+
+func GetCashierShifts(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetCashierShiftsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetCashierShiftsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin {
+		opts.UserID = userInfo.ID
+	}
+
+	shifts, err := ctx.DB.GetCashierShifts(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting cashier shifts")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, shifts, nil, "")
+}
+
+// GetCashierShift returns the shift report. Open shifts are reported up to
+// now.
+func GetCashierShift(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	shift, ok := getCashierShiftForUser(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	to := time.Now().UTC()
+	if shift.Closed != nil {
+		to = *shift.Closed
+	}
+
+	report, err := getCashierShiftReport(ctx, shift, to)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting cashier shift report")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, report, nil, "")
+}
+
+// CloseCashierShift closes the shift and stores its report as a PDF, to be
+// printed and signed at the register.
+func CloseCashierShift(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	shift, ok := getCashierShiftForUser(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	if shift.Closed != nil {
+		w.WriteJSON(http.StatusConflict, nil, db.ErrCashierShiftClosed, "cashier shift already closed")
+		return
+	}
+
+	before := *shift
+	closed := time.Now().UTC().Truncate(time.Second)
+	report, err := getCashierShiftReport(ctx, shift, closed)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting cashier shift report")
+		return
+	}
+
+	shift.Closed = &closed
+	shift.ClosedBy = &models.User{
+		ID:    userInfo.ID,
+		Email: userInfo.Email,
+	}
+
+	pdfBuffer, err := helpers.GenerateCashierShiftPDF(cashierShiftPDFData(report))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating cashier shift pdf")
+		return
+	}
+
+	shift.PDFURL, err = helpers.AddFileToS3(ctx, pdfBuffer, fmt.Sprintf("%s/%d/%d.pdf", ctx.Config.AwsS3.S3PathShift, shift.User.ID, shift.ID))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed uploading cashier shift pdf")
+		return
+	}
+
+	err = ctx.DB.CloseCashierShift(shift)
+	if err == db.ErrCashierShiftClosed {
+		w.WriteJSON(http.StatusConflict, nil, err, "cashier shift already closed")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed closing cashier shift")
+		return
+	}
+
+	w.Audit(db.ConstAuditActions.CashierShiftClose, db.ConstAuditEntities.CashierShift, shift.ID, before, shift)
+
+	w.WriteJSON(http.StatusOK, report, nil, "")
+}
+
+// getCashierShiftForUser loads the shift in the route, writing the response
+// when it's not found or the user is neither an admin nor its cashier.
+func getCashierShiftForUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request, userInfo models.InfoUser) (*models.CashierShift, bool) {
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return nil, false
+	}
+
+	vars := mux.Vars(r)
+	shiftID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing cashier shift id")
+		return nil, false
+	}
+
+	shift, err := ctx.DB.GetCashierShiftByID(shiftID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting cashier shift")
+		return nil, false
+	}
+
+	if shift == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "cashier shift not found")
+		return nil, false
+	}
+
+	if !userInfo.IsAdmin && shift.User.ID != userInfo.ID {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid user")
+		return nil, false
+	}
+
+	return shift, true
+}
+
+// getCashierShiftReport gathers what the cashier did from the opening of the
+// shift up to the given time and updates the shift totals.
+func getCashierShiftReport(ctx *config.AppContext, shift *models.CashierShift, to time.Time) (*models.CashierShiftReport, error) {
+	sales, err := ctx.DB.GetCashierShiftSales(shift.User.ID, shift.Opened, to)
+	if err != nil {
+		return nil, err
+	}
+
+	admissions, err := ctx.DB.GetCashierShiftAdmissions(shift.User.ID, shift.Opened, to)
+	if err != nil {
+		return nil, err
+	}
+
+	payments, err := ctx.DB.GetCashierShiftPayments(shift.User.ID, shift.Opened, to)
+	if err != nil {
+		return nil, err
+	}
+
+	refunds, err := ctx.DB.GetCashierShiftRefunds(shift.User.ID, shift.Opened, to)
+	if err != nil {
+		return nil, err
+	}
+
+	report := models.CashierShiftReport{
+		Shift:      shift,
+		Sales:      []models.CashierShiftSale{},
+		Voids:      []models.CashierShiftSale{},
+		Admissions: admissions,
+		Payments:   payments,
+		Refunds:    refunds,
+	}
+
+	shift.SalesTotal, shift.TicketsSold, shift.Admissions = 0, 0, 0
+	for _, sale := range sales {
+		if sale.PaymentStatus == nil || sale.PaymentStatus.ID != db.ConstPaymentStatuses.Approved.ID {
+			report.Voids = append(report.Voids, sale)
+			continue
+		}
+
+		report.Sales = append(report.Sales, sale)
+		if sale.SeasonPass != nil {
+			shift.SalesTotal += int64(sale.SeasonPass.Price)
+			continue
+		}
+
+		shift.SalesTotal += int64(sale.Order.Price)
+		shift.TicketsSold += int64(sale.Order.Tickets)
+	}
+	for _, admission := range admissions {
+		shift.Admissions += admission.Tickets
+	}
+	for _, refund := range refunds {
+		report.RefundsTotal += int64(refund.Payment.Amount)
+	}
+
+	return &report, nil
+}
+
+// cashierShiftPDFData formats the report for the PDF, with its times in the
+// park's time zone.
+func cashierShiftPDFData(report *models.CashierShiftReport) models.CashierShiftPDFHTML {
+	location := parkLocation()
+	shift := report.Shift
+
+	data := models.CashierShiftPDFHTML{
+		ID:           shift.ID,
+		Cashier:      fmt.Sprintf("%s %s", shift.User.Firstname, shift.User.Lastname),
+		Opened:       shift.Opened.In(location).Format("02-01-2006 15:04"),
+		SalesTotal:   shift.SalesTotal,
+		TicketsSold:  shift.TicketsSold,
+		Admissions:   shift.Admissions,
+		RefundsTotal: report.RefundsTotal,
+		Payments:     report.Payments,
+	}
+	if shift.Closed != nil {
+		data.Closed = shift.Closed.In(location).Format("02-01-2006 15:04")
+	}
+
+	for _, sale := range report.Sales {
+		data.Sales = append(data.Sales, cashierShiftPDFSale(sale, location))
+	}
+	for _, sale := range report.Voids {
+		data.Voids = append(data.Voids, cashierShiftPDFSale(sale, location))
+	}
+	for _, admission := range report.Admissions {
+		entry := models.CashierShiftPDFAdmission{
+			Time:      admission.Created.In(location).Format(db.ConstLayoutTime),
+			Type:      "Orden",
+			Reference: admission.Reference,
+			Event:     fmt.Sprintf("%s %s", admission.Event.Name, admission.Event.StartDateTime.Format(db.ConstLayoutTime)),
+			Tickets:   admission.Tickets,
+			Gate:      admission.Gate,
+		}
+		if admission.Type == db.ConstCashierShiftAdmissionTypes.Pass {
+			entry.Type = "Pase"
+		}
+		data.Entries = append(data.Entries, entry)
+	}
+	for _, refund := range report.Refunds {
+		data.Refunds = append(data.Refunds, models.CashierShiftPDFRefund{
+			Time:          refund.Payment.Updated.In(location).Format(db.ConstLayoutTime),
+			TransactionID: refund.Payment.Order.TransactionID,
+			PaymentMethod: refund.Payment.Method.Name,
+			Amount:        refund.Payment.Amount,
+		})
+	}
+
+	return data
+}
+
+func cashierShiftPDFSale(sale models.CashierShiftSale, location *time.Location) models.CashierShiftPDFSale {
+	var pdfSale models.CashierShiftPDFSale
+	if pass := sale.SeasonPass; pass != nil {
+		pdfSale = models.CashierShiftPDFSale{
+			Time:          pass.Created.In(location).Format(db.ConstLayoutTime),
+			TransactionID: pass.Code,
+			Client:        fmt.Sprintf("%s %s", pass.User.Firstname, pass.User.Lastname),
+			Event:         fmt.Sprintf("Pase %s desde %s", pass.Product.Name, pass.ValidFrom.Format("02-01-2006")),
+			Price:         pass.Price,
+		}
+	} else {
+		pdfSale = models.CashierShiftPDFSale{
+			Time:          sale.Order.Created.In(location).Format(db.ConstLayoutTime),
+			TransactionID: sale.Order.TransactionID,
+			Client:        fmt.Sprintf("%s %s", sale.Order.Client.Firstname, sale.Order.Client.Lastname),
+			Event:         fmt.Sprintf("%s %s", sale.Order.Event.Name, sale.Order.Event.StartDateTime.Format(db.ConstLayoutTime)),
+			Tickets:       sale.Order.Tickets,
+			Price:         sale.Order.Price,
+		}
+	}
+	if sale.PaymentMethod != nil {
+		pdfSale.PaymentMethod = sale.PaymentMethod.Name
+		pdfSale.PaymentStatus = sale.PaymentStatus.Name
+	}
+
+	return pdfSale
+}
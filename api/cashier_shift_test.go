@@ -0,0 +1,130 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+// shiftStorage returns the rows of the shift report queries as given.
+type shiftStorage struct {
+	db.Storage
+	sales      []models.CashierShiftSale
+	admissions []models.CashierShiftAdmission
+	payments   []models.CashierShiftPayments
+	refunds    []models.CashierShiftRefund
+}
+
+func (s *shiftStorage) GetCashierShiftSales(userID int, from time.Time, to time.Time) ([]models.CashierShiftSale, error) {
+	return s.sales, nil
+}
+
+func (s *shiftStorage) GetCashierShiftAdmissions(userID int, from time.Time, to time.Time) ([]models.CashierShiftAdmission, error) {
+	return s.admissions, nil
+}
+
+func (s *shiftStorage) GetCashierShiftPayments(userID int, from time.Time, to time.Time) ([]models.CashierShiftPayments, error) {
+	return s.payments, nil
+}
+
+func (s *shiftStorage) GetCashierShiftRefunds(userID int, from time.Time, to time.Time) ([]models.CashierShiftRefund, error) {
+	return s.refunds, nil
+}
+
+func TestCashierShiftReport(t *testing.T) {
+	created := time.Date(2026, 1, 10, 14, 0, 0, 0, time.UTC)
+	event := &models.Event{Name: "General", StartDateTime: time.Date(2026, 1, 10, 10, 0, 0, 0, time.UTC)}
+	client := &models.User{Firstname: "Ana", Lastname: "Rojas"}
+	cashier := db.ConstPaymentMethods.Cashier
+
+	storage := &shiftStorage{
+		sales: []models.CashierShiftSale{
+			{
+				Order:         &models.Order{ID: 1, TransactionID: "T1", Price: 12000, Tickets: 2, Created: created, Client: client, Event: event},
+				PaymentMethod: &cashier,
+				PaymentStatus: &db.ConstPaymentStatuses.Approved,
+			},
+			{
+				Order: &models.Order{ID: 2, TransactionID: "T2", Price: 8000, Tickets: 1, Created: created, Client: client, Event: event},
+			},
+			{
+				SeasonPass: &models.SeasonPass{
+					ID:        3,
+					Code:      "PP3",
+					Price:     90000,
+					Created:   created,
+					User:      client,
+					Product:   &models.PassProduct{Name: "Anual"},
+					ValidFrom: time.Date(2026, 1, 10, 0, 0, 0, 0, time.UTC),
+				},
+				PaymentMethod: &cashier,
+				PaymentStatus: &db.ConstPaymentStatuses.Approved,
+			},
+		},
+		admissions: []models.CashierShiftAdmission{
+			{Type: db.ConstCashierShiftAdmissionTypes.Order, Reference: "T1", Event: event, Tickets: 2, Created: created},
+			{Type: db.ConstCashierShiftAdmissionTypes.Pass, Reference: "PP3", Event: event, Tickets: 1, Created: created},
+		},
+		payments: []models.CashierShiftPayments{
+			{Method: &cashier, Payments: 2, Amount: 102000},
+		},
+		refunds: []models.CashierShiftRefund{
+			{Payment: &models.Payment{Amount: 5000, Method: &cashier, Order: &models.Order{TransactionID: "T0"}, Updated: created}},
+		},
+	}
+
+	ctx := &config.AppContext{DB: storage}
+	shift := &models.CashierShift{ID: 1, User: &models.User{ID: 7}, Opened: created.Add(-time.Hour)}
+
+	report, err := getCashierShiftReport(ctx, shift, created.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if shift.SalesTotal != 102000 || shift.TicketsSold != 2 || shift.Admissions != 3 {
+		t.Errorf("got sales total %d, %d tickets and %d admissions, want 102000, 2 and 3", shift.SalesTotal, shift.TicketsSold, shift.Admissions)
+	}
+
+	if len(report.Sales) != 2 || len(report.Voids) != 1 || report.Voids[0].Order.ID != 2 {
+		t.Errorf("got %d sales and voids %+v, want 2 sales and order 2 voided", len(report.Sales), report.Voids)
+	}
+
+	if report.RefundsTotal != 5000 {
+		t.Errorf("got refunds total %d, want 5000", report.RefundsTotal)
+	}
+
+	data := cashierShiftPDFData(report)
+
+	if len(data.Sales) != 2 || len(data.Voids) != 1 || len(data.Entries) != 2 || len(data.Refunds) != 1 || len(data.Payments) != 1 {
+		t.Fatalf("got %d sales, %d voids, %d entries, %d refunds and %d payments, want 2, 1, 2, 1 and 1", len(data.Sales), len(data.Voids), len(data.Entries), len(data.Refunds), len(data.Payments))
+	}
+
+	pass := data.Sales[1]
+	if pass.TransactionID != "PP3" || pass.Event != "Pase Anual desde 10-01-2026" || pass.Tickets != 0 || pass.Price != 90000 || pass.PaymentMethod != cashier.Name {
+		t.Errorf("got pass sale %+v, want the pass code, product and price", pass)
+	}
+
+	if data.Voids[0].PaymentMethod != "" || data.Voids[0].TransactionID != "T2" {
+		t.Errorf("got void %+v, want order T2 without payment", data.Voids[0])
+	}
+
+	if data.Entries[0].Type != "Orden" || data.Entries[1].Type != "Pase" {
+		t.Errorf("got entry types %q and %q, want Orden and Pase", data.Entries[0].Type, data.Entries[1].Type)
+	}
+
+	if data.Refunds[0].TransactionID != "T0" || data.Refunds[0].Amount != 5000 {
+		t.Errorf("got refund %+v, want order T0 for 5000", data.Refunds[0])
+	}
+}
+
+func TestCashierPaymentMethod(t *testing.T) {
+	if method := cashierPaymentMethod(false); method.ID != db.ConstPaymentMethods.Cashier.ID {
+		t.Errorf("got method %d for a cashier, want %d", method.ID, db.ConstPaymentMethods.Cashier.ID)
+	}
+	if method := cashierPaymentMethod(true); method.ID != db.ConstPaymentMethods.Reseller.ID {
+		t.Errorf("got method %d for a reseller, want %d", method.ID, db.ConstPaymentMethods.Reseller.ID)
+	}
+}
@@ -84,6 +84,8 @@ func InsertEvents(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http
 		return
 	}
 
+	w.Audit(db.ConstAuditActions.EventInsert, db.ConstAuditEntities.Event, 0, nil, opts)
+
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 }
 
@@ -155,3 +157,69 @@ func GetEventTypes(ctx *config.AppContext, w *middlewares.ResponseWriter, r *htt
 
 	w.WriteJSON(http.StatusOK, eventTypes, nil, "")
 }
+
+// UpdateEventType sets the tax rate of the event type, in basis points. It
+// only applies to the orders placed from now on.
+func UpdateEventType(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventTypeID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing event type id")
+		return
+	}
+
+	var opts models.UpdateEventTypeOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateEventTypeRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	if opts.TaxRate == nil {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "tax rate is required")
+		return
+	}
+
+	eventTypes, err := ctx.DB.GetEventTypes()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event types")
+		return
+	}
+
+	var eventType *models.EventType
+	for i := range eventTypes {
+		if eventTypes[i].ID == eventTypeID {
+			eventType = &eventTypes[i]
+		}
+	}
+
+	if eventType == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event type not found")
+		return
+	}
+
+	if err := ctx.DB.UpdateEventTypeTaxRate(eventType.ID, *opts.TaxRate); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating event type")
+		return
+	}
+
+	before := *eventType
+	eventType.TaxRate = opts.TaxRate
+	w.Audit(db.ConstAuditActions.EventTypeUpdate, db.ConstAuditEntities.EventType, eventType.ID, before, eventType)
+
+	w.WriteJSON(http.StatusOK, eventType, nil, "")
+}
@@ -0,0 +1,566 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// InsertEventNotice emails every paid client of an event cancelled or
+// rescheduled by the park the message of the admin and a link to choose a
+// new date or a refund. The emails are sent in the background, their
+// progress is reported by GetEventNotice.
+func InsertEventNotice(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing event id")
+		return
+	}
+
+	var opts models.InsertEventNoticeOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertEventNoticeRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	event, err := ctx.DB.GetEventByID(eventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event")
+		return
+	}
+
+	if event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event not found")
+		return
+	}
+
+	notice := models.EventNotice{
+		Event:   event,
+		User:    &models.User{ID: userInfo.ID},
+		Kind:    opts.Kind,
+		Message: opts.Message,
+		Expires: time.Now().UTC().AddDate(0, 0, ctx.Config.EventNotice.ResponseDays).Truncate(time.Second),
+	}
+
+	_, err = ctx.DB.InsertEventNotice(&notice)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting event notice")
+		return
+	}
+
+	w.Audit(db.ConstAuditActions.EventNoticeInsert, db.ConstAuditEntities.EventNotice, notice.ID, nil, notice)
+
+	go sendEventNotice(ctx, config.GetLogger(), notice.ID)
+
+	w.WriteJSON(http.StatusAccepted, notice, nil, "")
+}
+
+func GetEventNotices(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing event id")
+		return
+	}
+
+	notices, err := ctx.DB.GetEventNotices(eventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event notices")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, models.EventNoticesStruct{Notices: notices}, nil, "")
+}
+
+// GetEventNotice reports how many clients were sent the notice, how many
+// failed and what the clients answered.
+func GetEventNotice(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	notice, ok := getEventNoticeForAdmin(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, notice, nil, "")
+}
+
+// GetEventNoticeRecipients lists the clients of the notice, filtered by the
+// delivery status to find the failures or by their answer.
+func GetEventNoticeRecipients(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	notice, ok := getEventNoticeForAdmin(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetEventNoticeRecipientsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetEventNoticeRecipientsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	recipients, err := ctx.DB.GetEventNoticeRecipients(notice.ID, &opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event notice recipients")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, recipients, nil, "")
+}
+
+// ResendEventNotice sends the notice to the recipients that haven't got it
+// yet, retrying the failed ones with attempts left.
+func ResendEventNotice(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	notice, ok := getEventNoticeForAdmin(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	w.Audit(db.ConstAuditActions.EventNoticeResend, db.ConstAuditEntities.EventNotice, notice.ID, nil, nil)
+
+	go sendEventNotice(ctx, config.GetLogger(), notice.ID)
+
+	w.WriteJSON(http.StatusAccepted, notice, nil, "")
+}
+
+func getEventNoticeForAdmin(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request, userInfo models.InfoUser) (*models.EventNotice, bool) {
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return nil, false
+	}
+
+	vars := mux.Vars(r)
+	noticeID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing event notice id")
+		return nil, false
+	}
+
+	notice, err := ctx.DB.GetEventNoticeByID(noticeID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event notice")
+		return nil, false
+	}
+
+	if notice == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event notice not found")
+		return nil, false
+	}
+
+	return notice, true
+}
+
+// sendEventNotice emails the notice to its unsent recipients. Each email has
+// its own token, so a resend invalidates the previous link.
+func sendEventNotice(ctx *config.AppContext, logger *log.Entry, noticeID int) {
+	logger = logger.WithFields(log.Fields{
+		"event_notice_id": noticeID,
+	})
+
+	notice, err := ctx.DB.GetEventNoticeByID(noticeID)
+	if err != nil {
+		logger.WithError(err).Error("failed getting event notice")
+		return
+	}
+
+	if notice == nil {
+		return
+	}
+
+	recipientIDs, err := ctx.DB.GetUnsentEventNoticeRecipientIDs(notice.ID, ctx.Config.EventNotice.MaxAttempts)
+	if err != nil {
+		logger.WithError(err).Error("failed getting event notice recipients")
+		return
+	}
+
+	var sent, failed int
+	for _, recipientID := range recipientIDs {
+		recipientLogger := logger.WithField("event_notice_recipient_id", recipientID)
+
+		claimed, err := ctx.DB.ClaimEventNoticeRecipient(recipientID, ctx.Config.EventNotice.MaxAttempts)
+		if err != nil {
+			recipientLogger.WithError(err).Error("failed claiming event notice recipient")
+			continue
+		}
+
+		if !claimed {
+			continue
+		}
+
+		token, err := sendEventNoticeEmail(ctx, notice, recipientID)
+		if err != nil {
+			failed++
+			recipientLogger.WithError(err).Error("failed sending event notice")
+			if err := ctx.DB.UpdateEventNoticeRecipientFailed(recipientID, err.Error()); err != nil {
+				recipientLogger.WithError(err).Error("failed updating event notice recipient")
+			}
+			continue
+		}
+
+		sent++
+		if err := ctx.DB.UpdateEventNoticeRecipientSent(recipientID, helpers.HashToken(token)); err != nil {
+			recipientLogger.WithError(err).Error("failed updating event notice recipient")
+		}
+	}
+
+	logger.WithFields(log.Fields{
+		"sent":   sent,
+		"failed": failed,
+	}).Info("event notice sent")
+}
+
+func sendEventNoticeEmail(ctx *config.AppContext, notice *models.EventNotice, recipientID int) (string, error) {
+	recipient, err := ctx.DB.GetEventNoticeRecipientByID(recipientID)
+	if err != nil {
+		return "", err
+	}
+
+	if recipient == nil {
+		return "", fmt.Errorf("event notice recipient %d not found", recipientID)
+	}
+
+	order, err := ctx.DB.GetOrderByID(recipient.Order.ID)
+	if err != nil {
+		return "", err
+	}
+
+	if order == nil {
+		return "", fmt.Errorf("order %d not found", recipient.Order.ID)
+	}
+
+	token, err := helpers.GenerateRandomToken()
+	if err != nil {
+		return "", err
+	}
+
+	subject := ctx.Config.Mail.EventNotice.SubjectRescheduled
+	if notice.Kind == db.ConstEventNoticeKinds.Cancelled {
+		subject = ctx.Config.Mail.EventNotice.SubjectCancelled
+	}
+
+	ed := &helpers.EmailData{
+		EmailTo:      recipient.Email,
+		NameTo:       recipient.Client.Firstname,
+		EmailFrom:    ctx.Config.Mail.EmailFrom,
+		NameFrom:     ctx.Config.Mail.NameFrom,
+		Subject:      subject,
+		TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.EventNotice.Template),
+		AwsSMTP:      ctx.AwsSMTP,
+	}
+
+	err = ed.SendEmail(models.EventNoticeHTML{
+		Firstname:     recipient.Client.Firstname,
+		Lastname:      recipient.Client.Lastname,
+		EventType:     order.Event.Type.Name,
+		Date:          notice.Event.StartDateTime.Format("02-01-2006"),
+		Tickets:       order.Tickets,
+		TransactionID: order.TransactionID,
+		Cancelled:     notice.Kind == db.ConstEventNoticeKinds.Cancelled,
+		Message:       notice.Message,
+		URL:           fmt.Sprintf("%s%s/%s", ctx.Config.FrontendBaseURL, ctx.Config.FrontendEventNoticePath, token),
+		Expires:       notice.Expires.In(parkLocation()).Format("02-01-2006 15:04"),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetEventNoticeResponse shows the client following the link of the notice
+// the order and the dates it can be moved to.
+func GetEventNoticeResponse(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetEventNoticeResponseRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetEventNoticeResponseOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	recipient, notice, order, ok := getEventNoticeRecipientByToken(ctx, w, opts.Token)
+	if !ok {
+		return
+	}
+
+	response := models.EventNoticeResponse{
+		Kind:    notice.Kind,
+		Message: notice.Message,
+		Order: &models.Order{
+			ID:            order.ID,
+			TransactionID: order.TransactionID,
+			Event:         order.Event,
+			Tickets:       order.Tickets,
+		},
+		Events:     []models.Event{},
+		Resolution: recipient.Resolution,
+	}
+
+	if recipient.Resolution == "" {
+		events, err := ctx.DB.GetEvents(&models.GetEventsOpts{
+			TypeID:  order.Event.Type.ID,
+			LimitTo: ctx.Config.EventNotice.MaxEvents,
+		})
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting events")
+			return
+		}
+
+		for _, event := range events.Events {
+			if event.ID == notice.Event.ID {
+				continue
+			}
+
+			available, err := ctx.DB.GetEventAvailableTickets(event.ID, 0)
+			if err != nil {
+				w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event available tickets")
+				return
+			}
+
+			if available >= 0 && available < order.Tickets {
+				continue
+			}
+
+			response.Events = append(response.Events, event)
+		}
+	}
+
+	w.WriteJSON(http.StatusOK, response, nil, "")
+}
+
+// RespondEventNotice stores the answer of the client. A new date moves the
+// order at no cost, whatever the price of the new event. A refund is left
+// in the order history for the backoffice to process.
+func RespondEventNotice(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	var opts models.RespondEventNoticeOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.RespondEventNoticeRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	recipient, _, order, ok := getEventNoticeRecipientByToken(ctx, w, opts.Token)
+	if !ok {
+		return
+	}
+
+	if recipient.Resolution != "" {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event notice already answered")
+		return
+	}
+
+	if opts.Action == "refund" {
+		err := ctx.DB.ResolveEventNoticeRecipient(recipient, db.ConstEventNoticeResolutions.Refund, 0)
+		if err == db.ErrEventNoticeResolved {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "event notice already answered")
+			return
+		}
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed answering event notice")
+			return
+		}
+
+		w.WriteJSON(http.StatusOK, models.EventNoticeResponse{Resolution: db.ConstEventNoticeResolutions.Refund}, nil, "")
+		return
+	}
+
+	if opts.EventID == 0 {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event is required")
+		return
+	}
+
+	event, err := ctx.DB.GetEventByID(opts.EventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event")
+		return
+	}
+
+	if event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event not found")
+		return
+	}
+
+	if event.ID == order.Event.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order already belongs to this event")
+		return
+	}
+
+	if event.Type == nil || order.Event.Type == nil || event.Type.ID != order.Event.Type.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event type doesn't match")
+		return
+	}
+
+	if !event.StartDateTime.After(parkNow()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event already started")
+		return
+	}
+
+	// The answer is taken first so two requests can't both move the order.
+	err = ctx.DB.ResolveEventNoticeRecipient(recipient, db.ConstEventNoticeResolutions.Rescheduled, event.ID)
+	if err == db.ErrEventNoticeResolved {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event notice already answered")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed answering event notice")
+		return
+	}
+
+	reschedule := &models.OrderReschedule{
+		Order:     order,
+		User:      &models.User{ID: recipient.Client.ID},
+		FromEvent: order.Event,
+		ToEvent:   event,
+	}
+
+	_, err = ctx.DB.InsertOrderReschedule(reschedule)
+	if err != nil {
+		if err := ctx.DB.ReleaseEventNoticeRecipient(recipient.ID); err != nil {
+			w.LogError(err, "failed releasing event notice recipient")
+		}
+	}
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event sold out")
+		return
+	}
+	if err == db.ErrOrderRescheduleInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order can't be rescheduled")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting reschedule")
+		return
+	}
+
+	sendOrderRescheduledEmail(ctx, w, reschedule)
+
+	w.WriteJSON(http.StatusOK, models.OrderRescheduleResult{Reschedule: reschedule}, nil, "")
+}
+
+// getEventNoticeRecipientByToken loads the recipient of the link along with
+// its notice and order, writing the response when the link is no longer
+// valid.
+func getEventNoticeRecipientByToken(ctx *config.AppContext, w *middlewares.ResponseWriter, token string) (*models.EventNoticeRecipient, *models.EventNotice, *models.Order, bool) {
+	recipient, err := ctx.DB.GetEventNoticeRecipientByToken(helpers.HashToken(token))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event notice")
+		return nil, nil, nil, false
+	}
+
+	if recipient == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event notice not found")
+		return nil, nil, nil, false
+	}
+
+	notice, err := ctx.DB.GetEventNoticeByID(recipient.NoticeID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event notice")
+		return nil, nil, nil, false
+	}
+
+	if notice == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event notice not found")
+		return nil, nil, nil, false
+	}
+
+	if notice.Expires.Before(time.Now()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event notice expired")
+		return nil, nil, nil, false
+	}
+
+	order, err := ctx.DB.GetOrderByID(recipient.Order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return nil, nil, nil, false
+	}
+
+	if order == nil || order.Event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return nil, nil, nil, false
+	}
+
+	// Once answered the order may have moved, so it's only checked before.
+	if recipient.Resolution == "" {
+		if order.Event.ID != notice.Event.ID {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "order no longer belongs to the event")
+			return nil, nil, nil, false
+		}
+
+		if order.Payment == nil || order.Payment.Status == nil || order.Payment.Status.ID != db.ConstPaymentStatuses.Approved.ID {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "order not paid")
+			return nil, nil, nil, false
+		}
+
+		if order.Used != nil && *order.Used {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "order already used")
+			return nil, nil, nil, false
+		}
+	}
+
+	return recipient, notice, order, true
+}
@@ -0,0 +1,442 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// exportPageSize is how many rows are read from the database at a time.
+const exportPageSize = 500
+
+// exportTable describes an export. Page reads the rows from an offset and
+// returns the total rows matching the filters.
+type exportTable struct {
+	Name    string
+	Title   string
+	Headers []interface{}
+	Page    func(limitFrom int, limitTo int) ([][]interface{}, int, error)
+}
+
+// GetOrdersExport exports the orders matching the filters of GetOrders.
+func GetOrdersExport(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	format, ok := validateExport(w, r, models.GetOrdersRules)
+	if !ok {
+		return
+	}
+
+	var opts models.GetOrdersOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	location := parkLocation()
+	writeExport(ctx, w, userInfo, format, exportTable{
+		Name:  "ordenes",
+		Title: "órdenes",
+		Headers: []interface{}{
+			"ID", "Código", "Cliente", "Email cliente", "Vendedor", "Evento", "Tipo de evento", "Fecha evento",
+			"Entradas", "Total", "Neto", "IVA", "Pagada", "Usada", "Creada",
+		},
+		Page: func(limitFrom int, limitTo int) ([][]interface{}, int, error) {
+			// GetOrders changes the dates of its options, so every page gets
+			// a copy.
+			pageOpts := opts
+			pageOpts.LimitFrom = limitFrom
+			pageOpts.LimitTo = limitTo
+			orders, err := ctx.DB.GetOrders(&pageOpts)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			rows := make([][]interface{}, 0, len(orders.Orders))
+			for _, order := range orders.Orders {
+				eventType := ""
+				if order.Event.Type != nil {
+					eventType = order.Event.Type.Name
+				}
+				rows = append(rows, []interface{}{
+					order.ID,
+					order.TransactionID,
+					fmt.Sprintf("%s %s", order.Client.Firstname, order.Client.Lastname),
+					order.Client.Email,
+					fmt.Sprintf("%s %s", order.User.Firstname, order.User.Lastname),
+					order.Event.Name,
+					eventType,
+					order.Event.StartDateTime.Format("02-01-2006 15:04"),
+					order.Tickets,
+					order.Price,
+					order.NetAmount,
+					order.TaxAmount,
+					helpers.ExportBool(order.Paid != nil && *order.Paid),
+					helpers.ExportBool(order.Used != nil && *order.Used),
+					order.Created.In(location).Format("02-01-2006 15:04"),
+				})
+			}
+			return rows, orders.Total, nil
+		},
+	})
+}
+
+// GetUsersExport exports the users matching the filters of GetUsers.
+func GetUsersExport(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	format, ok := validateExport(w, r, models.GetUsersRules)
+	if !ok {
+		return
+	}
+
+	var opts models.GetUsersOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	for i, dni := range opts.DNIs {
+		if rut, ok := helpers.NormalizeRUT(dni); ok {
+			opts.DNIs[i] = rut
+		}
+	}
+
+	location := parkLocation()
+	writeExport(ctx, w, userInfo, format, exportTable{
+		Name:  "usuarios",
+		Title: "usuarios",
+		Headers: []interface{}{
+			"ID", "Nombre", "Apellido", "Email", "Teléfono", "Tipo de documento", "Documento", "Roles",
+			"Email verificado", "Activo", "Creado",
+		},
+		Page: func(limitFrom int, limitTo int) ([][]interface{}, int, error) {
+			pageOpts := opts
+			pageOpts.LimitFrom = limitFrom
+			pageOpts.LimitTo = limitTo
+			users, err := ctx.DB.GetUsers(&pageOpts)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			rows := make([][]interface{}, 0, len(users.Users))
+			for i := range users.Users {
+				user := &users.Users[i]
+				formatUserDNI(user)
+
+				roles := make([]string, 0, len(user.Roles))
+				for _, role := range user.Roles {
+					roles = append(roles, role.Name)
+				}
+
+				additional := user.Additional
+				if additional == nil {
+					additional = &models.UserAdditional{}
+				}
+				rows = append(rows, []interface{}{
+					user.ID,
+					user.Firstname,
+					user.Lastname,
+					user.Email,
+					additional.Phone,
+					strings.ToUpper(additional.DocumentType),
+					additional.DNIFormatted,
+					strings.Join(roles, ", "),
+					helpers.ExportBool(user.EmailVerified),
+					helpers.ExportBool(user.Active),
+					user.Created.In(location).Format("02-01-2006 15:04"),
+				})
+			}
+			return rows, users.Total, nil
+		},
+	})
+}
+
+// GetCampingsExport exports the campings matching the filters of GetCampings.
+func GetCampingsExport(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	format, ok := validateExport(w, r, models.GetCampingsRules)
+	if !ok {
+		return
+	}
+
+	var opts models.GetCampingsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	location := parkLocation()
+	writeExport(ctx, w, userInfo, format, exportTable{
+		Name:  "campings",
+		Title: "campings",
+		Headers: []interface{}{
+			"ID", "Código", "Cliente", "Email cliente", "Evento", "Inicio", "Término", "Entradas", "Total", "Creado",
+		},
+		Page: func(limitFrom int, limitTo int) ([][]interface{}, int, error) {
+			pageOpts := opts
+			pageOpts.LimitFrom = limitFrom
+			pageOpts.LimitTo = limitTo
+			campings, err := ctx.DB.GetCampings(&pageOpts)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			rows := make([][]interface{}, 0, len(campings.Campings))
+			for _, camping := range campings.Campings {
+				rows = append(rows, []interface{}{
+					camping.ID,
+					camping.TransactionID,
+					fmt.Sprintf("%s %s", camping.Client.Firstname, camping.Client.Lastname),
+					camping.Client.Email,
+					camping.Event.Name,
+					camping.Event.StartDateTime.Format("02-01-2006 15:04"),
+					camping.Event.EndDateTime.Format("02-01-2006 15:04"),
+					camping.Tickets,
+					camping.Price,
+					camping.Created.In(location).Format("02-01-2006 15:04"),
+				})
+			}
+			return rows, campings.Total, nil
+		},
+	})
+}
+
+// GetPaymentsExport exports the payments matching the filters of GetPayments.
+func GetPaymentsExport(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	format, ok := validateExport(w, r, models.GetPaymentsRules)
+	if !ok {
+		return
+	}
+
+	var opts models.GetPaymentsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	location := parkLocation()
+	writeExport(ctx, w, userInfo, format, exportTable{
+		Name:  "pagos",
+		Title: "pagos",
+		Headers: []interface{}{
+			"ID", "Orden", "Código", "Cliente", "Email cliente", "Medio de pago", "Estado", "Monto", "Referencia",
+			"Registrado por", "Creado", "Actualizado",
+		},
+		Page: func(limitFrom int, limitTo int) ([][]interface{}, int, error) {
+			pageOpts := opts
+			pageOpts.LimitFrom = limitFrom
+			pageOpts.LimitTo = limitTo
+			payments, err := ctx.DB.GetPayments(&pageOpts)
+			if err != nil {
+				return nil, 0, err
+			}
+
+			rows := make([][]interface{}, 0, len(payments.Payments))
+			for _, payment := range payments.Payments {
+				// Payments of a season pass have the pass code instead of
+				// the order and its holder as client.
+				var orderID interface{}
+				var code string
+				var client *models.User
+				if payment.SeasonPass != nil {
+					orderID, code, client = "", payment.SeasonPass.Code, payment.SeasonPass.User
+				} else {
+					orderID, code, client = payment.Order.ID, payment.Order.TransactionID, payment.Order.Client
+				}
+
+				rows = append(rows, []interface{}{
+					payment.ID,
+					orderID,
+					code,
+					fmt.Sprintf("%s %s", client.Firstname, client.Lastname),
+					client.Email,
+					payment.Method.Name,
+					payment.Status.Name,
+					payment.Amount,
+					payment.PreferenceID,
+					fmt.Sprintf("%s %s", payment.User.Firstname, payment.User.Lastname),
+					payment.Created.In(location).Format("02-01-2006 15:04"),
+					payment.Updated.In(location).Format("02-01-2006 15:04"),
+				})
+			}
+			return rows, payments.Total, nil
+		},
+	})
+}
+
+// validateExport checks the filters of the list with the export format.
+func validateExport(w *middlewares.ResponseWriter, r *http.Request, rules govalidator.MapData) (string, bool) {
+	exportRules := govalidator.MapData{}
+	for field, fieldRules := range rules {
+		exportRules[field] = fieldRules
+	}
+	for field, fieldRules := range models.ExportRules {
+		exportRules[field] = fieldRules
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   exportRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return "", false
+	}
+
+	var opts models.ExportOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	return opts.Format, true
+}
+
+// writeExport streams the export in the response. Exports with more rows
+// than the configured limit are generated in the background and the user
+// gets a download link by email.
+func writeExport(ctx *config.AppContext, w *middlewares.ResponseWriter, userInfo models.InfoUser, format string, table exportTable) {
+	rows, total, err := table.Page(0, exportPageSize)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting export rows")
+		return
+	}
+
+	if total > ctx.Config.Export.AsyncRows {
+		go uploadExport(ctx, config.GetLogger(), userInfo, format, table)
+		w.WriteJSON(http.StatusAccepted, nil, nil, "export will be sent by email")
+		return
+	}
+
+	w.Writer.Header().Set("Content-Type", helpers.ExportContentType(format))
+	w.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", exportFileName(table, format)))
+	w.Writer.WriteHeader(http.StatusOK)
+
+	if err := table.write(w.Writer, format, rows, total); err != nil {
+		w.LogError(err, "failed writing export")
+	}
+}
+
+func uploadExport(ctx *config.AppContext, logger *log.Entry, userInfo models.InfoUser, format string, table exportTable) {
+	logger = logger.WithFields(log.Fields{
+		"export":         table.Name,
+		"export_user_id": userInfo.ID,
+	})
+
+	rows, total, err := table.Page(0, exportPageSize)
+	if err != nil {
+		logger.WithError(err).Error("failed getting export rows")
+		return
+	}
+
+	var buffer bytes.Buffer
+	if err := table.write(&buffer, format, rows, total); err != nil {
+		logger.WithError(err).Error("failed writing export")
+		return
+	}
+
+	fileName := fmt.Sprintf("%s/%d/%s", ctx.Config.AwsS3.S3PathExport, userInfo.ID, exportFileName(table, format))
+	url, err := helpers.AddFileToS3(ctx, &buffer, fileName)
+	if err != nil {
+		logger.WithError(err).Error("failed uploading export to S3")
+		return
+	}
+
+	user, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil || user == nil {
+		logger.WithError(err).Error("failed getting export user")
+		return
+	}
+
+	ed := &helpers.EmailData{
+		EmailTo:      user.Email,
+		NameTo:       user.Firstname,
+		EmailFrom:    ctx.Config.Mail.EmailFrom,
+		NameFrom:     ctx.Config.Mail.NameFrom,
+		Subject:      ctx.Config.Mail.ExportReady.Subject,
+		TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.ExportReady.Template),
+		AwsSMTP:      ctx.AwsSMTP,
+	}
+
+	data := models.ExportReadyHTML{
+		Firstname: user.Firstname,
+		Name:      table.Title,
+		URL:       url,
+	}
+
+	if err := ed.SendEmail(data); err != nil {
+		logger.WithError(err).Error("failed sending email")
+		return
+	}
+	logger.Info("success sending export email")
+}
+
+// write writes the headers and every row of the export, starting with the
+// first page that was already read.
+func (t exportTable) write(out io.Writer, format string, rows [][]interface{}, total int) error {
+	writer, err := helpers.NewExportWriter(format, out)
+	if err != nil {
+		return err
+	}
+
+	if err := writer.Write(t.Headers); err != nil {
+		return err
+	}
+
+	offset := 0
+	for {
+		for _, row := range rows {
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+
+		offset += len(rows)
+		if len(rows) == 0 || offset >= total {
+			break
+		}
+
+		rows, _, err = t.Page(offset, exportPageSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+func exportFileName(table exportTable, format string) string {
+	return fmt.Sprintf("%s-%s.%s", table.Name, time.Now().In(parkLocation()).Format("20060102-150405"), format)
+}
@@ -0,0 +1,455 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// InsertGiftVoucher sells a gift voucher. Clients pay it through Mercado Pago
+// and the voucher is activated by the payment notification, while staff sell
+// it already paid at the cashier. Once active, the recipient gets the PDF.
+func InsertGiftVoucher(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	isStaff := userInfo.IsAdmin || userInfo.IsCashier
+
+	var opts models.InsertGiftVoucherOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertGiftVoucherRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	buyer, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if buyer == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if !isStaff && !buyer.EmailVerified {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "email not verified")
+		return
+	}
+
+	voucher := models.GiftVoucher{
+		Type:           opts.Type,
+		User:           buyer,
+		RecipientName:  strings.TrimSpace(opts.RecipientName),
+		RecipientEmail: strings.ToLower(strings.TrimSpace(opts.RecipientEmail)),
+		Message:        strings.TrimSpace(opts.Message),
+		Status:         db.ConstGiftVoucherStatuses.Active,
+	}
+
+	switch opts.Type {
+	case db.ConstGiftVoucherTypes.Amount:
+		if opts.Amount <= 0 {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "amount is required")
+			return
+		}
+
+		voucher.Amount = opts.Amount
+		voucher.Price = opts.Amount
+	case db.ConstGiftVoucherTypes.Tickets:
+		if opts.Tickets <= 0 {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "tickets are required")
+			return
+		}
+
+		if ctx.Config.GiftVoucher.TicketPrice <= 0 {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "ticket vouchers are not on sale")
+			return
+		}
+
+		voucher.Tickets = opts.Tickets
+		voucher.Price = opts.Tickets * ctx.Config.GiftVoucher.TicketPrice
+	}
+
+	if opts.EventTypeID != 0 {
+		eventTypes, err := ctx.DB.GetEventTypes()
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event types")
+			return
+		}
+
+		for i := range eventTypes {
+			if eventTypes[i].ID == opts.EventTypeID {
+				voucher.EventType = &eventTypes[i]
+			}
+		}
+
+		if voucher.EventType == nil {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "event type not found")
+			return
+		}
+	}
+
+	voucher.Code, err = db.GenerateVoucherCode()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating voucher code")
+		return
+	}
+
+	voucher.Expires, err = giftVoucherExpires(ctx)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed loading time location")
+		return
+	}
+
+	result := models.GiftVoucherResult{
+		Voucher: &voucher,
+	}
+
+	if !isStaff {
+		response, err := ctx.MercadoPago.MPCreateChargePreference(
+			voucher.Code,
+			"Gift card",
+			fmt.Sprintf("Gift card para %s", voucher.RecipientName),
+			voucher.Price,
+			ctx.Config.BackendBaseURL,
+		)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "problems with Mercado Pago")
+			return
+		}
+
+		if response == nil || response.ExternalReference == "" {
+			w.WriteJSON(http.StatusInternalServerError, nil, nil, "bad response from Mercado Pago")
+			return
+		}
+
+		voucher.Status = db.ConstGiftVoucherStatuses.Pending
+		voucher.PreferenceID = response.ExternalReference
+		result.Payment = response
+	}
+
+	if _, err := ctx.DB.InsertGiftVoucher(&voucher); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting gift voucher")
+		return
+	}
+
+	if isStaff {
+		w.Audit(db.ConstAuditActions.GiftVoucherInsert, db.ConstAuditEntities.GiftVoucher, voucher.ID, nil, voucher)
+		sendGiftVoucherEmail(ctx, w, &voucher)
+	}
+
+	w.WriteJSON(http.StatusOK, result, nil, "")
+}
+
+// GetGiftVouchers lists the vouchers. Clients only get the ones they bought.
+func GetGiftVouchers(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetGiftVouchersRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetGiftVouchersOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		opts.UserID = userInfo.ID
+	}
+
+	vouchers, err := ctx.DB.GetGiftVouchers(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting gift vouchers")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, vouchers, nil, "")
+}
+
+// GetGiftVoucher returns the balance of a voucher by its code, so it can be
+// checked before the checkout.
+func GetGiftVoucher(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	voucher, err := ctx.DB.GetGiftVoucherByCode(vars["code"])
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting gift voucher")
+		return
+	}
+
+	if voucher == nil || voucher.Status == db.ConstGiftVoucherStatuses.Pending {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "gift voucher not found")
+		return
+	}
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && voucher.User.ID != userInfo.ID {
+		voucher.User = nil
+		voucher.RecipientEmail = ""
+	}
+
+	w.WriteJSON(http.StatusOK, voucher, nil, "")
+}
+
+// GetGiftVoucherLiability reports the money moved by the vouchers in the
+// period and what is still owed to their holders.
+func GetGiftVoucherLiability(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetGiftVoucherLiabilityRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetGiftVoucherLiabilityOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	liability, err := ctx.DB.GetGiftVoucherLiability(opts.DateFrom, opts.DateTo)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting gift voucher liability")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, liability, nil, "")
+}
+
+// updateGiftVoucherPayment activates a voucher once Mercado Pago approves its
+// payment and sends it to the recipient.
+func updateGiftVoucherPayment(ctx *config.AppContext, w *middlewares.ResponseWriter, voucher *models.GiftVoucher, paymentStatus *models.PaymentStatus) {
+	if voucher.Status != db.ConstGiftVoucherStatuses.Pending {
+		w.LogInfo(voucher.ID, "gift voucher is not pending")
+		return
+	}
+
+	if paymentStatus.ID != db.ConstPaymentStatuses.Approved.ID {
+		w.LogInfo(paymentStatus, "gift voucher payment not approved")
+		return
+	}
+
+	err := ctx.DB.ActivateGiftVoucher(voucher.ID)
+	if err == db.ErrGiftVoucherInvalid {
+		w.LogInfo(voucher.ID, "gift voucher already activated")
+		return
+	}
+	if err != nil {
+		w.LogError(err, "failed activating gift voucher")
+		return
+	}
+
+	voucher.Status = db.ConstGiftVoucherStatuses.Active
+	sendGiftVoucherEmail(ctx, w, voucher)
+}
+
+func sendGiftVoucherEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, voucher *models.GiftVoucher) {
+	go func(ctx *config.AppContext, voucher *models.GiftVoucher) {
+		pdfBuffer, err := helpers.GenerateGiftVoucherPDF(voucher)
+		if err != nil {
+			w.LogError(err, "failed generating PDF")
+			return
+		}
+
+		ed := &helpers.EmailData{
+			EmailTo:      voucher.RecipientEmail,
+			NameTo:       voucher.RecipientName,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.GiftVoucher.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.GiftVoucher.Template),
+			FileName:     ctx.Config.Mail.GiftVoucher.FileName,
+			FileContent:  pdfBuffer.Bytes(),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err = ed.SendEmail(models.GiftVoucherHTML{
+			RecipientName: voucher.RecipientName,
+			BuyerName:     voucher.User.Firstname,
+			Code:          voucher.Code,
+			Expires:       voucher.Expires.Format("02-01-2006"),
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, voucher)
+}
+
+// sendPaidOrderEmail sends the tickets of an order paid outside Mercado Pago,
+// like one fully covered by a gift voucher, as the payment flow would.
+func sendPaidOrderEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, orderID int, paymentMethod string) {
+	go func(ctx *config.AppContext, orderID int) {
+		order, err := ctx.DB.GetOrderByID(orderID)
+		if err != nil {
+			w.LogError(err, "failed getting order")
+			return
+		}
+
+		if order == nil {
+			w.LogError(nil, "order not found")
+			return
+		}
+
+		pdfBuffer, err := helpers.GenerateOrderPDF(order)
+		if err != nil {
+			w.LogError(err, "failed generating PDF")
+			return
+		}
+
+		ed := &helpers.EmailData{
+			EmailTo:      order.Client.Email,
+			NameTo:       order.Client.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.PaymentSuccess.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.PaymentSuccess.Template),
+			FileName:     ctx.Config.Mail.PaymentSuccess.FileName,
+			FileContent:  pdfBuffer.Bytes(),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err = ed.SendEmail(models.OrderHTML{
+			ID:            order.ID,
+			Firstname:     order.Client.Firstname,
+			Lastname:      order.Client.Lastname,
+			PaymentMethod: paymentMethod,
+			OrderPrice:    order.Price,
+			NetAmount:     order.NetAmount,
+			TaxAmount:     order.TaxAmount,
+			TaxRate:       models.TaxRateLabel(order.TaxRate),
+			TransactionID: order.TransactionID,
+			Tickets:       order.Tickets,
+			Date:          time.Now().Format("02-01-2006"),
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, orderID)
+}
+
+// giftVoucherExpires is the last day a voucher issued today can be redeemed.
+func giftVoucherExpires(ctx *config.AppContext) (time.Time, error) {
+	timeLocation, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	today, _ := time.Parse(db.ConstLayoutDate, time.Now().In(timeLocation).Format(db.ConstLayoutDate))
+
+	return today.AddDate(0, ctx.Config.GiftVoucher.ValidityMonths, 0), nil
+}
+
+// newCreditGiftVoucher is an amount voucher with the money owed to the client
+// of the order, already active.
+func newCreditGiftVoucher(ctx *config.AppContext, order *models.Order, amount int, message string) (*models.GiftVoucher, error) {
+	code, err := db.GenerateVoucherCode()
+	if err != nil {
+		return nil, err
+	}
+
+	expires, err := giftVoucherExpires(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.GiftVoucher{
+		Code:           code,
+		Type:           db.ConstGiftVoucherTypes.Amount,
+		Amount:         amount,
+		Price:          amount,
+		User:           order.Client,
+		RecipientName:  strings.TrimSpace(order.Client.Firstname + " " + order.Client.Lastname),
+		RecipientEmail: order.Client.Email,
+		Message:        message,
+		Status:         db.ConstGiftVoucherStatuses.Active,
+		Expires:        expires,
+	}, nil
+}
+
+// voucherReleaseBatchSize is how many expired reservations are released on
+// each run.
+const voucherReleaseBatchSize = 100
+
+// RunVoucherReleases releases the expired voucher reservations right away
+// and then every configured interval, for as long as the server runs.
+func RunVoucherReleases(ctx *config.AppContext) {
+	runPeriodically("voucher-releases", minutesOrDefault(ctx.Config.GiftVoucher.ReleaseIntervalMinutes, 5), func() error {
+		return ReleaseVoucherReservations(ctx)
+	})
+}
+
+// ReleaseVoucherReservations gives the voucher balance back from the orders
+// that weren't paid before their reservation expired, and cancels them.
+func ReleaseVoucherReservations(ctx *config.AppContext) error {
+	logger := config.GetLogger()
+
+	redemptionIDs, err := ctx.DB.GetExpiredVoucherRedemptionIDs(voucherReleaseBatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, redemptionID := range redemptionIDs {
+		released, err := ctx.DB.ReleaseVoucherRedemption(redemptionID)
+		if err != nil {
+			logger.WithError(err).WithField("redemption_id", redemptionID).Error("failed releasing voucher redemption")
+			continue
+		}
+
+		if released {
+			logger.WithField("redemption_id", redemptionID).Info("voucher redemption released")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,601 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+const groupBookingMaxFileSize = 5 << 20
+
+var groupBookingProofExtensions = map[string]bool{
+	".pdf":  true,
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+}
+
+// InsertGroupBooking receives the request of a school or organization for a
+// group visit. An admin then approves it with the negotiated price.
+func InsertGroupBooking(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	var opts models.InsertGroupBookingOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertGroupBookingRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	if opts.Tickets < ctx.Config.GroupBooking.MinTickets {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, fmt.Sprintf("group bookings require at least %d tickets", ctx.Config.GroupBooking.MinTickets))
+		return
+	}
+
+	dni, ok := helpers.NormalizeRUT(opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "a valid organization RUT is required")
+		return
+	}
+
+	event, err := ctx.DB.GetEventByID(opts.EventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event")
+		return
+	}
+
+	if event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event not found")
+		return
+	}
+
+	if !event.StartDateTime.After(parkNow()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event already started")
+		return
+	}
+
+	booking := models.GroupBooking{
+		User:         &models.User{ID: userInfo.ID},
+		Event:        event,
+		Tickets:      opts.Tickets,
+		Organization: strings.TrimSpace(opts.Organization),
+		DNI:          dni,
+		ContactName:  strings.TrimSpace(opts.ContactName),
+		ContactEmail: strings.ToLower(strings.TrimSpace(opts.ContactEmail)),
+		ContactPhone: strings.TrimSpace(opts.ContactPhone),
+		Notes:        strings.TrimSpace(opts.Notes),
+	}
+
+	if _, err := ctx.DB.InsertGroupBooking(&booking); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting group booking")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, booking, nil, "")
+}
+
+// GetGroupBookings lists the group bookings. Clients only get their own.
+func GetGroupBookings(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetGroupBookingsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetGroupBookingsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin {
+		opts.UserID = userInfo.ID
+	}
+
+	bookings, err := ctx.DB.GetGroupBookings(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting group bookings")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, bookings, nil, "")
+}
+
+func GetGroupBooking(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, booking, nil, "")
+}
+
+// ApproveGroupBooking sets the negotiated price and the payment due date. The
+// order is created with a bank transfer payment and the contact gets the bank
+// details to pay it.
+func ApproveGroupBooking(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	var opts models.ApproveGroupBookingOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.ApproveGroupBookingRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	if opts.Price <= 0 {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "price must be greater than zero")
+		return
+	}
+
+	dueDate, err := time.Parse(db.ConstLayoutDate, opts.DueDate)
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing due date")
+		return
+	}
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	if booking.Status != db.ConstGroupBookingStatuses.Requested {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking can't be approved")
+		return
+	}
+
+	if dueDate.After(booking.Event.StartDateTime) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "due date must be before the event")
+		return
+	}
+
+	before := *booking
+	booking.Price = opts.Price
+	booking.DueDate = &dueDate
+
+	err = ctx.DB.ApproveGroupBooking(booking, userInfo.ID)
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event sold out")
+		return
+	}
+	if err == db.ErrGroupBookingInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking can't be approved")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed approving group booking")
+		return
+	}
+
+	w.Audit(db.ConstAuditActions.GroupBookingApprove, db.ConstAuditEntities.GroupBooking, booking.ID, before, booking)
+	sendGroupBookingApprovedEmail(ctx, w, booking)
+
+	w.WriteJSON(http.StatusOK, booking, nil, "")
+}
+
+func RejectGroupBooking(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	var opts models.RejectGroupBookingOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.RejectGroupBookingRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	before := *booking
+	booking.Reason = strings.TrimSpace(opts.Reason)
+
+	err := ctx.DB.RejectGroupBooking(booking.ID, booking.Reason)
+	if err == db.ErrGroupBookingInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking can't be rejected")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed rejecting group booking")
+		return
+	}
+
+	booking.Status = db.ConstGroupBookingStatuses.Rejected
+	w.Audit(db.ConstAuditActions.GroupBookingReject, db.ConstAuditEntities.GroupBooking, booking.ID, before, booking)
+	sendGroupBookingRejectedEmail(ctx, w, booking)
+
+	w.WriteJSON(http.StatusOK, booking, nil, "")
+}
+
+// UploadGroupBookingProof stores the bank transfer proof on S3 so an admin can
+// check it against the bank account.
+func UploadGroupBookingProof(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	if booking.Status != db.ConstGroupBookingStatuses.Approved && booking.Status != db.ConstGroupBookingStatuses.ProofUploaded {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking is not waiting for payment")
+		return
+	}
+
+	buffer, fileName, ok := readGroupBookingFile(w, r)
+	if !ok {
+		return
+	}
+
+	extension := strings.ToLower(filepath.Ext(fileName))
+	if !groupBookingProofExtensions[extension] {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "proof must be a PDF, JPG or PNG file")
+		return
+	}
+
+	url, err := helpers.AddFileToS3(ctx, buffer, fmt.Sprintf("%s/group-booking/%d/%s%s", ctx.Config.AwsS3.S3PathOrder, booking.ID, shortuuid.New(), extension))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed uploading proof")
+		return
+	}
+
+	err = ctx.DB.UpdateGroupBookingProof(booking.ID, url)
+	if err == db.ErrGroupBookingInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking is not waiting for payment")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating group booking")
+		return
+	}
+
+	booking.Status = db.ConstGroupBookingStatuses.ProofUploaded
+	booking.ProofURL = url
+
+	w.WriteJSON(http.StatusOK, booking, nil, "")
+}
+
+// UpdateGroupBookingPayment is used by admins once they checked the transfer.
+// Approving it pays the order and sends the tickets, rejecting it lets the
+// client upload another proof.
+func UpdateGroupBookingPayment(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	var opts models.UpdateGroupBookingPaymentOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateGroupBookingPaymentRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	before := *booking
+
+	err := ctx.DB.UpdateGroupBookingPayment(booking.ID, opts.Approved)
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event sold out")
+		return
+	}
+	if err == db.ErrGroupBookingInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking is not waiting for payment")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating group booking payment")
+		return
+	}
+
+	booking.Status = db.ConstGroupBookingStatuses.Approved
+	if opts.Approved {
+		booking.Status = db.ConstGroupBookingStatuses.Paid
+		sendPaidOrderEmail(ctx, w, booking.Order.ID, db.ConstPaymentMethods.BankTransfer.Name)
+		go issueOrderTaxDocument(ctx, config.GetLogger(), booking.Order.ID)
+	}
+
+	w.Audit(db.ConstAuditActions.GroupBookingPayment, db.ConstAuditEntities.GroupBooking, booking.ID, before, booking)
+
+	w.WriteJSON(http.StatusOK, booking, nil, "")
+}
+
+// ImportGroupBookingParticipants replaces the participant list with the rows
+// of a CSV file with the columns nombre, apellido, rut and email. The first
+// row is the header.
+func ImportGroupBookingParticipants(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	if booking.Status == db.ConstGroupBookingStatuses.Rejected || booking.Status == db.ConstGroupBookingStatuses.Cancelled {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "group booking is closed")
+		return
+	}
+
+	buffer, _, ok := readGroupBookingFile(w, r)
+	if !ok {
+		return
+	}
+
+	reader := csv.NewReader(buffer)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed reading CSV file")
+		return
+	}
+
+	var participants []models.GroupBookingParticipant
+	for i, record := range records {
+		if i == 0 {
+			continue
+		}
+
+		if len(record) < 2 {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, fmt.Sprintf("row %d must have at least nombre and apellido", i+1))
+			return
+		}
+
+		participant := models.GroupBookingParticipant{
+			Firstname: strings.TrimSpace(record[0]),
+			Lastname:  strings.TrimSpace(record[1]),
+		}
+		if participant.Firstname == "" || participant.Lastname == "" {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, fmt.Sprintf("row %d must have nombre and apellido", i+1))
+			return
+		}
+
+		if len(record) > 2 && strings.TrimSpace(record[2]) != "" {
+			dni, ok := helpers.NormalizeRUT(record[2])
+			if !ok {
+				w.WriteJSON(http.StatusBadRequest, nil, nil, fmt.Sprintf("row %d has an invalid RUT", i+1))
+				return
+			}
+			participant.DNI = dni
+		}
+
+		if len(record) > 3 {
+			participant.Email = strings.ToLower(strings.TrimSpace(record[3]))
+		}
+
+		participants = append(participants, participant)
+	}
+
+	if len(participants) > booking.Tickets {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, fmt.Sprintf("the booking has %d tickets and the file %d participants", booking.Tickets, len(participants)))
+		return
+	}
+
+	if err := ctx.DB.ReplaceGroupBookingParticipants(booking.ID, participants); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting participants")
+		return
+	}
+
+	participants, err = ctx.DB.GetGroupBookingParticipants(booking.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting participants")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, participants, nil, "")
+}
+
+func GetGroupBookingParticipants(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	booking, ok := getGroupBookingFromRequest(ctx, w, r, userInfo)
+	if !ok {
+		return
+	}
+
+	participants, err := ctx.DB.GetGroupBookingParticipants(booking.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting participants")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, participants, nil, "")
+}
+
+// getGroupBookingFromRequest loads the booking of the route. Admins see any
+// booking and clients only the ones they requested.
+func getGroupBookingFromRequest(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request, userInfo models.InfoUser) (*models.GroupBooking, bool) {
+	if !userInfo.IsAdmin && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return nil, false
+	}
+
+	vars := mux.Vars(r)
+	bookingID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing group booking id")
+		return nil, false
+	}
+
+	booking, err := ctx.DB.GetGroupBookingByID(bookingID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting group booking")
+		return nil, false
+	}
+
+	if booking == nil || (!userInfo.IsAdmin && booking.User.ID != userInfo.ID) {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "group booking not found")
+		return nil, false
+	}
+
+	return booking, true
+}
+
+// readGroupBookingFile reads the "file" field of a multipart request.
+func readGroupBookingFile(w *middlewares.ResponseWriter, r *http.Request) (*bytes.Buffer, string, bool) {
+	r.Body = http.MaxBytesReader(w.Writer, r.Body, groupBookingMaxFileSize+1024)
+	if err := r.ParseMultipartForm(groupBookingMaxFileSize); err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "file must be smaller than 5MB")
+		return nil, "", false
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "file is required")
+		return nil, "", false
+	}
+
+	defer file.Close()
+
+	if header.Size > groupBookingMaxFileSize {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "file must be smaller than 5MB")
+		return nil, "", false
+	}
+
+	buffer := new(bytes.Buffer)
+	if _, err := io.Copy(buffer, file); err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed reading file")
+		return nil, "", false
+	}
+
+	return buffer, header.Filename, true
+}
+
+func sendGroupBookingApprovedEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, booking *models.GroupBooking) {
+	go func(ctx *config.AppContext, booking *models.GroupBooking) {
+		ed := &helpers.EmailData{
+			EmailTo:      booking.ContactEmail,
+			NameTo:       booking.ContactName,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.GroupBookingApproved.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.GroupBookingApproved.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err := ed.SendEmail(models.GroupBookingApprovedHTML{
+			ContactName:  booking.ContactName,
+			Organization: booking.Organization,
+			EventType:    booking.Event.Type.Name,
+			Date:         booking.Event.StartDateTime.Format("02-01-2006"),
+			Tickets:      booking.Tickets,
+			Price:        booking.Price,
+			DueDate:      booking.DueDate.Format("02-01-2006"),
+			BankDetails:  ctx.Config.GroupBooking.BankDetails,
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, booking)
+}
+
+func sendGroupBookingRejectedEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, booking *models.GroupBooking) {
+	go func(ctx *config.AppContext, booking *models.GroupBooking) {
+		ed := &helpers.EmailData{
+			EmailTo:      booking.ContactEmail,
+			NameTo:       booking.ContactName,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.GroupBookingRejected.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.GroupBookingRejected.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err := ed.SendEmail(models.GroupBookingRejectedHTML{
+			ContactName:  booking.ContactName,
+			Organization: booking.Organization,
+			Reason:       booking.Reason,
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, booking)
+}
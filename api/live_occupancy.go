@@ -0,0 +1,230 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/mitchellh/mapstructure"
+)
+
+// gateAdmissionsTopic receives a models.GateAdmission on every admission.
+const gateAdmissionsTopic = "gate_admissions"
+
+// GetLiveOccupancy returns the occupancy of the events open today.
+func GetLiveOccupancy(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	occupancy, err := getLiveOccupancy(ctx)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting live occupancy")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, occupancy, nil, "")
+}
+
+// StreamLiveOccupancy pushes the occupancy of the events open today as
+// Server-Sent Events. A "snapshot" event is sent on connection and every
+// resync period, and an "admission" event each time the gate admits someone.
+func StreamLiveOccupancy(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	if ctx.Events == nil {
+		w.WriteJSON(http.StatusServiceUnavailable, nil, nil, "live occupancy not available")
+		return
+	}
+
+	occupancy, err := getLiveOccupancy(ctx)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting live occupancy")
+		return
+	}
+
+	admissions, unsubscribe := ctx.Events.Subscribe(gateAdmissionsTopic)
+	defer unsubscribe()
+
+	stream, err := w.EventStream(r)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed starting stream")
+		return
+	}
+
+	if err := stream.Send("snapshot", occupancy); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(time.Duration(ctx.Config.LiveOccupancy.HeartbeatSeconds) * time.Second)
+	defer heartbeat.Stop()
+	resync := time.NewTicker(time.Duration(ctx.Config.LiveOccupancy.ResyncSeconds) * time.Second)
+	defer resync.Stop()
+
+	for {
+		select {
+		case <-stream.Done():
+			return
+		case <-heartbeat.C:
+			if err := stream.Heartbeat(); err != nil {
+				return
+			}
+		case <-resync.C:
+			// Sales and admissions from other processes only show up here.
+			newOccupancy, err := getLiveOccupancy(ctx)
+			if err != nil {
+				w.LogError(err, "failed getting live occupancy")
+				continue
+			}
+			occupancy = newOccupancy
+			if err := stream.Send("snapshot", occupancy); err != nil {
+				return
+			}
+		case message := <-admissions:
+			admission, ok := message.(models.GateAdmission)
+			if !ok {
+				continue
+			}
+
+			event := occupancy.addAdmission(admission)
+			if event == nil {
+				// The event wasn't open when the snapshot was taken.
+				newOccupancy, err := getLiveOccupancy(ctx)
+				if err != nil {
+					w.LogError(err, "failed getting live occupancy")
+					continue
+				}
+				occupancy = newOccupancy
+				if err := stream.Send("snapshot", occupancy); err != nil {
+					return
+				}
+				continue
+			}
+
+			if err := stream.Send("admission", models.LiveOccupancyUpdate{
+				Admission: admission,
+				Event:     event,
+			}); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// liveOccupancy wraps the snapshot sent to a stream so admissions can be
+// added to it without reading the database again.
+type liveOccupancy struct {
+	*models.LiveOccupancy
+}
+
+func getLiveOccupancy(ctx *config.AppContext) (liveOccupancy, error) {
+	today := time.Now().In(parkLocation()).Format("2006-01-02")
+
+	slots, err := ctx.DB.GetOccupancySlots(today, 0)
+	if err != nil {
+		return liveOccupancy{}, err
+	}
+
+	admissions, err := ctx.DB.GetGateAdmissions(today)
+	if err != nil {
+		return liveOccupancy{}, err
+	}
+
+	occupancy := liveOccupancy{&models.LiveOccupancy{
+		Events:  []models.LiveEventOccupancy{},
+		Updated: time.Now(),
+	}}
+	for _, slot := range slots {
+		if slot.Ended {
+			continue
+		}
+
+		event := models.LiveEventOccupancy{
+			Event:       slot.Event,
+			TicketsSold: slot.TicketsSold,
+			Gates:       []models.GateOccupancy{},
+		}
+		for _, admission := range admissions {
+			if admission.EventID != slot.Event.ID {
+				continue
+			}
+			event.TicketsAdmitted += admission.Tickets
+			event.Gates = append(event.Gates, models.GateOccupancy{
+				Gate:            admission.Gate,
+				TicketsAdmitted: admission.Tickets,
+			})
+		}
+		updateLiveEventOccupancy(&event)
+
+		occupancy.Events = append(occupancy.Events, event)
+	}
+
+	return occupancy, nil
+}
+
+// addAdmission adds the admission to its event and returns it, or nil when
+// the event isn't part of the snapshot.
+func (o liveOccupancy) addAdmission(admission models.GateAdmission) *models.LiveEventOccupancy {
+	for i := range o.Events {
+		event := &o.Events[i]
+		if event.Event.ID != admission.EventID {
+			continue
+		}
+
+		event.TicketsAdmitted += admission.Tickets
+
+		found := false
+		for j := range event.Gates {
+			if event.Gates[j].Gate == admission.Gate {
+				event.Gates[j].TicketsAdmitted += admission.Tickets
+				found = true
+				break
+			}
+		}
+		if !found {
+			event.Gates = append(event.Gates, models.GateOccupancy{
+				Gate:            admission.Gate,
+				TicketsAdmitted: admission.Tickets,
+			})
+		}
+
+		updateLiveEventOccupancy(event)
+		o.Updated = time.Now()
+
+		return event
+	}
+
+	return nil
+}
+
+func updateLiveEventOccupancy(event *models.LiveEventOccupancy) {
+	if event.Event.Capacity > 0 {
+		event.Occupancy = float64(event.TicketsAdmitted) / float64(event.Event.Capacity)
+	}
+}
+
+// publishGateAdmission lets the live occupancy streams know about an
+// admission. Commands running outside the server have no broker.
+func publishGateAdmission(ctx *config.AppContext, eventID int, gate string, tickets int) {
+	if ctx.Events == nil {
+		return
+	}
+
+	ctx.Events.Publish(gateAdmissionsTopic, models.GateAdmission{
+		EventID: eventID,
+		Gate:    gate,
+		Tickets: int64(tickets),
+	})
+}
@@ -0,0 +1,235 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// GetOccupancyReport reports, for each event of a day, the tickets sold, the
+// ones admitted at the gate and the hours people arrived at.
+func GetOccupancyReport(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetOccupancyReportRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetOccupancyReportOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	report, err := getOccupancyReport(ctx, opts.Date, opts.EventTypeID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting occupancy report")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, report, nil, "")
+}
+
+func getOccupancyReport(ctx *config.AppContext, date string, eventTypeID int) (*models.OccupancyReport, error) {
+	slots, err := ctx.DB.GetOccupancySlots(date, eventTypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	arrivals, err := ctx.DB.GetOccupancyArrivals(date, eventTypeID)
+	if err != nil {
+		return nil, err
+	}
+
+	report := models.OccupancyReport{
+		Date:     date,
+		Slots:    slots,
+		Arrivals: []models.HourlyArrivals{},
+	}
+
+	dayArrivals := make(map[int]int64)
+	for i := range report.Slots {
+		slot := &report.Slots[i]
+		slot.Arrivals = []models.HourlyArrivals{}
+		for _, arrival := range arrivals {
+			if arrival.EventID == slot.Event.ID {
+				slot.Arrivals = append(slot.Arrivals, arrival.HourlyArrivals)
+			}
+		}
+
+		if slot.Ended {
+			slot.NoShows = slot.TicketsSold - slot.TicketsAdmitted
+		}
+		if slot.Event.Capacity > 0 {
+			slot.Occupancy = float64(slot.TicketsAdmitted) / float64(slot.Event.Capacity)
+		}
+
+		report.TicketsSold += slot.TicketsSold
+		report.TicketsAdmitted += slot.TicketsAdmitted
+		report.NoShows += slot.NoShows
+	}
+
+	for _, arrival := range arrivals {
+		dayArrivals[arrival.Hour] += arrival.Tickets
+	}
+	for hour := 0; hour < 24; hour++ {
+		if tickets, ok := dayArrivals[hour]; ok {
+			report.Arrivals = append(report.Arrivals, models.HourlyArrivals{Hour: hour, Tickets: tickets})
+		}
+	}
+
+	return &report, nil
+}
+
+// RunDailyReports sends the report of the day once the configured park time
+// has passed, checking every few minutes for as long as the server runs.
+func RunDailyReports(ctx *config.AppContext) {
+	runPeriodically("daily-report", 5*time.Minute, func() error {
+		return sendScheduledDailyReport(ctx)
+	})
+}
+
+// sendScheduledDailyReport sends the report of today after the configured
+// time. The day is claimed first, so the report goes out once even with
+// several servers running or after a restart.
+func sendScheduledDailyReport(ctx *config.AppContext) error {
+	sendAt, err := time.Parse("15:04", ctx.Config.DailyReport.Time)
+	if err != nil {
+		return err
+	}
+
+	now := parkNow()
+	if now.Hour()*60+now.Minute() < sendAt.Hour()*60+sendAt.Minute() {
+		return nil
+	}
+
+	date := now.Format(db.ConstLayoutDate)
+	claimed, err := ctx.DB.ClaimDailyReport(date)
+	if err != nil || !claimed {
+		return err
+	}
+
+	if err := SendDailyReport(ctx, date); err != nil {
+		if releaseErr := ctx.DB.ReleaseDailyReport(date); releaseErr != nil {
+			config.GetLogger().WithError(releaseErr).Error("failed releasing daily report")
+		}
+		return err
+	}
+
+	return nil
+}
+
+// SendDailyReport emails the admins the attendance and sales of a day of the
+// park, today when date is empty.
+func SendDailyReport(ctx *config.AppContext, date string) error {
+	logger := config.GetLogger()
+
+	if date == "" {
+		date = time.Now().In(parkLocation()).Format("2006-01-02")
+	}
+	day, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return err
+	}
+
+	occupancy, err := getOccupancyReport(ctx, date, 0)
+	if err != nil {
+		return err
+	}
+
+	paymentMethods, err := ctx.DB.GetSalesAnalytics(&models.GetSalesAnalyticsOpts{DateFrom: date, DateTo: date, GroupBy: "payment_method"})
+	if err != nil {
+		return err
+	}
+
+	cashiers, err := ctx.DB.GetSalesAnalytics(&models.GetSalesAnalyticsOpts{DateFrom: date, DateTo: date, GroupBy: "cashier"})
+	if err != nil {
+		return err
+	}
+
+	data := models.DailyReportHTML{
+		Date:            day.Format("02-01-2006"),
+		TicketsSold:     occupancy.TicketsSold,
+		TicketsAdmitted: occupancy.TicketsAdmitted,
+		NoShows:         occupancy.NoShows,
+	}
+	for _, slot := range occupancy.Slots {
+		data.Slots = append(data.Slots, models.DailyReportSlotHTML{
+			Name:            slot.Event.Name,
+			Time:            slot.Event.StartDateTime.Format("15:04"),
+			TicketsSold:     slot.TicketsSold,
+			TicketsAdmitted: slot.TicketsAdmitted,
+			NoShows:         slot.NoShows,
+			Occupancy:       fmt.Sprintf("%.0f%%", slot.Occupancy*100),
+		})
+	}
+	for _, row := range paymentMethods {
+		data.Revenue += row.Revenue
+		data.Orders += row.Orders
+		data.PaymentMethods = append(data.PaymentMethods, dailyReportSales(row))
+	}
+	// Web orders are registered with the web user, they aren't a cashier.
+	for _, row := range cashiers {
+		if row.Key == strconv.Itoa(db.ConstWebUserID) {
+			continue
+		}
+		data.Cashiers = append(data.Cashiers, dailyReportSales(row))
+	}
+
+	admins, err := ctx.DB.GetUsers(&models.GetUsersOpts{RoleIDs: []int{db.ConstRoles.Admin}, LimitTo: 1000})
+	if err != nil {
+		return err
+	}
+
+	for _, admin := range admins.Users {
+		ed := &helpers.EmailData{
+			EmailTo:      admin.Email,
+			NameTo:       admin.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      fmt.Sprintf("%s %s", ctx.Config.Mail.DailyReport.Subject, data.Date),
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.DailyReport.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		data.Firstname = admin.Firstname
+		if err := ed.SendEmail(data); err != nil {
+			logger.WithError(err).WithField("user_id", admin.ID).Error("failed sending daily report")
+			continue
+		}
+	}
+
+	logger.WithField("admins", len(admins.Users)).Info("daily report sent")
+
+	return nil
+}
+
+func dailyReportSales(row models.SalesAnalyticsRow) models.DailyReportSalesHTML {
+	return models.DailyReportSalesHTML{
+		Name:    row.Label,
+		Orders:  row.Orders,
+		Tickets: row.TicketsSold,
+		Revenue: row.Revenue,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"bitbucket.org/parqueoasis/backend/config"
@@ -26,7 +27,7 @@ func InsertOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.
 	// 	w.WriteJSON(http.StatusInternalServerError, nil, err, "failed loading time location")
 	// }
 
-	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient && !userInfo.IsReseller {
 		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
 		return
 	}
@@ -46,7 +47,7 @@ func InsertOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.
 
 	userID := userInfo.ID
 	if userInfo.IsClient {
-		userID = 1
+		userID = db.ConstWebUserID
 	}
 
 	event, err := ctx.DB.GetEventByID(opts.EventID)
@@ -65,14 +66,103 @@ func InsertOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.
 		return
 	}
 
-	order, err := ctx.DB.InsertOrder(userID, opts.UserID, event.ID, opts.Tickets, event.Price)
-	if err != nil {
-		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+	if userInfo.IsReseller && !userInfo.IsAdmin && !userInfo.IsCashier {
+		allotment, err := ctx.DB.GetResellerAllotment(userInfo.ID, event.ID)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+
+		if allotment == nil {
+			w.WriteJSON(http.StatusForbidden, nil, nil, "No tienes cupo asignado para este evento")
+			return
+		}
+
+		if allotment.Available() < opts.Tickets {
+			w.WriteJSON(http.StatusBadRequest, allotment, nil, "No quedan suficientes entradas en tu cupo")
+			return
+		}
+
+		order, err := ctx.DB.InsertResellerOrder(userInfo.ID, opts.UserID, allotment, opts.Tickets)
+		if err == db.ErrResellerAllotmentExceeded {
+			w.WriteJSON(http.StatusBadRequest, nil, err, "No quedan suficientes entradas en tu cupo")
+			return
+		}
+		if err == db.ErrEventSoldOut {
+			w.WriteJSON(http.StatusBadRequest, nil, err, "No quedan entradas disponibles para este evento")
+			return
+		}
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+
+		order.Event = event
+
+		w.WriteJSON(http.StatusOK, order, nil, "")
 		return
 	}
 
+	var waitlistEntry *models.WaitlistEntry
+	if opts.WaitlistToken != "" {
+		var ok bool
+		waitlistEntry, ok = getWaitlistOffer(ctx, w, opts.WaitlistToken, opts.UserID, event.ID, opts.Tickets)
+		if !ok {
+			return
+		}
+	}
+
+	var waitlistEntryID int
+	if waitlistEntry != nil {
+		waitlistEntryID = waitlistEntry.ID
+	}
+
+	var order *models.Order
+	if opts.VoucherCode != "" {
+		reservationExpires := time.Now().Add(time.Duration(ctx.Config.GiftVoucher.ReservationMinutes) * time.Minute)
+		order, _, err = ctx.DB.InsertVoucherOrder(userID, opts.UserID, event, opts.Tickets, strings.TrimSpace(opts.VoucherCode), reservationExpires, waitlistEntryID)
+		if err == db.ErrGiftVoucherInvalid {
+			w.WriteJSON(http.StatusBadRequest, nil, err, "La gift card no es válida para esta compra")
+			return
+		}
+		if err == db.ErrEventSoldOut {
+			w.WriteJSON(http.StatusBadRequest, nil, err, "No quedan entradas disponibles para este evento")
+			return
+		}
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+
+		if order.Price == 0 {
+			sendPaidOrderEmail(ctx, w, order.ID, db.ConstPaymentMethods.Voucher.Name)
+		}
+	} else {
+		reservedUntil := time.Now().Add(time.Duration(ctx.Config.OrderReservationMinutes) * time.Minute)
+		if waitlistEntry != nil && waitlistEntry.OfferExpires != nil && waitlistEntry.OfferExpires.After(reservedUntil) {
+			// The order takes over the hold of the offer, for as long as the
+			// offer promised.
+			reservedUntil = *waitlistEntry.OfferExpires
+		}
+		order, err = ctx.DB.InsertOrder(userID, opts.UserID, event.ID, opts.Tickets, event.Price, reservedUntil, waitlistEntryID)
+		if err == db.ErrEventSoldOut {
+			w.WriteJSON(http.StatusBadRequest, nil, err, "No quedan entradas disponibles para este evento")
+			return
+		}
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+		order.Price = event.Price * opts.Tickets
+	}
+
+	if waitlistEntry != nil {
+		if err := ctx.DB.PurchaseWaitlistEntry(waitlistEntry.ID, order.ID); err != nil {
+			w.LogError(err, "failed updating waitlist entry")
+		}
+	}
+
 	order.Event = event
-	order.Price = event.Price * opts.Tickets
 
 	w.WriteJSON(http.StatusOK, order, nil, "")
 }
@@ -185,6 +275,21 @@ func UseOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Req
 		return
 	}
 
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UseOrderRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.UseOrderOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
 	order, err := ctx.DB.GetOrderByID(id)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
@@ -216,13 +321,8 @@ func UseOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Req
 		return
 	}
 
-	if !time.Now().After(order.Event.StartDateTime.Add(-4*time.Hour)) && !time.Now().Equal(order.Event.StartDateTime) {
-		w.WriteJSON(http.StatusBadRequest, nil, nil, "El evento no ha empezado")
-		return
-	}
-
-	if !time.Now().Before(order.Event.EndDateTime.Add(4 * time.Hour)) {
-		w.WriteJSON(http.StatusBadRequest, nil, nil, "El evento ya ha terminado")
+	if msg := eventEntryError(order.Event); msg != "" {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, msg)
 		return
 	}
 
@@ -233,14 +333,36 @@ func UseOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Req
 		}
 	}
 
-	if err := ctx.DB.UseOrder(order.ID, userInfo.ID); err != nil {
+	if err := ctx.DB.UseOrder(order.ID, userInfo.ID, strings.TrimSpace(opts.Gate)); err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
 		return
 	}
 
+	publishGateAdmission(ctx, order.Event.ID, strings.TrimSpace(opts.Gate), order.Tickets)
+
+	usedOrder, err := ctx.DB.GetOrderByID(order.ID)
+	if err != nil {
+		w.LogError(err, "failed getting used order")
+	}
+	w.Audit(db.ConstAuditActions.OrderUse, db.ConstAuditEntities.Order, order.ID, order, usedOrder)
+
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 }
 
+// eventEntryError tells why the gate can't admit visitors to the event right
+// now, or returns an empty string when it can.
+func eventEntryError(event *models.Event) string {
+	if !time.Now().After(event.StartDateTime.Add(-4*time.Hour)) && !time.Now().Equal(event.StartDateTime) {
+		return "El evento no ha empezado"
+	}
+
+	if !time.Now().Before(event.EndDateTime.Add(4 * time.Hour)) {
+		return "El evento ya ha terminado"
+	}
+
+	return ""
+}
+
 func UpdateOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
 	userInfo := models.InfoUser{}
 	mapstructure.Decode(r.Context().Value("user"), &userInfo)
@@ -293,6 +415,12 @@ func UpdateOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.
 		return
 	}
 
+	updatedOrder, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		w.LogError(err, "failed getting updated order")
+	}
+	w.Audit(db.ConstAuditActions.OrderUpdate, db.ConstAuditEntities.Order, orderID, order, updatedOrder)
+
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 }
 
@@ -317,42 +445,61 @@ func GetSalesSummary(ctx *config.AppContext, w *middlewares.ResponseWriter, r *h
 	}
 
 	var salesSummary models.SalesSummary
-	monthlyCurrentYearSalesMap := make(map[string]int64)
-	monthlyLastYearSalesMap := make(map[string]int64)
+	monthlyCurrentYearSalesMap := make(map[string]*models.MonthlySalesSummaryDetail)
+	monthlyLastYearSalesMap := make(map[string]*models.MonthlySalesSummaryDetail)
 	currentYear, currentMonth, currentDay := time.Now().Date()
 
+	addMonthlySales := func(salesMap map[string]*models.MonthlySalesSummaryDetail, year int, dailySale models.DailySales) {
+		month := dailySale.Date.Month().String()
+		if salesMap[month] == nil {
+			salesMap[month] = &models.MonthlySalesSummaryDetail{
+				Year:  year,
+				Month: month,
+			}
+		}
+		salesMap[month].Total += dailySale.Total
+		salesMap[month].Net += dailySale.Net
+		salesMap[month].Tax += dailySale.Tax
+	}
+
 	for _, dailySale := range dailySales {
 		dailySaleYear, dailySaleMonth, dailySaleDay := dailySale.Date.Date()
 		if dailySaleYear == currentYear && dailySaleMonth == currentMonth && dailySaleDay == currentDay {
 			salesSummary.CurrentDay += dailySale.Total
+			salesSummary.CurrentDayNet += dailySale.Net
+			salesSummary.CurrentDayTax += dailySale.Tax
 		}
 		if dailySaleYear == currentYear && dailySaleMonth == currentMonth {
 			salesSummary.CurrentMonth += dailySale.Total
+			salesSummary.CurrentMonthNet += dailySale.Net
+			salesSummary.CurrentMonthTax += dailySale.Tax
 		}
 		if dailySaleYear == currentYear {
 			salesSummary.CurrentYear += dailySale.Total
-			monthlyCurrentYearSalesMap[dailySaleMonth.String()] += dailySale.Total
+			salesSummary.CurrentYearNet += dailySale.Net
+			salesSummary.CurrentYearTax += dailySale.Tax
+			addMonthlySales(monthlyCurrentYearSalesMap, currentYear, dailySale)
 		}
 		if dailySaleYear+1 == currentYear {
-			monthlyLastYearSalesMap[dailySaleMonth.String()] += dailySale.Total
+			addMonthlySales(monthlyLastYearSalesMap, currentYear-1, dailySale)
 		}
 	}
 
-	for month, total := range monthlyCurrentYearSalesMap {
-		salesSummary.MonthlyCurrentYear = append(salesSummary.MonthlyCurrentYear, models.MonthlySalesSummaryDetail{
-			Year:  currentYear,
-			Month: month,
-			Total: total,
-		})
+	for _, monthlySales := range monthlyCurrentYearSalesMap {
+		salesSummary.MonthlyCurrentYear = append(salesSummary.MonthlyCurrentYear, *monthlySales)
 	}
 
-	for month, total := range monthlyLastYearSalesMap {
-		salesSummary.MonthlyLastYear = append(salesSummary.MonthlyLastYear, models.MonthlySalesSummaryDetail{
-			Year:  currentYear - 1,
-			Month: month,
-			Total: total,
-		})
+	for _, monthlySales := range monthlyLastYearSalesMap {
+		salesSummary.MonthlyLastYear = append(salesSummary.MonthlyLastYear, *monthlySales)
+	}
+
+	today := time.Now().Format(db.ConstLayoutDate)
+	liability, err := ctx.DB.GetGiftVoucherLiability(today, today)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
 	}
+	salesSummary.VoucherLiability = liability.Outstanding
 
 	w.WriteJSON(http.StatusOK, salesSummary, nil, "")
 }
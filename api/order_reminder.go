@@ -0,0 +1,114 @@
+package api
+
+import (
+	"fmt"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/models"
+	log "github.com/sirupsen/logrus"
+)
+
+// RunVisitReminders sends the visit reminders right away and then every
+// configured interval, for as long as the server runs.
+func RunVisitReminders(ctx *config.AppContext) {
+	runPeriodically("visit-reminders", minutesOrDefault(ctx.Config.Reminder.IntervalMinutes, 10), func() error {
+		return SendVisitReminders(ctx)
+	})
+}
+
+// SendVisitReminders emails the clients whose paid orders start within the
+// configured hours their tickets, the park rules and a link to reschedule.
+// Each order is reminded once per event, failed emails are retried up to the
+// configured attempts.
+func SendVisitReminders(ctx *config.AppContext) error {
+	logger := config.GetLogger()
+
+	orderIDs, err := ctx.DB.GetPendingReminderOrderIDs(ctx.Config.Reminder.Hours, ctx.Config.Reminder.MaxAttempts, ctx.Config.Reminder.BatchSize)
+	if err != nil {
+		return err
+	}
+
+	for _, orderID := range orderIDs {
+		orderLogger := logger.WithField("order_id", orderID)
+
+		order, err := ctx.DB.GetOrderByID(orderID)
+		if err != nil {
+			orderLogger.WithError(err).Error("failed getting order")
+			continue
+		}
+
+		if order == nil {
+			continue
+		}
+
+		claimed, err := ctx.DB.ClaimOrderReminder(order.ID, order.Event.ID, ctx.Config.Reminder.MaxAttempts)
+		if err != nil {
+			orderLogger.WithError(err).Error("failed claiming visit reminder")
+			continue
+		}
+
+		if !claimed {
+			continue
+		}
+
+		status, lastError := db.ConstOrderReminderStatuses.Sent, ""
+		if err := sendVisitReminderEmail(ctx, order); err != nil {
+			orderLogger.WithError(err).Error("failed sending visit reminder")
+			status, lastError = db.ConstOrderReminderStatuses.Failed, err.Error()
+		}
+
+		if err := ctx.DB.UpdateOrderReminderStatus(order.ID, order.Event.ID, status, lastError); err != nil {
+			orderLogger.WithError(err).Error("failed updating visit reminder")
+			continue
+		}
+
+		if status == db.ConstOrderReminderStatuses.Sent {
+			orderLogger.WithFields(log.Fields{
+				"event_id": order.Event.ID,
+			}).Info("success sending visit reminder")
+		}
+	}
+
+	return nil
+}
+
+func sendVisitReminderEmail(ctx *config.AppContext, order *models.Order) error {
+	pdfBuffer, err := helpers.GenerateOrderPDF(order)
+	if err != nil {
+		return err
+	}
+
+	ed := &helpers.EmailData{
+		EmailTo:      order.Client.Email,
+		NameTo:       order.Client.Firstname,
+		EmailFrom:    ctx.Config.Mail.EmailFrom,
+		NameFrom:     ctx.Config.Mail.NameFrom,
+		Subject:      ctx.Config.Mail.VisitReminder.Subject,
+		TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.VisitReminder.Template),
+		FileName:     ctx.Config.Mail.VisitReminder.FileName,
+		FileContent:  pdfBuffer.Bytes(),
+		AwsSMTP:      ctx.AwsSMTP,
+	}
+
+	data := models.VisitReminderHTML{
+		Firstname:     order.Client.Firstname,
+		Lastname:      order.Client.Lastname,
+		EventType:     order.Event.Type.Name,
+		Date:          order.Event.StartDateTime.Format("02-01-2006"),
+		Time:          order.Event.StartDateTime.Format(db.ConstLayoutTime),
+		Tickets:       order.Tickets,
+		TransactionID: order.TransactionID,
+	}
+
+	// The link is left out once the order can no longer be rescheduled.
+	deadline := order.Event.StartDateTime.Add(-time.Duration(ctx.Config.Reschedule.DeadlineHours) * time.Hour)
+	if parkNow().Before(deadline) {
+		data.RescheduleURL = fmt.Sprintf("%s%s/%d", ctx.Config.FrontendBaseURL, ctx.Config.FrontendReschedulePath, order.ID)
+		data.RescheduleDeadline = deadline.Format("02-01-2006 15:04")
+	}
+
+	return ed.SendEmail(data)
+}
@@ -0,0 +1,335 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+func GetOrderRescheduleQuote(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing order id")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetOrderRescheduleQuoteRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetOrderRescheduleQuoteOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	reschedule, ok := quoteOrderReschedule(ctx, w, userInfo, orderID, opts.EventID)
+	if !ok {
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, reschedule, nil, "")
+}
+
+// RescheduleOrder moves a client's order to another event of the same type.
+// When the client owes money the change waits for the Mercado Pago payment,
+// which is completed by the payment notification.
+func RescheduleOrder(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing order id")
+		return
+	}
+
+	var opts models.RescheduleOrderOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.RescheduleOrderRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	reschedule, ok := quoteOrderReschedule(ctx, w, userInfo, orderID, opts.EventID)
+	if !ok {
+		return
+	}
+
+	result := models.OrderRescheduleResult{
+		Reschedule: reschedule,
+	}
+
+	if reschedule.Amount > 0 {
+		response, err := ctx.MercadoPago.MPCreateChargePreference(
+			strconv.Itoa(reschedule.Order.ID),
+			"Cambio de fecha",
+			fmt.Sprintf("%s-%s", reschedule.ToEvent.StartDateTime.String(), reschedule.ToEvent.EndDateTime.String()),
+			reschedule.Amount,
+			ctx.Config.BackendBaseURL,
+		)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "problems with Mercado Pago")
+			return
+		}
+
+		if response == nil || response.ExternalReference == "" {
+			w.WriteJSON(http.StatusInternalServerError, nil, nil, "bad response from Mercado Pago")
+			return
+		}
+
+		reschedule.PreferenceID = response.ExternalReference
+		result.Payment = response
+	}
+
+	// The difference of a cheaper event is given back as a gift voucher.
+	if reschedule.Amount < 0 {
+		reschedule.CreditVoucher, err = newCreditGiftVoucher(ctx, reschedule.Order, -reschedule.Amount, fmt.Sprintf("Saldo a favor por el cambio de fecha de la orden %d", reschedule.Order.ID))
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating credit voucher")
+			return
+		}
+	}
+
+	_, err = ctx.DB.InsertOrderReschedule(reschedule)
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event sold out")
+		return
+	}
+	if err == db.ErrOrderRescheduleInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order can't be rescheduled")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting reschedule")
+		return
+	}
+
+	if reschedule.Status == db.ConstOrderRescheduleStatuses.Completed {
+		sendOrderRescheduledEmail(ctx, w, reschedule)
+		if reschedule.CreditVoucher != nil {
+			sendGiftVoucherEmail(ctx, w, reschedule.CreditVoucher)
+		}
+		go processEventWaitlist(ctx, config.GetLogger(), reschedule.FromEvent.ID)
+	}
+
+	w.WriteJSON(http.StatusOK, result, nil, "")
+}
+
+// quoteOrderReschedule checks the reschedule rules and prices the change.
+// Only the client that owns the order can reschedule it, staff keeps using
+// UpdateOrder.
+func quoteOrderReschedule(ctx *config.AppContext, w *middlewares.ResponseWriter, userInfo models.InfoUser, orderID int, eventID int) (*models.OrderReschedule, bool) {
+	if !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return nil, false
+	}
+
+	order, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return nil, false
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return nil, false
+	}
+
+	if order.Client == nil || order.Client.ID != userInfo.ID {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid user")
+		return nil, false
+	}
+
+	if order.Payment == nil || order.Payment.Status == nil || order.Payment.Status.ID != db.ConstPaymentStatuses.Approved.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order not paid")
+		return nil, false
+	}
+
+	if order.Used != nil && *order.Used {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order already used")
+		return nil, false
+	}
+
+	if order.Event == nil {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order has no event")
+		return nil, false
+	}
+
+	deadline := order.Event.StartDateTime.Add(-time.Duration(ctx.Config.Reschedule.DeadlineHours) * time.Hour)
+	if parkNow().After(deadline) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "reschedule deadline passed")
+		return nil, false
+	}
+
+	event, err := ctx.DB.GetEventByID(eventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event")
+		return nil, false
+	}
+
+	if event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event not found")
+		return nil, false
+	}
+
+	if event.ID == order.Event.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order already belongs to this event")
+		return nil, false
+	}
+
+	if event.Type == nil || order.Event.Type == nil || event.Type.ID != order.Event.Type.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event type doesn't match")
+		return nil, false
+	}
+
+	if !event.StartDateTime.After(parkNow()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event already started")
+		return nil, false
+	}
+
+	available, err := ctx.DB.GetEventAvailableTickets(event.ID, 0)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event available tickets")
+		return nil, false
+	}
+
+	if available >= 0 && available < order.Tickets {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event sold out")
+		return nil, false
+	}
+
+	// The part of the order paid with a gift voucher counts as paid.
+	voucherAmount, err := ctx.DB.GetOrderVoucherAmount(order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order voucher amount")
+		return nil, false
+	}
+
+	priceDifference := event.Price*order.Tickets - order.Price - voucherAmount
+	fee := ctx.Config.Reschedule.Fee
+
+	return &models.OrderReschedule{
+		Order:           order,
+		User:            &models.User{ID: userInfo.ID},
+		FromEvent:       order.Event,
+		ToEvent:         event,
+		PriceDifference: priceDifference,
+		Fee:             fee,
+		Amount:          priceDifference + fee,
+	}, true
+}
+
+// updateOrderReschedulePayment completes a reschedule once Mercado Pago
+// approves the payment of the difference.
+func updateOrderReschedulePayment(ctx *config.AppContext, w *middlewares.ResponseWriter, reschedule *models.OrderReschedule, paymentStatus *models.PaymentStatus) {
+	if reschedule.Status != db.ConstOrderRescheduleStatuses.Pending {
+		w.LogInfo(reschedule, "reschedule is not pending")
+		return
+	}
+
+	if paymentStatus.ID != db.ConstPaymentStatuses.Approved.ID {
+		w.LogInfo(paymentStatus, "reschedule payment not approved")
+		return
+	}
+
+	err := ctx.DB.CompleteOrderReschedule(reschedule)
+	if err == db.ErrEventSoldOut || err == db.ErrOrderRescheduleInvalid {
+		// The client already paid, so the reschedule is flagged for the
+		// backoffice to refund it.
+		w.LogError(err, "paid reschedule can't be completed")
+		if err := ctx.DB.UpdateOrderRescheduleStatus(reschedule.ID, db.ConstOrderRescheduleStatuses.Failed); err != nil {
+			w.LogError(err, "failed updating reschedule status")
+		}
+		return
+	}
+	if err != nil {
+		w.LogError(err, "failed completing reschedule")
+		return
+	}
+
+	sendOrderRescheduledEmail(ctx, w, reschedule)
+	go processEventWaitlist(ctx, config.GetLogger(), reschedule.FromEvent.ID)
+}
+
+func sendOrderRescheduledEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, reschedule *models.OrderReschedule) {
+	go func(ctx *config.AppContext, reschedule *models.OrderReschedule) {
+		order, err := ctx.DB.GetOrderByID(reschedule.Order.ID)
+		if err != nil {
+			w.LogError(err, "failed getting order")
+			return
+		}
+
+		if order == nil {
+			w.LogError(nil, "order not found")
+			return
+		}
+
+		pdfBuffer, err := helpers.GenerateOrderPDF(order)
+		if err != nil {
+			w.LogError(err, "failed generating PDF")
+			return
+		}
+
+		ed := &helpers.EmailData{
+			EmailTo:      order.Client.Email,
+			NameTo:       order.Client.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.OrderRescheduled.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.OrderRescheduled.Template),
+			FileName:     ctx.Config.Mail.OrderRescheduled.FileName,
+			FileContent:  pdfBuffer.Bytes(),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		data := models.OrderRescheduledHTML{
+			Firstname:     order.Client.Firstname,
+			Lastname:      order.Client.Lastname,
+			EventType:     order.Event.Type.Name,
+			Date:          order.Event.StartDateTime.Format("02-01-2006"),
+			Tickets:       order.Tickets,
+			TransactionID: order.TransactionID,
+		}
+		if reschedule.Amount > 0 {
+			data.Amount = reschedule.Amount
+		}
+		if reschedule.CreditVoucher != nil {
+			data.Credit = reschedule.CreditVoucher.Amount
+			data.CreditCode = reschedule.CreditVoucher.Code
+		}
+
+		if err := ed.SendEmail(data); err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, reschedule)
+}
@@ -0,0 +1,482 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// canManageOrder tells if the user is staff or the client that owns the order.
+func canManageOrder(userInfo models.InfoUser, order *models.Order) bool {
+	if userInfo.IsAdmin || userInfo.IsCashier {
+		return true
+	}
+	return userInfo.IsClient && order.Client != nil && order.Client.ID == userInfo.ID
+}
+
+func InsertOrderTransfer(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing order id")
+		return
+	}
+
+	var opts models.InsertOrderTransferOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertOrderTransferRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+	opts.Email = strings.ToLower(strings.TrimSpace(opts.Email))
+
+	order, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return
+	}
+
+	if !canManageOrder(userInfo, order) {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	if order.Payment == nil || order.Payment.Status == nil || order.Payment.Status.ID != db.ConstPaymentStatuses.Approved.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order not paid")
+		return
+	}
+
+	if order.Used != nil && *order.Used {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order already used")
+		return
+	}
+
+	if order.Event == nil || order.Event.EndDateTime.Before(parkNow()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event already ended")
+		return
+	}
+
+	if strings.EqualFold(order.Client.Email, opts.Email) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order already belongs to this email")
+		return
+	}
+
+	pending, err := ctx.DB.GetPendingOrderTransfer(order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting pending transfer")
+		return
+	}
+
+	if pending != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order has a pending transfer")
+		return
+	}
+
+	token, err := helpers.GenerateRandomToken()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating transfer token")
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(ctx.Config.OrderTransferHours) * time.Hour)
+
+	transferID, err := ctx.DB.InsertOrderTransfer(order.ID, order.Client.ID, userInfo.ID, opts.Email, helpers.HashToken(token), expires)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting transfer")
+		return
+	}
+
+	go func(ctx *config.AppContext, order *models.Order, email string, token string, expires time.Time) {
+		ed := &helpers.EmailData{
+			EmailTo:      email,
+			NameTo:       email,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.OrderTransfer.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.OrderTransfer.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err := ed.SendEmail(models.OrderTransferHTML{
+			Firstname: order.Client.Firstname,
+			Lastname:  order.Client.Lastname,
+			EventType: order.Event.Type.Name,
+			Date:      order.Event.StartDateTime.Format("02-01-2006"),
+			Tickets:   order.Tickets,
+			Expires:   expires.Format("02-01-2006 15:04"),
+			URL:       fmt.Sprintf("%s%s/%s", ctx.Config.FrontendBaseURL, ctx.Config.FrontendOrderTransferPath, token),
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, order, opts.Email, token, expires)
+
+	w.WriteJSON(http.StatusOK, models.OrderTransfer{
+		ID:      transferID,
+		Email:   opts.Email,
+		Status:  db.ConstOrderTransferStatuses.Pending,
+		Expires: expires,
+	}, nil, "")
+}
+
+func CancelOrderTransfer(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing order id")
+		return
+	}
+
+	order, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return
+	}
+
+	if !canManageOrder(userInfo, order) {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	pending, err := ctx.DB.GetPendingOrderTransfer(order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting pending transfer")
+		return
+	}
+
+	if pending == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "transfer not found")
+		return
+	}
+
+	err = ctx.DB.CancelOrderTransfer(pending.ID, order.ID, userInfo.ID)
+	if err == db.ErrOrderTransferInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "transfer is no longer pending")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed cancelling transfer")
+		return
+	}
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+// GetOrderTransfer lets the recipient preview the transfer before accepting
+// it, and tells the frontend whether an account must be created or is
+// inactive.
+func GetOrderTransfer(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetOrderTransferRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetOrderTransferOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	transfer, ok := getPendingOrderTransferByToken(ctx, w, opts.Token)
+	if !ok {
+		return
+	}
+
+	order, err := ctx.DB.GetOrderByID(transfer.Order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return
+	}
+
+	transfer.Order = &models.Order{
+		ID:      order.ID,
+		Event:   order.Event,
+		Tickets: order.Tickets,
+	}
+
+	w.WriteJSON(http.StatusOK, transfer, nil, "")
+}
+
+func getPendingOrderTransferByToken(ctx *config.AppContext, w *middlewares.ResponseWriter, token string) (*models.OrderTransfer, bool) {
+	transfer, err := ctx.DB.GetOrderTransferByToken(helpers.HashToken(token))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting transfer")
+		return nil, false
+	}
+
+	if transfer == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "transfer not found")
+		return nil, false
+	}
+
+	if transfer.Status != db.ConstOrderTransferStatuses.Pending {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "transfer is no longer pending")
+		return nil, false
+	}
+
+	if transfer.Expires.Before(time.Now()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "transfer expired")
+		return nil, false
+	}
+
+	return transfer, true
+}
+
+// AcceptOrderTransfer moves the order to the recipient, creating a client
+// account for them when the email isn't registered. Receiving the token by
+// email proves ownership of the address, so the account is created verified.
+func AcceptOrderTransfer(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	var opts models.AcceptOrderTransferOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.AcceptOrderTransferRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	transfer, ok := getPendingOrderTransferByToken(ctx, w, opts.Token)
+	if !ok {
+		return
+	}
+
+	recipient, err := ctx.DB.GetUserLoginByEmail(transfer.Email)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting recipient")
+		return
+	}
+
+	// A deactivated account keeps its email, so signing up again would only
+	// fail with a duplicate; the park has to reactivate it first.
+	if recipient == nil && transfer.AccountInactive {
+		w.WriteJSON(http.StatusConflict, nil, nil, "recipient account is inactive")
+		return
+	}
+
+	if recipient == nil {
+		recipient, ok = insertOrderTransferRecipient(ctx, w, transfer.Email, &opts)
+		if !ok {
+			return
+		}
+	}
+
+	transactionID, err := ctx.DB.AcceptOrderTransfer(transfer, recipient.ID)
+	if err == db.ErrOrderTransferInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "transfer is no longer valid")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed accepting transfer")
+		return
+	}
+
+	order, err := ctx.DB.GetOrderByID(transfer.Order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return
+	}
+
+	pdfBuffer, err := helpers.GenerateOrderPDF(order)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating pdf")
+		return
+	}
+
+	go func(ctx *config.AppContext, order *models.Order, pdfBuffer *bytes.Buffer) {
+		ed := &helpers.EmailData{
+			EmailTo:      order.Client.Email,
+			NameTo:       order.Client.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.OrderTransferred.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.OrderTransferred.Template),
+			FileName:     ctx.Config.Mail.OrderTransferred.FileName,
+			FileContent:  pdfBuffer.Bytes(),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err := ed.SendEmail(models.OrderTransferredHTML{
+			Firstname:     order.Client.Firstname,
+			Lastname:      order.Client.Lastname,
+			EventType:     order.Event.Type.Name,
+			Date:          order.Event.StartDateTime.Format("02-01-2006"),
+			Tickets:       order.Tickets,
+			TransactionID: order.TransactionID,
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, order, pdfBuffer)
+
+	// The endpoint is public, so only return what the recipient needs to
+	// find their tickets, never the order's client or payment details.
+	w.WriteJSON(http.StatusOK, &models.Order{
+		ID:            order.ID,
+		TransactionID: transactionID,
+		Event:         order.Event,
+		Tickets:       order.Tickets,
+	}, nil, "")
+}
+
+func insertOrderTransferRecipient(ctx *config.AppContext, w *middlewares.ResponseWriter, email string, opts *models.AcceptOrderTransferOpts) (*models.User, bool) {
+	missing := make(map[string][]string)
+	for field, value := range map[string]string{
+		"password":  opts.Password,
+		"firstname": opts.Firstname,
+		"lastname":  opts.Lastname,
+		"dni":       opts.DNI,
+		"phone":     opts.Phone,
+	} {
+		if value == "" {
+			missing[field] = []string{fmt.Sprintf("The %s field is required", field)}
+		}
+	}
+	if len(missing) > 0 {
+		w.WriteJSON(http.StatusBadRequest, missing, nil, "failed validations")
+		return nil, false
+	}
+
+	documentType, dni, ok := normalizeUserDNI(opts.DocumentType, opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid dni")
+		return nil, false
+	}
+
+	emailCounter, dniCounter, err := ctx.DB.ValidateUserEmailAndDNI(email, dni)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed validating email and dni")
+		return nil, false
+	}
+
+	if emailCounter > 0 {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "email exists")
+		return nil, false
+	}
+
+	if dniCounter > 0 {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "dni exists")
+		return nil, false
+	}
+
+	password, err := helpers.HashPassword(opts.Password)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed hashing password")
+		return nil, false
+	}
+
+	userID, err := ctx.DB.InsertUser(&models.InsertAdminUserOpts{
+		Email:         email,
+		Password:      password,
+		Firstname:     opts.Firstname,
+		Lastname:      opts.Lastname,
+		DNI:           dni,
+		DocumentType:  documentType,
+		Phone:         opts.Phone,
+		Roles:         []int{db.ConstRoles.Client},
+		EmailVerified: true,
+	})
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting user")
+		return nil, false
+	}
+
+	return &models.User{
+		ID:        userID,
+		Email:     email,
+		Firstname: opts.Firstname,
+		Lastname:  opts.Lastname,
+	}, true
+}
+
+func GetOrderHistory(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing order id")
+		return
+	}
+
+	order, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return
+	}
+
+	if !canManageOrder(userInfo, order) {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	history, err := ctx.DB.GetOrderHistory(order.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order history")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, history, nil, "")
+}
@@ -15,6 +15,7 @@ import (
 	"bitbucket.org/parqueoasis/backend/middlewares"
 	"bitbucket.org/parqueoasis/backend/models"
 	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
 	"github.com/lithammer/shortuuid/v3"
 	"github.com/mitchellh/mapstructure"
 	"github.com/thedevsaddam/govalidator"
@@ -51,6 +52,17 @@ func InsertPaymentMercadoPago(ctx *config.AppContext, w *middlewares.ResponseWri
 		return
 	}
 
+	client, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if client == nil || !client.EmailVerified {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "email not verified")
+		return
+	}
+
 	if order.Payment != nil {
 		if order.Payment.Status != nil {
 			if order.Payment.Status.ID == db.ConstPaymentStatuses.Approved.ID {
@@ -64,7 +76,20 @@ func InsertPaymentMercadoPago(ctx *config.AppContext, w *middlewares.ResponseWri
 		}
 	}
 
-	response, err := ctx.MercadoPago.MPCreatePreference(order, ctx.Config.BackendBaseURL)
+	// The seats are held while the client pays, and the preference expires
+	// with them so it can't be paid once they may have been sold.
+	reservedUntil := time.Now().Add(time.Duration(ctx.Config.OrderReservationMinutes) * time.Minute)
+	err = ctx.DB.ReserveOrder(order.ID, reservedUntil)
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "event sold out")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed reserving order")
+		return
+	}
+
+	response, err := ctx.MercadoPago.MPCreatePreference(order, ctx.Config.BackendBaseURL, reservedUntil)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "problems with Mercado Pago")
 		return
@@ -145,11 +170,55 @@ func UpdatePaymentMercadoPago(ctx *config.AppContext, w *middlewares.ResponseWri
 		return
 	}
 
-	if err := ctx.DB.UpdatePaymentStatus(response.ExternalReference, paymentStatus.ID); err != nil {
+	reschedule, err := ctx.DB.GetOrderRescheduleByPreferenceID(response.ExternalReference)
+	if err != nil {
+		w.LogError(err, "failed getting reschedule")
+		return
+	}
+
+	if reschedule != nil {
+		updateOrderReschedulePayment(ctx, w, reschedule, paymentStatus)
+		return
+	}
+
+	pass, err := ctx.DB.GetSeasonPassByPreferenceID(response.ExternalReference)
+	if err != nil {
+		w.LogError(err, "failed getting season pass")
+		return
+	}
+
+	if pass != nil {
+		updateSeasonPassPayment(ctx, w, pass, paymentStatus)
+		return
+	}
+
+	voucher, err := ctx.DB.GetGiftVoucherByPreferenceID(response.ExternalReference)
+	if err != nil {
+		w.LogError(err, "failed getting gift voucher")
+		return
+	}
+
+	if voucher != nil {
+		updateGiftVoucherPayment(ctx, w, voucher, paymentStatus)
+		return
+	}
+
+	err = ctx.DB.UpdatePaymentStatus(response.ExternalReference, paymentStatus.ID)
+	if err == db.ErrEventSoldOut {
+		refundSoldOutPayment(ctx, w, opts.Data.ID, response.ExternalReference)
+		return
+	}
+	if err != nil {
 		w.LogError(err, "failed updating payment")
 		return
 	}
 
+	go issuePaymentTaxDocumentByReference(ctx, config.GetLogger(), response.ExternalReference, paymentStatus.ID)
+
+	if paymentStatus.ID == db.ConstPaymentStatuses.Reversed.ID {
+		go offerReversedOrderTickets(ctx, response.ExternalReference)
+	}
+
 	go func(ctx *config.AppContext, externalReference string) {
 		order, err := ctx.DB.GetOrderByExternalReference(externalReference)
 		if err != nil {
@@ -181,6 +250,9 @@ func UpdatePaymentMercadoPago(ctx *config.AppContext, w *middlewares.ResponseWri
 			Lastname:      order.Client.Lastname,
 			PaymentMethod: db.ConstPaymentMethods.MercadoPago.Name,
 			OrderPrice:    order.Price,
+			NetAmount:     order.NetAmount,
+			TaxAmount:     order.TaxAmount,
+			TaxRate:       models.TaxRateLabel(order.TaxRate),
 			TransactionID: order.TransactionID,
 			Tickets:       order.Tickets,
 			Date:          time.Now().Format("02-01-2016"),
@@ -201,11 +273,13 @@ func InsertPaymentCashier(ctx *config.AppContext, w *middlewares.ResponseWriter,
 	userInfo := models.InfoUser{}
 	mapstructure.Decode(r.Context().Value("user"), &userInfo)
 
-	if !userInfo.IsAdmin && !userInfo.IsCashier {
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsReseller {
 		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
 		return
 	}
 
+	isReseller := userInfo.IsReseller && !userInfo.IsAdmin && !userInfo.IsCashier
+
 	vars := mux.Vars(r)
 	orderID, err := strconv.Atoi(vars["order_id"])
 	if err != nil {
@@ -224,6 +298,11 @@ func InsertPaymentCashier(ctx *config.AppContext, w *middlewares.ResponseWriter,
 		return
 	}
 
+	if isReseller && order.User.ID != userInfo.ID {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid user")
+		return
+	}
+
 	if order.Payment != nil {
 		if order.Payment.Status != nil {
 			if order.Payment.Status.ID == db.ConstPaymentStatuses.Approved.ID {
@@ -238,7 +317,7 @@ func InsertPaymentCashier(ctx *config.AppContext, w *middlewares.ResponseWriter,
 	}
 
 	newOpts := db.InsertPaymentOpts{
-		MethodID:     db.ConstPaymentMethods.MercadoPago.ID,
+		MethodID:     cashierPaymentMethod(isReseller).ID,
 		Amount:       order.Price,
 		UserID:       userInfo.ID,
 		OrderID:      order.ID,
@@ -246,12 +325,24 @@ func InsertPaymentCashier(ctx *config.AppContext, w *middlewares.ResponseWriter,
 		StatusID:     db.ConstPaymentStatuses.Approved.ID,
 	}
 
-	_, err = ctx.DB.InsertPayment(&newOpts)
+	paymentID, err := ctx.DB.InsertPayment(&newOpts)
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "event sold out")
+		return
+	}
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting payment")
 		return
 	}
 
+	go issuePaymentTaxDocument(ctx, config.GetLogger(), paymentID)
+
+	paidOrder, err := ctx.DB.GetOrderByID(order.ID)
+	if err != nil {
+		w.LogError(err, "failed getting paid order")
+	}
+	w.Audit(db.ConstAuditActions.PaymentCashier, db.ConstAuditEntities.Order, order.ID, order, paidOrder)
+
 	pdfBuffer, err := helpers.GenerateOrderPDF(order)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating pdfs")
@@ -283,6 +374,9 @@ func InsertPaymentCashier(ctx *config.AppContext, w *middlewares.ResponseWriter,
 			Lastname:      order.Client.Lastname,
 			PaymentMethod: db.ConstPaymentMethods.Cashier.Name,
 			OrderPrice:    order.Price,
+			NetAmount:     order.NetAmount,
+			TaxAmount:     order.TaxAmount,
+			TaxRate:       models.TaxRateLabel(order.TaxRate),
 			TransactionID: order.TransactionID,
 			Tickets:       order.Tickets,
 			Date:          time.Now().Format("02-01-2016"),
@@ -299,3 +393,79 @@ func InsertPaymentCashier(ctx *config.AppContext, w *middlewares.ResponseWriter,
 	}, nil, "")
 	return
 }
+
+func GetPayments(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetPaymentsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetPaymentsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	payments, err := ctx.DB.GetPayments(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting payments")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, payments, nil, "")
+}
+
+// cashierPaymentMethod is the method of the payments taken at the booth, or
+// by a reseller for its own sales.
+func cashierPaymentMethod(isReseller bool) models.PaymentMethod {
+	if isReseller {
+		return db.ConstPaymentMethods.Reseller
+	}
+	return db.ConstPaymentMethods.Cashier
+}
+
+// offerReversedOrderTickets offers to the waitlist the tickets freed by a
+// reversed payment.
+// refundSoldOutPayment gives the money back of a payment approved after the
+// order lost its seats, and reverses it so the order stays unpaid.
+func refundSoldOutPayment(ctx *config.AppContext, w *middlewares.ResponseWriter, paymentID string, externalReference string) {
+	if err := ctx.MercadoPago.MPRefundPayment(paymentID); err != nil {
+		w.LogError(err, "failed refunding payment of sold out event")
+		return
+	}
+
+	if err := ctx.DB.UpdatePaymentStatus(externalReference, db.ConstPaymentStatuses.Reversed.ID); err != nil {
+		w.LogError(err, "failed updating refunded payment")
+		return
+	}
+
+	w.LogInfo(externalReference, "refunded payment of sold out event")
+}
+
+func offerReversedOrderTickets(ctx *config.AppContext, externalReference string) {
+	logger := config.GetLogger().WithField("external_reference", externalReference)
+
+	order, err := ctx.DB.GetOrderByExternalReference(externalReference)
+	if err != nil {
+		logger.WithError(err).Error("failed getting order")
+		return
+	}
+
+	if order == nil {
+		return
+	}
+
+	processEventWaitlist(ctx, logger, order.Event.ID)
+}
@@ -0,0 +1,150 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+func ExportMyData(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	exportUserData(ctx, w, r, userInfo.ID)
+}
+
+func ExportUserData(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing user id")
+		return
+	}
+
+	exportUserData(ctx, w, r, userID)
+}
+
+func exportUserData(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request, userID int) {
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.ExportUserDataRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.ExportUserDataOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	export, err := ctx.DB.GetUserDataExport(userID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user data")
+		return
+	}
+
+	if export == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if opts.Format != "zip" {
+		w.WriteJSON(http.StatusOK, export, nil, "")
+		return
+	}
+
+	buf, err := helpers.UserDataExportZip(export)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating zip")
+		return
+	}
+
+	w.Writer.Header().Set("Content-Type", "application/zip")
+	w.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=datos-%d.zip", userID))
+	w.Writer.WriteHeader(http.StatusOK)
+	if _, err := w.Writer.Write(buf.Bytes()); err != nil {
+		w.LogError(err, "failed writing zip")
+	}
+}
+
+// EraseUserData anonymizes a client's personal data. Only clients can be
+// erased, staff accounts must be deactivated instead.
+func EraseUserData(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing user id")
+		return
+	}
+
+	var opts models.EraseUserDataOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.EraseUserDataRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	user, err := ctx.DB.GetUserStatus(userID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	for _, role := range user.Roles {
+		if role.ID != db.ConstRoles.Client {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "only clients can be erased")
+			return
+		}
+	}
+
+	if err := ctx.DB.EraseUserData(user.ID, userInfo.ID, opts.Reason); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed erasing user data")
+		return
+	}
+
+	// The erased personal data must not survive in the audit log, so only
+	// the fact that the erasure happened is recorded.
+	w.Audit(db.ConstAuditActions.UserErase, db.ConstAuditEntities.User, user.ID, nil, nil)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
@@ -0,0 +1,346 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+func InsertResellerAllotment(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	var opts models.InsertResellerAllotmentOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertResellerAllotmentRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	user, err := ctx.DB.GetUserByID(opts.UserID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "Usuario no encontrado")
+		return
+	}
+
+	if !user.HasRole(db.ConstRoles.Reseller) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "El usuario no es revendedor")
+		return
+	}
+
+	event, err := ctx.DB.GetEventByID(opts.EventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	if event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "Evento no encontrado")
+		return
+	}
+
+	_, err = ctx.DB.InsertResellerAllotment(&opts)
+	if err == db.ErrResellerAllotmentBelowSold {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "El revendedor ya vendió más entradas que el cupo")
+		return
+	}
+	if err == db.ErrEventSoldOut {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "El evento no tiene capacidad para el cupo")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	allotment, err := ctx.DB.GetResellerAllotment(opts.UserID, opts.EventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	if allotment != nil {
+		w.Audit(db.ConstAuditActions.ResellerAllotment, db.ConstAuditEntities.ResellerAllotment, allotment.ID, nil, allotment)
+	}
+
+	w.WriteJSON(http.StatusOK, allotment, nil, "")
+}
+
+func GetResellerAllotments(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsReseller {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetResellerAllotmentsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetResellerAllotmentsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin {
+		opts.UserID = userInfo.ID
+	}
+
+	allotments, err := ctx.DB.GetResellerAllotments(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, allotments, nil, "")
+}
+
+func InsertResellerSettlement(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	vars := mux.Vars(r)
+	resellerID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing user id")
+		return
+	}
+
+	var opts models.InsertResellerSettlementOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertResellerSettlementRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	if opts.DateTo < opts.DateFrom {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "La fecha de término no puede ser antes de la de inicio")
+		return
+	}
+
+	user, err := ctx.DB.GetUserByID(resellerID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	if user == nil || !user.HasRole(db.ConstRoles.Reseller) {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "Revendedor no encontrado")
+		return
+	}
+
+	settlement, err := ctx.DB.InsertResellerSettlement(resellerID, opts.DateFrom, opts.DateTo)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	settlement.User = user
+
+	w.Audit(db.ConstAuditActions.ResellerSettlement, db.ConstAuditEntities.ResellerSettlement, settlement.ID, nil, settlement)
+
+	w.WriteJSON(http.StatusOK, settlement, nil, "")
+}
+
+func GetResellerSettlements(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsReseller {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetResellerSettlementsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetResellerSettlementsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin {
+		opts.UserID = userInfo.ID
+	}
+
+	settlements, err := ctx.DB.GetResellerSettlements(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, settlements, nil, "")
+}
+
+func GetResellerSettlementCSV(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsReseller {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	vars := mux.Vars(r)
+	settlementID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing settlement id")
+		return
+	}
+
+	settlement, err := ctx.DB.GetResellerSettlementByID(settlementID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	if settlement == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "Liquidación no encontrada")
+		return
+	}
+
+	if !userInfo.IsAdmin && settlement.User.ID != userInfo.ID {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "La liquidación no corresponde al revendedor")
+		return
+	}
+
+	sales, err := ctx.DB.GetResellerSettlementSales(settlement.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	w.Writer.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=liquidacion-%d.csv", settlement.ID))
+	w.Writer.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w.Writer)
+	writer.Write([]string{"Orden", "Código", "Cliente", "Email", "Evento", "Fecha", "Entradas", "Venta", "Comisión", "Usada"})
+	for _, sale := range sales {
+		used := "No"
+		if sale.Used {
+			used = "Sí"
+		}
+		writer.Write([]string{
+			strconv.Itoa(sale.Order.ID),
+			sale.Order.TransactionID,
+			fmt.Sprintf("%s %s", sale.Order.Client.Firstname, sale.Order.Client.Lastname),
+			sale.Order.Client.Email,
+			sale.Order.Event.Name,
+			sale.Order.Event.StartDateTime.Format("02-01-2006 15:04"),
+			strconv.Itoa(sale.Order.Tickets),
+			strconv.Itoa(sale.Price),
+			strconv.Itoa(sale.Commission),
+			used,
+		})
+	}
+	writer.Write([]string{})
+	writer.Write([]string{"Periodo", fmt.Sprintf("%s - %s", settlement.DateFrom, settlement.DateTo)})
+	writer.Write([]string{"Entradas vendidas", strconv.Itoa(settlement.TicketsSold)})
+	writer.Write([]string{"Entradas usadas", strconv.Itoa(settlement.TicketsUsed)})
+	writer.Write([]string{"Total ventas", strconv.Itoa(settlement.TotalSales)})
+	writer.Write([]string{"Comisión", strconv.Itoa(settlement.Commission)})
+	writer.Write([]string{"Monto adeudado", strconv.Itoa(settlement.Owed)})
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		w.LogError(err, "failed writing csv")
+	}
+}
+
+func UpdateResellerSettlementPaid(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	vars := mux.Vars(r)
+	settlementID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing settlement id")
+		return
+	}
+
+	settlement, err := ctx.DB.GetResellerSettlementByID(settlementID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	if settlement == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "Liquidación no encontrada")
+		return
+	}
+
+	if settlement.Paid {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "La liquidación ya está pagada")
+		return
+	}
+
+	if err := ctx.DB.UpdateResellerSettlementPaid(settlement.ID); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	paidSettlement, err := ctx.DB.GetResellerSettlementByID(settlement.ID)
+	if err != nil {
+		w.LogError(err, "failed getting paid settlement")
+	}
+	w.Audit(db.ConstAuditActions.ResellerSettlementPaid, db.ConstAuditEntities.ResellerSettlement, settlement.ID, settlement, paidSettlement)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
@@ -22,13 +22,37 @@ func GetRoutes() []*server.Route {
 		{Path: "/auth/login", Methods: []string{"POST", "HEAD"}, Handler: Login, IsProtected: false},
 		{Path: "/auth/password", Methods: []string{"PUT", "HEAD"}, Handler: UpdateUserPassword, IsProtected: false},
 		{Path: "/auth/token", Methods: []string{"POST", "HEAD"}, Handler: SendRememberToken, IsProtected: false},
+		{Path: "/auth/unlock", Methods: []string{"PUT", "HEAD"}, Handler: UnlockUser, IsProtected: false},
+		{Path: "/auth/attempts", Methods: []string{"GET", "HEAD"}, Handler: GetLoginAttempts, IsProtected: true},
+		{Path: "/auth/login/2fa", Methods: []string{"POST", "HEAD"}, Handler: LoginTwoFactor, IsProtected: false},
+		{Path: "/auth/login/2fa/setup", Methods: []string{"POST", "HEAD"}, Handler: SetupTwoFactorLogin, IsProtected: false},
+		{Path: "/auth/2fa", Methods: []string{"POST", "HEAD"}, Handler: SetupTwoFactor, IsProtected: true},
+		{Path: "/auth/2fa", Methods: []string{"PUT", "HEAD"}, Handler: EnableTwoFactor, IsProtected: true},
+		{Path: "/auth/2fa", Methods: []string{"DELETE", "HEAD"}, Handler: DisableTwoFactor, IsProtected: true},
+		{Path: "/auth/2fa/recovery", Methods: []string{"POST", "HEAD"}, Handler: UpdateTwoFactorRecoveryCodes, IsProtected: true},
 
 		// User
 		{Path: "/user/admin", Methods: []string{"POST", "HEAD"}, Handler: InsertAdminUser, IsProtected: true},
 		{Path: "/user", Methods: []string{"POST", "HEAD"}, Handler: InsertUser, IsProtected: false},
+		{Path: "/user/verify", Methods: []string{"PUT", "HEAD"}, Handler: VerifyEmail, IsProtected: false},
+		{Path: "/user/verify", Methods: []string{"POST", "HEAD"}, Handler: ResendEmailVerification, IsProtected: false},
 		{Path: "/user/{id:[0-9]+}", Methods: []string{"PUT", "HEAD"}, Handler: UpdateUser, IsProtected: true},
 		{Path: "/user/{id:[0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetUser, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}", Methods: []string{"DELETE", "HEAD"}, Handler: DeleteUser, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}/deactivate", Methods: []string{"PUT", "HEAD"}, Handler: DeactivateUser, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}/reactivate", Methods: []string{"PUT", "HEAD"}, Handler: ReactivateUser, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}/status", Methods: []string{"GET", "HEAD"}, Handler: GetUserStatusChanges, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}/2fa", Methods: []string{"DELETE", "HEAD"}, Handler: ResetUserTwoFactor, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}/export", Methods: []string{"GET", "HEAD"}, Handler: ExportUserData, IsProtected: true},
+		{Path: "/user/{id:[0-9]+}/erase", Methods: []string{"POST", "HEAD"}, Handler: EraseUserData, IsProtected: true},
 		{Path: "/user", Methods: []string{"GET", "HEAD"}, Handler: GetUsers, IsProtected: true},
+		{Path: "/user/export", Methods: []string{"GET", "HEAD"}, Handler: GetUsersExport, IsProtected: true},
+		{Path: "/me", Methods: []string{"GET", "HEAD"}, Handler: GetMe, IsProtected: true},
+		{Path: "/me", Methods: []string{"PUT", "HEAD"}, Handler: UpdateMe, IsProtected: true},
+		{Path: "/me/password", Methods: []string{"PUT", "HEAD"}, Handler: UpdateMePassword, IsProtected: true},
+		{Path: "/me/email", Methods: []string{"PUT", "HEAD"}, Handler: UpdateMeEmail, IsProtected: true},
+		{Path: "/me/export", Methods: []string{"GET", "HEAD"}, Handler: ExportMyData, IsProtected: true},
+		{Path: "/me/email/confirm", Methods: []string{"PUT", "HEAD"}, Handler: ConfirmEmailChange, IsProtected: false},
 		{Path: "/role", Methods: []string{"GET", "HEAD"}, Handler: GetRoles, IsProtected: true},
 
 		// Event
@@ -36,23 +60,92 @@ func GetRoutes() []*server.Route {
 		{Path: "/event", Methods: []string{"GET", "HEAD"}, Handler: GetEvents, IsProtected: false},
 		{Path: "/event/{id:[0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetEvent, IsProtected: false},
 		{Path: "/event/type", Methods: []string{"GET", "HEAD"}, Handler: GetEventTypes, IsProtected: true},
+		{Path: "/event/type/{id:[0-9]+}", Methods: []string{"PUT", "HEAD"}, Handler: UpdateEventType, IsProtected: true},
+		{Path: "/event/{id:[0-9]+}/waitlist", Methods: []string{"POST", "HEAD"}, Handler: JoinWaitlist, IsProtected: true},
+		{Path: "/event/{id:[0-9]+}/waitlist", Methods: []string{"DELETE", "HEAD"}, Handler: LeaveWaitlist, IsProtected: true},
+		{Path: "/event/{id:[0-9]+}/notice", Methods: []string{"POST", "HEAD"}, Handler: InsertEventNotice, IsProtected: true},
+		{Path: "/event/{id:[0-9]+}/notice", Methods: []string{"GET", "HEAD"}, Handler: GetEventNotices, IsProtected: true},
+		{Path: "/event/notice/{id:[0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetEventNotice, IsProtected: true},
+		{Path: "/event/notice/{id:[0-9]+}/recipient", Methods: []string{"GET", "HEAD"}, Handler: GetEventNoticeRecipients, IsProtected: true},
+		{Path: "/event/notice/{id:[0-9]+}/send", Methods: []string{"POST", "HEAD"}, Handler: ResendEventNotice, IsProtected: true},
+		{Path: "/event/notice/response", Methods: []string{"GET", "HEAD"}, Handler: GetEventNoticeResponse, IsProtected: false},
+		{Path: "/event/notice/response", Methods: []string{"PUT", "HEAD"}, Handler: RespondEventNotice, IsProtected: false},
+		{Path: "/waitlist", Methods: []string{"GET", "HEAD"}, Handler: GetWaitlist, IsProtected: true},
 
 		// Order
 		{Path: "/order", Methods: []string{"POST", "HEAD"}, Handler: InsertOrder, IsProtected: true},
 		{Path: "/order", Methods: []string{"GET", "HEAD"}, Handler: GetOrders, IsProtected: true},
+		{Path: "/order/export", Methods: []string{"GET", "HEAD"}, Handler: GetOrdersExport, IsProtected: true},
 		{Path: "/order/{id:[0-9]+}/pdf", Methods: []string{"GET", "HEAD"}, Handler: GetOrderPDF, IsProtected: true},
 		{Path: "/order/{id:[0-9]+}", Methods: []string{"PATCH", "HEAD"}, Handler: UseOrder, IsProtected: true},
 		{Path: "/order/{id:[0-9]+}", Methods: []string{"PUT", "HEAD"}, Handler: UpdateOrder, IsProtected: true},
+		{Path: "/order/{id:[0-9]+}/transfer", Methods: []string{"POST", "HEAD"}, Handler: InsertOrderTransfer, IsProtected: true},
+		{Path: "/order/{id:[0-9]+}/transfer", Methods: []string{"DELETE", "HEAD"}, Handler: CancelOrderTransfer, IsProtected: true},
+		{Path: "/order/{id:[0-9]+}/history", Methods: []string{"GET", "HEAD"}, Handler: GetOrderHistory, IsProtected: true},
+		{Path: "/order/{id:[0-9]+}/reschedule", Methods: []string{"GET", "HEAD"}, Handler: GetOrderRescheduleQuote, IsProtected: true},
+		{Path: "/order/{id:[0-9]+}/reschedule", Methods: []string{"POST", "HEAD"}, Handler: RescheduleOrder, IsProtected: true},
+		{Path: "/order/{id:[0-9]+}/invoice", Methods: []string{"PUT", "HEAD"}, Handler: UpdateOrderInvoice, IsProtected: true},
+		{Path: "/order/transfer", Methods: []string{"GET", "HEAD"}, Handler: GetOrderTransfer, IsProtected: false},
+		{Path: "/order/transfer", Methods: []string{"PUT", "HEAD"}, Handler: AcceptOrderTransfer, IsProtected: false},
 		{Path: "/sales", Methods: []string{"GET", "HEAD"}, Handler: GetSalesSummary, IsProtected: true},
 		{Path: "/sales/cashier", Methods: []string{"GET", "HEAD"}, Handler: GetCashierSummary, IsProtected: true},
+		{Path: "/sales/analytics", Methods: []string{"GET", "HEAD"}, Handler: GetSalesAnalytics, IsProtected: true},
+		{Path: "/cashier/shift", Methods: []string{"POST", "HEAD"}, Handler: OpenCashierShift, IsProtected: true},
+		{Path: "/cashier/shift", Methods: []string{"GET", "HEAD"}, Handler: GetCashierShifts, IsProtected: true},
+		{Path: "/cashier/shift/current", Methods: []string{"GET", "HEAD"}, Handler: GetCurrentCashierShift, IsProtected: true},
+		{Path: "/cashier/shift/{id:[0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetCashierShift, IsProtected: true},
+		{Path: "/cashier/shift/{id:[0-9]+}/close", Methods: []string{"POST", "HEAD"}, Handler: CloseCashierShift, IsProtected: true},
+		{Path: "/report/occupancy", Methods: []string{"GET", "HEAD"}, Handler: GetOccupancyReport, IsProtected: true},
+		{Path: "/report/occupancy/live", Methods: []string{"GET", "HEAD"}, Handler: GetLiveOccupancy, IsProtected: true},
+		{Path: "/report/occupancy/live/stream", Methods: []string{"GET"}, Handler: StreamLiveOccupancy, IsProtected: true},
 
 		// Payment
 		{Path: "/payment/{order_id:[0-9]+}/mercadopago", Methods: []string{"POST", "HEAD"}, Handler: InsertPaymentMercadoPago, IsProtected: true},
 		{Path: "/payment/{order_id:[0-9]+}/cashier", Methods: []string{"POST", "HEAD"}, Handler: InsertPaymentCashier, IsProtected: true},
 		{Path: "/payment/mercadopago", Methods: []string{"POST", "HEAD"}, Handler: UpdatePaymentMercadoPago, IsProtected: false},
+		{Path: "/payment", Methods: []string{"GET", "HEAD"}, Handler: GetPayments, IsProtected: true},
+		{Path: "/payment/export", Methods: []string{"GET", "HEAD"}, Handler: GetPaymentsExport, IsProtected: true},
+
+		// Season pass
+		{Path: "/pass/product", Methods: []string{"POST", "HEAD"}, Handler: InsertPassProduct, IsProtected: true},
+		{Path: "/pass/product", Methods: []string{"GET", "HEAD"}, Handler: GetPassProducts, IsProtected: false},
+		{Path: "/pass/product/{id:[0-9]+}", Methods: []string{"DELETE", "HEAD"}, Handler: DeletePassProduct, IsProtected: true},
+		{Path: "/pass", Methods: []string{"POST", "HEAD"}, Handler: InsertSeasonPass, IsProtected: true},
+		{Path: "/pass", Methods: []string{"GET", "HEAD"}, Handler: GetSeasonPasses, IsProtected: true},
+		{Path: "/pass/{id:[0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetSeasonPass, IsProtected: true},
+		{Path: "/pass/scan", Methods: []string{"POST", "HEAD"}, Handler: ScanSeasonPass, IsProtected: true},
+
+		// Gift voucher
+		{Path: "/voucher", Methods: []string{"POST", "HEAD"}, Handler: InsertGiftVoucher, IsProtected: true},
+		{Path: "/voucher", Methods: []string{"GET", "HEAD"}, Handler: GetGiftVouchers, IsProtected: true},
+		{Path: "/voucher/liability", Methods: []string{"GET", "HEAD"}, Handler: GetGiftVoucherLiability, IsProtected: true},
+		{Path: "/voucher/code/{code:[A-Za-z0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetGiftVoucher, IsProtected: true},
+
+		// Group booking
+		{Path: "/group-booking", Methods: []string{"POST", "HEAD"}, Handler: InsertGroupBooking, IsProtected: true},
+		{Path: "/group-booking", Methods: []string{"GET", "HEAD"}, Handler: GetGroupBookings, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}", Methods: []string{"GET", "HEAD"}, Handler: GetGroupBooking, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}/approve", Methods: []string{"PUT", "HEAD"}, Handler: ApproveGroupBooking, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}/reject", Methods: []string{"PUT", "HEAD"}, Handler: RejectGroupBooking, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}/proof", Methods: []string{"POST", "HEAD"}, Handler: UploadGroupBookingProof, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}/payment", Methods: []string{"PUT", "HEAD"}, Handler: UpdateGroupBookingPayment, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}/participants", Methods: []string{"POST", "HEAD"}, Handler: ImportGroupBookingParticipants, IsProtected: true},
+		{Path: "/group-booking/{id:[0-9]+}/participants", Methods: []string{"GET", "HEAD"}, Handler: GetGroupBookingParticipants, IsProtected: true},
 
 		// Camping
 		{Path: "/camping", Methods: []string{"POST", "HEAD"}, Handler: InsertCamping, IsProtected: true},
 		{Path: "/camping", Methods: []string{"GET", "HEAD"}, Handler: GetCampings, IsProtected: true},
+		{Path: "/camping/export", Methods: []string{"GET", "HEAD"}, Handler: GetCampingsExport, IsProtected: true},
+
+		// Reseller
+		{Path: "/reseller/allotment", Methods: []string{"POST", "HEAD"}, Handler: InsertResellerAllotment, IsProtected: true},
+		{Path: "/reseller/allotment", Methods: []string{"GET", "HEAD"}, Handler: GetResellerAllotments, IsProtected: true},
+		{Path: "/reseller/{id:[0-9]+}/settlement", Methods: []string{"POST", "HEAD"}, Handler: InsertResellerSettlement, IsProtected: true},
+		{Path: "/reseller/settlement", Methods: []string{"GET", "HEAD"}, Handler: GetResellerSettlements, IsProtected: true},
+		{Path: "/reseller/settlement/{id:[0-9]+}/csv", Methods: []string{"GET", "HEAD"}, Handler: GetResellerSettlementCSV, IsProtected: true},
+		{Path: "/reseller/settlement/{id:[0-9]+}/paid", Methods: []string{"PUT", "HEAD"}, Handler: UpdateResellerSettlementPaid, IsProtected: true},
+
+		// Audit
+		{Path: "/audit", Methods: []string{"GET", "HEAD"}, Handler: GetAuditLogs, IsProtected: true},
 	}
 }
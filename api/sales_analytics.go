@@ -0,0 +1,77 @@
+package api
+
+import (
+	"net/http"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// GetSalesAnalytics sums the paid orders and season passes created between
+// two dates of the park, grouped by the given dimension.
+func GetSalesAnalytics(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetSalesAnalyticsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetSalesAnalyticsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	// Both dates are ISO 8601, so they compare as strings.
+	if opts.DateFrom > opts.DateTo {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "date_from is after date_to")
+		return
+	}
+
+	rows, err := ctx.DB.GetSalesAnalytics(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting sales analytics")
+		return
+	}
+
+	analytics := models.SalesAnalytics{
+		DateFrom: opts.DateFrom,
+		DateTo:   opts.DateTo,
+		GroupBy:  opts.GroupBy,
+		Totals:   models.SalesAnalyticsRow{Key: "total", Label: "Total"},
+		Rows:     rows,
+	}
+	for i := range analytics.Rows {
+		row := &analytics.Rows[i]
+		if row.Orders > 0 {
+			row.AverageOrderValue = row.Revenue / row.Orders
+		}
+
+		analytics.Totals.Orders += row.Orders
+		analytics.Totals.TicketsSold += row.TicketsSold
+		analytics.Totals.TicketsUsed += row.TicketsUsed
+		analytics.Totals.Revenue += row.Revenue
+		analytics.Totals.Net += row.Net
+		analytics.Totals.Tax += row.Tax
+	}
+	if analytics.Totals.Orders > 0 {
+		analytics.Totals.AverageOrderValue = analytics.Totals.Revenue / analytics.Totals.Orders
+	}
+
+	w.WriteJSON(http.StatusOK, analytics, nil, "")
+}
@@ -0,0 +1,34 @@
+package api
+
+import (
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+)
+
+// runPeriodically runs the job right away and then every interval, for as
+// long as the server runs. Failures are logged and retried on the next run.
+func runPeriodically(name string, interval time.Duration, job func() error) {
+	logger := config.GetLogger().WithField("job", name)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := job(); err != nil {
+			logger.WithError(err).Error("failed running scheduled job")
+		}
+
+		<-ticker.C
+	}
+}
+
+// minutesOrDefault is the configured interval, or the default one when it's
+// not set.
+func minutesOrDefault(minutes int, defaultMinutes int) time.Duration {
+	if minutes <= 0 {
+		minutes = defaultMinutes
+	}
+
+	return time.Duration(minutes) * time.Minute
+}
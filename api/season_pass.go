@@ -0,0 +1,594 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/mitchellh/mapstructure"
+	"github.com/thedevsaddam/govalidator"
+)
+
+func InsertPassProduct(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	var opts models.InsertPassProductOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertPassProductRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	for _, date := range opts.BlackoutDates {
+		if _, err := time.Parse(db.ConstLayoutDate, date); err != nil {
+			w.WriteJSON(http.StatusBadRequest, nil, err, "invalid blackout date")
+			return
+		}
+	}
+
+	eventTypes, err := ctx.DB.GetEventTypes()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event types")
+		return
+	}
+
+	for _, eventTypeID := range opts.EventTypeIDs {
+		var found bool
+		for _, eventType := range eventTypes {
+			if eventType.ID == eventTypeID {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "event type not found")
+			return
+		}
+	}
+
+	productID, err := ctx.DB.InsertPassProduct(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting pass product")
+		return
+	}
+
+	product, err := ctx.DB.GetPassProductByID(productID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting pass product")
+		return
+	}
+	w.Audit(db.ConstAuditActions.PassProductInsert, db.ConstAuditEntities.PassProduct, productID, nil, product)
+
+	w.WriteJSON(http.StatusOK, product, nil, "")
+}
+
+// GetPassProducts lists the passes on sale. Admins can also ask for the
+// inactive ones.
+func GetPassProducts(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetPassProductsRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetPassProductsOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	products, err := ctx.DB.GetPassProducts(opts.All && userInfo.IsAdmin)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting pass products")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, products, nil, "")
+}
+
+// DeletePassProduct takes the pass off sale. Passes already sold keep working.
+func DeletePassProduct(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	productID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing pass product id")
+		return
+	}
+
+	product, err := ctx.DB.GetPassProductByID(productID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting pass product")
+		return
+	}
+
+	if product == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "pass product not found")
+		return
+	}
+
+	if err := ctx.DB.DeactivatePassProduct(product.ID); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed deactivating pass product")
+		return
+	}
+	w.Audit(db.ConstAuditActions.PassProductDeactivate, db.ConstAuditEntities.PassProduct, product.ID, nil, nil)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+// InsertSeasonPass sells a pass. Clients buy it for themselves through
+// Mercado Pago and the pass is activated by the payment notification, while
+// staff issue it already paid at the cashier.
+func InsertSeasonPass(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	isStaff := userInfo.IsAdmin || userInfo.IsCashier
+
+	var opts models.InsertSeasonPassOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.InsertSeasonPassRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	if !isStaff || opts.UserID == 0 {
+		opts.UserID = userInfo.ID
+	}
+
+	product, err := ctx.DB.GetPassProductByID(opts.ProductID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting pass product")
+		return
+	}
+
+	if product == nil || !product.Active {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "pass product not found")
+		return
+	}
+
+	holder, err := ctx.DB.GetUserByID(opts.UserID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if holder == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if !isStaff && !holder.EmailVerified {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "email not verified")
+		return
+	}
+
+	dni := opts.DNI
+	if dni == "" && holder.Additional != nil && (holder.Additional.DocumentType == "" || holder.Additional.DocumentType == models.ConstDocumentTypes.RUT) {
+		dni = holder.Additional.DNI
+	}
+
+	dni, ok := helpers.NormalizeRUT(dni)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "a valid holder RUT is required")
+		return
+	}
+
+	timeLocation, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed loading time location")
+		return
+	}
+
+	today, _ := time.Parse(db.ConstLayoutDate, time.Now().In(timeLocation).Format(db.ConstLayoutDate))
+	validFrom := today
+	if opts.ValidFrom != "" {
+		validFrom, _ = time.Parse(db.ConstLayoutDate, opts.ValidFrom)
+		if validFrom.Before(today) {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "valid from date already passed")
+			return
+		}
+	}
+
+	pass := models.SeasonPass{
+		Product:   product,
+		User:      holder,
+		DNI:       dni,
+		Code:      fmt.Sprintf("PP%s", shortuuid.New()),
+		ValidFrom: validFrom,
+		ValidTo:   validFrom.AddDate(0, 0, product.ValidityDays-1),
+		Price:     product.Price,
+		Status:    db.ConstSeasonPassStatuses.Active,
+	}
+
+	result := models.SeasonPassResult{
+		Pass: &pass,
+	}
+
+	var payment *db.InsertPaymentOpts
+	if isStaff && product.Price > 0 {
+		payment = &db.InsertPaymentOpts{
+			MethodID:     db.ConstPaymentMethods.Cashier.ID,
+			Amount:       product.Price,
+			UserID:       userInfo.ID,
+			PreferenceID: shortuuid.New(),
+			StatusID:     db.ConstPaymentStatuses.Approved.ID,
+		}
+	}
+
+	if !isStaff && product.Price > 0 {
+		response, err := ctx.MercadoPago.MPCreateChargePreference(
+			strconv.Itoa(product.ID),
+			product.Name,
+			fmt.Sprintf("%s-%s", pass.ValidFrom.Format(db.ConstLayoutDate), pass.ValidTo.Format(db.ConstLayoutDate)),
+			product.Price,
+			ctx.Config.BackendBaseURL,
+		)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "problems with Mercado Pago")
+			return
+		}
+
+		if response == nil || response.ExternalReference == "" {
+			w.WriteJSON(http.StatusInternalServerError, nil, nil, "bad response from Mercado Pago")
+			return
+		}
+
+		pass.Status = db.ConstSeasonPassStatuses.Pending
+		pass.PreferenceID = response.ExternalReference
+		result.Payment = response
+		payment = &db.InsertPaymentOpts{
+			MethodID:     db.ConstPaymentMethods.MercadoPago.ID,
+			Amount:       product.Price,
+			UserID:       userInfo.ID,
+			PreferenceID: response.ExternalReference,
+			StatusID:     db.ConstPaymentStatuses.Created.ID,
+		}
+	}
+
+	if _, err := ctx.DB.InsertSeasonPass(&pass, payment); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting season pass")
+		return
+	}
+
+	if isStaff {
+		w.Audit(db.ConstAuditActions.SeasonPassInsert, db.ConstAuditEntities.SeasonPass, pass.ID, nil, pass)
+
+		if payment != nil {
+			go issuePaymentTaxDocumentByReference(ctx, config.GetLogger(), payment.PreferenceID, payment.StatusID)
+		}
+	}
+
+	w.WriteJSON(http.StatusOK, result, nil, "")
+}
+
+// GetSeasonPasses lists the passes. Clients only get their own.
+func GetSeasonPasses(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetSeasonPassesRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetSeasonPassesOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		opts.UserID = userInfo.ID
+	}
+
+	if opts.DNI != "" {
+		if dni, ok := helpers.NormalizeRUT(opts.DNI); ok {
+			opts.DNI = dni
+		}
+	}
+
+	passes, err := ctx.DB.GetSeasonPasses(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting season passes")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, passes, nil, "")
+}
+
+// GetSeasonPass returns the pass with its visits and, once paid, the personal
+// QR code shown at the gate.
+func GetSeasonPass(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	vars := mux.Vars(r)
+	passID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing season pass id")
+		return
+	}
+
+	pass, err := ctx.DB.GetSeasonPassByID(passID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting season pass")
+		return
+	}
+
+	if pass == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "season pass not found")
+		return
+	}
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && pass.User.ID != userInfo.ID {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid user")
+		return
+	}
+
+	if pass.Status == db.ConstSeasonPassStatuses.Active {
+		pass.QRCode, err = helpers.QRCodeDataURI(pass.Code)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating QR code")
+			return
+		}
+	}
+
+	pass.Visits, err = ctx.DB.GetPassVisits(pass.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting season pass visits")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, pass, nil, "")
+}
+
+// ScanSeasonPass admits a pass holder at the gate. The pass is found by its
+// QR code or by the holder's RUT, validated against the event being entered
+// and a visit is recorded against it.
+func ScanSeasonPass(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "Rol inválido")
+		return
+	}
+
+	var opts models.ScanSeasonPassOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.ScanSeasonPassRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	timeLocation, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return
+	}
+
+	today, _ := time.Parse(db.ConstLayoutDate, time.Now().In(timeLocation).Format(db.ConstLayoutDate))
+
+	var passes []models.SeasonPass
+	switch {
+	case opts.Code != "":
+		pass, err := ctx.DB.GetSeasonPassByCode(strings.TrimSpace(opts.Code))
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+		if pass != nil {
+			passes = append(passes, *pass)
+		}
+	case opts.DNI != "":
+		dni, ok := helpers.NormalizeRUT(opts.DNI)
+		if !ok {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "RUT inválido")
+			return
+		}
+
+		passes, err = ctx.DB.GetActiveSeasonPassesByDNI(dni)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+	default:
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "Debes indicar el código o el RUT del pase")
+		return
+	}
+
+	if len(passes) == 0 {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "Pase no encontrado")
+		return
+	}
+
+	// A holder may have more than one pass, the first one admitting the
+	// visit is used.
+	var msg string
+	for i := range passes {
+		pass := &passes[i]
+
+		var event *models.Event
+		event, msg = seasonPassEntryEvent(ctx, pass, opts.EventID, today)
+		if msg != "" {
+			continue
+		}
+
+		visit, err := ctx.DB.InsertPassVisit(pass, event.ID, userInfo.ID, strings.TrimSpace(opts.Gate), today)
+		if err == db.ErrPassVisitLimit {
+			msg = "El pase ya alcanzó el límite de visitas"
+			continue
+		}
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+			return
+		}
+
+		visit.Event = event
+		publishGateAdmission(ctx, event.ID, visit.Gate, 1)
+		w.Audit(db.ConstAuditActions.SeasonPassVisit, db.ConstAuditEntities.SeasonPass, pass.ID, nil, visit)
+
+		w.WriteJSON(http.StatusOK, models.PassVisitResult{
+			Pass:  pass,
+			Visit: visit,
+		}, nil, "")
+		return
+	}
+
+	w.WriteJSON(http.StatusBadRequest, nil, nil, msg)
+}
+
+// seasonPassEntryEvent validates the pass for a visit today and returns the
+// event it admits to. Without an event id, the event open at the gate for one
+// of the pass event types is used.
+func seasonPassEntryEvent(ctx *config.AppContext, pass *models.SeasonPass, eventID int, today time.Time) (*models.Event, string) {
+	if pass.Status != db.ConstSeasonPassStatuses.Active {
+		return nil, "El pase no está activo"
+	}
+
+	if today.Before(pass.ValidFrom) || today.After(pass.ValidTo) {
+		return nil, "El pase no está vigente"
+	}
+
+	for _, date := range pass.Product.BlackoutDates {
+		if date == today.Format(db.ConstLayoutDate) {
+			return nil, "El pase no es válido en esta fecha"
+		}
+	}
+
+	allowedType := func(event *models.Event) bool {
+		for _, eventType := range pass.Product.EventTypes {
+			if event.Type != nil && event.Type.ID == eventType.ID {
+				return true
+			}
+		}
+		return false
+	}
+
+	if eventID != 0 {
+		event, err := ctx.DB.GetEventByID(eventID)
+		if err != nil {
+			return nil, "Error del servidor"
+		}
+
+		if event == nil {
+			return nil, "Evento no encontrado"
+		}
+
+		if !allowedType(event) {
+			return nil, "El pase no es válido para este evento"
+		}
+
+		if msg := eventEntryError(event); msg != "" {
+			return nil, msg
+		}
+
+		return event, ""
+	}
+
+	for _, eventType := range pass.Product.EventTypes {
+		events, err := ctx.DB.GetEvents(&models.GetEventsOpts{
+			Date:    today.Format(db.ConstLayoutDate),
+			TypeID:  eventType.ID,
+			LimitTo: 100,
+		})
+		if err != nil {
+			return nil, "Error del servidor"
+		}
+
+		for i := range events.Events {
+			if eventEntryError(&events.Events[i]) == "" {
+				return &events.Events[i], ""
+			}
+		}
+	}
+
+	return nil, "No hay eventos abiertos para este pase"
+}
+
+// updateSeasonPassPayment records the Mercado Pago status of the payment of a
+// pass. The pass is activated once the payment is approved and cancelled if
+// it's reversed.
+func updateSeasonPassPayment(ctx *config.AppContext, w *middlewares.ResponseWriter, pass *models.SeasonPass, paymentStatus *models.PaymentStatus) {
+	if paymentStatus.ID == db.ConstPaymentStatuses.Approved.ID && pass.Status != db.ConstSeasonPassStatuses.Pending {
+		w.LogInfo(pass.ID, "season pass is not pending")
+		return
+	}
+
+	if paymentStatus.ID == db.ConstPaymentStatuses.Reversed.ID && pass.Status != db.ConstSeasonPassStatuses.Active {
+		w.LogInfo(pass.ID, "season pass is not active")
+		return
+	}
+
+	if err := ctx.DB.UpdateSeasonPassPayment(pass.ID, pass.PreferenceID, paymentStatus.ID); err != nil {
+		w.LogError(err, "failed updating season pass payment")
+		return
+	}
+
+	go issuePaymentTaxDocumentByReference(ctx, config.GetLogger(), pass.PreferenceID, paymentStatus.ID)
+
+	w.LogInfo(paymentStatus, "season pass payment updated")
+}
@@ -0,0 +1,349 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/dte"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// UpdateOrderInvoice stores the business details of the company buying the
+// order, so its payment gets a factura instead of a boleta. It must be set
+// before the order is paid.
+func UpdateOrderInvoice(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	orderID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing order id")
+		return
+	}
+
+	var opts models.UpdateOrderInvoiceOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateOrderInvoiceRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	dni, ok := helpers.NormalizeRUT(opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "a valid company RUT is required")
+		return
+	}
+
+	order, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting order")
+		return
+	}
+
+	if order == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "order not found")
+		return
+	}
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && (order.Client == nil || order.Client.ID != userInfo.ID) {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid user")
+		return
+	}
+
+	if order.Payment != nil && order.Payment.Status != nil && order.Payment.Status.ID == db.ConstPaymentStatuses.Approved.ID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "order already paid")
+		return
+	}
+
+	invoice := models.OrderInvoice{
+		OrderID:      order.ID,
+		DNI:          dni,
+		BusinessName: strings.TrimSpace(opts.BusinessName),
+		Activity:     strings.TrimSpace(opts.Activity),
+		Address:      strings.TrimSpace(opts.Address),
+		Commune:      strings.TrimSpace(opts.Commune),
+		Email:        strings.ToLower(strings.TrimSpace(opts.Email)),
+	}
+
+	if err := ctx.DB.UpsertOrderInvoice(&invoice); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating order invoice")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, invoice, nil, "")
+}
+
+// IssuePendingTaxDocuments retries the documents that couldn't be issued when
+// the payment was approved or reversed.
+func IssuePendingTaxDocuments(ctx *config.AppContext) error {
+	logger := config.GetLogger()
+
+	paymentIDs, err := ctx.DB.GetPendingTaxDocumentPaymentIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, paymentID := range paymentIDs {
+		issuePaymentTaxDocument(ctx, logger, paymentID)
+	}
+
+	paymentIDs, err = ctx.DB.GetPendingCreditNotePaymentIDs()
+	if err != nil {
+		return err
+	}
+
+	for _, paymentID := range paymentIDs {
+		issuePaymentCreditNote(ctx, logger, paymentID)
+	}
+
+	return nil
+}
+
+// issuePaymentTaxDocument issues the boleta of an approved payment, or its
+// factura when the order has invoice details. Failures are left for
+// IssuePendingTaxDocuments to retry.
+func issuePaymentTaxDocument(ctx *config.AppContext, logger *log.Entry, paymentID int) {
+	logger = logger.WithField("tax_document_payment_id", paymentID)
+
+	claimed, err := ctx.DB.ClaimPaymentTaxDocument(paymentID)
+	if err != nil {
+		logger.WithError(err).Error("failed claiming tax document")
+		return
+	}
+
+	if !claimed {
+		return
+	}
+
+	payment, err := ctx.DB.GetTaxDocumentPayment(paymentID)
+	if err != nil || payment == nil {
+		logger.WithError(err).Error("failed getting tax document payment")
+		if err := ctx.DB.FailPaymentTaxDocument(paymentID, "payment not found"); err != nil {
+			logger.WithError(err).Error("failed updating tax document status")
+		}
+		return
+	}
+
+	document := &dte.Document{
+		Type:  dte.TypeBoleta,
+		Date:  time.Now().In(parkLocation()).Format(db.ConstLayoutDate),
+		Items: []dte.Item{taxDocumentItem(payment)},
+		Total: payment.Amount,
+	}
+
+	if payment.Invoice != nil {
+		email := payment.Invoice.Email
+		if email == "" {
+			email = payment.ClientEmail
+		}
+
+		document.Type = dte.TypeFactura
+		document.Receiver = &dte.Receiver{
+			RUT:          payment.Invoice.DNI,
+			BusinessName: payment.Invoice.BusinessName,
+			Activity:     payment.Invoice.Activity,
+			Address:      payment.Invoice.Address,
+			Commune:      payment.Invoice.Commune,
+			Email:        email,
+		}
+	}
+
+	issued, err := ctx.DTE.Issue(document)
+	if err != nil {
+		logger.WithError(err).Error("failed issuing tax document")
+		if err := ctx.DB.FailPaymentTaxDocument(paymentID, err.Error()); err != nil {
+			logger.WithError(err).Error("failed updating tax document status")
+		}
+		return
+	}
+
+	err = ctx.DB.UpdatePaymentTaxDocument(paymentID, &models.TaxDocument{
+		Type:   issued.Type,
+		Folio:  issued.Folio,
+		PDFURL: issued.PDFURL,
+	})
+	if err != nil {
+		logger.WithError(err).WithField("folio", issued.Folio).Error("failed storing issued tax document")
+		return
+	}
+
+	logger.WithField("folio", issued.Folio).Info("success issuing tax document")
+}
+
+// issuePaymentCreditNote voids the document of a refunded payment.
+func issuePaymentCreditNote(ctx *config.AppContext, logger *log.Entry, paymentID int) {
+	logger = logger.WithField("credit_note_payment_id", paymentID)
+
+	claimed, err := ctx.DB.ClaimPaymentCreditNote(paymentID)
+	if err != nil {
+		logger.WithError(err).Error("failed claiming credit note")
+		return
+	}
+
+	if !claimed {
+		return
+	}
+
+	payment, err := ctx.DB.GetTaxDocumentPayment(paymentID)
+	if err != nil || payment == nil {
+		logger.WithError(err).Error("failed getting tax document payment")
+		if err := ctx.DB.FailPaymentCreditNote(paymentID, "payment not found"); err != nil {
+			logger.WithError(err).Error("failed updating credit note status")
+		}
+		return
+	}
+
+	document := &dte.Document{
+		Type:  dte.TypeCreditNote,
+		Date:  time.Now().In(parkLocation()).Format(db.ConstLayoutDate),
+		Items: []dte.Item{taxDocumentItem(payment)},
+		Total: payment.Amount,
+		Reference: &dte.Reference{
+			Type:   payment.TaxDocumentType,
+			Folio:  payment.TaxFolio,
+			Code:   dte.CodeVoidDocument,
+			Reason: "Devolución de la compra",
+		},
+	}
+	if payment.TaxIssued != nil {
+		document.Reference.Date = payment.TaxIssued.In(parkLocation()).Format(db.ConstLayoutDate)
+	}
+
+	if payment.Invoice != nil {
+		document.Receiver = &dte.Receiver{
+			RUT:          payment.Invoice.DNI,
+			BusinessName: payment.Invoice.BusinessName,
+			Activity:     payment.Invoice.Activity,
+			Address:      payment.Invoice.Address,
+			Commune:      payment.Invoice.Commune,
+			Email:        payment.Invoice.Email,
+		}
+	}
+
+	issued, err := ctx.DTE.Issue(document)
+	if err != nil {
+		logger.WithError(err).Error("failed issuing credit note")
+		if err := ctx.DB.FailPaymentCreditNote(paymentID, err.Error()); err != nil {
+			logger.WithError(err).Error("failed updating credit note status")
+		}
+		return
+	}
+
+	err = ctx.DB.UpdatePaymentCreditNote(paymentID, &models.TaxDocument{
+		Type:   dte.TypeCreditNote,
+		Folio:  issued.Folio,
+		PDFURL: issued.PDFURL,
+	})
+	if err != nil {
+		logger.WithError(err).WithField("folio", issued.Folio).Error("failed storing issued credit note")
+		return
+	}
+
+	logger.WithField("folio", issued.Folio).Info("success issuing credit note")
+}
+
+// issuePaymentTaxDocumentByReference issues the document matching the new
+// status of a payment updated through its reference.
+func issuePaymentTaxDocumentByReference(ctx *config.AppContext, logger *log.Entry, preferenceID string, statusID int) {
+	if statusID != db.ConstPaymentStatuses.Approved.ID && statusID != db.ConstPaymentStatuses.Reversed.ID {
+		return
+	}
+
+	paymentID, err := ctx.DB.GetPaymentIDByPreferenceID(preferenceID)
+	if err != nil {
+		logger.WithError(err).Error("failed getting payment")
+		return
+	}
+
+	if paymentID == 0 {
+		return
+	}
+
+	if statusID == db.ConstPaymentStatuses.Reversed.ID {
+		issuePaymentCreditNote(ctx, logger, paymentID)
+		return
+	}
+
+	issuePaymentTaxDocument(ctx, logger, paymentID)
+}
+
+// issueOrderTaxDocument issues the document of the current payment of an
+// order, for payments approved outside the payment routes.
+func issueOrderTaxDocument(ctx *config.AppContext, logger *log.Entry, orderID int) {
+	order, err := ctx.DB.GetOrderByID(orderID)
+	if err != nil {
+		logger.WithError(err).Error("failed getting order")
+		return
+	}
+
+	if order == nil || order.Payment == nil {
+		logger.WithField("order_id", orderID).Error("order payment not found")
+		return
+	}
+
+	issuePaymentTaxDocument(ctx, logger, order.Payment.ID)
+}
+
+// taxDocumentItem bills the tickets of the order, or the season pass.
+// Discounted orders whose amount isn't a multiple of the tickets are billed
+// as a single line.
+func taxDocumentItem(payment *models.TaxDocumentPayment) dte.Item {
+	item := dte.Item{
+		Name:      fmt.Sprintf("Entrada %s %s", payment.EventType, payment.EventDate.Format("02-01-2006")),
+		Quantity:  1,
+		UnitPrice: payment.Amount,
+		Total:     payment.Amount,
+		Exempt:    payment.TaxRate == 0,
+	}
+
+	if payment.SeasonPassID != 0 {
+		item.Name = fmt.Sprintf("Pase %s desde %s", payment.PassProduct, payment.EventDate.Format("02-01-2006"))
+		return item
+	}
+
+	if payment.Tickets > 0 && payment.Amount%payment.Tickets == 0 {
+		item.Quantity = payment.Tickets
+		item.UnitPrice = payment.Amount / payment.Tickets
+	}
+
+	return item
+}
+
+func parkLocation() *time.Location {
+	location, err := time.LoadLocation("America/Santiago")
+	if err != nil {
+		return time.UTC
+	}
+	return location
+}
+
+// parkNow is the current wall time at the park, comparable with the event
+// times: they're stored as Santiago wall times and read without a location.
+func parkNow() time.Time {
+	now := time.Now().In(parkLocation())
+
+	return time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), now.Second(), now.Nanosecond(), time.UTC)
+}
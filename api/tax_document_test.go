@@ -0,0 +1,254 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/dte"
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+// taxDocumentStorage keeps the payments and their issued documents in
+// memory, claiming each document only once as the queries do.
+type taxDocumentStorage struct {
+	db.Storage
+	payments    map[int]*models.TaxDocumentPayment
+	claimed     map[int]bool
+	documents   map[int]*models.TaxDocument
+	creditNotes map[int]*models.TaxDocument
+	failures    map[int]string
+}
+
+func newTaxDocumentStorage(payments ...*models.TaxDocumentPayment) *taxDocumentStorage {
+	s := &taxDocumentStorage{
+		payments:    make(map[int]*models.TaxDocumentPayment),
+		claimed:     make(map[int]bool),
+		documents:   make(map[int]*models.TaxDocument),
+		creditNotes: make(map[int]*models.TaxDocument),
+		failures:    make(map[int]string),
+	}
+	for _, payment := range payments {
+		s.payments[payment.PaymentID] = payment
+	}
+	return s
+}
+
+func (s *taxDocumentStorage) GetTaxDocumentPayment(paymentID int) (*models.TaxDocumentPayment, error) {
+	return s.payments[paymentID], nil
+}
+
+func (s *taxDocumentStorage) ClaimPaymentTaxDocument(paymentID int) (bool, error) {
+	if s.claimed[paymentID] {
+		return false, nil
+	}
+	s.claimed[paymentID] = true
+	return true, nil
+}
+
+func (s *taxDocumentStorage) ClaimPaymentCreditNote(paymentID int) (bool, error) {
+	return s.creditNotes[paymentID] == nil, nil
+}
+
+func (s *taxDocumentStorage) UpdatePaymentTaxDocument(paymentID int, document *models.TaxDocument) error {
+	s.documents[paymentID] = document
+	payment := s.payments[paymentID]
+	payment.TaxDocumentType = document.Type
+	payment.TaxFolio = document.Folio
+	issued := time.Now()
+	payment.TaxIssued = &issued
+	return nil
+}
+
+func (s *taxDocumentStorage) UpdatePaymentCreditNote(paymentID int, document *models.TaxDocument) error {
+	s.creditNotes[paymentID] = document
+	return nil
+}
+
+func (s *taxDocumentStorage) FailPaymentTaxDocument(paymentID int, reason string) error {
+	s.failures[paymentID] = reason
+	return nil
+}
+
+func (s *taxDocumentStorage) FailPaymentCreditNote(paymentID int, reason string) error {
+	s.failures[paymentID] = reason
+	return nil
+}
+
+// recordingProvider issues through the fake provider and keeps the documents
+// it was asked to issue.
+type recordingProvider struct {
+	dte.FakeProvider
+	documents []*dte.Document
+}
+
+func (p *recordingProvider) Issue(document *dte.Document) (*dte.IssuedDocument, error) {
+	p.documents = append(p.documents, document)
+	return p.FakeProvider.Issue(document)
+}
+
+func taxDocumentTestPayment(paymentID int) *models.TaxDocumentPayment {
+	return &models.TaxDocumentPayment{
+		PaymentID:   paymentID,
+		Amount:      24000,
+		StatusID:    db.ConstPaymentStatuses.Approved.ID,
+		OrderID:     paymentID,
+		Tickets:     3,
+		TaxRate:     19,
+		EventType:   "General",
+		EventDate:   time.Date(2026, 1, 10, 10, 0, 0, 0, time.UTC),
+		ClientEmail: "client@example.com",
+	}
+}
+
+func TestIssuePaymentBoleta(t *testing.T) {
+	storage := newTaxDocumentStorage(taxDocumentTestPayment(1), taxDocumentTestPayment(2))
+	provider := &recordingProvider{}
+	ctx := &config.AppContext{DB: storage, DTE: provider}
+
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 1)
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 2)
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 1)
+
+	if len(provider.documents) != 2 {
+		t.Fatalf("got %d documents issued, want 2", len(provider.documents))
+	}
+
+	document := provider.documents[0]
+	if document.Type != dte.TypeBoleta || document.Receiver != nil || document.Total != 24000 {
+		t.Errorf("got document %+v, want a boleta of 24000 without receiver", document)
+	}
+
+	item := document.Items[0]
+	if item.Quantity != 3 || item.UnitPrice != 8000 || item.Total != 24000 || item.Exempt {
+		t.Errorf("got item %+v, want 3 taxed tickets of 8000", item)
+	}
+
+	for paymentID, folio := range map[int]int{1: 1, 2: 2} {
+		stored := storage.documents[paymentID]
+		if stored == nil || stored.Type != dte.TypeBoleta || stored.Folio != folio || stored.PDFURL == "" {
+			t.Errorf("payment %d: got document %+v, want boleta folio %d", paymentID, stored, folio)
+		}
+	}
+}
+
+func TestIssuePaymentFactura(t *testing.T) {
+	payment := taxDocumentTestPayment(1)
+	payment.Amount = 25000
+	payment.TaxRate = 0
+	payment.Invoice = &models.OrderInvoice{
+		OrderID:      1,
+		DNI:          "76086428-5",
+		BusinessName: "Colegio Los Andes",
+		Activity:     "Educación",
+		Address:      "Av. Siempre Viva 123",
+		Commune:      "Santiago",
+	}
+
+	storage := newTaxDocumentStorage(taxDocumentTestPayment(2), payment)
+	provider := &recordingProvider{}
+	ctx := &config.AppContext{DB: storage, DTE: provider}
+
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 2)
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 1)
+
+	document := provider.documents[1]
+	if document.Type != dte.TypeFactura {
+		t.Fatalf("got document type %d, want factura", document.Type)
+	}
+
+	if document.Receiver == nil || document.Receiver.RUT != "76086428-5" || document.Receiver.Email != payment.ClientEmail {
+		t.Errorf("got receiver %+v, want the invoice details with the client email", document.Receiver)
+	}
+
+	item := document.Items[0]
+	if item.Quantity != 1 || item.UnitPrice != 25000 || !item.Exempt {
+		t.Errorf("got item %+v, want a single exempt line of 25000", item)
+	}
+
+	stored := storage.documents[1]
+	if stored == nil || stored.Type != dte.TypeFactura || stored.Folio != 1 {
+		t.Errorf("got document %+v, want factura folio 1 on its own sequence", stored)
+	}
+}
+
+func TestIssuePaymentFacturaWithoutRUT(t *testing.T) {
+	payment := taxDocumentTestPayment(1)
+	payment.Invoice = &models.OrderInvoice{OrderID: 1, BusinessName: "Colegio Los Andes"}
+
+	storage := newTaxDocumentStorage(payment)
+	ctx := &config.AppContext{DB: storage, DTE: &dte.FakeProvider{}}
+
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 1)
+
+	if storage.documents[1] != nil {
+		t.Errorf("got document %+v, want none", storage.documents[1])
+	}
+
+	if storage.failures[1] == "" {
+		t.Error("failure wasn't stored for retry")
+	}
+}
+
+func TestIssuePaymentCreditNote(t *testing.T) {
+	storage := newTaxDocumentStorage(taxDocumentTestPayment(1))
+	provider := &recordingProvider{}
+	ctx := &config.AppContext{DB: storage, DTE: provider}
+
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 1)
+	issuePaymentCreditNote(ctx, config.GetLogger(), 1)
+	issuePaymentCreditNote(ctx, config.GetLogger(), 1)
+
+	if len(provider.documents) != 2 {
+		t.Fatalf("got %d documents issued, want the boleta and one credit note", len(provider.documents))
+	}
+
+	document := provider.documents[1]
+	if document.Type != dte.TypeCreditNote || document.Total != 24000 {
+		t.Errorf("got document %+v, want a credit note of 24000", document)
+	}
+
+	reference := document.Reference
+	if reference == nil || reference.Type != dte.TypeBoleta || reference.Folio != 1 || reference.Code != dte.CodeVoidDocument || reference.Date == "" {
+		t.Errorf("got reference %+v, want it to void boleta folio 1", reference)
+	}
+
+	stored := storage.creditNotes[1]
+	if stored == nil || stored.Type != dte.TypeCreditNote || stored.Folio != 1 {
+		t.Errorf("got credit note %+v, want folio 1", stored)
+	}
+}
+
+func TestIssueSeasonPassBoleta(t *testing.T) {
+	payment := &models.TaxDocumentPayment{
+		PaymentID:    1,
+		Amount:       90000,
+		StatusID:     db.ConstPaymentStatuses.Approved.ID,
+		SeasonPassID: 7,
+		PassProduct:  "Anual",
+		TaxRate:      1900,
+		EventDate:    time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC),
+		ClientEmail:  "holder@example.com",
+	}
+
+	storage := newTaxDocumentStorage(payment)
+	provider := &recordingProvider{}
+	ctx := &config.AppContext{DB: storage, DTE: provider}
+
+	issuePaymentTaxDocument(ctx, config.GetLogger(), 1)
+
+	if len(provider.documents) != 1 {
+		t.Fatalf("got %d documents issued, want 1", len(provider.documents))
+	}
+
+	document := provider.documents[0]
+	if document.Type != dte.TypeBoleta || document.Total != 90000 {
+		t.Errorf("got document %+v, want a boleta of 90000", document)
+	}
+
+	item := document.Items[0]
+	if item.Name != "Pase Anual desde 01-03-2026" || item.Quantity != 1 || item.UnitPrice != 90000 || item.Exempt {
+		t.Errorf("got item %+v, want a single taxed line for the pass", item)
+	}
+}
@@ -1,8 +1,10 @@
 package api
 
 import (
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"bitbucket.org/parqueoasis/backend/config"
 	"bitbucket.org/parqueoasis/backend/db"
@@ -37,6 +39,14 @@ func InsertAdminUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *h
 		return
 	}
 
+	documentType, dni, ok := normalizeUserDNI(opts.DocumentType, opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid dni")
+		return
+	}
+	opts.DocumentType = documentType
+	opts.DNI = dni
+
 	emailCounter, dniCounter, err := ctx.DB.ValidateUserEmailAndDNI(opts.Email, opts.DNI)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed validating email and dni")
@@ -59,6 +69,7 @@ func InsertAdminUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *h
 		return
 	}
 	opts.Password = newPassword
+	opts.EmailVerified = true
 
 	userID, err := ctx.DB.InsertUser(&opts)
 	if err != nil {
@@ -74,19 +85,24 @@ func InsertAdminUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *h
 	}
 
 	user := models.User{
-		ID:        userID,
-		Firstname: opts.Firstname,
-		Lastname:  opts.Lastname,
-		Email:     opts.Email,
-		Password:  opts.Password,
-		Active:    true,
+		ID:            userID,
+		Firstname:     opts.Firstname,
+		Lastname:      opts.Lastname,
+		Email:         opts.Email,
+		Password:      opts.Password,
+		Active:        true,
+		EmailVerified: true,
 		Additional: &models.UserAdditional{
-			DNI:   opts.DNI,
-			Phone: opts.Phone,
+			DNI:          opts.DNI,
+			DNIFormatted: helpers.FormatDNI(opts.DocumentType, opts.DNI),
+			DocumentType: opts.DocumentType,
+			Phone:        opts.Phone,
 		},
 		Roles: roles,
 	}
 
+	w.Audit(db.ConstAuditActions.UserInsert, db.ConstAuditEntities.User, user.ID, nil, user)
+
 	w.WriteJSON(http.StatusOK, user, nil, "")
 }
 
@@ -114,6 +130,12 @@ func GetUsers(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Req
 	decoder := schema.NewDecoder()
 	decoder.Decode(&opts, r.URL.Query())
 
+	for i, dni := range opts.DNIs {
+		if rut, ok := helpers.NormalizeRUT(dni); ok {
+			opts.DNIs[i] = rut
+		}
+	}
+
 	users, err := ctx.DB.GetUsers(&opts)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting users")
@@ -125,6 +147,10 @@ func GetUsers(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Req
 		return
 	}
 
+	for i := range users.Users {
+		formatUserDNI(&users.Users[i])
+	}
+
 	w.WriteJSON(http.StatusOK, users, nil, "")
 }
 
@@ -157,6 +183,14 @@ func UpdateUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.R
 		return
 	}
 
+	documentType, dni, ok := normalizeUserDNI(opts.DocumentType, opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid dni")
+		return
+	}
+	opts.DocumentType = documentType
+	opts.DNI = dni
+
 	user, err := ctx.DB.GetUserByID(userID)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
@@ -211,7 +245,21 @@ func UpdateUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.R
 
 	if err := ctx.DB.UpdateUser(userID, &opts); err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating user")
+		return
+	}
+
+	if opts.Password != user.Password {
+		if err := ctx.DB.ClearUserRememberToken(userID); err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed clearing remember token")
+			return
+		}
+	}
+
+	updatedUser, err := ctx.DB.GetUserByID(userID)
+	if err != nil {
+		w.LogError(err, "failed getting updated user")
 	}
+	w.Audit(db.ConstAuditActions.UserUpdate, db.ConstAuditEntities.User, userID, user, updatedUser)
 
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 }
@@ -238,9 +286,31 @@ func GetUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Requ
 		return
 	}
 
+	if user != nil {
+		formatUserDNI(user)
+	}
+
 	w.WriteJSON(http.StatusOK, user, nil, "")
 }
 
+// normalizeUserDNI defaults the document type to RUT and returns the document
+// number in the canonical form it is stored and compared with.
+func normalizeUserDNI(documentType string, dni string) (string, string, bool) {
+	if documentType == "" {
+		documentType = models.ConstDocumentTypes.RUT
+	}
+
+	dni, ok := helpers.NormalizeDNI(documentType, dni)
+	return documentType, dni, ok
+}
+
+func formatUserDNI(user *models.User) {
+	if user.Additional == nil {
+		return
+	}
+	user.Additional.DNIFormatted = helpers.FormatDNI(user.Additional.DocumentType, user.Additional.DNI)
+}
+
 func InsertUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
 	var opts models.InsertUserOpts
 	validatorOpts := govalidator.Options{
@@ -255,6 +325,14 @@ func InsertUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.R
 		return
 	}
 
+	documentType, dni, ok := normalizeUserDNI(opts.DocumentType, opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid dni")
+		return
+	}
+	opts.DocumentType = documentType
+	opts.DNI = dni
+
 	emailCounter, dniCounter, err := ctx.DB.ValidateUserEmailAndDNI(opts.Email, opts.DNI)
 	if err != nil {
 		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed validating email and dni")
@@ -278,25 +356,166 @@ func InsertUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.R
 	}
 	opts.Password = newPassword
 
+	token, err := helpers.GenerateRandomToken()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating verification token")
+		return
+	}
+
 	finalOpts := models.InsertAdminUserOpts{
+		Email:                    opts.Email,
+		Password:                 opts.Password,
+		Firstname:                opts.Firstname,
+		Lastname:                 opts.Lastname,
+		DNI:                      opts.DNI,
+		DocumentType:             opts.DocumentType,
+		Phone:                    opts.Phone,
+		Roles:                    []int{db.ConstRoles.Client},
+		EmailVerified:            false,
+		EmailVerificationToken:   helpers.HashToken(token),
+		EmailVerificationExpires: time.Now().Add(time.Duration(ctx.Config.EmailVerification.TokenHours) * time.Hour),
+	}
+
+	_, err = ctx.DB.InsertUser(&finalOpts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, nil, "failed inserting user")
+		return
+	}
+
+	sendEmailVerification(ctx, w, &models.User{
 		Email:     opts.Email,
-		Password:  opts.Password,
 		Firstname: opts.Firstname,
 		Lastname:  opts.Lastname,
-		DNI:       opts.DNI,
-		Phone:     opts.Phone,
-		Roles:     []int{db.ConstRoles.Client},
+	}, token)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+func sendEmailVerification(ctx *config.AppContext, w *middlewares.ResponseWriter, user *models.User, token string) {
+	go func(ctx *config.AppContext, user *models.User, token string) {
+		ed := &helpers.EmailData{
+			EmailTo:      user.Email,
+			NameTo:       user.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.EmailVerification.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.EmailVerification.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err := ed.SendEmail(models.EmailVerificationHTML{
+			Firstname: user.Firstname,
+			Lastname:  user.Lastname,
+			URL:       fmt.Sprintf("%s%s/%s", ctx.Config.FrontendBaseURL, ctx.Config.FrontendEmailVerificationPath, token),
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, user, token)
+}
+
+func VerifyEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	var opts models.VerifyEmailOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.VerifyEmailRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
 	}
 
-	_, err = ctx.DB.InsertUser(&finalOpts)
+	user, err := ctx.DB.VerifyUserEmail(helpers.HashToken(opts.Token))
 	if err != nil {
-		w.WriteJSON(http.StatusInternalServerError, nil, nil, "failed inserting user")
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed verifying email")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid verification token")
 		return
 	}
 
 	w.WriteJSON(http.StatusNoContent, nil, nil, "")
 }
 
+// ResendEmailVerification answers the same way whether the email exists or
+// not, so it can't be used to find out which emails are registered. Requests
+// are throttled by email and by IP like the password reset.
+func ResendEmailVerification(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.StartLogger("ResendEmailVerification")
+
+	var opts models.ResendEmailVerificationOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.ResendEmailVerificationRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	ip := helpers.GetRequestIP(ctx, r)
+	window := time.Duration(ctx.Config.EmailVerification.WindowMinutes) * time.Minute
+	emailCounter, ipCounter, err := ctx.DB.CountEmailVerificationRequests(opts.Email, ip, time.Now().Add(-window))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed counting verification requests")
+		return
+	}
+
+	if ipCounter >= ctx.Config.EmailVerification.MaxRequestsIP {
+		w.Writer.Header().Set("Retry-After", strconv.Itoa(int(window.Seconds())))
+		w.WriteJSON(http.StatusTooManyRequests, nil, nil, "too many requests")
+		return
+	}
+
+	if err := ctx.DB.InsertEmailVerificationRequest(opts.Email, ip); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting verification request")
+		return
+	}
+
+	if emailCounter >= ctx.Config.EmailVerification.MaxRequestsEmail {
+		w.LogInfo(opts.Email, "email verification requests limit reached")
+		w.WriteJSON(http.StatusNoContent, nil, nil, "")
+		return
+	}
+
+	user, err := ctx.DB.GetUserLoginByEmail(opts.Email)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil || user.EmailVerified {
+		w.WriteJSON(http.StatusNoContent, nil, nil, "")
+		return
+	}
+
+	token, err := helpers.GenerateRandomToken()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating verification token")
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(ctx.Config.EmailVerification.TokenHours) * time.Hour)
+	if err := ctx.DB.UpdateUserEmailVerificationToken(user.ID, helpers.HashToken(token), expires); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating verification token")
+		return
+	}
+
+	sendEmailVerification(ctx, w, user, token)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
 func GetRoles(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
 	userInfo := models.InfoUser{}
 	mapstructure.Decode(r.Context().Value("user"), &userInfo)
@@ -314,3 +533,396 @@ func GetRoles(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Req
 
 	w.WriteJSON(http.StatusOK, roles, nil, "")
 }
+
+func DeactivateUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	updateUserStatus(ctx, w, r, db.ConstUserStatuses.Deactivated)
+}
+
+func ReactivateUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	updateUserStatus(ctx, w, r, db.ConstUserStatuses.Reactivated)
+}
+
+// DeleteUser soft deletes a user. The row is kept so their orders and
+// payments are still attributable, but the user can't log in nor be
+// reactivated.
+func DeleteUser(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	updateUserStatus(ctx, w, r, db.ConstUserStatuses.Deleted)
+}
+
+func updateUserStatus(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request, status string) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing user id")
+		return
+	}
+
+	var opts models.UpdateUserStatusOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateUserStatusRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	user, err := ctx.DB.GetUserStatus(userID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if user.DeletedAt != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "user is deleted")
+		return
+	}
+
+	if status == db.ConstUserStatuses.Deactivated && !user.Active {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "user is already inactive")
+		return
+	}
+
+	if status == db.ConstUserStatuses.Reactivated && user.Active {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "user is already active")
+		return
+	}
+
+	err = ctx.DB.UpdateUserStatus(user.ID, userInfo.ID, status, opts.Reason)
+	if err != nil {
+		if err == db.ErrLastActiveAdmin {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "can't deactivate the last active admin")
+			return
+		}
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating user status")
+		return
+	}
+
+	updatedUser, err := ctx.DB.GetUserStatus(user.ID)
+	if err != nil {
+		w.LogError(err, "failed getting updated user")
+	}
+	w.Audit(db.ConstAuditActions.UserStatusUpdate, db.ConstAuditEntities.User, user.ID, user, updatedUser)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+func GetUserStatusChanges(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing user id")
+		return
+	}
+
+	changes, err := ctx.DB.GetUserStatusChanges(userID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user status changes")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, changes, nil, "")
+}
+
+func GetMe(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	user, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	formatUserDNI(user)
+
+	w.WriteJSON(http.StatusOK, user, nil, "")
+}
+
+// UpdateMe lets users edit their own profile. Email, password and roles are
+// always taken from the stored user, so they can't be changed through it.
+func UpdateMe(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	var opts models.UpdateMeOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateMeRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	documentType, dni, ok := normalizeUserDNI(opts.DocumentType, opts.DNI)
+	if !ok {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid dni")
+		return
+	}
+
+	user, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if user.Additional.DNI != dni {
+		_, dniCounter, err := ctx.DB.ValidateUserEmailAndDNI(user.Email, dni)
+		if err != nil {
+			w.WriteJSON(http.StatusInternalServerError, nil, err, "failed validating email and dni")
+			return
+		}
+
+		if dniCounter > 0 {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "dni exists")
+			return
+		}
+	}
+
+	var roles []int
+	for _, role := range user.Roles {
+		roles = append(roles, role.ID)
+	}
+
+	err = ctx.DB.UpdateUser(user.ID, &models.UpdateUserOpts{
+		Email:        user.Email,
+		Password:     user.Password,
+		Firstname:    opts.Firstname,
+		Lastname:     opts.Lastname,
+		DNI:          dni,
+		DocumentType: documentType,
+		Phone:        opts.Phone,
+		Roles:        roles,
+	})
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating user")
+		return
+	}
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+// UpdateMePassword changes the password of the logged user. Every other
+// session is revoked and a new token is returned for the current one.
+func UpdateMePassword(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	var opts models.UpdateMePasswordOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateMePasswordRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	user, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if !helpers.AuthenticateHashedPassword(user.Password, opts.CurrentPassword) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid password")
+		return
+	}
+
+	password, err := helpers.HashPassword(opts.Password)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed hashing password")
+		return
+	}
+	user.Password = password
+
+	if err := ctx.DB.UpdateUserPassword(user); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating password")
+		return
+	}
+
+	if err := ctx.DB.RevokeUserSessions(user.ID); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed revoking sessions")
+		return
+	}
+
+	user.Token, err = helpers.GenerateToken(user, ctx.Config.JWTSecret)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating token")
+		return
+	}
+
+	formatUserDNI(user)
+
+	w.WriteJSON(http.StatusOK, user, nil, "")
+}
+
+// UpdateMeEmail starts an email change. The address is only replaced once the
+// link sent to the new address is opened.
+func UpdateMeEmail(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	w.StartLogger("UpdateMeEmail")
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	var opts models.UpdateMeEmailOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.UpdateMeEmailRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	user, err := ctx.DB.GetUserByID(userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting user")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "user not found")
+		return
+	}
+
+	if !helpers.AuthenticateHashedPassword(user.Password, opts.Password) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid password")
+		return
+	}
+
+	if user.Email == opts.Email {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "email is the same")
+		return
+	}
+
+	emailCounter, _, err := ctx.DB.ValidateUserEmailAndDNI(opts.Email, "")
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed validating email")
+		return
+	}
+
+	if emailCounter > 0 {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "email exists")
+		return
+	}
+
+	token, err := helpers.GenerateRandomToken()
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed generating token")
+		return
+	}
+
+	expires := time.Now().Add(time.Duration(ctx.Config.EmailChangeTokenMinutes) * time.Minute)
+	if err := ctx.DB.UpdateUserEmailChange(user.ID, opts.Email, helpers.HashToken(token), expires); err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed updating email change")
+		return
+	}
+
+	go func(ctx *config.AppContext, user *models.User, email string, token string) {
+		ed := &helpers.EmailData{
+			EmailTo:      email,
+			NameTo:       user.Firstname,
+			EmailFrom:    ctx.Config.Mail.EmailFrom,
+			NameFrom:     ctx.Config.Mail.NameFrom,
+			Subject:      ctx.Config.Mail.EmailChange.Subject,
+			TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.EmailChange.Template),
+			AwsSMTP:      ctx.AwsSMTP,
+		}
+
+		err := ed.SendEmail(models.EmailChangeHTML{
+			Firstname: user.Firstname,
+			Lastname:  user.Lastname,
+			Email:     email,
+			URL:       fmt.Sprintf("%s%s/%s", ctx.Config.FrontendBaseURL, ctx.Config.FrontendEmailChangePath, token),
+		})
+		if err != nil {
+			w.LogError(err, "failed sending email")
+			return
+		}
+		w.LogInfo(nil, "success sending email")
+	}(ctx, user, opts.Email, token)
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+func ConfirmEmailChange(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	var opts models.ConfirmEmailChangeOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.ConfirmEmailChangeRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	user, err := ctx.DB.ConfirmUserEmailChange(helpers.HashToken(opts.Token))
+	if err != nil {
+		if err == db.ErrEmailExists {
+			w.WriteJSON(http.StatusBadRequest, nil, nil, "email exists")
+			return
+		}
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed confirming email change")
+		return
+	}
+
+	if user == nil {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "invalid email change token")
+		return
+	}
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
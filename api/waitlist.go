@@ -0,0 +1,320 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/schema"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"github.com/thedevsaddam/govalidator"
+)
+
+// JoinWaitlist adds the client to the waitlist of a sold out event.
+func JoinWaitlist(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing event id")
+		return
+	}
+
+	var opts models.JoinWaitlistOpts
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.JoinWaitlistRules,
+		Data:    &opts,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.ValidateJSON()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	event, err := ctx.DB.GetEventByID(eventID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event")
+		return
+	}
+
+	if event == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "event not found")
+		return
+	}
+
+	if !event.StartDateTime.After(parkNow()) {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event already started")
+		return
+	}
+
+	available, err := ctx.DB.GetEventAvailableTickets(event.ID, 0)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting event available tickets")
+		return
+	}
+
+	if available < 0 || available >= opts.Tickets {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "event has tickets available")
+		return
+	}
+
+	entry, err := ctx.DB.GetActiveWaitlistEntry(event.ID, userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting waitlist entry")
+		return
+	}
+
+	if entry != nil {
+		w.WriteJSON(http.StatusBadRequest, entry, nil, "already in the waitlist")
+		return
+	}
+
+	entryID, err := ctx.DB.InsertWaitlistEntry(event.ID, userInfo.ID, opts.Tickets)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed inserting waitlist entry")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, models.WaitlistEntry{
+		ID:      entryID,
+		Event:   event,
+		Tickets: opts.Tickets,
+		Status:  db.ConstWaitlistStatuses.Waiting,
+		Created: time.Now(),
+	}, nil, "")
+}
+
+// LeaveWaitlist removes the client from the waitlist of the event. When the
+// client had an offer, its tickets are offered to the next in line.
+func LeaveWaitlist(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	vars := mux.Vars(r)
+	eventID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "failed parsing event id")
+		return
+	}
+
+	entry, err := ctx.DB.GetActiveWaitlistEntry(eventID, userInfo.ID)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting waitlist entry")
+		return
+	}
+
+	if entry == nil {
+		w.WriteJSON(http.StatusNotFound, nil, nil, "waitlist entry not found")
+		return
+	}
+
+	err = ctx.DB.CancelWaitlistEntry(entry.ID)
+	if err == db.ErrWaitlistEntryInvalid {
+		w.WriteJSON(http.StatusBadRequest, nil, err, "waitlist entry is no longer active")
+		return
+	}
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed cancelling waitlist entry")
+		return
+	}
+
+	if entry.Status == db.ConstWaitlistStatuses.Offered {
+		go processEventWaitlist(ctx, config.GetLogger(), eventID)
+	}
+
+	w.WriteJSON(http.StatusNoContent, nil, nil, "")
+}
+
+// GetWaitlist lists the waitlist entries. Clients only get their own.
+func GetWaitlist(ctx *config.AppContext, w *middlewares.ResponseWriter, r *http.Request) {
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier && !userInfo.IsClient {
+		w.WriteJSON(http.StatusForbidden, nil, nil, "invalid roles")
+		return
+	}
+
+	validatorOpts := govalidator.Options{
+		Request: r,
+		Rules:   models.GetWaitlistRules,
+	}
+	v := govalidator.New(validatorOpts)
+	errs := v.Validate()
+	if len(errs) > 0 {
+		w.WriteJSON(http.StatusBadRequest, errs, nil, "failed validations")
+		return
+	}
+
+	var opts models.GetWaitlistOpts
+	decoder := schema.NewDecoder()
+	decoder.Decode(&opts, r.URL.Query())
+
+	if !userInfo.IsAdmin && !userInfo.IsCashier {
+		opts.UserID = userInfo.ID
+	}
+
+	waitlist, err := ctx.DB.GetWaitlist(&opts)
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "failed getting waitlist")
+		return
+	}
+
+	w.WriteJSON(http.StatusOK, waitlist, nil, "")
+}
+
+// getWaitlistOffer validates the waitlist token sent with an order and
+// returns the offer it belongs to.
+func getWaitlistOffer(ctx *config.AppContext, w *middlewares.ResponseWriter, token string, clientID int, eventID int, tickets int) (*models.WaitlistEntry, bool) {
+	entry, err := ctx.DB.GetWaitlistEntryByToken(helpers.HashToken(token))
+	if err != nil {
+		w.WriteJSON(http.StatusInternalServerError, nil, err, "Error del servidor")
+		return nil, false
+	}
+
+	if entry == nil ||
+		entry.Status != db.ConstWaitlistStatuses.Offered ||
+		entry.OfferExpires == nil || entry.OfferExpires.Before(time.Now()) ||
+		entry.Event.ID != eventID ||
+		entry.User.ID != clientID {
+		w.WriteJSON(http.StatusBadRequest, nil, nil, "La oferta de la lista de espera no es válida")
+		return nil, false
+	}
+
+	if tickets > entry.Tickets {
+		w.WriteJSON(http.StatusBadRequest, entry, nil, "La oferta de la lista de espera no cubre esa cantidad de entradas")
+		return nil, false
+	}
+
+	return entry, true
+}
+
+// RunWaitlists processes the waitlists right away and then every configured
+// interval, for as long as the server runs.
+func RunWaitlists(ctx *config.AppContext) {
+	runPeriodically("waitlists", minutesOrDefault(ctx.Config.Waitlist.IntervalMinutes, 5), func() error {
+		return ProcessWaitlists(ctx)
+	})
+}
+
+// ProcessWaitlists expires the unused offers and offers the freed tickets
+// to the clients waiting for them.
+func ProcessWaitlists(ctx *config.AppContext) error {
+	if err := ctx.DB.ExpireWaitlistOffers(); err != nil {
+		return err
+	}
+
+	eventIDs, err := ctx.DB.GetWaitlistEventIDs()
+	if err != nil {
+		return err
+	}
+
+	logger := config.GetLogger()
+	for _, eventID := range eventIDs {
+		processEventWaitlist(ctx, logger, eventID)
+	}
+
+	return nil
+}
+
+// processEventWaitlist offers the available tickets of the event following
+// the order of arrival. An entry asking for more tickets than available
+// doesn't block the smaller ones behind it. Seats held by unpaid orders are
+// not available, they're offered on a later run if their reservation lapses.
+func processEventWaitlist(ctx *config.AppContext, logger *log.Entry, eventID int) {
+	logger = logger.WithFields(log.Fields{
+		"waitlist_event_id": eventID,
+	})
+
+	available, err := ctx.DB.GetEventAvailableTickets(eventID, 0)
+	if err != nil {
+		logger.WithError(err).Error("failed getting event available tickets")
+		return
+	}
+
+	if available <= 0 {
+		return
+	}
+
+	entries, err := ctx.DB.GetWaitingEntries(eventID)
+	if err != nil {
+		logger.WithError(err).Error("failed getting waiting entries")
+		return
+	}
+
+	for i := range entries {
+		entry := &entries[i]
+		if entry.Tickets > available {
+			continue
+		}
+
+		token, err := helpers.GenerateRandomToken()
+		if err != nil {
+			logger.WithError(err).Error("failed generating waitlist token")
+			return
+		}
+
+		expires := time.Now().Add(time.Duration(ctx.Config.Waitlist.OfferMinutes) * time.Minute)
+
+		err = ctx.DB.OfferWaitlistEntry(entry.ID, helpers.HashToken(token), expires)
+		if err == db.ErrWaitlistEntryInvalid {
+			continue
+		}
+		if err != nil {
+			logger.WithError(err).Error("failed offering waitlist entry")
+			return
+		}
+
+		available -= entry.Tickets
+
+		if err := sendWaitlistOfferEmail(ctx, entry, token, expires); err != nil {
+			logger.WithError(err).WithField("waitlist_entry_id", entry.ID).Error("failed sending email")
+			continue
+		}
+		logger.WithField("waitlist_entry_id", entry.ID).Info("success sending waitlist offer")
+	}
+}
+
+func sendWaitlistOfferEmail(ctx *config.AppContext, entry *models.WaitlistEntry, token string, expires time.Time) error {
+	ed := &helpers.EmailData{
+		EmailTo:      entry.User.Email,
+		NameTo:       entry.User.Firstname,
+		EmailFrom:    ctx.Config.Mail.EmailFrom,
+		NameFrom:     ctx.Config.Mail.NameFrom,
+		Subject:      ctx.Config.Mail.WaitlistOffer.Subject,
+		TemplatePath: fmt.Sprintf("%s%s/%s", ctx.Config.Mail.Folder, ctx.Config.Mail.Path, ctx.Config.Mail.WaitlistOffer.Template),
+		AwsSMTP:      ctx.AwsSMTP,
+	}
+
+	return ed.SendEmail(models.WaitlistOfferHTML{
+		Firstname: entry.User.Firstname,
+		Lastname:  entry.User.Lastname,
+		EventType: entry.Event.Type.Name,
+		Date:      entry.Event.StartDateTime.Format("02-01-2006"),
+		Tickets:   entry.Tickets,
+		Expires:   expires.Format("02-01-2006 15:04"),
+		URL:       fmt.Sprintf("%s%s/%s", ctx.Config.FrontendBaseURL, ctx.Config.FrontendWaitlistPath, token),
+	})
+}
@@ -5,7 +5,9 @@ import (
 	"strconv"
 
 	db "bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/dte"
 	mercadopago "bitbucket.org/parqueoasis/backend/mercadopago"
+	"bitbucket.org/parqueoasis/backend/pubsub"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/jmoiron/sqlx"
@@ -23,13 +25,38 @@ type Configuration struct {
 	AwsSMTP                       awsSMTP
 	AwsS3                         awsS3
 	MercadoPago                   mercadopagoConf
+	DTE                           dteConf
 	Mail                          mail
+	Login                         loginConf
+	PasswordReset                 passwordResetConf
+	EmailVerification             emailVerificationConf
+	TwoFactor                     twoFactorConf
+	Reschedule                    rescheduleConf
+	Waitlist                      waitlistConf
+	GiftVoucher                   giftVoucherConf
+	GroupBooking                  groupBookingConf
+	Export                        exportConf
+	LiveOccupancy                 liveOccupancyConf
+	Reminder                      reminderConf
+	DailyReport                   dailyReportConf
+	EventNotice                   eventNoticeConf
 	Environment                   string `env:"ENVIRONMENT,default=development"`
 	CasbinModel                   string `env:"RBAC_FILE,default=config/rbac.conf"`
 	FrontendBaseURL               string `env:"FRONTEND_BASEURL"`
 	BackofficeBaseURL             string `env:"BACKOFFICE_BASEURL"`
 	BackofficePasswordRecoverPath string `env:"BACKOFFICE_PASSWORD_RECOVER_PATH"`
+	BackofficeUnlockPath          string `env:"BACKOFFICE_UNLOCK_PATH"`
+	FrontendEmailVerificationPath string `env:"FRONTEND_EMAIL_VERIFICATION_PATH"`
+	FrontendEmailChangePath       string `env:"FRONTEND_EMAIL_CHANGE_PATH"`
+	EmailChangeTokenMinutes       int    `env:"EMAIL_CHANGE_TOKEN_MINUTES,default=60"`
+	FrontendOrderTransferPath     string `env:"FRONTEND_ORDER_TRANSFER_PATH"`
+	OrderTransferHours            int    `env:"ORDER_TRANSFER_HOURS,default=72"`
+	OrderReservationMinutes       int    `env:"ORDER_RESERVATION_MINUTES,default=30"`
+	FrontendWaitlistPath          string `env:"FRONTEND_WAITLIST_PATH"`
+	FrontendReschedulePath        string `env:"FRONTEND_RESCHEDULE_PATH"`
+	FrontendEventNoticePath       string `env:"FRONTEND_EVENT_NOTICE_PATH"`
 	BackendBaseURL                string `env:"BACKEND_BASEURL"`
+	TrustedProxies                string `env:"TRUSTED_PROXIES"`
 	AppName                       string `env:"APP_NAME,default=app"`
 }
 
@@ -57,20 +84,126 @@ type mercadopagoConf struct {
 	GetPaymentURL    string `env:"MERCADOPAGO_GET_PAYMENT_URL"`
 }
 
+type dteConf struct {
+	Provider      string `env:"DTE_PROVIDER,default=fake"`
+	BaseURL       string `env:"DTE_BASEURL"`
+	Token         string `env:"DTE_TOKEN"`
+	IssuerRUT     string `env:"DTE_ISSUER_RUT"`
+	DocumentsPath string `env:"DTE_DOCUMENTS_PATH,default=/documents"`
+}
+
 type awsS3 struct {
-	S3Region    string `env:"S3_REGION,required"`
-	S3Bucket    string `env:"S3_BUCKET,required"`
-	S3Url       string `env:"S3_URL,required"`
-	S3PathOrder string `env:"S3_PATH_ORDER,default=order"`
+	S3Region     string `env:"S3_REGION,required"`
+	S3Bucket     string `env:"S3_BUCKET,required"`
+	S3Url        string `env:"S3_URL,required"`
+	S3PathOrder  string `env:"S3_PATH_ORDER,default=order"`
+	S3PathExport string `env:"S3_PATH_EXPORT,default=export"`
+	S3PathShift  string `env:"S3_PATH_SHIFT,default=shift"`
+}
+
+type loginConf struct {
+	MaxAttemptsEmail int `env:"LOGIN_MAX_ATTEMPTS_EMAIL,default=5"`
+	MaxAttemptsIP    int `env:"LOGIN_MAX_ATTEMPTS_IP,default=20"`
+	WindowMinutes    int `env:"LOGIN_WINDOW_MINUTES,default=15"`
+	LockoutMinutes   int `env:"LOGIN_LOCKOUT_MINUTES,default=30"`
+	DelayBaseSeconds int `env:"LOGIN_DELAY_BASE_SECONDS,default=1"`
+	DelayMaxSeconds  int `env:"LOGIN_DELAY_MAX_SECONDS,default=30"`
+}
+
+type passwordResetConf struct {
+	TokenMinutes     int `env:"PASSWORD_RESET_TOKEN_MINUTES,default=60"`
+	MaxRequestsEmail int `env:"PASSWORD_RESET_MAX_REQUESTS_EMAIL,default=3"`
+	MaxRequestsIP    int `env:"PASSWORD_RESET_MAX_REQUESTS_IP,default=10"`
+	WindowMinutes    int `env:"PASSWORD_RESET_WINDOW_MINUTES,default=60"`
+}
+
+type emailVerificationConf struct {
+	TokenHours       int `env:"EMAIL_VERIFICATION_TOKEN_HOURS,default=48"`
+	MaxRequestsEmail int `env:"EMAIL_VERIFICATION_MAX_REQUESTS_EMAIL,default=3"`
+	MaxRequestsIP    int `env:"EMAIL_VERIFICATION_MAX_REQUESTS_IP,default=10"`
+	WindowMinutes    int `env:"EMAIL_VERIFICATION_WINDOW_MINUTES,default=60"`
+}
+
+type twoFactorConf struct {
+	Issuer           string `env:"TWO_FACTOR_ISSUER,default=Parque Oasis"`
+	RequiredAdmin    bool   `env:"TWO_FACTOR_REQUIRED_ADMIN,default=false"`
+	RequiredCashier  bool   `env:"TWO_FACTOR_REQUIRED_CASHIER,default=false"`
+	ChallengeMinutes int    `env:"TWO_FACTOR_CHALLENGE_MINUTES,default=5"`
+	RecoveryCodes    int    `env:"TWO_FACTOR_RECOVERY_CODES,default=10"`
+}
+
+type rescheduleConf struct {
+	Fee           int `env:"RESCHEDULE_FEE,default=0"`
+	DeadlineHours int `env:"RESCHEDULE_DEADLINE_HOURS,default=24"`
+}
+
+type waitlistConf struct {
+	OfferMinutes    int  `env:"WAITLIST_OFFER_MINUTES,default=60"`
+	Enabled         bool `env:"WAITLIST_ENABLED,default=true"`
+	IntervalMinutes int  `env:"WAITLIST_INTERVAL_MINUTES,default=5"`
+}
+
+type giftVoucherConf struct {
+	TicketPrice            int `env:"GIFT_VOUCHER_TICKET_PRICE,default=0"`
+	ValidityMonths         int `env:"GIFT_VOUCHER_VALIDITY_MONTHS,default=12"`
+	ReservationMinutes     int `env:"GIFT_VOUCHER_RESERVATION_MINUTES,default=60"`
+	ReleaseIntervalMinutes int `env:"GIFT_VOUCHER_RELEASE_INTERVAL_MINUTES,default=5"`
+}
+
+type groupBookingConf struct {
+	MinTickets  int    `env:"GROUP_BOOKING_MIN_TICKETS,default=40"`
+	BankDetails string `env:"GROUP_BOOKING_BANK_DETAILS"`
+}
+
+type exportConf struct {
+	AsyncRows int `env:"EXPORT_ASYNC_ROWS,default=5000"`
+}
+
+type liveOccupancyConf struct {
+	HeartbeatSeconds int `env:"LIVE_OCCUPANCY_HEARTBEAT_SECONDS,default=15"`
+	ResyncSeconds    int `env:"LIVE_OCCUPANCY_RESYNC_SECONDS,default=60"`
+}
+
+type eventNoticeConf struct {
+	ResponseDays int `env:"EVENT_NOTICE_RESPONSE_DAYS,default=30"`
+	MaxAttempts  int `env:"EVENT_NOTICE_MAX_ATTEMPTS,default=3"`
+	MaxEvents    int `env:"EVENT_NOTICE_MAX_EVENTS,default=30"`
+}
+
+type reminderConf struct {
+	Enabled         bool `env:"REMINDER_ENABLED,default=true"`
+	Hours           int  `env:"REMINDER_HOURS,default=24"`
+	IntervalMinutes int  `env:"REMINDER_INTERVAL_MINUTES,default=10"`
+	MaxAttempts     int  `env:"REMINDER_MAX_ATTEMPTS,default=3"`
+	BatchSize       int  `env:"REMINDER_BATCH_SIZE,default=100"`
+}
+
+type dailyReportConf struct {
+	Enabled bool   `env:"DAILY_REPORT_ENABLED,default=true"`
+	Time    string `env:"DAILY_REPORT_TIME,default=23:00"`
 }
 
 type mail struct {
-	PaymentSuccess  mailPaymentSuccess
-	PasswordRecover mailPasswordRecover
-	NameFrom        string `env:"MAIL_NAME_FROM"`
-	EmailFrom       string `env:"MAIL_EMAIL_FROM"`
-	Folder          string `env:"MAIL_FOLDER"`
-	Path            string `env:"MAIL_PATH"`
+	PaymentSuccess       mailPaymentSuccess
+	PasswordRecover      mailPasswordRecover
+	AccountLocked        mailAccountLocked
+	EmailVerification    mailEmailVerification
+	EmailChange          mailEmailChange
+	OrderTransfer        mailOrderTransfer
+	OrderTransferred     mailOrderTransferred
+	OrderRescheduled     mailOrderRescheduled
+	WaitlistOffer        mailWaitlistOffer
+	GiftVoucher          mailGiftVoucher
+	GroupBookingApproved mailGroupBookingApproved
+	GroupBookingRejected mailGroupBookingRejected
+	ExportReady          mailExportReady
+	DailyReport          mailDailyReport
+	VisitReminder        mailVisitReminder
+	EventNotice          mailEventNotice
+	NameFrom             string `env:"MAIL_NAME_FROM"`
+	EmailFrom            string `env:"MAIL_EMAIL_FROM"`
+	Folder               string `env:"MAIL_FOLDER"`
+	Path                 string `env:"MAIL_PATH"`
 }
 
 type mailPaymentSuccess struct {
@@ -84,6 +217,81 @@ type mailPasswordRecover struct {
 	Template string `env:"MAIL_PASSWORD_RECOVER_TEMPLATE"`
 }
 
+type mailAccountLocked struct {
+	Subject  string `env:"MAIL_ACCOUNT_LOCKED_SUBJECT,default=Tu cuenta ha sido bloqueada"`
+	Template string `env:"MAIL_ACCOUNT_LOCKED_TEMPLATE,default=account_locked.html"`
+}
+
+type mailEmailVerification struct {
+	Subject  string `env:"MAIL_EMAIL_VERIFICATION_SUBJECT,default=Confirma tu correo"`
+	Template string `env:"MAIL_EMAIL_VERIFICATION_TEMPLATE,default=email_verification.html"`
+}
+
+type mailEmailChange struct {
+	Subject  string `env:"MAIL_EMAIL_CHANGE_SUBJECT,default=Confirma tu nuevo correo"`
+	Template string `env:"MAIL_EMAIL_CHANGE_TEMPLATE,default=email_change.html"`
+}
+
+type mailOrderTransfer struct {
+	Subject  string `env:"MAIL_ORDER_TRANSFER_SUBJECT,default=Te han transferido entradas"`
+	Template string `env:"MAIL_ORDER_TRANSFER_TEMPLATE,default=order_transfer.html"`
+}
+
+type mailOrderTransferred struct {
+	Subject  string `env:"MAIL_ORDER_TRANSFERRED_SUBJECT,default=Tus entradas transferidas"`
+	Template string `env:"MAIL_ORDER_TRANSFERRED_TEMPLATE,default=order_transferred.html"`
+	FileName string `env:"MAIL_ORDER_TRANSFERRED_FILENAME,default=entradas.pdf"`
+}
+
+type mailOrderRescheduled struct {
+	Subject  string `env:"MAIL_ORDER_RESCHEDULED_SUBJECT,default=Tu cambio de fecha está listo"`
+	Template string `env:"MAIL_ORDER_RESCHEDULED_TEMPLATE,default=order_rescheduled.html"`
+	FileName string `env:"MAIL_ORDER_RESCHEDULED_FILENAME,default=entradas.pdf"`
+}
+
+type mailWaitlistOffer struct {
+	Subject  string `env:"MAIL_WAITLIST_OFFER_SUBJECT,default=Se liberaron entradas para tu evento"`
+	Template string `env:"MAIL_WAITLIST_OFFER_TEMPLATE,default=waitlist_offer.html"`
+}
+
+type mailGiftVoucher struct {
+	Subject  string `env:"MAIL_GIFT_VOUCHER_SUBJECT,default=Te regalaron una gift card"`
+	Template string `env:"MAIL_GIFT_VOUCHER_TEMPLATE,default=gift_voucher.html"`
+	FileName string `env:"MAIL_GIFT_VOUCHER_FILENAME,default=giftcard.pdf"`
+}
+
+type mailGroupBookingApproved struct {
+	Subject  string `env:"MAIL_GROUP_BOOKING_APPROVED_SUBJECT,default=Tu reserva grupal fue aprobada"`
+	Template string `env:"MAIL_GROUP_BOOKING_APPROVED_TEMPLATE,default=group_booking_approved.html"`
+}
+
+type mailGroupBookingRejected struct {
+	Subject  string `env:"MAIL_GROUP_BOOKING_REJECTED_SUBJECT,default=Tu solicitud de reserva grupal"`
+	Template string `env:"MAIL_GROUP_BOOKING_REJECTED_TEMPLATE,default=group_booking_rejected.html"`
+}
+
+type mailExportReady struct {
+	Subject  string `env:"MAIL_EXPORT_READY_SUBJECT,default=Tu exportación está lista"`
+	Template string `env:"MAIL_EXPORT_READY_TEMPLATE,default=export_ready.html"`
+}
+
+type mailDailyReport struct {
+	Subject  string `env:"MAIL_DAILY_REPORT_SUBJECT,default=Reporte diario"`
+	Template string `env:"MAIL_DAILY_REPORT_TEMPLATE,default=daily_report.html"`
+}
+
+type mailVisitReminder struct {
+	Subject  string `env:"MAIL_VISIT_REMINDER_SUBJECT,default=Te esperamos en Parque Oasis"`
+	Template string `env:"MAIL_VISIT_REMINDER_TEMPLATE,default=visit_reminder.html"`
+	FileName string `env:"MAIL_VISIT_REMINDER_FILENAME,default=entradas.pdf"`
+}
+
+type mailEventNotice struct {
+	SubjectCancelled   string `env:"MAIL_EVENT_NOTICE_CANCELLED_SUBJECT,default=Tu visita a Parque Oasis fue cancelada"`
+	SubjectRescheduled string `env:"MAIL_EVENT_NOTICE_RESCHEDULED_SUBJECT,default=Tu visita a Parque Oasis cambió de horario"`
+	Template           string `env:"MAIL_EVENT_NOTICE_TEMPLATE,default=event_notice.html"`
+}
+
 type AppContext struct {
 	Language    string
 	Config      Configuration
@@ -92,6 +300,8 @@ type AppContext struct {
 	AwsSMTP     *gomail.Dialer
 	AwsS3       *session.Session
 	MercadoPago *mercadopago.MP
+	DTE         dte.Provider
+	Events      *pubsub.Broker
 }
 
 func CreateConnectionSQL(conf database) (*sqlx.DB, error) {
@@ -120,6 +330,23 @@ func CreateMercadoPagoIntegration(conf mercadopagoConf) *mercadopago.MP {
 	return &mp
 }
 
+// CreateDTEProvider returns the provider issuing the tax documents. Any value
+// other than "http" uses the local fake provider.
+func CreateDTEProvider(conf dteConf) dte.Provider {
+	if conf.Provider == "http" {
+		return &dte.HTTPProvider{
+			BaseURL:       conf.BaseURL,
+			Token:         conf.Token,
+			IssuerRUT:     conf.IssuerRUT,
+			DocumentsPath: conf.DocumentsPath,
+		}
+	}
+
+	return &dte.FakeProvider{
+		BaseURL: conf.BaseURL,
+	}
+}
+
 func CreateNewSessionS3(conf awsS3) (*session.Session, error) {
 	s, err := session.NewSession(&aws.Config{Region: aws.String(conf.S3Region)})
 	return s, err
@@ -131,6 +358,11 @@ func SetLogger(newLogger *log.Entry) {
 	logger = newLogger
 }
 
+// GetLogger returns the logger of the current request, or the standard one
+// when there is none, like in the commands.
 func GetLogger() *log.Entry {
+	if logger == nil {
+		return log.NewEntry(log.StandardLogger())
+	}
 	return logger
 }
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"strings"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type AuditStorage interface {
+	InsertAuditLog(entry *models.AuditLog) error
+	GetAuditLogs(opts *models.GetAuditLogsOpts) (*models.AuditLogsStruct, error)
+}
+
+const (
+	insertAuditLog = `
+	INSERT
+		audit_log
+	SET
+		actor_id = :actor_id,
+		action = :action,
+		entity = :entity,
+		entity_id = :entity_id,
+		before_data = :before_data,
+		after_data = :after_data,
+		diff = :diff,
+		method = :method,
+		path = :path,
+		status_code = :status_code,
+		ip = :ip,
+		user_agent = :user_agent,
+		request_id = :request_id,
+		client_request_id = :client_request_id
+	`
+
+	getAuditLogs = `
+	SELECT
+		audit_log.id,
+		audit_log.action,
+		audit_log.entity,
+		COALESCE(audit_log.entity_id, 0),
+		audit_log.before_data,
+		audit_log.after_data,
+		audit_log.diff,
+		audit_log.method,
+		audit_log.path,
+		audit_log.status_code,
+		audit_log.ip,
+		COALESCE(audit_log.user_agent, ''),
+		COALESCE(audit_log.request_id, ''),
+		COALESCE(audit_log.client_request_id, ''),
+		audit_log.created,
+		actor.id,
+		actor.firstname,
+		actor.lastname,
+		actor.email
+	FROM
+		audit_log
+		INNER JOIN user actor ON actor.id = audit_log.actor_id
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		audit_log.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countAuditLogs = `
+	SELECT
+		COUNT(audit_log.id)
+	FROM
+		audit_log
+	WHERE
+		true
+		#FILTERS#
+	`
+)
+
+func (db *DB) InsertAuditLog(entry *models.AuditLog) error {
+	stmt, err := db.PrepareNamed(insertAuditLog)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"actor_id":          entry.Actor.ID,
+		"action":            entry.Action,
+		"entity":            entry.Entity,
+		"entity_id":         nil,
+		"before_data":       nullableJSON(entry.Before),
+		"after_data":        nullableJSON(entry.After),
+		"diff":              nullableJSON(entry.Diff),
+		"method":            entry.Method,
+		"path":              entry.Path,
+		"status_code":       entry.StatusCode,
+		"ip":                entry.IP,
+		"user_agent":        entry.UserAgent,
+		"request_id":        entry.RequestID,
+		"client_request_id": nil,
+	}
+	if entry.ClientRequestID != "" {
+		args["client_request_id"] = entry.ClientRequestID
+	}
+	if entry.EntityID != 0 {
+		args["entity_id"] = entry.EntityID
+	}
+
+	_, err = stmt.Exec(args)
+	return err
+}
+
+func nullableJSON(data []byte) interface{} {
+	if data == nil {
+		return nil
+	}
+	return string(data)
+}
+
+func (db *DB) GetAuditLogs(opts *models.GetAuditLogsOpts) (*models.AuditLogsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if len(opts.ActorIDs) > 0 {
+		filters += " AND audit_log.actor_id IN (:actor_ids) "
+		args["actor_ids"] = opts.ActorIDs
+	}
+	if len(opts.Actions) > 0 {
+		filters += " AND audit_log.action IN (:actions) "
+		args["actions"] = opts.Actions
+	}
+	if len(opts.Entities) > 0 {
+		filters += " AND audit_log.entity IN (:entities) "
+		args["entities"] = opts.Entities
+	}
+	if opts.EntityID != 0 {
+		filters += " AND audit_log.entity_id = :entity_id "
+		args["entity_id"] = opts.EntityID
+	}
+	if opts.RequestID != "" {
+		filters += " AND audit_log.request_id = :request_id "
+		args["request_id"] = opts.RequestID
+	}
+	if opts.IP != "" {
+		filters += " AND audit_log.ip = :ip "
+		args["ip"] = opts.IP
+	}
+	if opts.DateFrom != "" {
+		filters += " AND DATE(CONVERT_TZ(audit_log.created, 'UTC', 'America/Santiago')) >= :date_from "
+		args["date_from"] = opts.DateFrom
+	}
+	if opts.DateTo != "" {
+		filters += " AND DATE(CONVERT_TZ(audit_log.created, 'UTC', 'America/Santiago')) <= :date_to "
+		args["date_to"] = opts.DateTo
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countAuditLogs(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query, queryArgs, err := sqlx.Named(strings.ReplaceAll(getAuditLogs, "#FILTERS#", filters), args)
+	if err != nil {
+		return nil, err
+	}
+
+	query, queryArgs, err = sqlx.In(query, queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(db.Rebind(query), queryArgs...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	logs := models.AuditLogsStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		entry := models.AuditLog{
+			Actor: &models.User{},
+		}
+		var before, after, diff []byte
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Action,
+			&entry.Entity,
+			&entry.EntityID,
+			&before,
+			&after,
+			&diff,
+			&entry.Method,
+			&entry.Path,
+			&entry.StatusCode,
+			&entry.IP,
+			&entry.UserAgent,
+			&entry.RequestID,
+			&entry.ClientRequestID,
+			&entry.Created,
+			&entry.Actor.ID,
+			&entry.Actor.Firstname,
+			&entry.Actor.Lastname,
+			&entry.Actor.Email,
+		); err != nil {
+			return nil, err
+		}
+
+		entry.Before = before
+		entry.After = after
+		entry.Diff = diff
+
+		logs.Logs = append(logs.Logs, entry)
+	}
+
+	return &logs, nil
+}
+
+func (db *DB) countAuditLogs(filters string, args map[string]interface{}) (int, error) {
+	query, queryArgs, err := sqlx.Named(strings.ReplaceAll(countAuditLogs, "#FILTERS#", filters), args)
+	if err != nil {
+		return 0, err
+	}
+
+	query, queryArgs, err = sqlx.In(query, queryArgs...)
+	if err != nil {
+		return 0, err
+	}
+
+	row := db.QueryRow(db.Rebind(query), queryArgs...)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
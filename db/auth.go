@@ -3,14 +3,60 @@ package db
 import (
 	"database/sql"
 	"encoding/json"
+	"strings"
+	"time"
 
 	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/pkg/errors"
 )
 
 type AuthStorage interface {
 	GetUserLoginByEmail(string) (*models.User, error)
 	GetUserByRememberToken(string) (*models.User, error)
-	UpdateUserRememberToken(int, string) error
+	UpdateUserRememberToken(userID int, tokenHash string, expires time.Time) error
+	ClearUserRememberToken(userID int) error
+	InsertPasswordResetRequest(email string, ip string) error
+	CountPasswordResetRequests(email string, ip string, since time.Time) (emailCounter int, ipCounter int, err error)
+	InsertLoginAttempt(attempt *models.LoginAttempt) error
+	GetLoginAttemptsSummary(email string, ip string, since time.Time) (*models.LoginAttemptsSummary, error)
+	GetLoginAttempts(opts *models.GetLoginAttemptsOpts) (*models.LoginAttemptsStruct, error)
+	LockUser(userID int, lockedUntil time.Time, unlockTokenHash string) error
+	UnlockUserByToken(tokenHash string) (*models.User, error)
+	UpdateUserTwoFactorChallenge(userID int, tokenHash string, expires time.Time) error
+	GetUserLoginByTwoFactorChallenge(tokenHash string) (*models.User, error)
+	ClearUserTwoFactorChallenge(userID int) error
+	GetUserTwoFactor(userID int) (*models.User, error)
+	UpdateUserTwoFactorSecret(userID int, secret string) error
+	EnableUserTwoFactor(userID int, recoveryCodeHashes []string) error
+	DisableUserTwoFactor(userID int) error
+	UpdateTwoFactorRecoveryCodes(userID int, recoveryCodeHashes []string) error
+	UseTwoFactorRecoveryCode(userID int, recoveryCodeHash string) (bool, error)
+	UseTwoFactorStep(userID int, step int64) (bool, error)
+	GetUserSession(userID int) (*models.User, error)
+	RevokeUserSessions(userID int) error
+}
+
+var ConstLoginAttemptReasons = struct {
+	Success         string
+	UserNotFound    string
+	InvalidPassword string
+	Locked          string
+	Throttled       string
+	Unlocked        string
+
+	TwoFactorRequired string
+	InvalidTwoFactor  string
+}{
+	Success:         "success",
+	UserNotFound:    "user_not_found",
+	InvalidPassword: "invalid_password",
+	Locked:          "locked",
+	Throttled:       "throttled",
+	Unlocked:        "unlocked",
+
+	TwoFactorRequired: "two_factor_required",
+	InvalidTwoFactor:  "invalid_two_factor",
 }
 
 const (
@@ -24,6 +70,10 @@ const (
 		user.created,
 		user.updated,
 		user.active,
+		user.email_verified,
+		user.locked_until,
+		user.two_factor_enabled,
+		COALESCE(user.two_factor_secret, ''),
 		COALESCE(CONCAT('[',GROUP_CONCAT(JSON_OBJECT('id', role.id, 'name', role.name)),']'), '[]')
 	FROM user
 	INNER JOIN pivot_role_user ON (pivot_role_user.user_id = user.id)
@@ -40,16 +90,59 @@ const (
 	FROM user
 	WHERE user.active = 1
 	AND user.remember_token = :remember_token
+	AND user.remember_token_expires > current_timestamp()
 	`
 
 	updateUserRememberToken = `
 	UPDATE
 		user
 	SET
-		remember_token = :token
+		remember_token = :token,
+		remember_token_expires = :expires
 	WHERE
 		id = :user_id
 	`
+
+	clearUserRememberToken = `
+	UPDATE
+		user
+	SET
+		remember_token = NULL,
+		remember_token_expires = NULL
+	WHERE
+		id = :user_id AND
+		remember_token IS NOT NULL
+	`
+
+	insertPasswordResetRequest = `
+	INSERT
+		password_reset_request
+	SET
+		email = :email,
+		ip = :ip
+	`
+
+	countPasswordResetRequests = `
+	SELECT
+		(
+			SELECT
+				COUNT(password_reset_request.id)
+			FROM
+				password_reset_request
+			WHERE
+				password_reset_request.email = :email AND
+				password_reset_request.created >= :since
+		) email_counter,
+		(
+			SELECT
+				COUNT(password_reset_request.id)
+			FROM
+				password_reset_request
+			WHERE
+				password_reset_request.ip = :ip AND
+				password_reset_request.created >= :since
+		) ip_counter
+	`
 )
 
 func (db *DB) GetUserLoginByEmail(email string) (*models.User, error) {
@@ -62,8 +155,10 @@ func (db *DB) GetUserLoginByEmail(email string) (*models.User, error) {
 		"email": email,
 	}
 
-	row := stmt.QueryRow(args)
+	return scanUserLogin(stmt.QueryRow(args))
+}
 
+func scanUserLogin(row *sqlx.Row) (*models.User, error) {
 	var user models.User
 	var rolesBytes []byte
 
@@ -76,6 +171,10 @@ func (db *DB) GetUserLoginByEmail(email string) (*models.User, error) {
 		&user.Created,
 		&user.Updated,
 		&user.Active,
+		&user.EmailVerified,
+		&user.LockedUntil,
+		&user.TwoFactorEnabled,
+		&user.TwoFactorSecret,
 		&rolesBytes,
 	); err != nil {
 		if err == sql.ErrNoRows {
@@ -85,7 +184,7 @@ func (db *DB) GetUserLoginByEmail(email string) (*models.User, error) {
 	}
 
 	var roles []models.Role
-	err = json.Unmarshal(rolesBytes, &roles)
+	err := json.Unmarshal(rolesBytes, &roles)
 	if err != nil {
 		return nil, err
 	}
@@ -121,14 +220,900 @@ func (db *DB) GetUserByRememberToken(token string) (*models.User, error) {
 	return &user, nil
 }
 
-func (db *DB) UpdateUserRememberToken(userID int, token string) error {
+func (db *DB) UpdateUserRememberToken(userID int, tokenHash string, expires time.Time) error {
 	stmt, err := db.PrepareNamed(updateUserRememberToken)
 	if err != nil {
 		return err
 	}
 
 	args := map[string]interface{}{
-		"token":   token,
+		"token":   tokenHash,
+		"expires": expires.UTC(),
+		"user_id": userID,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) ClearUserRememberToken(userID int) error {
+	stmt, err := db.PrepareNamed(clearUserRememberToken)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) InsertPasswordResetRequest(email string, ip string) error {
+	stmt, err := db.PrepareNamed(insertPasswordResetRequest)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"email": email,
+		"ip":    ip,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) CountPasswordResetRequests(email string, ip string, since time.Time) (emailCounter int, ipCounter int, err error) {
+	stmt, err := db.PrepareNamed(countPasswordResetRequests)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	args := map[string]interface{}{
+		"email": email,
+		"ip":    ip,
+		"since": since.UTC(),
+	}
+
+	row := stmt.QueryRow(args)
+
+	if err := row.Scan(
+		&emailCounter,
+		&ipCounter,
+	); err != nil {
+		return 0, 0, err
+	}
+
+	return emailCounter, ipCounter, nil
+}
+
+const (
+	insertLoginAttempt = `
+	INSERT
+		login_attempt
+	SET
+		email = :email,
+		ip = :ip,
+		user_agent = :user_agent,
+		user_id = :user_id,
+		success = :success,
+		reason = :reason
+	`
+
+	getLoginAttemptsSummary = `
+	SELECT
+		(
+			SELECT
+				COUNT(login_attempt.id)
+			FROM
+				login_attempt
+			WHERE
+				login_attempt.email = :email AND
+				login_attempt.reason IN (:invalid_password, :user_not_found, :invalid_two_factor) AND
+				login_attempt.created >= :since AND
+				login_attempt.created > COALESCE(
+					(
+						SELECT
+							MAX(last_success.created)
+						FROM
+							login_attempt AS last_success
+						WHERE
+							last_success.email = :email AND
+							last_success.success = true
+					), '1970-01-01'
+				) AND
+				login_attempt.created > COALESCE(
+					(
+						SELECT
+							MAX(user.login_failures_reset_at)
+						FROM
+							user
+						WHERE
+							user.email = :email
+					), '1970-01-01'
+				)
+		) email_failures,
+		(
+			SELECT
+				COUNT(login_attempt.id)
+			FROM
+				login_attempt
+			WHERE
+				login_attempt.ip = :ip AND
+				login_attempt.reason IN (:invalid_password, :user_not_found, :invalid_two_factor) AND
+				login_attempt.created >= :since
+		) ip_failures,
+		(
+			SELECT
+				MAX(login_attempt.created)
+			FROM
+				login_attempt
+			WHERE
+				login_attempt.email = :email AND
+				login_attempt.reason IN (:invalid_password, :user_not_found, :invalid_two_factor) AND
+				login_attempt.created >= :since
+		) last_failure
+	`
+
+	getLoginAttempts = `
+	SELECT
+		login_attempt.id,
+		login_attempt.email,
+		login_attempt.ip,
+		login_attempt.user_agent,
+		login_attempt.success,
+		login_attempt.reason,
+		login_attempt.created,
+		COALESCE(login_attempt.user_id, 0)
+	FROM
+		login_attempt
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		login_attempt.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countLoginAttempts = `
+	SELECT
+		COUNT(login_attempt.id)
+	FROM
+		login_attempt
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	lockUser = `
+	UPDATE
+		user
+	SET
+		locked_until = :locked_until,
+		unlock_token = :unlock_token
+	WHERE
+		id = :user_id
+	`
+
+	getUserByUnlockToken = `
+	SELECT
+		user.id,
+		user.email
+	FROM
+		user
+	WHERE
+		user.active = 1 AND
+		user.unlock_token = :unlock_token
+	`
+
+	unlockUser = `
+	UPDATE
+		user
+	SET
+		locked_until = NULL,
+		unlock_token = NULL,
+		login_failures_reset_at = current_timestamp()
+	WHERE
+		id = :user_id
+	`
+)
+
+func (db *DB) InsertLoginAttempt(attempt *models.LoginAttempt) error {
+	stmt, err := db.PrepareNamed(insertLoginAttempt)
+	if err != nil {
+		return err
+	}
+
+	var userID *int
+	if attempt.User != nil {
+		userID = &attempt.User.ID
+	}
+
+	args := map[string]interface{}{
+		"email":      attempt.Email,
+		"ip":         attempt.IP,
+		"user_agent": attempt.UserAgent,
+		"user_id":    userID,
+		"success":    attempt.Success,
+		"reason":     attempt.Reason,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) GetLoginAttemptsSummary(email string, ip string, since time.Time) (*models.LoginAttemptsSummary, error) {
+	stmt, err := db.PrepareNamed(getLoginAttemptsSummary)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"email":              email,
+		"ip":                 ip,
+		"since":              since.UTC(),
+		"invalid_password":   ConstLoginAttemptReasons.InvalidPassword,
+		"user_not_found":     ConstLoginAttemptReasons.UserNotFound,
+		"invalid_two_factor": ConstLoginAttemptReasons.InvalidTwoFactor,
+	}
+
+	var summary models.LoginAttemptsSummary
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&summary.EmailFailures,
+		&summary.IPFailures,
+		&summary.LastFailure,
+	); err != nil {
+		return nil, err
+	}
+
+	return &summary, nil
+}
+
+func (db *DB) GetLoginAttempts(opts *models.GetLoginAttemptsOpts) (*models.LoginAttemptsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.Email != "" {
+		filters += " AND login_attempt.email = :email "
+		args["email"] = opts.Email
+	}
+	if opts.IP != "" {
+		filters += " AND login_attempt.ip = :ip "
+		args["ip"] = opts.IP
+	}
+	if opts.Success != nil {
+		filters += " AND login_attempt.success = :success "
+		args["success"] = opts.Success
+	}
+	if opts.DateFrom != "" {
+		filters += " AND DATE(CONVERT_TZ(login_attempt.created, 'UTC', 'America/Santiago')) >= :date_from "
+		args["date_from"] = opts.DateFrom
+	}
+	if opts.DateTo != "" {
+		filters += " AND DATE(CONVERT_TZ(login_attempt.created, 'UTC', 'America/Santiago')) <= :date_to "
+		args["date_to"] = opts.DateTo
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countLoginAttempts(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ReplaceAll(getLoginAttempts, "#FILTERS#", filters)
+
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attempts := models.LoginAttemptsStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		var userID int
+		if err := rows.Scan(
+			&attempt.ID,
+			&attempt.Email,
+			&attempt.IP,
+			&attempt.UserAgent,
+			&attempt.Success,
+			&attempt.Reason,
+			&attempt.Created,
+			&userID,
+		); err != nil {
+			return nil, err
+		}
+
+		if userID != 0 {
+			attempt.User = &models.User{
+				ID: userID,
+			}
+		}
+
+		attempts.Attempts = append(attempts.Attempts, attempt)
+	}
+
+	return &attempts, nil
+}
+
+func (db *DB) countLoginAttempts(filters string, args map[string]interface{}) (int, error) {
+	query := strings.ReplaceAll(countLoginAttempts, "#FILTERS#", filters)
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (db *DB) LockUser(userID int, lockedUntil time.Time, unlockTokenHash string) error {
+	stmt, err := db.PrepareNamed(lockUser)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id":      userID,
+		"locked_until": lockedUntil.UTC(),
+		"unlock_token": unlockTokenHash,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UnlockUserByToken unlocks the user and resets the failures counted by the
+// login throttle, so the next wrong password doesn't lock the account again.
+func (db *DB) UnlockUserByToken(tokenHash string) (*models.User, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(getUserByUnlockToken)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"unlock_token": tokenHash,
+	}
+
+	var user models.User
+
+	row := stmt.QueryRow(args)
+	if err = row.Scan(
+		&user.ID,
+		&user.Email,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stmt, err = tx.PrepareNamed(unlockUser)
+	if err != nil {
+		return nil, err
+	}
+
+	args["user_id"] = user.ID
+	if _, err = stmt.Exec(args); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+const (
+	updateUserTwoFactorChallenge = `
+	UPDATE
+		user
+	SET
+		two_factor_challenge = :token,
+		two_factor_challenge_expires = :expires
+	WHERE
+		id = :user_id
+	`
+
+	getUserLoginByTwoFactorChallenge = `
+	SELECT
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		user.password,
+		user.created,
+		user.updated,
+		user.active,
+		user.email_verified,
+		user.locked_until,
+		user.two_factor_enabled,
+		COALESCE(user.two_factor_secret, ''),
+		COALESCE(CONCAT('[',GROUP_CONCAT(JSON_OBJECT('id', role.id, 'name', role.name)),']'), '[]')
+	FROM user
+	INNER JOIN pivot_role_user ON (pivot_role_user.user_id = user.id)
+	INNER JOIN role ON (role.id = pivot_role_user.role_id AND role.active = 1)
+	WHERE user.two_factor_challenge = :token
+	AND user.two_factor_challenge_expires > current_timestamp()
+	AND user.active = 1
+	GROUP BY user.id
+	`
+
+	clearUserTwoFactorChallenge = `
+	UPDATE
+		user
+	SET
+		two_factor_challenge = NULL,
+		two_factor_challenge_expires = NULL
+	WHERE
+		id = :user_id
+	`
+
+	getUserTwoFactor = `
+	SELECT
+		user.id,
+		user.email,
+		user.password,
+		user.two_factor_enabled,
+		COALESCE(user.two_factor_secret, '')
+	FROM
+		user
+	WHERE
+		user.id = :user_id
+	`
+
+	updateUserTwoFactorSecret = `
+	UPDATE
+		user
+	SET
+		two_factor_secret = :secret
+	WHERE
+		id = :user_id AND
+		two_factor_enabled = false
+	`
+
+	enableUserTwoFactor = `
+	UPDATE
+		user
+	SET
+		two_factor_enabled = true
+	WHERE
+		id = :user_id AND
+		two_factor_secret IS NOT NULL
+	`
+
+	disableUserTwoFactor = `
+	UPDATE
+		user
+	SET
+		two_factor_enabled = false,
+		two_factor_secret = NULL,
+		two_factor_challenge = NULL,
+		two_factor_challenge_expires = NULL
+	WHERE
+		id = :user_id
+	`
+
+	deleteTwoFactorRecoveryCodes = `
+	DELETE FROM
+		two_factor_recovery_code
+	WHERE
+		user_id = :user_id
+	`
+
+	insertTwoFactorRecoveryCode = `
+	INSERT
+		two_factor_recovery_code
+	SET
+		user_id = :user_id,
+		code = :code
+	`
+
+	useTwoFactorRecoveryCode = `
+	UPDATE
+		two_factor_recovery_code
+	SET
+		used_at = current_timestamp()
+	WHERE
+		user_id = :user_id AND
+		code = :code AND
+		used_at IS NULL
+	`
+
+	useTwoFactorStep = `
+	UPDATE
+		user
+	SET
+		two_factor_last_step = :step
+	WHERE
+		id = :user_id AND
+		(two_factor_last_step IS NULL OR two_factor_last_step < :step)
+	`
+)
+
+func (db *DB) UpdateUserTwoFactorChallenge(userID int, tokenHash string, expires time.Time) error {
+	stmt, err := db.PrepareNamed(updateUserTwoFactorChallenge)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"token":   tokenHash,
+		"expires": expires.UTC(),
+		"user_id": userID,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) GetUserLoginByTwoFactorChallenge(tokenHash string) (*models.User, error) {
+	stmt, err := db.PrepareNamed(getUserLoginByTwoFactorChallenge)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"token": tokenHash,
+	}
+
+	return scanUserLogin(stmt.QueryRow(args))
+}
+
+func (db *DB) ClearUserTwoFactorChallenge(userID int) error {
+	stmt, err := db.PrepareNamed(clearUserTwoFactorChallenge)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) GetUserTwoFactor(userID int) (*models.User, error) {
+	stmt, err := db.PrepareNamed(getUserTwoFactor)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	var user models.User
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Password,
+		&user.TwoFactorEnabled,
+		&user.TwoFactorSecret,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+func (db *DB) UpdateUserTwoFactorSecret(userID int, secret string) error {
+	stmt, err := db.PrepareNamed(updateUserTwoFactorSecret)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+		"secret":  secret,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) EnableUserTwoFactor(userID int, recoveryCodeHashes []string) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(enableUserTwoFactor)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	res, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		err = errors.New("two factor secret not set")
+		return err
+	}
+
+	err = replaceTwoFactorRecoveryCodesTx(tx, userID, recoveryCodeHashes)
+	return err
+}
+
+func (db *DB) DisableUserTwoFactor(userID int) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(disableUserTwoFactor)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	if _, err = stmt.Exec(args); err != nil {
+		return err
+	}
+
+	err = replaceTwoFactorRecoveryCodesTx(tx, userID, nil)
+	return err
+}
+
+func (db *DB) UpdateTwoFactorRecoveryCodes(userID int, recoveryCodeHashes []string) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	err = replaceTwoFactorRecoveryCodesTx(tx, userID, recoveryCodeHashes)
+	return err
+}
+
+func replaceTwoFactorRecoveryCodesTx(tx Tx, userID int, recoveryCodeHashes []string) error {
+	stmt, err := tx.PrepareNamed(deleteTwoFactorRecoveryCodes)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	if _, err := stmt.Exec(args); err != nil {
+		return err
+	}
+
+	stmt, err = tx.PrepareNamed(insertTwoFactorRecoveryCode)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodeHashes {
+		args["code"] = code
+		if _, err := stmt.Exec(args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (db *DB) UseTwoFactorRecoveryCode(userID int, recoveryCodeHash string) (bool, error) {
+	stmt, err := db.PrepareNamed(useTwoFactorRecoveryCode)
+	if err != nil {
+		return false, err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+		"code":    recoveryCodeHash,
+	}
+
+	res, err := stmt.Exec(args)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// UseTwoFactorStep records the time step of an accepted authenticator code.
+// It returns false when that step or a later one was already used, so a code
+// can't be replayed while it's still inside the validation window.
+func (db *DB) UseTwoFactorStep(userID int, step int64) (bool, error) {
+	stmt, err := db.PrepareNamed(useTwoFactorStep)
+	if err != nil {
+		return false, err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+		"step":    step,
+	}
+
+	res, err := stmt.Exec(args)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+const (
+	getUserSession = `
+	SELECT
+		user.id,
+		user.active,
+		user.sessions_revoked_at
+	FROM
+		user
+	WHERE
+		user.id = :user_id
+	`
+)
+
+func (db *DB) GetUserSession(userID int) (*models.User, error) {
+	stmt, err := db.PrepareNamed(getUserSession)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	var user models.User
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&user.ID,
+		&user.Active,
+		&user.SessionsRevokedAt,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+const (
+	revokeUserSessions = `
+	UPDATE
+		user
+	SET
+		sessions_revoked_at = current_timestamp()
+	WHERE
+		id = :user_id
+	`
+)
+
+func (db *DB) RevokeUserSessions(userID int) error {
+	stmt, err := db.PrepareNamed(revokeUserSessions)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
 		"user_id": userID,
 	}
 
@@ -55,7 +55,8 @@ const (
 		camping.active = true
 		#FILTERS#
 	ORDER BY
-		event.start_date_time DESC
+		event.start_date_time DESC,
+		camping.id DESC
 	LIMIT :limit_to OFFSET :limit_from
 	`
 
@@ -147,7 +148,7 @@ func (db *DB) GetCampings(opts *models.GetCampingsOpts) (*models.GetCampingsStru
 	}
 	if opts.EventTo != "" {
 		filters += " AND event.start_date_time <= :event_to "
-		args["event_to"] = opts.EventFrom
+		args["event_to"] = opts.EventTo
 	}
 	if opts.TransactionID != "" {
 		filters += " AND camping.transaction_id = :transaction_id "
@@ -213,6 +214,7 @@ func (db *DB) GetCampings(opts *models.GetCampingsOpts) (*models.GetCampingsStru
 			return nil, err
 		}
 
+		event.Type = &eventType
 		camping.Client = &client
 		camping.Event = &event
 
@@ -0,0 +1,738 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type CashierShiftStorage interface {
+	InsertCashierShift(userID int) (*models.CashierShift, error)
+	GetCashierShiftByID(shiftID int) (*models.CashierShift, error)
+	GetOpenCashierShift(userID int) (*models.CashierShift, error)
+	GetCashierShifts(opts *models.GetCashierShiftsOpts) (*models.CashierShiftsStruct, error)
+	CloseCashierShift(shift *models.CashierShift) error
+	GetCashierShiftSales(userID int, from time.Time, to time.Time) ([]models.CashierShiftSale, error)
+	GetCashierShiftAdmissions(userID int, from time.Time, to time.Time) ([]models.CashierShiftAdmission, error)
+	GetCashierShiftPayments(userID int, from time.Time, to time.Time) ([]models.CashierShiftPayments, error)
+	GetCashierShiftRefunds(userID int, from time.Time, to time.Time) ([]models.CashierShiftRefund, error)
+}
+
+var ErrCashierShiftOpen = errors.New("cashier already has an open shift")
+
+var ErrCashierShiftClosed = errors.New("cashier shift already closed")
+
+var ConstCashierShiftAdmissionTypes = struct {
+	Order string
+	Pass  string
+}{
+	Order: "order",
+	Pass:  "pass",
+}
+
+const (
+	lockCashierShiftUser = `
+	SELECT
+		id
+	FROM
+		user
+	WHERE
+		id = ?
+	FOR UPDATE
+	`
+
+	countOpenCashierShifts = `
+	SELECT
+		COUNT(id)
+	FROM
+		cashier_shift
+	WHERE
+		user_id = ? AND
+		closed IS NULL
+	`
+
+	insertCashierShift = `
+	INSERT
+		cashier_shift
+	SET
+		user_id = ?,
+		opened = ?
+	`
+
+	selectCashierShift = `
+	SELECT
+		cashier_shift.id,
+		cashier_shift.opened,
+		cashier_shift.closed,
+		cashier_shift.sales_total,
+		cashier_shift.tickets_sold,
+		cashier_shift.admissions,
+		COALESCE(cashier_shift.pdf_url, ''),
+		cashier_shift.created,
+		cashier_shift.updated,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		COALESCE(closed_by.id, 0),
+		COALESCE(closed_by.firstname, ''),
+		COALESCE(closed_by.lastname, '')
+	FROM
+		cashier_shift
+	INNER JOIN
+		user ON (user.id = cashier_shift.user_id)
+	LEFT JOIN
+		user AS closed_by ON (closed_by.id = cashier_shift.closed_by)
+	`
+
+	getCashierShiftByID = selectCashierShift + `
+	WHERE
+		cashier_shift.id = ?
+	`
+
+	getOpenCashierShift = selectCashierShift + `
+	WHERE
+		cashier_shift.user_id = ? AND
+		cashier_shift.closed IS NULL
+	ORDER BY
+		cashier_shift.id DESC
+	LIMIT 1
+	`
+
+	getCashierShifts = selectCashierShift + `
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		cashier_shift.opened DESC,
+		cashier_shift.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countCashierShifts = `
+	SELECT
+		COUNT(cashier_shift.id)
+	FROM
+		cashier_shift
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	closeCashierShift = `
+	UPDATE
+		cashier_shift
+	SET
+		closed = :closed,
+		closed_by = :closed_by,
+		sales_total = :sales_total,
+		tickets_sold = :tickets_sold,
+		admissions = :admissions,
+		pdf_url = :pdf_url
+	WHERE
+		id = :id AND
+		closed IS NULL
+	`
+
+	// getCashierShiftSales lists the orders the cashier created with their
+	// last payment, if any, and the season passes they sold.
+	getCashierShiftSales = `
+	SELECT
+		sale.type,
+		sale.id,
+		sale.reference,
+		sale.tickets,
+		sale.price,
+		sale.created,
+		sale.client_id,
+		sale.client_firstname,
+		sale.client_lastname,
+		sale.event_id,
+		sale.event_name,
+		sale.event_start_date_time,
+		sale.payment_method_id,
+		sale.payment_method_name,
+		sale.payment_status_id,
+		sale.payment_status_name
+	FROM
+		(
+			SELECT
+				:order_type AS type,
+				orders.id AS id,
+				orders.transaction_id AS reference,
+				orders.tickets AS tickets,
+				orders.price AS price,
+				orders.created AS created,
+				client.id AS client_id,
+				client.firstname AS client_firstname,
+				client.lastname AS client_lastname,
+				event.id AS event_id,
+				event.name AS event_name,
+				event.start_date_time AS event_start_date_time,
+				COALESCE(payment_method.id, 0) AS payment_method_id,
+				COALESCE(payment_method.name, '') AS payment_method_name,
+				COALESCE(payment_status.id, 0) AS payment_status_id,
+				COALESCE(payment_status.name, '') AS payment_status_name
+			FROM
+				orders
+			INNER JOIN
+				user AS client ON (client.id = orders.client_id)
+			INNER JOIN
+				event ON (event.id = orders.event_id)
+			LEFT JOIN
+				payment ON (payment.id = (SELECT id FROM payment WHERE payment.order_id = orders.id AND payment.active = true ORDER BY payment.id DESC LIMIT 1))
+			LEFT JOIN
+				payment_method ON (payment_method.id = payment.method_id)
+			LEFT JOIN
+				payment_status ON (payment_status.id = payment.status_id)
+			WHERE
+				orders.active = true AND
+				orders.user_id = :user_id AND
+				orders.created BETWEEN :date_from AND :date_to
+			UNION ALL
+			SELECT
+				:pass_type,
+				season_pass.id,
+				season_pass.code,
+				0,
+				season_pass.price,
+				season_pass.created,
+				holder.id,
+				holder.firstname,
+				holder.lastname,
+				pass_product.id,
+				pass_product.name,
+				season_pass.valid_from,
+				payment_method.id,
+				payment_method.name,
+				payment_status.id,
+				payment_status.name
+			FROM
+				payment
+			INNER JOIN
+				season_pass ON (season_pass.id = payment.season_pass_id)
+			INNER JOIN
+				pass_product ON (pass_product.id = season_pass.pass_product_id)
+			INNER JOIN
+				user AS holder ON (holder.id = season_pass.user_id)
+			INNER JOIN
+				payment_method ON (payment_method.id = payment.method_id)
+			INNER JOIN
+				payment_status ON (payment_status.id = payment.status_id)
+			WHERE
+				payment.active = true AND
+				payment.user_id = :user_id AND
+				season_pass.created BETWEEN :date_from AND :date_to
+		) AS sale
+	ORDER BY
+		sale.created ASC,
+		sale.id ASC
+	`
+
+	getCashierShiftAdmissions = `
+	SELECT
+		admission.type,
+		admission.reference,
+		admission.event_id,
+		admission.event_name,
+		admission.event_start_date_time,
+		admission.tickets,
+		admission.gate,
+		admission.created
+	FROM
+		(
+			SELECT
+				:order_type AS type,
+				orders.transaction_id AS reference,
+				event.id AS event_id,
+				event.name AS event_name,
+				event.start_date_time AS event_start_date_time,
+				orders.tickets AS tickets,
+				COALESCE(order_use.gate, '') AS gate,
+				order_use.created AS created
+			FROM
+				order_use
+			INNER JOIN
+				orders ON (orders.id = order_use.order_id)
+			INNER JOIN
+				event ON (event.id = orders.event_id)
+			WHERE
+				order_use.user_id = :user_id AND
+				order_use.created BETWEEN :date_from AND :date_to
+			UNION ALL
+			SELECT
+				:pass_type,
+				season_pass.code,
+				event.id,
+				event.name,
+				event.start_date_time,
+				1,
+				COALESCE(pass_visit.gate, ''),
+				pass_visit.created
+			FROM
+				pass_visit
+			INNER JOIN
+				season_pass ON (season_pass.id = pass_visit.season_pass_id)
+			INNER JOIN
+				event ON (event.id = pass_visit.event_id)
+			WHERE
+				pass_visit.user_id = :user_id AND
+				pass_visit.created BETWEEN :date_from AND :date_to
+		) AS admission
+	ORDER BY
+		admission.created ASC
+	`
+
+	getCashierShiftPayments = `
+	SELECT
+		payment_method.id,
+		payment_method.name,
+		COUNT(payment.id),
+		COALESCE(SUM(payment.amount), 0)
+	FROM
+		payment
+	INNER JOIN
+		payment_method ON (payment_method.id = payment.method_id)
+	WHERE
+		payment.active = true AND
+		payment.user_id = :user_id AND
+		payment.status_id = :status_id AND
+		payment.created BETWEEN :date_from AND :date_to
+	GROUP BY
+		payment_method.id
+	ORDER BY
+		payment_method.id ASC
+	`
+
+	// getCashierShiftRefunds lists the payments taken by the cashier that were
+	// reversed during the shift.
+	getCashierShiftRefunds = `
+	SELECT
+		payment.id,
+		payment.amount,
+		payment.created,
+		payment.updated,
+		payment_method.id,
+		payment_method.name,
+		orders.id,
+		orders.transaction_id
+	FROM
+		payment
+	INNER JOIN
+		payment_method ON (payment_method.id = payment.method_id)
+	INNER JOIN
+		orders ON (orders.id = payment.order_id)
+	WHERE
+		payment.active = true AND
+		payment.user_id = :user_id AND
+		payment.status_id = :status_id AND
+		payment.updated BETWEEN :date_from AND :date_to
+	ORDER BY
+		payment.updated ASC
+	`
+)
+
+func (db *DB) InsertCashierShift(userID int) (*models.CashierShift, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	var id int
+	err = tx.QueryRow(lockCashierShiftUser, userID).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	var open int
+	err = tx.QueryRow(countOpenCashierShifts, userID).Scan(&open)
+	if err != nil {
+		return nil, err
+	}
+
+	if open > 0 {
+		err = ErrCashierShiftOpen
+		return nil, err
+	}
+
+	opened := time.Now().UTC().Truncate(time.Second)
+	result, err := tx.Exec(insertCashierShift, userID, opened)
+	if err != nil {
+		return nil, err
+	}
+
+	shiftID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.CashierShift{
+		ID:      int(shiftID),
+		User:    &models.User{ID: userID},
+		Opened:  opened,
+		Created: opened,
+		Updated: opened,
+	}, nil
+}
+
+func (db *DB) GetCashierShiftByID(shiftID int) (*models.CashierShift, error) {
+	shift, err := scanCashierShift(db.QueryRow(getCashierShiftByID, shiftID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return shift, err
+}
+
+func (db *DB) GetOpenCashierShift(userID int) (*models.CashierShift, error) {
+	shift, err := scanCashierShift(db.QueryRow(getOpenCashierShift, userID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return shift, err
+}
+
+func (db *DB) GetCashierShifts(opts *models.GetCashierShiftsOpts) (*models.CashierShiftsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.UserID != 0 {
+		filters += " AND cashier_shift.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.DateFrom != "" {
+		filters += " AND DATE(CONVERT_TZ(cashier_shift.opened, 'UTC', 'America/Santiago')) >= :date_from "
+		args["date_from"] = opts.DateFrom
+	}
+	if opts.DateTo != "" {
+		filters += " AND DATE(CONVERT_TZ(cashier_shift.opened, 'UTC', 'America/Santiago')) <= :date_to "
+		args["date_to"] = opts.DateTo
+	}
+	if opts.Open != nil {
+		if *opts.Open {
+			filters += " AND cashier_shift.closed IS NULL "
+		} else {
+			filters += " AND cashier_shift.closed IS NOT NULL "
+		}
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(countCashierShifts, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	if err := stmt.QueryRow(args).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	stmt, err = db.PrepareNamed(strings.ReplaceAll(getCashierShifts, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	shifts := models.CashierShiftsStruct{
+		Total: total,
+	}
+	for rows.Next() {
+		shift, err := scanCashierShift(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		shifts.Shifts = append(shifts.Shifts, *shift)
+	}
+
+	return &shifts, nil
+}
+
+func (db *DB) CloseCashierShift(shift *models.CashierShift) error {
+	stmt, err := db.PrepareNamed(closeCashierShift)
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"id":           shift.ID,
+		"closed":       shift.Closed,
+		"closed_by":    shift.ClosedBy.ID,
+		"sales_total":  shift.SalesTotal,
+		"tickets_sold": shift.TicketsSold,
+		"admissions":   shift.Admissions,
+		"pdf_url":      shift.PDFURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		return ErrCashierShiftClosed
+	}
+
+	return nil
+}
+
+func (db *DB) GetCashierShiftSales(userID int, from time.Time, to time.Time) ([]models.CashierShiftSale, error) {
+	stmt, err := db.PrepareNamed(getCashierShiftSales)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"user_id":    userID,
+		"date_from":  from,
+		"date_to":    to,
+		"order_type": ConstCashierShiftAdmissionTypes.Order,
+		"pass_type":  ConstCashierShiftAdmissionTypes.Pass,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	sales := []models.CashierShiftSale{}
+	for rows.Next() {
+		var saleType string
+		order := models.Order{
+			Client: &models.User{},
+			Event:  &models.Event{},
+		}
+		sale := models.CashierShiftSale{
+			PaymentMethod: &models.PaymentMethod{},
+			PaymentStatus: &models.PaymentStatus{},
+		}
+		if err := rows.Scan(
+			&saleType,
+			&order.ID,
+			&order.TransactionID,
+			&order.Tickets,
+			&order.Price,
+			&order.Created,
+			&order.Client.ID,
+			&order.Client.Firstname,
+			&order.Client.Lastname,
+			&order.Event.ID,
+			&order.Event.Name,
+			&order.Event.StartDateTime,
+			&sale.PaymentMethod.ID,
+			&sale.PaymentMethod.Name,
+			&sale.PaymentStatus.ID,
+			&sale.PaymentStatus.Name,
+		); err != nil {
+			return nil, err
+		}
+
+		if sale.PaymentMethod.ID == 0 {
+			sale.PaymentMethod = nil
+			sale.PaymentStatus = nil
+		}
+
+		// The pass rows have the code as reference, the holder as client
+		// and the product, with the first day of the pass, as event.
+		if saleType == ConstCashierShiftAdmissionTypes.Pass {
+			sale.SeasonPass = &models.SeasonPass{
+				ID:        order.ID,
+				Code:      order.TransactionID,
+				Price:     order.Price,
+				Created:   order.Created,
+				User:      order.Client,
+				Product:   &models.PassProduct{ID: order.Event.ID, Name: order.Event.Name},
+				ValidFrom: order.Event.StartDateTime,
+			}
+		} else {
+			sale.Order = &order
+		}
+
+		sales = append(sales, sale)
+	}
+
+	return sales, nil
+}
+
+func (db *DB) GetCashierShiftAdmissions(userID int, from time.Time, to time.Time) ([]models.CashierShiftAdmission, error) {
+	stmt, err := db.PrepareNamed(getCashierShiftAdmissions)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"user_id":    userID,
+		"date_from":  from,
+		"date_to":    to,
+		"order_type": ConstCashierShiftAdmissionTypes.Order,
+		"pass_type":  ConstCashierShiftAdmissionTypes.Pass,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	admissions := []models.CashierShiftAdmission{}
+	for rows.Next() {
+		admission := models.CashierShiftAdmission{
+			Event: &models.Event{},
+		}
+		if err := rows.Scan(
+			&admission.Type,
+			&admission.Reference,
+			&admission.Event.ID,
+			&admission.Event.Name,
+			&admission.Event.StartDateTime,
+			&admission.Tickets,
+			&admission.Gate,
+			&admission.Created,
+		); err != nil {
+			return nil, err
+		}
+
+		admissions = append(admissions, admission)
+	}
+
+	return admissions, nil
+}
+
+func (db *DB) GetCashierShiftPayments(userID int, from time.Time, to time.Time) ([]models.CashierShiftPayments, error) {
+	stmt, err := db.PrepareNamed(getCashierShiftPayments)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"user_id":   userID,
+		"date_from": from,
+		"date_to":   to,
+		"status_id": ConstPaymentStatuses.Approved.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	payments := []models.CashierShiftPayments{}
+	for rows.Next() {
+		payment := models.CashierShiftPayments{
+			Method: &models.PaymentMethod{},
+		}
+		if err := rows.Scan(
+			&payment.Method.ID,
+			&payment.Method.Name,
+			&payment.Payments,
+			&payment.Amount,
+		); err != nil {
+			return nil, err
+		}
+
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+func (db *DB) GetCashierShiftRefunds(userID int, from time.Time, to time.Time) ([]models.CashierShiftRefund, error) {
+	stmt, err := db.PrepareNamed(getCashierShiftRefunds)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"user_id":   userID,
+		"date_from": from,
+		"date_to":   to,
+		"status_id": ConstPaymentStatuses.Reversed.ID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	refunds := []models.CashierShiftRefund{}
+	for rows.Next() {
+		payment := models.Payment{
+			Method: &models.PaymentMethod{},
+			Order:  &models.Order{},
+		}
+		if err := rows.Scan(
+			&payment.ID,
+			&payment.Amount,
+			&payment.Created,
+			&payment.Updated,
+			&payment.Method.ID,
+			&payment.Method.Name,
+			&payment.Order.ID,
+			&payment.Order.TransactionID,
+		); err != nil {
+			return nil, err
+		}
+
+		refunds = append(refunds, models.CashierShiftRefund{Payment: &payment})
+	}
+
+	return refunds, nil
+}
+
+func scanCashierShift(row rowScanner) (*models.CashierShift, error) {
+	shift := models.CashierShift{
+		User:     &models.User{},
+		ClosedBy: &models.User{},
+	}
+
+	if err := row.Scan(
+		&shift.ID,
+		&shift.Opened,
+		&shift.Closed,
+		&shift.SalesTotal,
+		&shift.TicketsSold,
+		&shift.Admissions,
+		&shift.PDFURL,
+		&shift.Created,
+		&shift.Updated,
+		&shift.User.ID,
+		&shift.User.Firstname,
+		&shift.User.Lastname,
+		&shift.User.Email,
+		&shift.ClosedBy.ID,
+		&shift.ClosedBy.Firstname,
+		&shift.ClosedBy.Lastname,
+	); err != nil {
+		return nil, err
+	}
+
+	if shift.ClosedBy.ID == 0 {
+		shift.ClosedBy = nil
+	}
+
+	return &shift, nil
+}
@@ -16,12 +16,14 @@ type EventStorage interface {
 	GetEventsByIDs(eventIDs []int) ([]models.Event, error)
 	GetEvents(*models.GetEventsOpts) (*models.EventsStruct, error)
 	GetEventTypes() ([]models.EventType, error)
+	UpdateEventTypeTaxRate(eventTypeID int, taxRate int) error
+	GetEventTicketsSold(eventID int) (int, error)
 }
 
 const (
 	insertEvents = `
 	INSERT INTO
-		event (name, event_type_id, start_date_time, end_date_time, price)
+		event (name, event_type_id, start_date_time, end_date_time, price, capacity)
 	VALUES
 		%s
 	`
@@ -35,6 +37,7 @@ const (
 		event.start_date_time,
 		event.end_date_time,
 		event.price,
+		COALESCE(event.capacity, 0),
 		event.created,
 		event.updated
 	FROM
@@ -55,6 +58,7 @@ const (
 		event.start_date_time,
 		event.end_date_time,
 		event.price,
+		COALESCE(event.capacity, 0),
 		event.created,
 		event.updated
 	FROM
@@ -75,6 +79,7 @@ const (
 		event.start_date_time,
 		event.end_date_time,
 		event.price,
+		COALESCE(event.capacity, 0),
 		event.created,
 		event.updated
 	FROM
@@ -89,6 +94,28 @@ const (
 	LIMIT :limit_to OFFSET :limit_from
 	`
 
+	// getEventTicketsSold counts the tickets of paid orders, which are the
+	// ones taking capacity from the event.
+	getEventTicketsSold = `
+	SELECT
+		COALESCE(SUM(orders.tickets), 0)
+	FROM
+		orders
+	WHERE
+		orders.event_id = ? AND
+		orders.active = true AND
+		EXISTS(
+			SELECT
+				payment.id
+			FROM
+				payment
+			WHERE
+				payment.order_id = orders.id AND
+				payment.status_id = ? AND
+				payment.active = true
+		)
+	`
+
 	countEvents = `
 	SELECT
 		COUNT(id)
@@ -129,8 +156,12 @@ func (db *DB) insertEventsTx(tx Tx, opts *models.InsertEventsOpts) error {
 
 	for _, eventDate := range opts.Dates {
 		for _, eventDateTime := range eventDate.Times {
-			paramsArr = append(paramsArr, "(?, ?,?,?,?)")
-			argsArr = append(argsArr, opts.Name, opts.TypeID, fmt.Sprintf("%s %s", eventDate.Date, eventDateTime.StartTime), fmt.Sprintf("%s %s", eventDate.Date, eventDateTime.EndTime), eventDateTime.Price)
+			var capacity interface{}
+			if eventDateTime.Capacity > 0 {
+				capacity = eventDateTime.Capacity
+			}
+			paramsArr = append(paramsArr, "(?, ?,?,?,?,?)")
+			argsArr = append(argsArr, opts.Name, opts.TypeID, fmt.Sprintf("%s %s", eventDate.Date, eventDateTime.StartTime), fmt.Sprintf("%s %s", eventDate.Date, eventDateTime.EndTime), eventDateTime.Price, capacity)
 		}
 	}
 
@@ -174,6 +205,7 @@ func (db *DB) GetEventByID(eventID int) (*models.Event, error) {
 		&event.StartDateTime,
 		&event.EndDateTime,
 		&event.Price,
+		&event.Capacity,
 		&event.Created,
 		&event.Updated,
 	); err != nil {
@@ -222,6 +254,7 @@ func (db *DB) GetEventsByIDs(eventIDs []int) ([]models.Event, error) {
 			&event.StartDateTime,
 			&event.EndDateTime,
 			&event.Price,
+			&event.Capacity,
 			&event.Created,
 			&event.Updated,
 		); err != nil {
@@ -285,6 +318,7 @@ func (db *DB) GetEvents(opts *models.GetEventsOpts) (*models.EventsStruct, error
 			&event.StartDateTime,
 			&event.EndDateTime,
 			&event.Price,
+			&event.Capacity,
 			&event.Created,
 			&event.Updated,
 		); err != nil {
@@ -320,10 +354,20 @@ const (
 	getEventTypes = `
 	SELECT
 		event_type.id,
-		event_type.name
+		event_type.name,
+		event_type.tax_rate
 	FROM
 		event_type
 	`
+
+	updateEventTypeTaxRate = `
+	UPDATE
+		event_type
+	SET
+		tax_rate = ?
+	WHERE
+		id = ?
+	`
 )
 
 func (db *DB) GetEventTypes() ([]models.EventType, error) {
@@ -340,6 +384,7 @@ func (db *DB) GetEventTypes() ([]models.EventType, error) {
 		if err := rows.Scan(
 			&eventType.ID,
 			&eventType.Name,
+			&eventType.TaxRate,
 		); err != nil {
 			return nil, err
 		}
@@ -349,3 +394,23 @@ func (db *DB) GetEventTypes() ([]models.EventType, error) {
 
 	return eventTypes, nil
 }
+
+func (db *DB) UpdateEventTypeTaxRate(eventTypeID int, taxRate int) error {
+	_, err := db.Exec(updateEventTypeTaxRate, taxRate, eventTypeID)
+
+	return err
+}
+
+func (db *DB) GetEventTicketsSold(eventID int) (int, error) {
+	return eventTicketsSold(db, eventID)
+}
+
+func eventTicketsSold(c conn, eventID int) (int, error) {
+	var sold int
+	row := c.QueryRow(getEventTicketsSold, eventID, ConstPaymentStatuses.Approved.ID)
+	if err := row.Scan(&sold); err != nil {
+		return 0, err
+	}
+
+	return sold, nil
+}
@@ -0,0 +1,639 @@
+package db
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type EventNoticeStorage interface {
+	InsertEventNotice(notice *models.EventNotice) (int, error)
+	GetEventNoticeByID(noticeID int) (*models.EventNotice, error)
+	GetEventNotices(eventID int) ([]models.EventNotice, error)
+	GetEventNoticeRecipients(noticeID int, opts *models.GetEventNoticeRecipientsOpts) (*models.EventNoticeRecipientsStruct, error)
+	GetUnsentEventNoticeRecipientIDs(noticeID int, maxAttempts int) ([]int, error)
+	GetEventNoticeRecipientByID(recipientID int) (*models.EventNoticeRecipient, error)
+	GetEventNoticeRecipientByToken(token string) (*models.EventNoticeRecipient, error)
+	ClaimEventNoticeRecipient(recipientID int, maxAttempts int) (bool, error)
+	UpdateEventNoticeRecipientSent(recipientID int, token string) error
+	UpdateEventNoticeRecipientFailed(recipientID int, lastError string) error
+	ResolveEventNoticeRecipient(recipient *models.EventNoticeRecipient, resolution string, eventID int) error
+	ReleaseEventNoticeRecipient(recipientID int) error
+}
+
+var ErrEventNoticeResolved = errors.New("event notice already answered")
+
+var ConstEventNoticeKinds = struct {
+	Cancelled   string
+	Rescheduled string
+}{
+	Cancelled:   "cancelled",
+	Rescheduled: "rescheduled",
+}
+
+// ConstEventNoticeRecipientStatuses track the delivery of the notice to each
+// client. A recipient left sending by a restart is not retried, as the email
+// may already be out.
+var ConstEventNoticeRecipientStatuses = struct {
+	Pending string
+	Sending string
+	Sent    string
+	Failed  string
+}{
+	Pending: "pending",
+	Sending: "sending",
+	Sent:    "sent",
+	Failed:  "failed",
+}
+
+var ConstEventNoticeResolutions = struct {
+	Rescheduled string
+	Refund      string
+}{
+	Rescheduled: "rescheduled",
+	Refund:      "refund",
+}
+
+const (
+	insertEventNotice = `
+	INSERT
+		event_notice
+	SET
+		event_id = :event_id,
+		user_id = :user_id,
+		kind = :kind,
+		message = :message,
+		expires = :expires
+	`
+
+	// insertEventNoticeRecipients adds every paid and unused order of the
+	// event as a recipient of the notice.
+	insertEventNoticeRecipients = `
+	INSERT INTO
+		event_notice_recipient (event_notice_id, order_id, client_id, email, status)
+	SELECT
+		:notice_id,
+		orders.id,
+		client.id,
+		client.email,
+		:pending
+	FROM
+		orders
+	INNER JOIN
+		user AS client ON (client.id = orders.client_id)
+	INNER JOIN
+		payment ON (payment.id = (SELECT id FROM payment WHERE payment.order_id = orders.id AND payment.active = true ORDER BY payment.id DESC LIMIT 1))
+	WHERE
+		orders.active = true AND
+		orders.event_id = :event_id AND
+		payment.status_id = :approved AND
+		NOT EXISTS (SELECT 1 FROM order_use WHERE order_use.order_id = orders.id)
+	`
+
+	selectEventNotice = `
+	SELECT
+		event_notice.id,
+		event_notice.kind,
+		event_notice.message,
+		event_notice.expires,
+		event_notice.created,
+		event_notice.updated,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		user.id,
+		user.firstname,
+		user.lastname,
+		COUNT(event_notice_recipient.id),
+		COALESCE(SUM(event_notice_recipient.status IN (:pending, :sending)), 0),
+		COALESCE(SUM(event_notice_recipient.status = :sent), 0),
+		COALESCE(SUM(event_notice_recipient.status = :failed), 0),
+		COALESCE(SUM(event_notice_recipient.resolution = :rescheduled), 0),
+		COALESCE(SUM(event_notice_recipient.resolution = :refund), 0)
+	FROM
+		event_notice
+	INNER JOIN
+		event ON (event.id = event_notice.event_id)
+	INNER JOIN
+		user ON (user.id = event_notice.user_id)
+	LEFT JOIN
+		event_notice_recipient ON (event_notice_recipient.event_notice_id = event_notice.id)
+	`
+
+	getEventNoticeByID = selectEventNotice + `
+	WHERE
+		event_notice.id = :id
+	GROUP BY
+		event_notice.id
+	`
+
+	getEventNotices = selectEventNotice + `
+	WHERE
+		event_notice.event_id = :event_id
+	GROUP BY
+		event_notice.id
+	ORDER BY
+		event_notice.id DESC
+	`
+
+	selectEventNoticeRecipient = `
+	SELECT
+		event_notice_recipient.id,
+		event_notice_recipient.event_notice_id,
+		event_notice_recipient.email,
+		event_notice_recipient.status,
+		event_notice_recipient.attempts,
+		COALESCE(event_notice_recipient.last_error, ''),
+		event_notice_recipient.sent,
+		COALESCE(event_notice_recipient.resolution, ''),
+		COALESCE(event_notice_recipient.resolved_event_id, 0),
+		event_notice_recipient.resolved,
+		event_notice_recipient.created,
+		event_notice_recipient.updated,
+		orders.id,
+		orders.transaction_id,
+		orders.tickets,
+		orders.price,
+		client.id,
+		client.firstname,
+		client.lastname
+	FROM
+		event_notice_recipient
+	INNER JOIN
+		orders ON (orders.id = event_notice_recipient.order_id)
+	INNER JOIN
+		user AS client ON (client.id = event_notice_recipient.client_id)
+	`
+
+	getEventNoticeRecipients = selectEventNoticeRecipient + `
+	WHERE
+		event_notice_recipient.event_notice_id = :notice_id
+		#FILTERS#
+	ORDER BY
+		event_notice_recipient.id ASC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countEventNoticeRecipients = `
+	SELECT
+		COUNT(event_notice_recipient.id)
+	FROM
+		event_notice_recipient
+	WHERE
+		event_notice_recipient.event_notice_id = :notice_id
+		#FILTERS#
+	`
+
+	getEventNoticeRecipientByID = selectEventNoticeRecipient + `
+	WHERE
+		event_notice_recipient.id = ?
+	`
+
+	getEventNoticeRecipientByToken = selectEventNoticeRecipient + `
+	WHERE
+		event_notice_recipient.token = ?
+	`
+
+	getUnsentEventNoticeRecipientIDs = `
+	SELECT
+		id
+	FROM
+		event_notice_recipient
+	WHERE
+		event_notice_id = ? AND
+		(status = ? OR (status = ? AND attempts < ?))
+	ORDER BY
+		id ASC
+	`
+
+	claimEventNoticeRecipient = `
+	UPDATE
+		event_notice_recipient
+	SET
+		status = ?,
+		attempts = attempts + 1
+	WHERE
+		id = ? AND
+		(status = ? OR (status = ? AND attempts < ?))
+	`
+
+	updateEventNoticeRecipientSent = `
+	UPDATE
+		event_notice_recipient
+	SET
+		status = ?,
+		token = ?,
+		last_error = NULL,
+		sent = current_timestamp()
+	WHERE
+		id = ?
+	`
+
+	updateEventNoticeRecipientFailed = `
+	UPDATE
+		event_notice_recipient
+	SET
+		status = ?,
+		last_error = ?
+	WHERE
+		id = ?
+	`
+
+	resolveEventNoticeRecipient = `
+	UPDATE
+		event_notice_recipient
+	SET
+		resolution = ?,
+		resolved_event_id = ?,
+		resolved = current_timestamp()
+	WHERE
+		id = ? AND
+		resolution IS NULL
+	`
+
+	releaseEventNoticeRecipient = `
+	UPDATE
+		event_notice_recipient
+	SET
+		resolution = NULL,
+		resolved_event_id = NULL,
+		resolved = NULL
+	WHERE
+		id = ?
+	`
+)
+
+// InsertEventNotice stores the notice along with its recipients, which are
+// the paid clients of the event at this moment.
+func (db *DB) InsertEventNotice(notice *models.EventNotice) (int, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(insertEventNotice)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"event_id": notice.Event.ID,
+		"user_id":  notice.User.ID,
+		"kind":     notice.Kind,
+		"message":  notice.Message,
+		"expires":  notice.Expires,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err = tx.PrepareNamed(insertEventNoticeRecipients)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err = stmt.Exec(map[string]interface{}{
+		"notice_id": id,
+		"event_id":  notice.Event.ID,
+		"pending":   ConstEventNoticeRecipientStatuses.Pending,
+		"approved":  ConstPaymentStatuses.Approved.ID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	recipients, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	notice.ID = int(id)
+	notice.Recipients = int(recipients)
+	notice.Pending = int(recipients)
+
+	return notice.ID, nil
+}
+
+func (db *DB) GetEventNoticeByID(noticeID int) (*models.EventNotice, error) {
+	stmt, err := db.PrepareNamed(getEventNoticeByID)
+	if err != nil {
+		return nil, err
+	}
+
+	args := eventNoticeCountArgs()
+	args["id"] = noticeID
+
+	notice, err := scanEventNotice(stmt.QueryRow(args))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return notice, err
+}
+
+func (db *DB) GetEventNotices(eventID int) ([]models.EventNotice, error) {
+	stmt, err := db.PrepareNamed(getEventNotices)
+	if err != nil {
+		return nil, err
+	}
+
+	args := eventNoticeCountArgs()
+	args["event_id"] = eventID
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	notices := []models.EventNotice{}
+	for rows.Next() {
+		notice, err := scanEventNotice(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		notices = append(notices, *notice)
+	}
+
+	return notices, nil
+}
+
+func (db *DB) GetEventNoticeRecipients(noticeID int, opts *models.GetEventNoticeRecipientsOpts) (*models.EventNoticeRecipientsStruct, error) {
+	var filters string
+	args := map[string]interface{}{
+		"notice_id": noticeID,
+	}
+	if opts.Status != "" {
+		filters += " AND event_notice_recipient.status = :status "
+		args["status"] = opts.Status
+	}
+	if opts.Resolution != "" {
+		filters += " AND event_notice_recipient.resolution = :resolution "
+		args["resolution"] = opts.Resolution
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(countEventNoticeRecipients, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	var total int
+	if err := stmt.QueryRow(args).Scan(&total); err != nil {
+		return nil, err
+	}
+
+	stmt, err = db.PrepareNamed(strings.ReplaceAll(getEventNoticeRecipients, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	recipients := models.EventNoticeRecipientsStruct{
+		Recipients: []models.EventNoticeRecipient{},
+		Total:      total,
+	}
+	for rows.Next() {
+		recipient, err := scanEventNoticeRecipient(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		recipients.Recipients = append(recipients.Recipients, *recipient)
+	}
+
+	return &recipients, nil
+}
+
+// GetUnsentEventNoticeRecipientIDs lists the recipients still waiting for the
+// notice and the failed ones with attempts left.
+func (db *DB) GetUnsentEventNoticeRecipientIDs(noticeID int, maxAttempts int) ([]int, error) {
+	ids := []int{}
+	err := db.Select(&ids, getUnsentEventNoticeRecipientIDs,
+		noticeID,
+		ConstEventNoticeRecipientStatuses.Pending,
+		ConstEventNoticeRecipientStatuses.Failed,
+		maxAttempts,
+	)
+	return ids, err
+}
+
+func (db *DB) GetEventNoticeRecipientByID(recipientID int) (*models.EventNoticeRecipient, error) {
+	recipient, err := scanEventNoticeRecipient(db.QueryRow(getEventNoticeRecipientByID, recipientID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return recipient, err
+}
+
+func (db *DB) GetEventNoticeRecipientByToken(token string) (*models.EventNoticeRecipient, error) {
+	recipient, err := scanEventNoticeRecipient(db.QueryRow(getEventNoticeRecipientByToken, token))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return recipient, err
+}
+
+// ClaimEventNoticeRecipient marks the recipient as being sent the notice. It
+// reports false when another process got to it first or it ran out of
+// attempts.
+func (db *DB) ClaimEventNoticeRecipient(recipientID int, maxAttempts int) (bool, error) {
+	result, err := db.Exec(claimEventNoticeRecipient,
+		ConstEventNoticeRecipientStatuses.Sending,
+		recipientID,
+		ConstEventNoticeRecipientStatuses.Pending,
+		ConstEventNoticeRecipientStatuses.Failed,
+		maxAttempts,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UpdateEventNoticeRecipientSent stores the hash of the token sent in the
+// email. A resend replaces it, so only the last link works.
+func (db *DB) UpdateEventNoticeRecipientSent(recipientID int, token string) error {
+	_, err := db.Exec(updateEventNoticeRecipientSent, ConstEventNoticeRecipientStatuses.Sent, token, recipientID)
+	return err
+}
+
+func (db *DB) UpdateEventNoticeRecipientFailed(recipientID int, lastError string) error {
+	_, err := db.Exec(updateEventNoticeRecipientFailed, ConstEventNoticeRecipientStatuses.Failed, lastError, recipientID)
+	return err
+}
+
+// ResolveEventNoticeRecipient stores the answer of the client, failing with
+// ErrEventNoticeResolved when it already answered. Refund requests are left
+// in the order history for the backoffice to process.
+func (db *DB) ResolveEventNoticeRecipient(recipient *models.EventNoticeRecipient, resolution string, eventID int) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	var resolvedEventID interface{}
+	if eventID != 0 {
+		resolvedEventID = eventID
+	}
+
+	result, err := tx.Exec(resolveEventNoticeRecipient, resolution, resolvedEventID, recipient.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected != 1 {
+		err = ErrEventNoticeResolved
+		return err
+	}
+
+	if resolution == ConstEventNoticeResolutions.Refund {
+		err = db.insertOrderHistoryTx(tx, recipient.Order.ID, recipient.Client.ID, ConstOrderHistoryActions.RefundRequested, strconv.Itoa(recipient.NoticeID))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReleaseEventNoticeRecipient clears the answer of the client, used when the
+// chosen date couldn't be applied.
+func (db *DB) ReleaseEventNoticeRecipient(recipientID int) error {
+	_, err := db.Exec(releaseEventNoticeRecipient, recipientID)
+	return err
+}
+
+func eventNoticeCountArgs() map[string]interface{} {
+	return map[string]interface{}{
+		"pending":     ConstEventNoticeRecipientStatuses.Pending,
+		"sending":     ConstEventNoticeRecipientStatuses.Sending,
+		"sent":        ConstEventNoticeRecipientStatuses.Sent,
+		"failed":      ConstEventNoticeRecipientStatuses.Failed,
+		"rescheduled": ConstEventNoticeResolutions.Rescheduled,
+		"refund":      ConstEventNoticeResolutions.Refund,
+	}
+}
+
+func scanEventNotice(row rowScanner) (*models.EventNotice, error) {
+	notice := models.EventNotice{
+		Event: &models.Event{},
+		User:  &models.User{},
+	}
+
+	if err := row.Scan(
+		&notice.ID,
+		&notice.Kind,
+		&notice.Message,
+		&notice.Expires,
+		&notice.Created,
+		&notice.Updated,
+		&notice.Event.ID,
+		&notice.Event.Name,
+		&notice.Event.StartDateTime,
+		&notice.Event.EndDateTime,
+		&notice.User.ID,
+		&notice.User.Firstname,
+		&notice.User.Lastname,
+		&notice.Recipients,
+		&notice.Pending,
+		&notice.Sent,
+		&notice.Failed,
+		&notice.Rescheduled,
+		&notice.Refunds,
+	); err != nil {
+		return nil, err
+	}
+
+	return &notice, nil
+}
+
+func scanEventNoticeRecipient(row rowScanner) (*models.EventNoticeRecipient, error) {
+	recipient := models.EventNoticeRecipient{
+		Order:         &models.Order{},
+		Client:        &models.User{},
+		ResolvedEvent: &models.Event{},
+	}
+
+	if err := row.Scan(
+		&recipient.ID,
+		&recipient.NoticeID,
+		&recipient.Email,
+		&recipient.Status,
+		&recipient.Attempts,
+		&recipient.LastError,
+		&recipient.Sent,
+		&recipient.Resolution,
+		&recipient.ResolvedEvent.ID,
+		&recipient.Resolved,
+		&recipient.Created,
+		&recipient.Updated,
+		&recipient.Order.ID,
+		&recipient.Order.TransactionID,
+		&recipient.Order.Tickets,
+		&recipient.Order.Price,
+		&recipient.Client.ID,
+		&recipient.Client.Firstname,
+		&recipient.Client.Lastname,
+	); err != nil {
+		return nil, err
+	}
+
+	if recipient.ResolvedEvent.ID == 0 {
+		recipient.ResolvedEvent = nil
+	}
+
+	return &recipient, nil
+}
@@ -0,0 +1,931 @@
+package db
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"math/big"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/pkg/errors"
+)
+
+type GiftVoucherStorage interface {
+	InsertGiftVoucher(voucher *models.GiftVoucher) (int, error)
+	GetGiftVoucherByID(voucherID int) (*models.GiftVoucher, error)
+	GetGiftVoucherByCode(code string) (*models.GiftVoucher, error)
+	GetGiftVoucherByPreferenceID(preferenceID string) (*models.GiftVoucher, error)
+	GetGiftVouchers(opts *models.GetGiftVouchersOpts) (*models.GiftVouchersStruct, error)
+	ActivateGiftVoucher(voucherID int) error
+	InsertVoucherOrder(userID int, clientID int, event *models.Event, tickets int, code string, reservationExpires time.Time, waitlistEntryID int) (*models.Order, *models.GiftVoucherRedemption, error)
+	GetOrderVoucherAmount(orderID int) (int, error)
+	GetExpiredVoucherRedemptionIDs(limit int) ([]int, error)
+	ReleaseVoucherRedemption(redemptionID int) (bool, error)
+	GetGiftVoucherLiability(dateFrom string, dateTo string) (*models.GiftVoucherLiability, error)
+}
+
+// ErrGiftVoucherInvalid is returned when the voucher can't be redeemed for
+// the order, because it's not active, expired, has no balance left or is
+// restricted to another event type.
+var ErrGiftVoucherInvalid = errors.New("gift voucher can't be redeemed")
+
+var ConstGiftVoucherStatuses = struct {
+	Pending   string
+	Active    string
+	Redeemed  string
+	Cancelled string
+}{
+	Pending:   "pending",
+	Active:    "active",
+	Redeemed:  "redeemed",
+	Cancelled: "cancelled",
+}
+
+// ConstGiftVoucherRedemptionStatuses are the states of the balance taken from
+// a voucher by an order. It's reserved until the rest of the order is paid,
+// and given back to the voucher when the reservation expires unpaid.
+var ConstGiftVoucherRedemptionStatuses = struct {
+	Reserved  string
+	Confirmed string
+	Released  string
+}{
+	Reserved:  "reserved",
+	Confirmed: "confirmed",
+	Released:  "released",
+}
+
+var ConstGiftVoucherTypes = struct {
+	Amount  string
+	Tickets string
+}{
+	Amount:  "amount",
+	Tickets: "tickets",
+}
+
+const voucherCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// GenerateVoucherCode returns a random code without ambiguous characters,
+// easy to type at the checkout.
+func GenerateVoucherCode() (string, error) {
+	code := make([]byte, 10)
+	max := big.NewInt(int64(len(voucherCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		code[i] = voucherCodeAlphabet[n.Int64()]
+	}
+
+	return "GV" + string(code), nil
+}
+
+const (
+	insertGiftVoucher = `
+	INSERT
+		gift_voucher
+	SET
+		code = :code,
+		type = :type,
+		amount = :amount,
+		balance = :amount,
+		tickets = :tickets,
+		tickets_balance = :tickets,
+		event_type_id = :event_type_id,
+		price = :price,
+		user_id = :user_id,
+		recipient_name = :recipient_name,
+		recipient_email = :recipient_email,
+		message = :message,
+		status = :status,
+		preference_id = :preference_id,
+		expires = :expires,
+		activated_at = IF(:status = 'active', current_timestamp(), NULL)
+	`
+
+	selectGiftVoucher = `
+	SELECT
+		gift_voucher.id,
+		gift_voucher.code,
+		gift_voucher.type,
+		gift_voucher.amount,
+		gift_voucher.balance,
+		gift_voucher.tickets,
+		gift_voucher.tickets_balance,
+		COALESCE(event_type.id, 0),
+		COALESCE(event_type.name, ''),
+		gift_voucher.price,
+		gift_voucher.recipient_name,
+		gift_voucher.recipient_email,
+		gift_voucher.message,
+		gift_voucher.status,
+		COALESCE(gift_voucher.preference_id, ''),
+		gift_voucher.expires,
+		gift_voucher.created,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		gift_voucher
+	INNER JOIN
+		user ON (user.id = gift_voucher.user_id)
+	LEFT JOIN
+		event_type ON (event_type.id = gift_voucher.event_type_id)
+	`
+
+	getGiftVoucherByID = selectGiftVoucher + `
+	WHERE
+		gift_voucher.id = :id
+	`
+
+	getGiftVoucherByCode = selectGiftVoucher + `
+	WHERE
+		gift_voucher.code = :code
+	`
+
+	getGiftVoucherByCodeForUpdate = getGiftVoucherByCode + `
+	FOR UPDATE
+	`
+
+	getGiftVoucherByPreferenceID = selectGiftVoucher + `
+	WHERE
+		gift_voucher.preference_id = :preference_id
+	`
+
+	getGiftVouchers = selectGiftVoucher + `
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		gift_voucher.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countGiftVouchers = `
+	SELECT
+		COUNT(gift_voucher.id)
+	FROM
+		gift_voucher
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	activateGiftVoucher = `
+	UPDATE
+		gift_voucher
+	SET
+		status = ?,
+		activated_at = current_timestamp()
+	WHERE
+		id = ? AND
+		status = ?
+	`
+
+	updateGiftVoucherBalance = `
+	UPDATE
+		gift_voucher
+	SET
+		balance = :balance,
+		tickets_balance = :tickets_balance,
+		status = :status
+	WHERE
+		id = :id
+	`
+
+	insertGiftVoucherRedemption = `
+	INSERT
+		gift_voucher_redemption
+	SET
+		gift_voucher_id = :gift_voucher_id,
+		order_id = :order_id,
+		amount = :amount,
+		tickets = :tickets,
+		value = :value,
+		status = :status,
+		expires = :expires
+	`
+
+	getOrderVoucherAmount = `
+	SELECT
+		COALESCE(SUM(gift_voucher_redemption.amount), 0)
+	FROM
+		gift_voucher_redemption
+	WHERE
+		gift_voucher_redemption.order_id = ? AND
+		gift_voucher_redemption.status <> ?
+	`
+
+	confirmOrderVoucherRedemptions = `
+	UPDATE
+		gift_voucher_redemption
+	SET
+		status = ?
+	WHERE
+		order_id = ? AND
+		status = ?
+	`
+
+	extendOrderVoucherRedemptions = `
+	UPDATE
+		gift_voucher_redemption
+	SET
+		expires = GREATEST(COALESCE(expires, ?), ?)
+	WHERE
+		order_id = ? AND
+		status = ?
+	`
+
+	confirmPaymentVoucherRedemptions = `
+	UPDATE
+		gift_voucher_redemption
+	INNER JOIN
+		payment ON (payment.order_id = gift_voucher_redemption.order_id)
+	SET
+		gift_voucher_redemption.status = ?
+	WHERE
+		payment.preference_id = ? AND
+		gift_voucher_redemption.status = ?
+	`
+
+	getExpiredVoucherRedemptionIDs = `
+	SELECT
+		gift_voucher_redemption.id
+	FROM
+		gift_voucher_redemption
+	WHERE
+		gift_voucher_redemption.status = ? AND
+		gift_voucher_redemption.expires <= current_timestamp() AND
+		NOT EXISTS(
+			SELECT
+				payment.id
+			FROM
+				payment
+			WHERE
+				payment.order_id = gift_voucher_redemption.order_id AND
+				payment.status_id IN (?, ?) AND
+				payment.active = true
+		)
+	ORDER BY
+		gift_voucher_redemption.id
+	LIMIT ?
+	`
+
+	getVoucherRedemptionOrderID = `
+	SELECT
+		gift_voucher_redemption.order_id
+	FROM
+		gift_voucher_redemption
+	WHERE
+		gift_voucher_redemption.id = ?
+	`
+
+	getVoucherRedemptionForUpdate = `
+	SELECT
+		gift_voucher_redemption.gift_voucher_id,
+		gift_voucher_redemption.amount,
+		gift_voucher_redemption.tickets
+	FROM
+		gift_voucher_redemption
+	WHERE
+		gift_voucher_redemption.id = ? AND
+		gift_voucher_redemption.status = ? AND
+		gift_voucher_redemption.expires <= current_timestamp()
+	FOR UPDATE
+	`
+
+	// lockOrderPayments takes the payments of the order before its
+	// redemption, in the same order the payment notifications do, so a
+	// payment being approved can't race the release.
+	lockOrderPayments = `
+	SELECT
+		payment.status_id
+	FROM
+		payment
+	WHERE
+		payment.order_id = ? AND
+		payment.active = true
+	FOR UPDATE
+	`
+
+	getGiftVoucherByIDForUpdate = getGiftVoucherByID + `
+	FOR UPDATE
+	`
+
+	updateVoucherRedemptionStatus = `
+	UPDATE
+		gift_voucher_redemption
+	SET
+		status = ?
+	WHERE
+		id = ?
+	`
+
+	deactivateUnpaidOrder = `
+	UPDATE
+		orders
+	SET
+		active = false
+	WHERE
+		id = ?
+	`
+
+	// giftVoucherRemainingValue is the money still owed for a voucher. Ticket
+	// vouchers are valued at the price paid per ticket.
+	giftVoucherRemainingValue = `
+		CASE
+			WHEN gift_voucher.type = 'amount' THEN gift_voucher.balance
+			ELSE FLOOR(gift_voucher.price * gift_voucher.tickets_balance / gift_voucher.tickets)
+		END
+	`
+
+	getGiftVoucherLiability = `
+	SELECT
+		(
+			SELECT
+				COALESCE(SUM(gift_voucher.price), 0)
+			FROM
+				gift_voucher
+			WHERE
+				gift_voucher.activated_at IS NOT NULL AND
+				DATE(CONVERT_TZ(gift_voucher.activated_at, 'UTC', 'America/Santiago')) BETWEEN :date_from AND :date_to
+		),
+		(
+			SELECT
+				COALESCE(SUM(gift_voucher_redemption.value), 0)
+			FROM
+				gift_voucher_redemption
+			WHERE
+				gift_voucher_redemption.status <> :released AND
+				DATE(CONVERT_TZ(gift_voucher_redemption.created, 'UTC', 'America/Santiago')) BETWEEN :date_from AND :date_to
+		),
+		(
+			SELECT
+				COALESCE(SUM(` + giftVoucherRemainingValue + `), 0)
+			FROM
+				gift_voucher
+			WHERE
+				gift_voucher.status = :active AND
+				gift_voucher.expires BETWEEN :date_from AND :date_to AND
+				gift_voucher.expires < DATE(CONVERT_TZ(current_timestamp(), 'UTC', 'America/Santiago'))
+		),
+		(
+			SELECT
+				COALESCE(SUM(` + giftVoucherRemainingValue + `), 0)
+			FROM
+				gift_voucher
+			WHERE
+				gift_voucher.status = :active AND
+				gift_voucher.expires >= DATE(CONVERT_TZ(current_timestamp(), 'UTC', 'America/Santiago'))
+		)
+	`
+)
+
+func (db *DB) InsertGiftVoucher(voucher *models.GiftVoucher) (int, error) {
+	return addGiftVoucher(db, voucher)
+}
+
+func addGiftVoucher(c conn, voucher *models.GiftVoucher) (int, error) {
+	stmt, err := c.PrepareNamed(insertGiftVoucher)
+	if err != nil {
+		return 0, err
+	}
+
+	var eventTypeID, preferenceID interface{}
+	if voucher.EventType != nil && voucher.EventType.ID != 0 {
+		eventTypeID = voucher.EventType.ID
+	}
+	if voucher.PreferenceID != "" {
+		preferenceID = voucher.PreferenceID
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"code":            voucher.Code,
+		"type":            voucher.Type,
+		"amount":          voucher.Amount,
+		"tickets":         voucher.Tickets,
+		"event_type_id":   eventTypeID,
+		"price":           voucher.Price,
+		"user_id":         voucher.User.ID,
+		"recipient_name":  voucher.RecipientName,
+		"recipient_email": voucher.RecipientEmail,
+		"message":         voucher.Message,
+		"status":          voucher.Status,
+		"preference_id":   preferenceID,
+		"expires":         voucher.Expires.Format(isoLayout),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	voucher.ID = int(id)
+
+	return voucher.ID, nil
+}
+
+func getGiftVoucher(c conn, query string, args map[string]interface{}) (*models.GiftVoucher, error) {
+	stmt, err := c.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	voucher, err := scanGiftVoucher(stmt.QueryRow(args))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return voucher, err
+}
+
+func (db *DB) GetGiftVoucherByID(voucherID int) (*models.GiftVoucher, error) {
+	return getGiftVoucher(db, getGiftVoucherByID, map[string]interface{}{
+		"id": voucherID,
+	})
+}
+
+func (db *DB) GetGiftVoucherByCode(code string) (*models.GiftVoucher, error) {
+	return getGiftVoucher(db, getGiftVoucherByCode, map[string]interface{}{
+		"code": strings.ToUpper(code),
+	})
+}
+
+func (db *DB) GetGiftVoucherByPreferenceID(preferenceID string) (*models.GiftVoucher, error) {
+	return getGiftVoucher(db, getGiftVoucherByPreferenceID, map[string]interface{}{
+		"preference_id": preferenceID,
+	})
+}
+
+func (db *DB) GetGiftVouchers(opts *models.GetGiftVouchersOpts) (*models.GiftVouchersStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.Code != "" {
+		filters += " AND gift_voucher.code = :code "
+		args["code"] = strings.ToUpper(opts.Code)
+	}
+	if opts.Status != "" {
+		filters += " AND gift_voucher.status = :status "
+		args["status"] = opts.Status
+	}
+	if opts.UserID != 0 {
+		filters += " AND gift_voucher.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countGiftVouchers(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(getGiftVouchers, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	vouchers := models.GiftVouchersStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		voucher, err := scanGiftVoucher(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		vouchers.Vouchers = append(vouchers.Vouchers, *voucher)
+	}
+
+	return &vouchers, nil
+}
+
+func (db *DB) countGiftVouchers(filters string, args map[string]interface{}) (int, error) {
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(countGiftVouchers, "#FILTERS#", filters))
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func scanGiftVoucher(row rowScanner) (*models.GiftVoucher, error) {
+	voucher := models.GiftVoucher{
+		EventType: &models.EventType{},
+		User:      &models.User{},
+	}
+
+	if err := row.Scan(
+		&voucher.ID,
+		&voucher.Code,
+		&voucher.Type,
+		&voucher.Amount,
+		&voucher.Balance,
+		&voucher.Tickets,
+		&voucher.TicketsBalance,
+		&voucher.EventType.ID,
+		&voucher.EventType.Name,
+		&voucher.Price,
+		&voucher.RecipientName,
+		&voucher.RecipientEmail,
+		&voucher.Message,
+		&voucher.Status,
+		&voucher.PreferenceID,
+		&voucher.Expires,
+		&voucher.Created,
+		&voucher.User.ID,
+		&voucher.User.Firstname,
+		&voucher.User.Lastname,
+		&voucher.User.Email,
+	); err != nil {
+		return nil, err
+	}
+
+	if voucher.EventType.ID == 0 {
+		voucher.EventType = nil
+	}
+
+	return &voucher, nil
+}
+
+// ActivateGiftVoucher activates a pending voucher once it's paid. It returns
+// ErrGiftVoucherInvalid when the voucher wasn't pending.
+func (db *DB) ActivateGiftVoucher(voucherID int) error {
+	result, err := db.Exec(activateGiftVoucher, ConstGiftVoucherStatuses.Active, voucherID, ConstGiftVoucherStatuses.Pending)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrGiftVoucherInvalid
+	}
+
+	return nil
+}
+
+// InsertVoucherOrder creates an order paying as much of it as possible with
+// the voucher. Amount vouchers discount up to their balance and ticket
+// vouchers cover as many tickets as they have left. When the voucher covers
+// the whole order, it's recorded as paid. Otherwise the balance is reserved
+// until reservationExpires, it's confirmed when the rest of the order is paid
+// and released by ReleaseVoucherRedemption if it isn't.
+func (db *DB) InsertVoucherOrder(userID int, clientID int, event *models.Event, tickets int, code string, reservationExpires time.Time, waitlistEntryID int) (*models.Order, *models.GiftVoucherRedemption, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	voucher, err := getGiftVoucher(tx, getGiftVoucherByCodeForUpdate, map[string]interface{}{
+		"code": strings.ToUpper(code),
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if voucher == nil ||
+		voucher.Status != ConstGiftVoucherStatuses.Active ||
+		voucher.Expired() ||
+		(voucher.EventType != nil && (event.Type == nil || voucher.EventType.ID != event.Type.ID)) {
+		err = ErrGiftVoucherInvalid
+		return nil, nil, err
+	}
+
+	if err = checkEventCapacityTx(tx, event.ID, tickets, waitlistEntryID, 0, 0); err != nil {
+		return nil, nil, err
+	}
+
+	gross := event.Price * tickets
+	redemption := models.GiftVoucherRedemption{
+		Voucher: voucher,
+	}
+
+	switch voucher.Type {
+	case ConstGiftVoucherTypes.Amount:
+		redemption.Amount = voucher.Balance
+		if redemption.Amount > gross {
+			redemption.Amount = gross
+		}
+		redemption.Value = redemption.Amount
+		voucher.Balance -= redemption.Amount
+	case ConstGiftVoucherTypes.Tickets:
+		redemption.Tickets = voucher.TicketsBalance
+		if redemption.Tickets > tickets {
+			redemption.Tickets = tickets
+		}
+		redemption.Amount = event.Price * redemption.Tickets
+		redemption.Value = voucher.Price * redemption.Tickets / voucher.Tickets
+		voucher.TicketsBalance -= redemption.Tickets
+	}
+
+	if redemption.Amount <= 0 {
+		err = ErrGiftVoucherInvalid
+		return nil, nil, err
+	}
+
+	if voucher.Balance == 0 && voucher.TicketsBalance == 0 {
+		voucher.Status = ConstGiftVoucherStatuses.Redeemed
+	}
+
+	transactionID := GenerateTicketUUID()
+	price := gross - redemption.Amount
+
+	orderID, err := db.insertOrderTx(tx, userID, clientID, event.ID, transactionID, tickets, price, redemption.Amount, reservationExpires)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stmt, err := tx.PrepareNamed(insertGiftVoucherRedemption)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status, expires := ConstGiftVoucherRedemptionStatuses.Reserved, interface{}(reservationExpires.UTC())
+	if price == 0 {
+		status, expires = ConstGiftVoucherRedemptionStatuses.Confirmed, nil
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"gift_voucher_id": voucher.ID,
+		"order_id":        orderID,
+		"amount":          redemption.Amount,
+		"tickets":         redemption.Tickets,
+		"value":           redemption.Value,
+		"status":          status,
+		"expires":         expires,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	redemptionID, err := result.LastInsertId()
+	if err != nil {
+		return nil, nil, err
+	}
+	redemption.ID = int(redemptionID)
+
+	stmt, err = tx.PrepareNamed(updateGiftVoucherBalance)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = stmt.Exec(map[string]interface{}{
+		"id":              voucher.ID,
+		"balance":         voucher.Balance,
+		"tickets_balance": voucher.TicketsBalance,
+		"status":          voucher.Status,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if price == 0 {
+		_, err = db.insertPaymentTx(tx, &InsertPaymentOpts{
+			MethodID:     ConstPaymentMethods.Voucher.ID,
+			Amount:       0,
+			UserID:       userID,
+			OrderID:      orderID,
+			PreferenceID: shortuuid.New(),
+			StatusID:     ConstPaymentStatuses.Approved.ID,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	order := models.Order{
+		ID: orderID,
+		User: &models.User{
+			ID: userID,
+		},
+		Client: &models.User{
+			ID: clientID,
+		},
+		Tickets:       tickets,
+		Price:         price,
+		TransactionID: transactionID,
+	}
+	redemption.Order = &order
+
+	return &order, &redemption, nil
+}
+
+func (db *DB) GetOrderVoucherAmount(orderID int) (int, error) {
+	var amount int
+	if err := db.QueryRow(getOrderVoucherAmount, orderID, ConstGiftVoucherRedemptionStatuses.Released).Scan(&amount); err != nil {
+		return 0, err
+	}
+
+	return amount, nil
+}
+
+// extendOrderVoucherRedemptionsTx keeps the balance reserved by the order
+// until the order's own reservation ends.
+func extendOrderVoucherRedemptionsTx(tx Tx, orderID int, expires time.Time) error {
+	_, err := tx.Exec(extendOrderVoucherRedemptions, expires, expires, orderID, ConstGiftVoucherRedemptionStatuses.Reserved)
+	return err
+}
+
+// confirmOrderVoucherRedemptionsTx confirms the balance reserved by the order
+// once it's paid.
+func confirmOrderVoucherRedemptionsTx(tx Tx, orderID int) error {
+	_, err := tx.Exec(confirmOrderVoucherRedemptions, ConstGiftVoucherRedemptionStatuses.Confirmed, orderID, ConstGiftVoucherRedemptionStatuses.Reserved)
+
+	return err
+}
+
+// confirmPaymentVoucherRedemptionsTx confirms the balance reserved by the
+// order of the payment once it's approved.
+func confirmPaymentVoucherRedemptionsTx(tx Tx, externalReference string) error {
+	_, err := tx.Exec(confirmPaymentVoucherRedemptions, ConstGiftVoucherRedemptionStatuses.Confirmed, externalReference, ConstGiftVoucherRedemptionStatuses.Reserved)
+
+	return err
+}
+
+// GetExpiredVoucherRedemptionIDs returns the reservations whose order wasn't
+// paid in time.
+func (db *DB) GetExpiredVoucherRedemptionIDs(limit int) ([]int, error) {
+	var ids []int
+	if err := db.Select(&ids, getExpiredVoucherRedemptionIDs, ConstGiftVoucherRedemptionStatuses.Reserved, ConstPaymentStatuses.Approved.ID, ConstPaymentStatuses.Processing.ID, limit); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// ReleaseVoucherRedemption gives the reserved balance back to the voucher and
+// cancels the unpaid order that took it, so it can't be paid later at the
+// discounted price. It returns false, without changes, when the redemption
+// isn't an expired reservation anymore or the order is being paid.
+func (db *DB) ReleaseVoucherRedemption(redemptionID int) (bool, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	var orderID int
+	err = tx.QueryRow(getVoucherRedemptionOrderID, redemptionID).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		err = nil
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	var statusIDs []int
+	if err = tx.Select(&statusIDs, lockOrderPayments, orderID); err != nil {
+		return false, err
+	}
+
+	var voucherID, amount, tickets int
+	err = tx.QueryRow(getVoucherRedemptionForUpdate, redemptionID, ConstGiftVoucherRedemptionStatuses.Reserved).Scan(
+		&voucherID,
+		&amount,
+		&tickets,
+	)
+	if err == sql.ErrNoRows {
+		err = nil
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	for _, statusID := range statusIDs {
+		if statusID == ConstPaymentStatuses.Approved.ID {
+			err = confirmOrderVoucherRedemptionsTx(tx, orderID)
+			return false, err
+		}
+		if statusID == ConstPaymentStatuses.Processing.ID {
+			return false, nil
+		}
+	}
+
+	voucher, err := getGiftVoucher(tx, getGiftVoucherByIDForUpdate, map[string]interface{}{
+		"id": voucherID,
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if voucher == nil {
+		err = errors.Errorf("gift voucher %d not found", voucherID)
+		return false, err
+	}
+
+	switch voucher.Type {
+	case ConstGiftVoucherTypes.Amount:
+		voucher.Balance += amount
+	case ConstGiftVoucherTypes.Tickets:
+		voucher.TicketsBalance += tickets
+	}
+
+	if voucher.Status == ConstGiftVoucherStatuses.Redeemed {
+		voucher.Status = ConstGiftVoucherStatuses.Active
+	}
+
+	stmt, err := tx.PrepareNamed(updateGiftVoucherBalance)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = stmt.Exec(map[string]interface{}{
+		"id":              voucher.ID,
+		"balance":         voucher.Balance,
+		"tickets_balance": voucher.TicketsBalance,
+		"status":          voucher.Status,
+	}); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.Exec(updateVoucherRedemptionStatus, ConstGiftVoucherRedemptionStatuses.Released, redemptionID); err != nil {
+		return false, err
+	}
+
+	if _, err = tx.Exec(deactivateUnpaidOrder, orderID); err != nil {
+		return false, err
+	}
+
+	if err = db.insertOrderHistoryTx(tx, orderID, ConstWebUserID, ConstOrderHistoryActions.VoucherReleased, voucher.Code); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (db *DB) GetGiftVoucherLiability(dateFrom string, dateTo string) (*models.GiftVoucherLiability, error) {
+	stmt, err := db.PrepareNamed(getGiftVoucherLiability)
+	if err != nil {
+		return nil, err
+	}
+
+	liability := models.GiftVoucherLiability{
+		DateFrom: dateFrom,
+		DateTo:   dateTo,
+	}
+
+	row := stmt.QueryRow(map[string]interface{}{
+		"date_from": dateFrom,
+		"date_to":   dateTo,
+		"active":    ConstGiftVoucherStatuses.Active,
+		"released":  ConstGiftVoucherRedemptionStatuses.Released,
+	})
+	if err := row.Scan(
+		&liability.Sold,
+		&liability.Redeemed,
+		&liability.Expired,
+		&liability.Outstanding,
+	); err != nil {
+		return nil, err
+	}
+
+	return &liability, nil
+}
@@ -6,6 +6,9 @@ const (
 	ConstLayoutDate      = `2006-01-02`
 	ConstLayoutTime      = `15:04`
 	ConstIso8061         = `%Y-%m-%dT%TZ`
+
+	// ConstWebUserID is the user placing the orders bought online by clients.
+	ConstWebUserID = 1
 )
 
 var ConstRoles = struct {
@@ -21,3 +24,99 @@ var ConstRoles = struct {
 	Client:   4,
 	API:      5,
 }
+
+var ConstUserStatuses = struct {
+	Deactivated string
+	Reactivated string
+	Deleted     string
+	Erased      string
+}{
+	Deactivated: "deactivated",
+	Reactivated: "reactivated",
+	Deleted:     "deleted",
+	Erased:      "erased",
+}
+
+var ConstAuditEntities = struct {
+	User               string
+	Order              string
+	Payment            string
+	Event              string
+	ResellerAllotment  string
+	ResellerSettlement string
+	PassProduct        string
+	SeasonPass         string
+	GiftVoucher        string
+	GroupBooking       string
+	EventType          string
+	CashierShift       string
+	EventNotice        string
+}{
+	User:               "user",
+	Order:              "order",
+	Payment:            "payment",
+	Event:              "event",
+	ResellerAllotment:  "reseller_allotment",
+	ResellerSettlement: "reseller_settlement",
+	PassProduct:        "pass_product",
+	SeasonPass:         "season_pass",
+	GiftVoucher:        "gift_voucher",
+	GroupBooking:       "group_booking",
+	EventType:          "event_type",
+	CashierShift:       "cashier_shift",
+	EventNotice:        "event_notice",
+}
+
+var ConstAuditActions = struct {
+	UserInsert             string
+	UserUpdate             string
+	UserStatusUpdate       string
+	UserErase              string
+	UserTwoFactorReset     string
+	OrderUpdate            string
+	OrderUse               string
+	PaymentCashier         string
+	EventInsert            string
+	ResellerAllotment      string
+	ResellerSettlement     string
+	ResellerSettlementPaid string
+	PassProductInsert      string
+	PassProductDeactivate  string
+	SeasonPassInsert       string
+	SeasonPassVisit        string
+	GiftVoucherInsert      string
+	GroupBookingApprove    string
+	GroupBookingReject     string
+	GroupBookingPayment    string
+	EventTypeUpdate        string
+	CashierShiftOpen       string
+	CashierShiftClose      string
+	EventNoticeInsert      string
+	EventNoticeResend      string
+}{
+	UserInsert:             "user.insert",
+	UserUpdate:             "user.update",
+	UserStatusUpdate:       "user.status_update",
+	UserErase:              "user.erase",
+	UserTwoFactorReset:     "user.two_factor_reset",
+	OrderUpdate:            "order.update",
+	OrderUse:               "order.use",
+	PaymentCashier:         "payment.cashier",
+	EventInsert:            "event.insert",
+	ResellerAllotment:      "reseller.allotment",
+	ResellerSettlement:     "reseller.settlement",
+	ResellerSettlementPaid: "reseller.settlement_paid",
+	PassProductInsert:      "pass_product.insert",
+	PassProductDeactivate:  "pass_product.deactivate",
+	SeasonPassInsert:       "season_pass.insert",
+	SeasonPassVisit:        "season_pass.visit",
+	GiftVoucherInsert:      "gift_voucher.insert",
+	GroupBookingApprove:    "group_booking.approve",
+	GroupBookingReject:     "group_booking.reject",
+	GroupBookingPayment:    "group_booking.payment",
+	EventTypeUpdate:        "event_type.update",
+	CashierShiftOpen:       "cashier_shift.open",
+	CashierShiftClose:      "cashier_shift.close",
+	EventNoticeInsert:      "event_notice.insert",
+	EventNoticeResend:      "event_notice.resend",
+}
@@ -0,0 +1,651 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/lithammer/shortuuid/v3"
+	"github.com/pkg/errors"
+)
+
+type GroupBookingStorage interface {
+	InsertGroupBooking(booking *models.GroupBooking) (int, error)
+	GetGroupBookingByID(bookingID int) (*models.GroupBooking, error)
+	GetGroupBookings(opts *models.GetGroupBookingsOpts) (*models.GroupBookingsStruct, error)
+	ApproveGroupBooking(booking *models.GroupBooking, adminID int) error
+	RejectGroupBooking(bookingID int, reason string) error
+	UpdateGroupBookingProof(bookingID int, proofURL string) error
+	UpdateGroupBookingPayment(bookingID int, approved bool) error
+	ReplaceGroupBookingParticipants(bookingID int, participants []models.GroupBookingParticipant) error
+	GetGroupBookingParticipants(bookingID int) ([]models.GroupBookingParticipant, error)
+}
+
+// ErrGroupBookingInvalid is returned when the booking isn't in the status the
+// action expects, e.g. approving a booking already rejected.
+var ErrGroupBookingInvalid = errors.New("group booking status doesn't allow the action")
+
+var ConstGroupBookingStatuses = struct {
+	Requested     string
+	Approved      string
+	ProofUploaded string
+	Paid          string
+	Rejected      string
+	Cancelled     string
+}{
+	Requested:     "requested",
+	Approved:      "approved",
+	ProofUploaded: "proof_uploaded",
+	Paid:          "paid",
+	Rejected:      "rejected",
+	Cancelled:     "cancelled",
+}
+
+const (
+	insertGroupBooking = `
+	INSERT
+		group_booking
+	SET
+		user_id = :user_id,
+		event_id = :event_id,
+		tickets = :tickets,
+		organization = :organization,
+		dni = :dni,
+		contact_name = :contact_name,
+		contact_email = :contact_email,
+		contact_phone = :contact_phone,
+		notes = :notes,
+		status = :status
+	`
+
+	selectGroupBooking = `
+	SELECT
+		group_booking.id,
+		group_booking.tickets,
+		group_booking.organization,
+		group_booking.dni,
+		group_booking.contact_name,
+		group_booking.contact_email,
+		group_booking.contact_phone,
+		group_booking.notes,
+		group_booking.status,
+		group_booking.price,
+		group_booking.due_date,
+		COALESCE(group_booking.order_id, 0),
+		COALESCE(group_booking.proof_url, ''),
+		group_booking.reason,
+		(
+			SELECT
+				COUNT(group_booking_participant.id)
+			FROM
+				group_booking_participant
+			WHERE
+				group_booking_participant.group_booking_id = group_booking.id
+		),
+		group_booking.created,
+		group_booking.updated,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		event.price,
+		event_type.id,
+		event_type.name
+	FROM
+		group_booking
+	INNER JOIN
+		user ON (user.id = group_booking.user_id)
+	INNER JOIN
+		event ON (event.id = group_booking.event_id)
+	INNER JOIN
+		event_type ON (event_type.id = event.event_type_id)
+	`
+
+	getGroupBookingByID = selectGroupBooking + `
+	WHERE
+		group_booking.id = :id
+	`
+
+	getGroupBookings = selectGroupBooking + `
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		group_booking.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countGroupBookings = `
+	SELECT
+		COUNT(group_booking.id)
+	FROM
+		group_booking
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	getGroupBookingForUpdate = `
+	SELECT
+		group_booking.status,
+		COALESCE(group_booking.preference_id, '')
+	FROM
+		group_booking
+	WHERE
+		group_booking.id = ?
+	FOR UPDATE
+	`
+
+	approveGroupBooking = `
+	UPDATE
+		group_booking
+	SET
+		status = :status,
+		price = :price,
+		due_date = :due_date,
+		order_id = :order_id,
+		preference_id = :preference_id,
+		approved_by = :approved_by
+	WHERE
+		id = :id
+	`
+
+	updateGroupBookingStatus = `
+	UPDATE
+		group_booking
+	SET
+		status = ?
+	WHERE
+		id = ?
+	`
+
+	rejectGroupBooking = `
+	UPDATE
+		group_booking
+	SET
+		status = ?,
+		reason = ?
+	WHERE
+		id = ? AND
+		status = ?
+	`
+
+	updateGroupBookingProof = `
+	UPDATE
+		group_booking
+	SET
+		status = ?,
+		proof_url = ?
+	WHERE
+		id = ?
+	`
+
+	deleteGroupBookingParticipants = `
+	DELETE FROM
+		group_booking_participant
+	WHERE
+		group_booking_id = ?
+	`
+
+	insertGroupBookingParticipants = `
+	INSERT INTO
+		group_booking_participant (group_booking_id, firstname, lastname, dni, email)
+	VALUES
+		%s
+	`
+
+	getGroupBookingParticipants = `
+	SELECT
+		group_booking_participant.id,
+		group_booking_participant.firstname,
+		group_booking_participant.lastname,
+		group_booking_participant.dni,
+		group_booking_participant.email
+	FROM
+		group_booking_participant
+	WHERE
+		group_booking_participant.group_booking_id = ?
+	ORDER BY
+		group_booking_participant.id ASC
+	`
+)
+
+func (db *DB) InsertGroupBooking(booking *models.GroupBooking) (int, error) {
+	stmt, err := db.PrepareNamed(insertGroupBooking)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"user_id":       booking.User.ID,
+		"event_id":      booking.Event.ID,
+		"tickets":       booking.Tickets,
+		"organization":  booking.Organization,
+		"dni":           booking.DNI,
+		"contact_name":  booking.ContactName,
+		"contact_email": booking.ContactEmail,
+		"contact_phone": booking.ContactPhone,
+		"notes":         booking.Notes,
+		"status":        ConstGroupBookingStatuses.Requested,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	booking.ID = int(id)
+	booking.Status = ConstGroupBookingStatuses.Requested
+
+	return booking.ID, nil
+}
+
+func (db *DB) GetGroupBookingByID(bookingID int) (*models.GroupBooking, error) {
+	stmt, err := db.PrepareNamed(getGroupBookingByID)
+	if err != nil {
+		return nil, err
+	}
+
+	booking, err := scanGroupBooking(stmt.QueryRow(map[string]interface{}{
+		"id": bookingID,
+	}))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return booking, err
+}
+
+func (db *DB) GetGroupBookings(opts *models.GetGroupBookingsOpts) (*models.GroupBookingsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.Status != "" {
+		filters += " AND group_booking.status = :status "
+		args["status"] = opts.Status
+	}
+	if opts.EventID != 0 {
+		filters += " AND group_booking.event_id = :event_id "
+		args["event_id"] = opts.EventID
+	}
+	if opts.UserID != 0 {
+		filters += " AND group_booking.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.Overdue {
+		filters += " AND group_booking.status IN (:approved, :proof_uploaded) AND group_booking.due_date < DATE(CONVERT_TZ(current_timestamp(), 'UTC', 'America/Santiago')) "
+		args["approved"] = ConstGroupBookingStatuses.Approved
+		args["proof_uploaded"] = ConstGroupBookingStatuses.ProofUploaded
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countGroupBookings(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(getGroupBookings, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	bookings := models.GroupBookingsStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		booking, err := scanGroupBooking(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		bookings.Bookings = append(bookings.Bookings, *booking)
+	}
+
+	return &bookings, nil
+}
+
+func (db *DB) countGroupBookings(filters string, args map[string]interface{}) (int, error) {
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(countGroupBookings, "#FILTERS#", filters))
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func scanGroupBooking(row rowScanner) (*models.GroupBooking, error) {
+	booking := models.GroupBooking{
+		User: &models.User{},
+		Event: &models.Event{
+			Type: &models.EventType{},
+		},
+	}
+
+	var orderID int
+	if err := row.Scan(
+		&booking.ID,
+		&booking.Tickets,
+		&booking.Organization,
+		&booking.DNI,
+		&booking.ContactName,
+		&booking.ContactEmail,
+		&booking.ContactPhone,
+		&booking.Notes,
+		&booking.Status,
+		&booking.Price,
+		&booking.DueDate,
+		&orderID,
+		&booking.ProofURL,
+		&booking.Reason,
+		&booking.Participants,
+		&booking.Created,
+		&booking.Updated,
+		&booking.User.ID,
+		&booking.User.Firstname,
+		&booking.User.Lastname,
+		&booking.User.Email,
+		&booking.Event.ID,
+		&booking.Event.Name,
+		&booking.Event.StartDateTime,
+		&booking.Event.EndDateTime,
+		&booking.Event.Price,
+		&booking.Event.Type.ID,
+		&booking.Event.Type.Name,
+	); err != nil {
+		return nil, err
+	}
+
+	if orderID != 0 {
+		booking.Order = &models.Order{
+			ID: orderID,
+		}
+	}
+
+	return &booking, nil
+}
+
+// lockGroupBookingTx locks the booking and checks it's in one of the given
+// statuses. It returns the preference id of the booking payment.
+func lockGroupBookingTx(tx Tx, bookingID int, statuses ...string) (string, error) {
+	var status, preferenceID string
+	if err := tx.QueryRow(getGroupBookingForUpdate, bookingID).Scan(&status, &preferenceID); err != nil {
+		if err == sql.ErrNoRows {
+			return "", ErrGroupBookingInvalid
+		}
+		return "", err
+	}
+
+	for _, s := range statuses {
+		if s == status {
+			return preferenceID, nil
+		}
+	}
+
+	return "", ErrGroupBookingInvalid
+}
+
+// ApproveGroupBooking creates the order of the booking at the negotiated
+// price, with a bank transfer payment waiting for the transfer. The order
+// holds its seats until the due date ends at the park, and they're checked
+// again when the transfer is confirmed, like any other order.
+func (db *DB) ApproveGroupBooking(booking *models.GroupBooking, adminID int) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	_, err = lockGroupBookingTx(tx, booking.ID, ConstGroupBookingStatuses.Requested)
+	if err != nil {
+		return err
+	}
+
+	if err = checkEventCapacityTx(tx, booking.Event.ID, booking.Tickets, 0, 0, 0); err != nil {
+		return err
+	}
+
+	reservedUntil := booking.DueDate.AddDate(0, 0, 1)
+	if location, locationErr := time.LoadLocation("America/Santiago"); locationErr == nil {
+		year, month, day := booking.DueDate.Date()
+		reservedUntil = time.Date(year, month, day+1, 0, 0, 0, 0, location)
+	}
+
+	transactionID := GenerateTicketUUID()
+	orderID, err := db.insertOrderTx(tx, adminID, booking.User.ID, booking.Event.ID, transactionID, booking.Tickets, booking.Price, 0, reservedUntil)
+	if err != nil {
+		return err
+	}
+
+	preferenceID := fmt.Sprintf("GB%s", shortuuid.New())
+	_, err = db.insertPaymentTx(tx, &InsertPaymentOpts{
+		MethodID:     ConstPaymentMethods.BankTransfer.ID,
+		Amount:       booking.Price,
+		UserID:       adminID,
+		OrderID:      orderID,
+		PreferenceID: preferenceID,
+		StatusID:     ConstPaymentStatuses.Created.ID,
+	})
+	if err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareNamed(approveGroupBooking)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(map[string]interface{}{
+		"id":            booking.ID,
+		"status":        ConstGroupBookingStatuses.Approved,
+		"price":         booking.Price,
+		"due_date":      booking.DueDate.Format(isoLayout),
+		"order_id":      orderID,
+		"preference_id": preferenceID,
+		"approved_by":   adminID,
+	})
+	if err != nil {
+		return err
+	}
+
+	booking.Status = ConstGroupBookingStatuses.Approved
+	booking.Order = &models.Order{
+		ID:            orderID,
+		TransactionID: transactionID,
+		Tickets:       booking.Tickets,
+		Price:         booking.Price,
+	}
+
+	return nil
+}
+
+func (db *DB) RejectGroupBooking(bookingID int, reason string) error {
+	result, err := db.Exec(rejectGroupBooking, ConstGroupBookingStatuses.Rejected, reason, bookingID, ConstGroupBookingStatuses.Requested)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrGroupBookingInvalid
+	}
+
+	return nil
+}
+
+// UpdateGroupBookingProof stores the bank transfer proof and leaves the
+// payment processing until an admin checks it.
+func (db *DB) UpdateGroupBookingProof(bookingID int, proofURL string) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	preferenceID, err := lockGroupBookingTx(tx, bookingID, ConstGroupBookingStatuses.Approved, ConstGroupBookingStatuses.ProofUploaded)
+	if err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(updateGroupBookingProof, ConstGroupBookingStatuses.ProofUploaded, proofURL, bookingID); err != nil {
+		return err
+	}
+
+	err = db.updatePaymentStatusTx(tx, preferenceID, ConstPaymentStatuses.Processing.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// UpdateGroupBookingPayment confirms the bank transfer, approving the payment
+// of the booking order, or turns down the proof so a new one can be uploaded.
+func (db *DB) UpdateGroupBookingPayment(bookingID int, approved bool) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	preferenceID, err := lockGroupBookingTx(tx, bookingID, ConstGroupBookingStatuses.Approved, ConstGroupBookingStatuses.ProofUploaded)
+	if err != nil {
+		return err
+	}
+
+	status, paymentStatusID := ConstGroupBookingStatuses.Paid, ConstPaymentStatuses.Approved.ID
+	if !approved {
+		status, paymentStatusID = ConstGroupBookingStatuses.Approved, ConstPaymentStatuses.Created.ID
+	}
+
+	if _, err = tx.Exec(updateGroupBookingStatus, status, bookingID); err != nil {
+		return err
+	}
+
+	err = db.updatePaymentStatusTx(tx, preferenceID, paymentStatusID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) ReplaceGroupBookingParticipants(bookingID int, participants []models.GroupBookingParticipant) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	if _, err = tx.Exec(deleteGroupBookingParticipants, bookingID); err != nil {
+		return err
+	}
+
+	if len(participants) == 0 {
+		return nil
+	}
+
+	var paramsArr []string
+	var argsArr []interface{}
+	for _, participant := range participants {
+		paramsArr = append(paramsArr, "(?, ?, ?, ?, ?)")
+		argsArr = append(argsArr, bookingID, participant.Firstname, participant.Lastname, participant.DNI, participant.Email)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(insertGroupBookingParticipants, strings.Join(paramsArr, ",")), argsArr...)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) GetGroupBookingParticipants(bookingID int) ([]models.GroupBookingParticipant, error) {
+	rows, err := db.Query(getGroupBookingParticipants, bookingID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	participants := []models.GroupBookingParticipant{}
+	for rows.Next() {
+		var participant models.GroupBookingParticipant
+		if err := rows.Scan(
+			&participant.ID,
+			&participant.Firstname,
+			&participant.Lastname,
+			&participant.DNI,
+			&participant.Email,
+		); err != nil {
+			return nil, err
+		}
+
+		participants = append(participants, participant)
+	}
+
+	return participants, nil
+}
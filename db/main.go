@@ -19,6 +19,22 @@ type Storage interface {
 	OrderStorage
 	PaymentStorage
 	CampingStorage
+	ResellerStorage
+	PrivacyStorage
+	AuditStorage
+	OrderTransferStorage
+	OrderHistoryStorage
+	OrderRescheduleStorage
+	WaitlistStorage
+	SeasonPassStorage
+	GiftVoucherStorage
+	GroupBookingStorage
+	TaxDocumentStorage
+	SalesAnalyticsStorage
+	OccupancyStorage
+	CashierShiftStorage
+	OrderReminderStorage
+	EventNoticeStorage
 }
 
 type db interface {
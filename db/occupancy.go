@@ -0,0 +1,283 @@
+package db
+
+import (
+	"strings"
+
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+type OccupancyStorage interface {
+	GetOccupancySlots(date string, eventTypeID int) ([]models.OccupancySlot, error)
+	GetOccupancyArrivals(date string, eventTypeID int) ([]models.EventArrivals, error)
+	GetGateAdmissions(date string) ([]models.GateAdmission, error)
+	ClaimDailyReport(date string) (bool, error)
+	ReleaseDailyReport(date string) error
+}
+
+const (
+	claimDailyReport = `
+	INSERT IGNORE
+		daily_report
+	SET
+		date = ?
+	`
+
+	releaseDailyReport = `
+	DELETE FROM
+		daily_report
+	WHERE
+		date = ?
+	`
+
+	// getOccupancySlots counts as sold the tickets of paid orders, like
+	// getEventTicketsSold, and as admitted the ones of used orders.
+	getOccupancySlots = `
+	SELECT
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		event.capacity,
+		event_type.id,
+		event_type.name,
+		event.end_date_time < CONVERT_TZ(UTC_TIMESTAMP(), 'UTC', 'America/Santiago'),
+		COALESCE(SUM(IF(paid.order_id IS NULL, 0, orders.tickets)), 0),
+		COALESCE(SUM(IF(paid.order_id IS NULL OR order_use.id IS NULL, 0, orders.tickets)), 0)
+	FROM
+		event
+	INNER JOIN
+		event_type ON (event_type.id = event.event_type_id)
+	LEFT JOIN
+		orders ON (orders.event_id = event.id AND orders.active = true)
+	LEFT JOIN
+		(SELECT DISTINCT payment.order_id FROM payment WHERE payment.status_id = :status_id AND payment.active = true) AS paid ON (paid.order_id = orders.id)
+	LEFT JOIN
+		order_use ON (order_use.id = (SELECT id FROM order_use WHERE order_use.order_id = orders.id LIMIT 1))
+	WHERE
+		event.active = true AND
+		DATE(event.start_date_time) = :date
+		#FILTERS#
+	GROUP BY
+		event.id
+	ORDER BY
+		event.start_date_time ASC,
+		event.id ASC
+	`
+
+	// getOccupancyArrivals groups the entries of the day's events by the hour
+	// of the park they happened at.
+	getOccupancyArrivals = `
+	SELECT
+		event.id,
+		HOUR(CONVERT_TZ(order_use.created, 'UTC', 'America/Santiago')) AS arrival_hour,
+		SUM(orders.tickets)
+	FROM
+		order_use
+	INNER JOIN
+		orders ON (orders.id = order_use.order_id AND orders.active = true)
+	INNER JOIN
+		event ON (event.id = orders.event_id)
+	WHERE
+		order_use.id = (SELECT id FROM order_use AS first_use WHERE first_use.order_id = orders.id LIMIT 1) AND
+		event.active = true AND
+		DATE(event.start_date_time) = :date
+		#FILTERS#
+	GROUP BY
+		event.id,
+		arrival_hour
+	ORDER BY
+		event.id ASC,
+		arrival_hour ASC
+	`
+
+	// getGateAdmissions adds the tickets of the used orders and the season
+	// pass visits of the day's events, by the gate they came through.
+	getGateAdmissions = `
+	SELECT
+		admission.event_id,
+		admission.gate,
+		SUM(admission.tickets)
+	FROM
+		(
+			SELECT
+				orders.event_id,
+				COALESCE(order_use.gate, '') AS gate,
+				orders.tickets
+			FROM
+				order_use
+			INNER JOIN
+				orders ON (orders.id = order_use.order_id AND orders.active = true)
+			INNER JOIN
+				event ON (event.id = orders.event_id)
+			WHERE
+				order_use.id = (SELECT id FROM order_use AS first_use WHERE first_use.order_id = orders.id LIMIT 1) AND
+				event.active = true AND
+				DATE(event.start_date_time) = :date
+			UNION ALL
+			SELECT
+				pass_visit.event_id,
+				COALESCE(pass_visit.gate, '') AS gate,
+				1
+			FROM
+				pass_visit
+			INNER JOIN
+				event ON (event.id = pass_visit.event_id)
+			WHERE
+				event.active = true AND
+				DATE(event.start_date_time) = :date
+		) AS admission
+	GROUP BY
+		admission.event_id,
+		admission.gate
+	ORDER BY
+		admission.event_id ASC,
+		admission.gate ASC
+	`
+)
+
+func (db *DB) GetOccupancySlots(date string, eventTypeID int) ([]models.OccupancySlot, error) {
+	var filters string
+	args := map[string]interface{}{
+		"date":      date,
+		"status_id": ConstPaymentStatuses.Approved.ID,
+	}
+	if eventTypeID != 0 {
+		filters += " AND event.event_type_id = :event_type_id "
+		args["event_type_id"] = eventTypeID
+	}
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(getOccupancySlots, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	slots := []models.OccupancySlot{}
+	for rows.Next() {
+		var slot models.OccupancySlot
+		var event models.Event
+		var eventType models.EventType
+		if err := rows.Scan(
+			&event.ID,
+			&event.Name,
+			&event.StartDateTime,
+			&event.EndDateTime,
+			&event.Capacity,
+			&eventType.ID,
+			&eventType.Name,
+			&slot.Ended,
+			&slot.TicketsSold,
+			&slot.TicketsAdmitted,
+		); err != nil {
+			return nil, err
+		}
+
+		event.Type = &eventType
+		slot.Event = &event
+
+		slots = append(slots, slot)
+	}
+
+	return slots, nil
+}
+
+func (db *DB) GetOccupancyArrivals(date string, eventTypeID int) ([]models.EventArrivals, error) {
+	var filters string
+	args := map[string]interface{}{
+		"date": date,
+	}
+	if eventTypeID != 0 {
+		filters += " AND event.event_type_id = :event_type_id "
+		args["event_type_id"] = eventTypeID
+	}
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(getOccupancyArrivals, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	arrivals := []models.EventArrivals{}
+	for rows.Next() {
+		var arrival models.EventArrivals
+		if err := rows.Scan(
+			&arrival.EventID,
+			&arrival.Hour,
+			&arrival.Tickets,
+		); err != nil {
+			return nil, err
+		}
+
+		arrivals = append(arrivals, arrival)
+	}
+
+	return arrivals, nil
+}
+
+func (db *DB) GetGateAdmissions(date string) ([]models.GateAdmission, error) {
+	stmt, err := db.PrepareNamed(getGateAdmissions)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"date": date,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	admissions := []models.GateAdmission{}
+	for rows.Next() {
+		var admission models.GateAdmission
+		if err := rows.Scan(
+			&admission.EventID,
+			&admission.Gate,
+			&admission.Tickets,
+		); err != nil {
+			return nil, err
+		}
+
+		admissions = append(admissions, admission)
+	}
+
+	return admissions, nil
+}
+
+// ClaimDailyReport records that the report of the day is being sent. It
+// returns false when it was already claimed, by this or another server.
+func (db *DB) ClaimDailyReport(date string) (bool, error) {
+	result, err := db.Exec(claimDailyReport, date)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// ReleaseDailyReport removes the claim of a report that couldn't be sent, so
+// it's retried.
+func (db *DB) ReleaseDailyReport(date string) error {
+	_, err := db.Exec(releaseDailyReport, date)
+
+	return err
+}
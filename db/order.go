@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"time"
 
 	"bitbucket.org/parqueoasis/backend/models"
 	"github.com/jmoiron/sqlx"
@@ -12,12 +13,13 @@ import (
 )
 
 type OrderStorage interface {
-	InsertOrder(userID int, clientID int, eventID int, tickets int, price int) (*models.Order, error)
+	InsertOrder(userID int, clientID int, eventID int, tickets int, price int, reservedUntil time.Time, waitlistEntryID int) (*models.Order, error)
+	ReserveOrder(orderID int, reservedUntil time.Time) error
 	GetOrderByID(orderID int) (*models.Order, error)
 	GetOrderByExternalReference(externalReference string) (*models.Order, error)
 	GetOrderByTransactionID(transactionID string) (*models.Order, error)
 	UpdateOrder(orderID int, eventID int) error
-	UseOrder(orderID int, userID int) error
+	UseOrder(orderID int, userID int, gate string) error
 	GetOrders(opts *models.GetOrdersOpts) (*models.GetOrdersStruct, error)
 	GetSalesSummary() ([]models.DailySales, error)
 	GetCashierSummary(cashierIDs []int, dateFrom string, dateTo string) ([]models.CashierMonthlySales, error)
@@ -33,7 +35,99 @@ const (
 		transaction_id = :transaction_id,
 		event_id = :event_id,
 		tickets = :tickets,
-		price = :price
+		price = :price,
+		tax_rate = :tax_rate,
+		net_amount = :net_amount,
+		tax_amount = :tax_amount,
+		reserved_until = :reserved_until
+	`
+
+	// getEventPendingTickets counts the tickets of unpaid orders still holding
+	// their seats, because their reservation is running or their payment is
+	// being processed.
+	getEventPendingTickets = `
+	SELECT
+		COALESCE(SUM(orders.tickets), 0)
+	FROM
+		orders
+	WHERE
+		orders.event_id = ? AND
+		orders.id <> ? AND
+		orders.active = true AND
+		(
+			orders.reserved_until > current_timestamp() OR
+			EXISTS(
+				SELECT
+					payment.id
+				FROM
+					payment
+				WHERE
+					payment.order_id = orders.id AND
+					payment.status_id = ? AND
+					payment.active = true
+			)
+		) AND
+		NOT EXISTS(
+			SELECT
+				payment.id
+			FROM
+				payment
+			WHERE
+				payment.order_id = orders.id AND
+				payment.status_id = ? AND
+				payment.active = true
+		)
+	`
+
+	// getOrderSeats returns what is needed to check the seats of an order
+	// before it's paid. Reseller sales take their seats from the allotment.
+	getOrderSeats = `
+	SELECT
+		orders.event_id,
+		orders.tickets,
+		orders.active,
+		EXISTS(
+			SELECT
+				payment.id
+			FROM
+				payment
+			WHERE
+				payment.order_id = orders.id AND
+				payment.status_id = ? AND
+				payment.active = true
+		),
+		EXISTS(
+			SELECT
+				reseller_sale.id
+			FROM
+				reseller_sale
+			WHERE
+				reseller_sale.order_id = orders.id
+		)
+	FROM
+		orders
+	WHERE
+		orders.id = ?
+	`
+
+	reserveOrder = `
+	UPDATE
+		orders
+	SET
+		reserved_until = GREATEST(COALESCE(reserved_until, ?), ?)
+	WHERE
+		id = ?
+	`
+
+	getEventTaxRate = `
+	SELECT
+		event_type.tax_rate
+	FROM
+		event
+	INNER JOIN
+		event_type ON (event_type.id = event.event_type_id)
+	WHERE
+		event.id = ?
 	`
 
 	getOrderByTransactionID = `
@@ -71,7 +165,17 @@ const (
 						'method', JSON_OBJECT(
 							'id', payment_method.id,
 							'name', payment_method.name
-						)
+						),
+						'tax_document', IF(payment.tax_folio IS NULL, NULL, JSON_OBJECT(
+							'type', payment.tax_document_type,
+							'folio', payment.tax_folio,
+							'pdf_url', payment.tax_pdf_url
+						)),
+						'credit_note', IF(payment.credit_note_folio IS NULL, NULL, JSON_OBJECT(
+							'type', 61,
+							'folio', payment.credit_note_folio,
+							'pdf_url', payment.credit_note_pdf_url
+						))
 					)
 				FROM
 					payment
@@ -114,6 +218,9 @@ const (
 		orders.transaction_id,
 		orders.tickets,
 		orders.price,
+		orders.tax_rate,
+		orders.net_amount,
+		orders.tax_amount,
 		orders.created,
 		orders.updated,
 		user.id,
@@ -153,7 +260,17 @@ const (
 						'method', JSON_OBJECT(
 							'id', payment_method.id,
 							'name', payment_method.name
-						)
+						),
+						'tax_document', IF(payment.tax_folio IS NULL, NULL, JSON_OBJECT(
+							'type', payment.tax_document_type,
+							'folio', payment.tax_folio,
+							'pdf_url', payment.tax_pdf_url
+						)),
+						'credit_note', IF(payment.credit_note_folio IS NULL, NULL, JSON_OBJECT(
+							'type', 61,
+							'folio', payment.credit_note_folio,
+							'pdf_url', payment.credit_note_pdf_url
+						))
 					)
 				FROM
 					payment
@@ -205,6 +322,9 @@ const (
 		orders.transaction_id,
 		orders.tickets,
 		orders.price,
+		orders.tax_rate,
+		orders.net_amount,
+		orders.tax_amount,
 		orders.created,
 		orders.updated,
 		user.id,
@@ -244,7 +364,17 @@ const (
 						'method', JSON_OBJECT(
 							'id', payment_method.id,
 							'name', payment_method.name
-						)
+						),
+						'tax_document', IF(payment.tax_folio IS NULL, NULL, JSON_OBJECT(
+							'type', payment.tax_document_type,
+							'folio', payment.tax_folio,
+							'pdf_url', payment.tax_pdf_url
+						)),
+						'credit_note', IF(payment.credit_note_folio IS NULL, NULL, JSON_OBJECT(
+							'type', 61,
+							'folio', payment.credit_note_folio,
+							'pdf_url', payment.credit_note_pdf_url
+						))
 					)
 				FROM
 					payment
@@ -305,6 +435,9 @@ const (
 		orders.transaction_id,
 		orders.tickets,
 		orders.price,
+		orders.tax_rate,
+		orders.net_amount,
+		orders.tax_amount,
 		orders.created,
 		orders.updated,
 		client.id,
@@ -342,7 +475,8 @@ const (
 		orders.active = true
 		#FILTERS#
 	ORDER BY
-		event.start_date_time DESC
+		event.start_date_time DESC,
+		orders.id DESC
 	LIMIT :limit_to OFFSET :limit_from
 	`
 
@@ -367,12 +501,14 @@ const (
 	getSalesSummary = `
 	SELECT
 		orders.created,
-		orders.price
+		SUM(orders.price),
+		SUM(orders.net_amount),
+		SUM(orders.tax_amount)
 	FROM
 		orders
 	INNER JOIN
 		event ON (event.id = orders.event_id)
-	LEFT JOIN
+	INNER JOIN
 		payment ON (payment.id = (SELECT id FROM payment WHERE order_id = orders.id AND status_id = 3 ORDER BY id DESC LIMIT 1))
 	WHERE
 		YEAR(orders.created) BETWEEN YEAR(current_timestamp())-1 AND YEAR(current_timestamp())	
@@ -387,7 +523,8 @@ const (
 		order_use
 	SET
 		user_id = :user_id,
-		order_id = :order_id
+		order_id = :order_id,
+		gate = :gate
 	`
 
 	getCashierSummary = `
@@ -421,7 +558,10 @@ const (
 	`
 )
 
-func (db *DB) InsertOrder(userID int, clientID int, eventID int, tickets int, price int) (*models.Order, error) {
+// InsertOrder creates an unpaid order holding its seats until reservedUntil.
+// It returns ErrEventSoldOut when the event can't take the tickets, the
+// waitlist hold of waitlistEntryID counting as available.
+func (db *DB) InsertOrder(userID int, clientID int, eventID int, tickets int, price int, reservedUntil time.Time, waitlistEntryID int) (*models.Order, error) {
 	tx, err := db.NewTx()
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to start transaction")
@@ -436,9 +576,13 @@ func (db *DB) InsertOrder(userID int, clientID int, eventID int, tickets int, pr
 		tx.Commit()
 	}()
 
+	if err = checkEventCapacityTx(tx, eventID, tickets, waitlistEntryID, 0, 0); err != nil {
+		return nil, err
+	}
+
 	transactionID := GenerateTicketUUID()
 
-	orderID, newErr := db.insertOrderTx(tx, userID, clientID, eventID, transactionID, tickets, price)
+	orderID, newErr := db.insertOrderTx(tx, userID, clientID, eventID, transactionID, tickets, price*tickets, 0, reservedUntil)
 	if newErr != nil {
 		err = newErr
 		return nil, err
@@ -459,7 +603,19 @@ func (db *DB) InsertOrder(userID int, clientID int, eventID int, tickets int, pr
 	return &order, nil
 }
 
-func (db *DB) insertOrderTx(tx Tx, userID int, clientID int, eventID int, transactionID string, tickets int, price int) (int, error) {
+// insertOrderTx inserts an order for the total price, splitting its net and
+// IVA amounts with the tax rate of the event type. voucherAmount is the part
+// of the tickets paid with a gift voucher, which isn't charged but is taxed
+// along with the price. Until it's paid, the order holds its seats until
+// reservedUntil, or not at all if it's zero.
+func (db *DB) insertOrderTx(tx Tx, userID int, clientID int, eventID int, transactionID string, tickets int, price int, voucherAmount int, reservedUntil time.Time) (int, error) {
+	var taxRate int
+	if err := tx.QueryRow(getEventTaxRate, eventID).Scan(&taxRate); err != nil {
+		return 0, err
+	}
+
+	taxes := orderTaxAmounts(price, voucherAmount, taxRate)
+
 	stmt, err := tx.PrepareNamed(insertOrder)
 	if err != nil {
 		return 0, err
@@ -471,7 +627,14 @@ func (db *DB) insertOrderTx(tx Tx, userID int, clientID int, eventID int, transa
 		"client_id":      clientID,
 		"tickets":        tickets,
 		"transaction_id": transactionID,
-		"price":          price * tickets,
+		"price":          price,
+		"tax_rate":       taxes.Rate,
+		"net_amount":     taxes.Net,
+		"tax_amount":     taxes.Tax,
+		"reserved_until": nil,
+	}
+	if !reservedUntil.IsZero() {
+		args["reserved_until"] = reservedUntil.UTC()
 	}
 
 	result, err := stmt.Exec(args)
@@ -487,6 +650,68 @@ func (db *DB) insertOrderTx(tx Tx, userID int, clientID int, eventID int, transa
 	return int(id), nil
 }
 
+// orderTaxAmounts splits the taxes of an order. A gift voucher is only a
+// means of payment, its sale isn't taxed, so the part of the tickets it pays
+// is taxed when it's redeemed.
+func orderTaxAmounts(price int, voucherAmount int, taxRate int) models.TaxAmounts {
+	return models.NewTaxAmounts(price+voucherAmount, taxRate)
+}
+
+// ReserveOrder holds the seats of an unpaid order until reservedUntil, while
+// it's being paid. An order whose reservation lapsed only gets its seats back
+// if the event still has them, otherwise it returns ErrEventSoldOut.
+func (db *DB) ReserveOrder(orderID int, reservedUntil time.Time) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	if err = checkOrderSeatsTx(tx, orderID); err != nil {
+		return err
+	}
+
+	until := reservedUntil.UTC()
+	if _, err = tx.Exec(reserveOrder, until, until, orderID); err != nil {
+		return err
+	}
+
+	if err = extendOrderVoucherRedemptionsTx(tx, orderID, until); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkOrderSeatsTx returns ErrEventSoldOut when the event no longer has the
+// seats of an unpaid order, counting the ones the order holds as its own. It
+// must run before the order is paid.
+func checkOrderSeatsTx(tx Tx, orderID int) error {
+	var eventID, tickets int
+	var active, paid, resold bool
+	err := tx.QueryRow(getOrderSeats, ConstPaymentStatuses.Approved.ID, orderID).Scan(&eventID, &tickets, &active, &paid, &resold)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !active || paid || resold {
+		return nil
+	}
+
+	return checkEventCapacityTx(tx, eventID, tickets, 0, 0, orderID)
+}
+
 func (db *DB) GetOrderByExternalReference(externalReference string) (*models.Order, error) {
 	stmt, err := db.PrepareNamed(getOrderByExternalReference)
 	if err != nil {
@@ -511,6 +736,9 @@ func (db *DB) GetOrderByExternalReference(externalReference string) (*models.Ord
 		&order.TransactionID,
 		&order.Tickets,
 		&order.Price,
+		&order.TaxRate,
+		&order.NetAmount,
+		&order.TaxAmount,
 		&order.Created,
 		&order.Updated,
 		&user.ID,
@@ -620,6 +848,9 @@ func (db *DB) GetOrderByID(id int) (*models.Order, error) {
 		&order.TransactionID,
 		&order.Tickets,
 		&order.Price,
+		&order.TaxRate,
+		&order.NetAmount,
+		&order.TaxAmount,
 		&order.Created,
 		&order.Updated,
 		&user.ID,
@@ -709,7 +940,7 @@ func (db *DB) updateOrderTx(tx Tx, orderID int, eventID int) error {
 	return nil
 }
 
-func (db *DB) UseOrder(orderID int, userID int) error {
+func (db *DB) UseOrder(orderID int, userID int, gate string) error {
 	tx, err := db.NewTx()
 	if err != nil {
 		return errors.Wrap(err, "failed to start transaction")
@@ -724,7 +955,7 @@ func (db *DB) UseOrder(orderID int, userID int) error {
 		tx.Commit()
 	}()
 
-	err = db.insertOrderUseTx(tx, orderID, userID)
+	err = db.insertOrderUseTx(tx, orderID, userID, gate)
 	if err != nil {
 		return err
 	}
@@ -732,7 +963,7 @@ func (db *DB) UseOrder(orderID int, userID int) error {
 	return nil
 }
 
-func (db *DB) insertOrderUseTx(tx Tx, orderID int, userID int) error {
+func (db *DB) insertOrderUseTx(tx Tx, orderID int, userID int, gate string) error {
 	stmt, err := tx.PrepareNamed(insertOrderUse)
 	if err != nil {
 		return err
@@ -741,6 +972,7 @@ func (db *DB) insertOrderUseTx(tx Tx, orderID int, userID int) error {
 	args := map[string]interface{}{
 		"user_id":  userID,
 		"order_id": orderID,
+		"gate":     gate,
 	}
 
 	_, err = stmt.Exec(args)
@@ -770,6 +1002,7 @@ func (db *DB) GetOrders(opts *models.GetOrdersOpts) (*models.GetOrdersStruct, er
 	}
 	if opts.EventTypeID != 0 {
 		filters += " AND event.event_type_id = :event_type_id "
+		args["event_type_id"] = opts.EventTypeID
 	}
 	if opts.ClientID != 0 {
 		filters += " AND orders.client_id = :client_id "
@@ -824,6 +1057,9 @@ func (db *DB) GetOrders(opts *models.GetOrdersOpts) (*models.GetOrdersStruct, er
 			&order.TransactionID,
 			&order.Tickets,
 			&order.Price,
+			&order.TaxRate,
+			&order.NetAmount,
+			&order.TaxAmount,
 			&order.Created,
 			&order.Updated,
 			&client.ID,
@@ -847,6 +1083,7 @@ func (db *DB) GetOrders(opts *models.GetOrdersOpts) (*models.GetOrdersStruct, er
 			return nil, err
 		}
 
+		event.Type = &eventType
 		order.Client = &client
 		order.User = &user
 		order.Event = &event
@@ -889,6 +1126,8 @@ func (db *DB) GetSalesSummary() ([]models.DailySales, error) {
 		if err := rows.Scan(
 			&dailySales.Date,
 			&dailySales.Total,
+			&dailySales.Net,
+			&dailySales.Tax,
 		); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+type OrderHistoryStorage interface {
+	GetOrderHistory(orderID int) ([]models.OrderHistory, error)
+}
+
+var ConstOrderHistoryActions = struct {
+	TransferRequested string
+	TransferCancelled string
+	TransferAccepted  string
+	Rescheduled       string
+	RefundRequested   string
+	VoucherReleased   string
+}{
+	TransferRequested: "transfer_requested",
+	TransferCancelled: "transfer_cancelled",
+	TransferAccepted:  "transfer_accepted",
+	Rescheduled:       "rescheduled",
+	RefundRequested:   "refund_requested",
+	VoucherReleased:   "voucher_released",
+}
+
+const (
+	insertOrderHistory = `
+	INSERT
+		order_history
+	SET
+		order_id = :order_id,
+		user_id = :user_id,
+		action = :action,
+		detail = :detail
+	`
+
+	getOrderHistory = `
+	SELECT
+		order_history.id,
+		order_history.action,
+		order_history.detail,
+		order_history.created,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		order_history
+	INNER JOIN
+		user ON (user.id = order_history.user_id)
+	WHERE
+		order_history.order_id = :order_id
+	ORDER BY
+		order_history.id ASC
+	`
+)
+
+func (db *DB) insertOrderHistoryTx(tx Tx, orderID int, userID int, action string, detail string) error {
+	stmt, err := tx.PrepareNamed(insertOrderHistory)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"order_id": orderID,
+		"user_id":  userID,
+		"action":   action,
+		"detail":   detail,
+	}
+
+	_, err = stmt.Exec(args)
+	return err
+}
+
+func (db *DB) GetOrderHistory(orderID int) ([]models.OrderHistory, error) {
+	stmt, err := db.PrepareNamed(getOrderHistory)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"order_id": orderID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	history := []models.OrderHistory{}
+	for rows.Next() {
+		entry := models.OrderHistory{
+			User: &models.User{},
+		}
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.Action,
+			&entry.Detail,
+			&entry.Created,
+			&entry.User.ID,
+			&entry.User.Firstname,
+			&entry.User.Lastname,
+			&entry.User.Email,
+		); err != nil {
+			return nil, err
+		}
+
+		history = append(history, entry)
+	}
+
+	return history, nil
+}
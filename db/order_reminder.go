@@ -0,0 +1,182 @@
+package db
+
+import (
+	"database/sql"
+
+	"github.com/pkg/errors"
+)
+
+type OrderReminderStorage interface {
+	GetPendingReminderOrderIDs(hours int, maxAttempts int, limit int) ([]int, error)
+	ClaimOrderReminder(orderID int, eventID int, maxAttempts int) (bool, error)
+	UpdateOrderReminderStatus(orderID int, eventID int, status string, lastError string) error
+}
+
+// ConstOrderReminderStatuses are the states of the reminder of an order for
+// an event. A reminder left sending by a restart is not retried, as the
+// email may already be out.
+var ConstOrderReminderStatuses = struct {
+	Sending string
+	Sent    string
+	Failed  string
+}{
+	Sending: "sending",
+	Sent:    "sent",
+	Failed:  "failed",
+}
+
+const (
+	// getPendingReminderOrderIDs lists the paid and unused orders whose event
+	// starts within the given hours, skipping the ones already reminded for
+	// that event. Rescheduled orders are reminded again for their new event.
+	getPendingReminderOrderIDs = `
+	SELECT
+		orders.id
+	FROM
+		orders
+	INNER JOIN
+		event ON (event.id = orders.event_id)
+	INNER JOIN
+		payment ON (payment.id = (SELECT id FROM payment WHERE payment.order_id = orders.id AND payment.active = true ORDER BY payment.id DESC LIMIT 1))
+	LEFT JOIN
+		order_reminder ON (order_reminder.order_id = orders.id AND order_reminder.event_id = orders.event_id)
+	WHERE
+		orders.active = true AND
+		payment.status_id = :approved AND
+		event.start_date_time > CONVERT_TZ(UTC_TIMESTAMP(), 'UTC', 'America/Santiago') AND
+		event.start_date_time <= CONVERT_TZ(UTC_TIMESTAMP(), 'UTC', 'America/Santiago') + INTERVAL :hours HOUR AND
+		NOT EXISTS (SELECT 1 FROM order_use WHERE order_use.order_id = orders.id) AND
+		(order_reminder.id IS NULL OR (order_reminder.status = :failed AND order_reminder.attempts < :max_attempts))
+	ORDER BY
+		event.start_date_time ASC,
+		orders.id ASC
+	LIMIT :limit
+	`
+
+	getOrderReminderForUpdate = `
+	SELECT
+		status,
+		attempts
+	FROM
+		order_reminder
+	WHERE
+		order_id = ? AND
+		event_id = ?
+	FOR UPDATE
+	`
+
+	insertOrderReminder = `
+	INSERT
+		order_reminder
+	SET
+		order_id = ?,
+		event_id = ?,
+		status = ?,
+		attempts = 1
+	`
+
+	retryOrderReminder = `
+	UPDATE
+		order_reminder
+	SET
+		status = ?,
+		attempts = attempts + 1
+	WHERE
+		order_id = ? AND
+		event_id = ?
+	`
+
+	updateOrderReminderStatus = `
+	UPDATE
+		order_reminder
+	SET
+		status = ?,
+		last_error = ?,
+		sent = IF(status = ?, current_timestamp(), sent)
+	WHERE
+		order_id = ? AND
+		event_id = ?
+	`
+)
+
+func (db *DB) GetPendingReminderOrderIDs(hours int, maxAttempts int, limit int) ([]int, error) {
+	stmt, err := db.PrepareNamed(getPendingReminderOrderIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"approved":     ConstPaymentStatuses.Approved.ID,
+		"hours":        hours,
+		"failed":       ConstOrderReminderStatuses.Failed,
+		"max_attempts": maxAttempts,
+		"limit":        limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	orderIDs := []int{}
+	for rows.Next() {
+		var orderID int
+		if err := rows.Scan(&orderID); err != nil {
+			return nil, err
+		}
+
+		orderIDs = append(orderIDs, orderID)
+	}
+
+	return orderIDs, nil
+}
+
+// ClaimOrderReminder marks the reminder of the order for the event as being
+// sent. It reports false when it was already sent, is being sent or ran out
+// of attempts, so a reminder goes out only once.
+func (db *DB) ClaimOrderReminder(orderID int, eventID int, maxAttempts int) (bool, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	var status string
+	var attempts int
+	err = tx.QueryRow(getOrderReminderForUpdate, orderID, eventID).Scan(&status, &attempts)
+	if err == sql.ErrNoRows {
+		_, err = tx.Exec(insertOrderReminder, orderID, eventID, ConstOrderReminderStatuses.Sending)
+		if err != nil {
+			return false, err
+		}
+
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if status != ConstOrderReminderStatuses.Failed || attempts >= maxAttempts {
+		return false, nil
+	}
+
+	_, err = tx.Exec(retryOrderReminder, ConstOrderReminderStatuses.Sending, orderID, eventID)
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func (db *DB) UpdateOrderReminderStatus(orderID int, eventID int, status string, lastError string) error {
+	_, err := db.Exec(updateOrderReminderStatus, status, lastError, ConstOrderReminderStatuses.Sent, orderID, eventID)
+	return err
+}
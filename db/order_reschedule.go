@@ -0,0 +1,369 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type OrderRescheduleStorage interface {
+	InsertOrderReschedule(reschedule *models.OrderReschedule) (int, error)
+	GetOrderRescheduleByPreferenceID(preferenceID string) (*models.OrderReschedule, error)
+	CompleteOrderReschedule(reschedule *models.OrderReschedule) error
+	UpdateOrderRescheduleStatus(rescheduleID int, status string) error
+}
+
+var (
+	// ErrEventSoldOut is returned when the event has no capacity left for
+	// the tickets of the order.
+	ErrEventSoldOut = errors.New("event sold out")
+	// ErrOrderRescheduleInvalid is returned when the order was used, moved or
+	// rescheduled again after the reschedule was requested.
+	ErrOrderRescheduleInvalid = errors.New("order reschedule is no longer valid")
+)
+
+var ConstOrderRescheduleStatuses = struct {
+	Pending   string
+	Completed string
+	Cancelled string
+	Failed    string
+}{
+	Pending:   "pending",
+	Completed: "completed",
+	Cancelled: "cancelled",
+	Failed:    "failed",
+}
+
+const (
+	insertOrderReschedule = `
+	INSERT
+		order_reschedule
+	SET
+		order_id = :order_id,
+		user_id = :user_id,
+		from_event_id = :from_event_id,
+		to_event_id = :to_event_id,
+		price_difference = :price_difference,
+		fee = :fee,
+		amount = :amount,
+		status = :status,
+		preference_id = :preference_id
+	`
+
+	cancelPendingOrderReschedules = `
+	UPDATE
+		order_reschedule
+	SET
+		status = :cancelled
+	WHERE
+		order_id = :order_id AND
+		status = :pending
+	`
+
+	getOrderRescheduleByPreferenceID = `
+	SELECT
+		order_reschedule.id,
+		order_reschedule.order_id,
+		order_reschedule.user_id,
+		order_reschedule.from_event_id,
+		order_reschedule.to_event_id,
+		order_reschedule.price_difference,
+		order_reschedule.fee,
+		order_reschedule.amount,
+		order_reschedule.status,
+		COALESCE(order_reschedule.preference_id, ''),
+		order_reschedule.created
+	FROM
+		order_reschedule
+	WHERE
+		order_reschedule.preference_id = :preference_id
+	`
+
+	updateOrderRescheduleCreditVoucher = `
+	UPDATE
+		order_reschedule
+	SET
+		gift_voucher_id = ?
+	WHERE
+		id = ?
+	`
+
+	updateOrderRescheduleStatus = `
+	UPDATE
+		order_reschedule
+	SET
+		status = :status
+	WHERE
+		id = :id AND
+		status = :pending
+	`
+
+	getOrderForReschedule = `
+	SELECT
+		orders.event_id,
+		orders.tickets,
+		EXISTS(
+			SELECT
+				order_use.id
+			FROM
+				order_use
+			WHERE
+				order_use.order_id = orders.id
+		)
+	FROM
+		orders
+	WHERE
+		orders.id = ?
+	FOR UPDATE
+	`
+
+	getEventCapacityForUpdate = `
+	SELECT
+		COALESCE(event.capacity, 0)
+	FROM
+		event
+	WHERE
+		event.id = ?
+	FOR UPDATE
+	`
+
+	// MySQL assigns the columns from left to right, so the amounts are
+	// computed with the new price. The part paid with gift vouchers is taxed
+	// along with the price, as when the order was created.
+	rescheduleOrder = `
+	UPDATE
+		orders
+	SET
+		event_id = ?,
+		price = price + ?,
+		net_amount = ROUND((price + ?) * 10000 / (10000 + tax_rate)),
+		tax_amount = price + ? - net_amount
+	WHERE
+		id = ?
+	`
+)
+
+// InsertOrderReschedule records a reschedule request, cancelling any previous
+// pending one for the order. When nothing has to be paid the order is moved
+// to the new event right away, otherwise it waits for the payment. The credit
+// voucher of a cheaper event is issued along with the change.
+func (db *DB) InsertOrderReschedule(reschedule *models.OrderReschedule) (int, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(cancelPendingOrderReschedules)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = stmt.Exec(map[string]interface{}{
+		"order_id":  reschedule.Order.ID,
+		"cancelled": ConstOrderRescheduleStatuses.Cancelled,
+		"pending":   ConstOrderRescheduleStatuses.Pending,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	stmt, err = tx.PrepareNamed(insertOrderReschedule)
+	if err != nil {
+		return 0, err
+	}
+
+	var preferenceID interface{}
+	if reschedule.PreferenceID != "" {
+		preferenceID = reschedule.PreferenceID
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"order_id":         reschedule.Order.ID,
+		"user_id":          reschedule.User.ID,
+		"from_event_id":    reschedule.FromEvent.ID,
+		"to_event_id":      reschedule.ToEvent.ID,
+		"price_difference": reschedule.PriceDifference,
+		"fee":              reschedule.Fee,
+		"amount":           reschedule.Amount,
+		"status":           ConstOrderRescheduleStatuses.Pending,
+		"preference_id":    preferenceID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	reschedule.ID = int(id)
+	reschedule.Status = ConstOrderRescheduleStatuses.Pending
+
+	if reschedule.Amount <= 0 {
+		err = db.applyOrderRescheduleTx(tx, reschedule)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if reschedule.Amount < 0 && reschedule.CreditVoucher != nil {
+		voucherID, newErr := addGiftVoucher(tx, reschedule.CreditVoucher)
+		if newErr != nil {
+			err = newErr
+			return 0, err
+		}
+
+		if _, err = tx.Exec(updateOrderRescheduleCreditVoucher, voucherID, reschedule.ID); err != nil {
+			return 0, err
+		}
+	}
+
+	return reschedule.ID, nil
+}
+
+func (db *DB) GetOrderRescheduleByPreferenceID(preferenceID string) (*models.OrderReschedule, error) {
+	stmt, err := db.PrepareNamed(getOrderRescheduleByPreferenceID)
+	if err != nil {
+		return nil, err
+	}
+
+	reschedule := models.OrderReschedule{
+		Order:     &models.Order{},
+		User:      &models.User{},
+		FromEvent: &models.Event{},
+		ToEvent:   &models.Event{},
+	}
+
+	row := stmt.QueryRow(map[string]interface{}{
+		"preference_id": preferenceID,
+	})
+	if err := row.Scan(
+		&reschedule.ID,
+		&reschedule.Order.ID,
+		&reschedule.User.ID,
+		&reschedule.FromEvent.ID,
+		&reschedule.ToEvent.ID,
+		&reschedule.PriceDifference,
+		&reschedule.Fee,
+		&reschedule.Amount,
+		&reschedule.Status,
+		&reschedule.PreferenceID,
+		&reschedule.Created,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &reschedule, nil
+}
+
+func (db *DB) CompleteOrderReschedule(reschedule *models.OrderReschedule) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	err = db.applyOrderRescheduleTx(tx, reschedule)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) UpdateOrderRescheduleStatus(rescheduleID int, status string) error {
+	stmt, err := db.PrepareNamed(updateOrderRescheduleStatus)
+	if err != nil {
+		return err
+	}
+
+	_, err = stmt.Exec(map[string]interface{}{
+		"id":      rescheduleID,
+		"status":  status,
+		"pending": ConstOrderRescheduleStatuses.Pending,
+	})
+	return err
+}
+
+// applyOrderRescheduleTx moves the order to the new event. The order and the
+// event are locked so concurrent purchases can't oversell the event.
+func (db *DB) applyOrderRescheduleTx(tx Tx, reschedule *models.OrderReschedule) error {
+	var eventID, tickets int
+	var used bool
+	row := tx.QueryRow(getOrderForReschedule, reschedule.Order.ID)
+	if err := row.Scan(
+		&eventID,
+		&tickets,
+		&used,
+	); err != nil {
+		return err
+	}
+
+	if eventID != reschedule.FromEvent.ID || used {
+		return ErrOrderRescheduleInvalid
+	}
+
+	if err := checkEventCapacityTx(tx, reschedule.ToEvent.ID, tickets, 0, 0, 0); err != nil {
+		return err
+	}
+
+	var voucherAmount int
+	if err := tx.QueryRow(getOrderVoucherAmount, reschedule.Order.ID, ConstGiftVoucherRedemptionStatuses.Released).Scan(&voucherAmount); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(rescheduleOrder, reschedule.ToEvent.ID, reschedule.PriceDifference, voucherAmount, voucherAmount, reschedule.Order.ID); err != nil {
+		return err
+	}
+
+	stmt, err := tx.PrepareNamed(updateOrderRescheduleStatus)
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"id":      reschedule.ID,
+		"status":  ConstOrderRescheduleStatuses.Completed,
+		"pending": ConstOrderRescheduleStatuses.Pending,
+	})
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrOrderRescheduleInvalid
+	}
+
+	reschedule.Status = ConstOrderRescheduleStatuses.Completed
+
+	detail := fmt.Sprintf("%d -> %d", reschedule.FromEvent.ID, reschedule.ToEvent.ID)
+	return db.insertOrderHistoryTx(tx, reschedule.Order.ID, reschedule.User.ID, ConstOrderHistoryActions.Rescheduled, detail)
+}
@@ -0,0 +1,26 @@
+package db
+
+import "testing"
+
+func TestOrderTaxAmounts(t *testing.T) {
+	tests := []struct {
+		name          string
+		price         int
+		voucherAmount int
+		taxRate       int
+		net           int
+		tax           int
+	}{
+		{name: "paid order", price: 20000, taxRate: 1900, net: 16807, tax: 3193},
+		{name: "partially redeemed", price: 5000, voucherAmount: 15000, taxRate: 1900, net: 16807, tax: 3193},
+		{name: "fully redeemed", price: 0, voucherAmount: 20000, taxRate: 1900, net: 16807, tax: 3193},
+		{name: "exempt", price: 5000, voucherAmount: 15000, taxRate: 0, net: 20000, tax: 0},
+	}
+
+	for _, test := range tests {
+		taxes := orderTaxAmounts(test.price, test.voucherAmount, test.taxRate)
+		if taxes.Net != test.net || taxes.Tax != test.tax || taxes.Rate != test.taxRate {
+			t.Errorf("%s: got net %d tax %d rate %d, want net %d tax %d rate %d", test.name, taxes.Net, taxes.Tax, taxes.Rate, test.net, test.tax, test.taxRate)
+		}
+	}
+}
@@ -0,0 +1,391 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type OrderTransferStorage interface {
+	InsertOrderTransfer(orderID int, fromClientID int, actorID int, email string, token string, expires time.Time) (int, error)
+	GetPendingOrderTransfer(orderID int) (*models.OrderTransfer, error)
+	GetOrderTransferByToken(token string) (*models.OrderTransfer, error)
+	CancelOrderTransfer(transferID int, orderID int, actorID int) error
+	AcceptOrderTransfer(transfer *models.OrderTransfer, toClientID int) (string, error)
+}
+
+// ErrOrderTransferInvalid is returned when the order changed hands or was
+// used after the transfer was requested.
+var ErrOrderTransferInvalid = errors.New("order transfer is no longer valid")
+
+var ConstOrderTransferStatuses = struct {
+	Pending   string
+	Accepted  string
+	Cancelled string
+}{
+	Pending:   "pending",
+	Accepted:  "accepted",
+	Cancelled: "cancelled",
+}
+
+const (
+	insertOrderTransfer = `
+	INSERT
+		order_transfer
+	SET
+		order_id = :order_id,
+		from_client_id = :from_client_id,
+		email = :email,
+		token = :token,
+		status = :status,
+		expires = :expires
+	`
+
+	getPendingOrderTransfer = `
+	SELECT
+		order_transfer.id,
+		order_transfer.order_id,
+		order_transfer.from_client_id,
+		order_transfer.email,
+		order_transfer.status,
+		order_transfer.expires,
+		order_transfer.created
+	FROM
+		order_transfer
+	WHERE
+		order_transfer.order_id = :order_id AND
+		order_transfer.status = :status AND
+		order_transfer.expires > current_timestamp()
+	ORDER BY
+		order_transfer.id DESC
+	LIMIT 1
+	`
+
+	getOrderTransferByToken = `
+	SELECT
+		order_transfer.id,
+		order_transfer.order_id,
+		order_transfer.from_client_id,
+		order_transfer.email,
+		order_transfer.status,
+		order_transfer.expires,
+		order_transfer.created,
+		from_client.firstname,
+		from_client.lastname,
+		EXISTS(
+			SELECT
+				user.id
+			FROM
+				user
+			WHERE
+				user.email = order_transfer.email AND
+				user.active = 1
+		),
+		EXISTS(
+			SELECT
+				user.id
+			FROM
+				user
+			WHERE
+				user.email = order_transfer.email AND
+				user.active = 0
+		)
+	FROM
+		order_transfer
+	INNER JOIN
+		user from_client ON (from_client.id = order_transfer.from_client_id)
+	WHERE
+		order_transfer.token = :token
+	`
+
+	updateOrderTransferStatus = `
+	UPDATE
+		order_transfer
+	SET
+		status = :status,
+		to_client_id = :to_client_id,
+		accepted_at = :accepted_at
+	WHERE
+		id = :id AND
+		status = :pending
+	`
+
+	getOrderForTransfer = `
+	SELECT
+		orders.client_id,
+		EXISTS(
+			SELECT
+				order_use.id
+			FROM
+				order_use
+			WHERE
+				order_use.order_id = orders.id
+		)
+	FROM
+		orders
+	WHERE
+		orders.id = :order_id
+	FOR UPDATE
+	`
+
+	transferOrder = `
+	UPDATE
+		orders
+	SET
+		client_id = :client_id,
+		transaction_id = :transaction_id
+	WHERE
+		id = :order_id
+	`
+)
+
+func (db *DB) InsertOrderTransfer(orderID int, fromClientID int, actorID int, email string, token string, expires time.Time) (int, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(insertOrderTransfer)
+	if err != nil {
+		return 0, err
+	}
+
+	args := map[string]interface{}{
+		"order_id":       orderID,
+		"from_client_id": fromClientID,
+		"email":          email,
+		"token":          token,
+		"status":         ConstOrderTransferStatuses.Pending,
+		"expires":        expires.UTC(),
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	err = db.insertOrderHistoryTx(tx, orderID, actorID, ConstOrderHistoryActions.TransferRequested, email)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (db *DB) GetPendingOrderTransfer(orderID int) (*models.OrderTransfer, error) {
+	stmt, err := db.PrepareNamed(getPendingOrderTransfer)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"order_id": orderID,
+		"status":   ConstOrderTransferStatuses.Pending,
+	}
+
+	transfer := models.OrderTransfer{
+		Order: &models.Order{},
+		From:  &models.User{},
+	}
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&transfer.ID,
+		&transfer.Order.ID,
+		&transfer.From.ID,
+		&transfer.Email,
+		&transfer.Status,
+		&transfer.Expires,
+		&transfer.Created,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+func (db *DB) GetOrderTransferByToken(token string) (*models.OrderTransfer, error) {
+	stmt, err := db.PrepareNamed(getOrderTransferByToken)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"token": token,
+	}
+
+	transfer := models.OrderTransfer{
+		Order: &models.Order{},
+		From:  &models.User{},
+	}
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&transfer.ID,
+		&transfer.Order.ID,
+		&transfer.From.ID,
+		&transfer.Email,
+		&transfer.Status,
+		&transfer.Expires,
+		&transfer.Created,
+		&transfer.From.Firstname,
+		&transfer.From.Lastname,
+		&transfer.AccountExists,
+		&transfer.AccountInactive,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &transfer, nil
+}
+
+func (db *DB) CancelOrderTransfer(transferID int, orderID int, actorID int) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	err = db.updateOrderTransferStatusTx(tx, transferID, ConstOrderTransferStatuses.Cancelled, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	err = db.insertOrderHistoryTx(tx, orderID, actorID, ConstOrderHistoryActions.TransferCancelled, "")
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AcceptOrderTransfer moves the order to the recipient and re-issues its
+// ticket code, so the PDF held by the previous owner stops working. It
+// returns the new ticket code.
+func (db *DB) AcceptOrderTransfer(transfer *models.OrderTransfer, toClientID int) (string, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return "", errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(getOrderForTransfer)
+	if err != nil {
+		return "", err
+	}
+
+	var clientID int
+	var used bool
+	row := stmt.QueryRow(map[string]interface{}{
+		"order_id": transfer.Order.ID,
+	})
+	if err = row.Scan(
+		&clientID,
+		&used,
+	); err != nil {
+		return "", err
+	}
+
+	if clientID != transfer.From.ID || used {
+		err = ErrOrderTransferInvalid
+		return "", err
+	}
+
+	transactionID := GenerateTicketUUID()
+
+	stmt, err = tx.PrepareNamed(transferOrder)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = stmt.Exec(map[string]interface{}{
+		"order_id":       transfer.Order.ID,
+		"client_id":      toClientID,
+		"transaction_id": transactionID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now().UTC()
+	err = db.updateOrderTransferStatusTx(tx, transfer.ID, ConstOrderTransferStatuses.Accepted, &toClientID, &now)
+	if err != nil {
+		return "", err
+	}
+
+	err = db.insertOrderHistoryTx(tx, transfer.Order.ID, toClientID, ConstOrderHistoryActions.TransferAccepted, transfer.Email)
+	if err != nil {
+		return "", err
+	}
+
+	return transactionID, nil
+}
+
+func (db *DB) updateOrderTransferStatusTx(tx Tx, transferID int, status string, toClientID *int, acceptedAt *time.Time) error {
+	stmt, err := tx.PrepareNamed(updateOrderTransferStatus)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"id":           transferID,
+		"status":       status,
+		"to_client_id": toClientID,
+		"accepted_at":  acceptedAt,
+		"pending":      ConstOrderTransferStatuses.Pending,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrOrderTransferInvalid
+	}
+
+	return nil
+}
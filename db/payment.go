@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"strings"
 
 	"bitbucket.org/parqueoasis/backend/models"
 	"github.com/pkg/errors"
@@ -37,8 +38,11 @@ var ConstPaymentStatuses = struct {
 }
 
 var ConstPaymentMethods = struct {
-	Cashier     models.PaymentMethod
-	MercadoPago models.PaymentMethod
+	Cashier      models.PaymentMethod
+	MercadoPago  models.PaymentMethod
+	Reseller     models.PaymentMethod
+	Voucher      models.PaymentMethod
+	BankTransfer models.PaymentMethod
 }{
 	Cashier: models.PaymentMethod{
 		ID:   1,
@@ -48,12 +52,25 @@ var ConstPaymentMethods = struct {
 		ID:   2,
 		Name: "Mercado Pago",
 	},
+	Reseller: models.PaymentMethod{
+		ID:   3,
+		Name: "Revendedor",
+	},
+	Voucher: models.PaymentMethod{
+		ID:   4,
+		Name: "Vale de regalo",
+	},
+	BankTransfer: models.PaymentMethod{
+		ID:   5,
+		Name: "Transferencia bancaria",
+	},
 }
 
 type PaymentStorage interface {
 	InsertPayment(*InsertPaymentOpts) (int, error)
 	GetPaymentStatusByMethodIDAndMethodStatusName(methodID int, statusName string) (*models.PaymentStatus, error)
 	UpdatePaymentStatus(externalReference string, statusID int) error
+	GetPayments(opts *models.GetPaymentsOpts) (*models.PaymentsStruct, error)
 }
 
 type InsertPaymentOpts struct {
@@ -62,6 +79,7 @@ type InsertPaymentOpts struct {
 	UserID       int    `json:"user_id"`
 	PreferenceID string `json:"preference_id"`
 	OrderID      int    `json:"order_id"`
+	SeasonPassID int    `json:"season_pass_id"`
 	StatusID     int    `json:"status_id"`
 }
 
@@ -75,6 +93,7 @@ const (
 		user_id = :user_id,
 		preference_id = :preference_id,
 		order_id = :order_id,
+		season_pass_id = :season_pass_id,
 		status_id = :status_id
 	`
 
@@ -91,6 +110,72 @@ const (
 		payment_method_status.name = :name
 	`
 
+	getPayments = `
+	SELECT
+		payment.id,
+		payment.amount,
+		COALESCE(payment.preference_id, ""),
+		payment.created,
+		payment.updated,
+		payment_method.id,
+		payment_method.name,
+		payment_status.id,
+		payment_status.name,
+		COALESCE(orders.id, 0),
+		COALESCE(orders.transaction_id, ''),
+		COALESCE(orders.tickets, 0),
+		COALESCE(orders.price, 0),
+		COALESCE(season_pass.id, 0),
+		COALESCE(season_pass.code, ''),
+		client.id,
+		client.firstname,
+		client.lastname,
+		client.email,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		payment
+	INNER JOIN
+		payment_method ON (payment_method.id = payment.method_id)
+	INNER JOIN
+		payment_status ON (payment_status.id = payment.status_id)
+	LEFT JOIN
+		orders ON (orders.id = payment.order_id)
+	LEFT JOIN
+		season_pass ON (season_pass.id = payment.season_pass_id)
+	INNER JOIN
+		user AS client ON (client.id = COALESCE(orders.client_id, season_pass.user_id))
+	INNER JOIN
+		user ON (user.id = payment.user_id)
+	WHERE
+		payment.active = true
+		#FILTERS#
+	ORDER BY
+		payment.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countPayments = `
+	SELECT
+		COUNT(payment.id)
+	FROM
+		payment
+	WHERE
+		payment.active = true
+		#FILTERS#
+	`
+
+	getPaymentOrderID = `
+	SELECT
+		COALESCE(payment.order_id, 0)
+	FROM
+		payment
+	WHERE
+		payment.preference_id = ?
+	`
+
 	updatePaymentStatus = `
 	UPDATE
 		payment
@@ -125,19 +210,37 @@ func (db *DB) InsertPayment(opts *InsertPaymentOpts) (int, error) {
 	return id, nil
 }
 
+// insertPaymentTx inserts a payment of the order. An approved payment returns
+// ErrEventSoldOut if the event no longer has the seats of the order.
 func (db *DB) insertPaymentTx(tx Tx, opts *InsertPaymentOpts) (int, error) {
+	if opts.StatusID == ConstPaymentStatuses.Approved.ID {
+		if err := checkOrderSeatsTx(tx, opts.OrderID); err != nil {
+			return 0, err
+		}
+	}
+
 	stmt, err := tx.PrepareNamed(insertPayment)
 	if err != nil {
 		return 0, err
 	}
 
+	// A payment is either of an order or of a season pass.
+	var orderID, seasonPassID interface{}
+	if opts.OrderID != 0 {
+		orderID = opts.OrderID
+	}
+	if opts.SeasonPassID != 0 {
+		seasonPassID = opts.SeasonPassID
+	}
+
 	args := map[string]interface{}{
-		"method_id":     opts.MethodID,
-		"amount":        opts.Amount,
-		"user_id":       opts.UserID,
-		"preference_id": opts.PreferenceID,
-		"order_id":      opts.OrderID,
-		"status_id":     opts.StatusID,
+		"method_id":      opts.MethodID,
+		"amount":         opts.Amount,
+		"user_id":        opts.UserID,
+		"preference_id":  opts.PreferenceID,
+		"order_id":       orderID,
+		"season_pass_id": seasonPassID,
+		"status_id":      opts.StatusID,
 	}
 
 	result, err := stmt.Exec(args)
@@ -159,6 +262,12 @@ func (db *DB) insertPaymentTx(tx Tx, opts *InsertPaymentOpts) (int, error) {
 		return 0, err
 	}
 
+	if opts.StatusID == ConstPaymentStatuses.Approved.ID {
+		if err := confirmOrderVoucherRedemptionsTx(tx, opts.OrderID); err != nil {
+			return 0, err
+		}
+	}
+
 	return int(id), nil
 }
 
@@ -212,7 +321,21 @@ func (db *DB) UpdatePaymentStatus(externalReference string, statusID int) error
 	return nil
 }
 
+// updatePaymentStatusTx updates the status of a payment by its reference.
+// Approving it returns ErrEventSoldOut if the event no longer has the seats
+// of the order.
 func (db *DB) updatePaymentStatusTx(tx Tx, externalReference string, statusID int) error {
+	if statusID == ConstPaymentStatuses.Approved.ID {
+		var orderID int
+		if err := tx.QueryRow(getPaymentOrderID, externalReference).Scan(&orderID); err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		if err := checkOrderSeatsTx(tx, orderID); err != nil {
+			return err
+		}
+	}
+
 	stmt, err := tx.PrepareNamed(updatePaymentStatus)
 	if err != nil {
 		return err
@@ -237,5 +360,135 @@ func (db *DB) updatePaymentStatusTx(tx Tx, externalReference string, statusID in
 		return errors.Errorf("expected %d and updated %d", 1, rowsAffected)
 	}
 
+	if statusID == ConstPaymentStatuses.Approved.ID {
+		if err := confirmPaymentVoucherRedemptionsTx(tx, externalReference); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
+
+func (db *DB) GetPayments(opts *models.GetPaymentsOpts) (*models.PaymentsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.DateFrom != "" {
+		filters += " AND DATE(CONVERT_TZ(payment.created, 'UTC', 'America/Santiago')) >= :date_from "
+		args["date_from"] = opts.DateFrom
+	}
+	if opts.DateTo != "" {
+		filters += " AND DATE(CONVERT_TZ(payment.created, 'UTC', 'America/Santiago')) <= :date_to "
+		args["date_to"] = opts.DateTo
+	}
+	if opts.MethodID != 0 {
+		filters += " AND payment.method_id = :method_id "
+		args["method_id"] = opts.MethodID
+	}
+	if opts.StatusID != 0 {
+		filters += " AND payment.status_id = :status_id "
+		args["status_id"] = opts.StatusID
+	}
+	if opts.OrderID != 0 {
+		filters += " AND payment.order_id = :order_id "
+		args["order_id"] = opts.OrderID
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	totalPayments, err := db.countPayments(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ReplaceAll(getPayments, "#FILTERS#", filters)
+
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	payments := models.PaymentsStruct{
+		Total: totalPayments,
+	}
+
+	for rows.Next() {
+		var payment models.Payment
+		var method models.PaymentMethod
+		var status models.PaymentStatus
+		var order models.Order
+		var pass models.SeasonPass
+		var client models.User
+		var user models.User
+		if err := rows.Scan(
+			&payment.ID,
+			&payment.Amount,
+			&payment.PreferenceID,
+			&payment.Created,
+			&payment.Updated,
+			&method.ID,
+			&method.Name,
+			&status.ID,
+			&status.Name,
+			&order.ID,
+			&order.TransactionID,
+			&order.Tickets,
+			&order.Price,
+			&pass.ID,
+			&pass.Code,
+			&client.ID,
+			&client.Firstname,
+			&client.Lastname,
+			&client.Email,
+			&user.ID,
+			&user.Firstname,
+			&user.Lastname,
+			&user.Email,
+		); err != nil {
+			return nil, err
+		}
+
+		payment.Method = &method
+		payment.Status = &status
+		payment.User = &user
+
+		if pass.ID != 0 {
+			pass.User = &client
+			payment.SeasonPass = &pass
+		} else {
+			order.Client = &client
+			payment.Order = &order
+		}
+
+		payments.Payments = append(payments.Payments, payment)
+	}
+
+	return &payments, nil
+}
+
+func (db *DB) countPayments(filters string, args map[string]interface{}) (int, error) {
+	query := strings.ReplaceAll(countPayments, "#FILTERS#", filters)
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
@@ -0,0 +1,560 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type PrivacyStorage interface {
+	GetUserDataExport(userID int) (*models.UserDataExport, error)
+	EraseUserData(userID int, actorID int, reason string) error
+}
+
+const (
+	getUserDataProfile = `
+	SELECT
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		user.created,
+		user.updated,
+		user.active,
+		user.deleted_at,
+		user.email_verified,
+		user_additional.id,
+		user_additional.phone,
+		user_additional.dni,
+		user_additional.document_type
+	FROM
+		user
+	INNER JOIN
+		user_additional ON (user_additional.user_id = user.id)
+	WHERE
+		user.id = :user_id
+	`
+
+	getUserDataOrders = `
+	SELECT
+		orders.id,
+		orders.transaction_id,
+		orders.tickets,
+		orders.price,
+		orders.paid,
+		orders.created,
+		orders.updated,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time
+	FROM
+		orders
+	INNER JOIN
+		event ON (event.id = orders.event_id)
+	WHERE
+		orders.client_id = :user_id
+	ORDER BY
+		orders.id
+	`
+
+	getUserDataPayments = `
+	SELECT
+		payment.id,
+		payment.amount,
+		COALESCE(payment.preference_id, ''),
+		payment.created,
+		payment.updated,
+		payment.order_id,
+		payment_method.id,
+		payment_method.name,
+		payment_status.id,
+		payment_status.name
+	FROM
+		payment
+	INNER JOIN
+		orders ON (orders.id = payment.order_id)
+	LEFT JOIN
+		payment_method ON (payment_method.id = payment.method_id)
+	LEFT JOIN
+		payment_status ON (payment_status.id = payment.status_id)
+	WHERE
+		orders.client_id = :user_id
+	ORDER BY
+		payment.id
+	`
+
+	getUserDataCampings = `
+	SELECT
+		camping.id,
+		camping.transaction_id,
+		camping.tickets,
+		camping.price,
+		camping.created,
+		camping.updated,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time
+	FROM
+		camping
+	INNER JOIN
+		event ON (event.id = camping.event_id)
+	WHERE
+		camping.client_id = :user_id
+	ORDER BY
+		camping.id
+	`
+
+	getUserDataScans = `
+	SELECT
+		order_use.created,
+		orders.id,
+		orders.transaction_id
+	FROM
+		order_use
+	INNER JOIN
+		orders ON (orders.id = order_use.order_id)
+	WHERE
+		orders.client_id = :user_id
+	ORDER BY
+		order_use.created
+	`
+
+	getUserDataLoginAttempts = `
+	SELECT
+		login_attempt.id,
+		login_attempt.email,
+		login_attempt.ip,
+		login_attempt.user_agent,
+		login_attempt.success,
+		login_attempt.reason,
+		login_attempt.created
+	FROM
+		login_attempt
+	WHERE
+		login_attempt.user_id = :user_id
+	ORDER BY
+		login_attempt.id
+	`
+)
+
+// GetUserDataExport gathers everything stored about a client, active or not,
+// to answer a data access request.
+func (db *DB) GetUserDataExport(userID int) (*models.UserDataExport, error) {
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	profile, err := db.getUserDataProfile(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if profile == nil {
+		return nil, nil
+	}
+
+	export := models.UserDataExport{
+		Generated: time.Now().UTC(),
+		Profile:   profile,
+	}
+
+	if export.Orders, err = db.getUserDataOrders(args); err != nil {
+		return nil, err
+	}
+
+	if export.Payments, err = db.getUserDataPayments(args); err != nil {
+		return nil, err
+	}
+
+	if export.Campings, err = db.getUserDataCampings(args); err != nil {
+		return nil, err
+	}
+
+	if export.Scans, err = db.getUserDataScans(args); err != nil {
+		return nil, err
+	}
+
+	if export.LoginAttempts, err = db.getUserDataLoginAttempts(args); err != nil {
+		return nil, err
+	}
+
+	return &export, nil
+}
+
+func (db *DB) getUserDataProfile(args map[string]interface{}) (*models.User, error) {
+	stmt, err := db.PrepareNamed(getUserDataProfile)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	var additional models.UserAdditional
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&user.ID,
+		&user.Firstname,
+		&user.Lastname,
+		&user.Email,
+		&user.Created,
+		&user.Updated,
+		&user.Active,
+		&user.DeletedAt,
+		&user.EmailVerified,
+		&additional.ID,
+		&additional.Phone,
+		&additional.DNI,
+		&additional.DocumentType,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	user.Additional = &additional
+
+	return &user, nil
+}
+
+func (db *DB) getUserDataOrders(args map[string]interface{}) ([]models.Order, error) {
+	stmt, err := db.PrepareNamed(getUserDataOrders)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	orders := []models.Order{}
+	for rows.Next() {
+		var order models.Order
+		var event models.Event
+		var paid bool
+		if err := rows.Scan(
+			&order.ID,
+			&order.TransactionID,
+			&order.Tickets,
+			&order.Price,
+			&paid,
+			&order.Created,
+			&order.Updated,
+			&event.ID,
+			&event.Name,
+			&event.StartDateTime,
+			&event.EndDateTime,
+		); err != nil {
+			return nil, err
+		}
+
+		order.Paid = &paid
+		order.Event = &event
+		orders = append(orders, order)
+	}
+
+	return orders, nil
+}
+
+func (db *DB) getUserDataPayments(args map[string]interface{}) ([]models.Payment, error) {
+	stmt, err := db.PrepareNamed(getUserDataPayments)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	payments := []models.Payment{}
+	for rows.Next() {
+		var payment models.Payment
+		var order models.Order
+		var method models.PaymentMethod
+		var status models.PaymentStatus
+		if err := rows.Scan(
+			&payment.ID,
+			&payment.Amount,
+			&payment.PreferenceID,
+			&payment.Created,
+			&payment.Updated,
+			&order.ID,
+			&method.ID,
+			&method.Name,
+			&status.ID,
+			&status.Name,
+		); err != nil {
+			return nil, err
+		}
+
+		payment.Order = &order
+		payment.Method = &method
+		payment.Status = &status
+		payments = append(payments, payment)
+	}
+
+	return payments, nil
+}
+
+func (db *DB) getUserDataCampings(args map[string]interface{}) ([]models.Camping, error) {
+	stmt, err := db.PrepareNamed(getUserDataCampings)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	campings := []models.Camping{}
+	for rows.Next() {
+		var camping models.Camping
+		var event models.Event
+		if err := rows.Scan(
+			&camping.ID,
+			&camping.TransactionID,
+			&camping.Tickets,
+			&camping.Price,
+			&camping.Created,
+			&camping.Updated,
+			&event.ID,
+			&event.Name,
+			&event.StartDateTime,
+			&event.EndDateTime,
+		); err != nil {
+			return nil, err
+		}
+
+		camping.Event = &event
+		campings = append(campings, camping)
+	}
+
+	return campings, nil
+}
+
+func (db *DB) getUserDataScans(args map[string]interface{}) ([]models.OrderScan, error) {
+	stmt, err := db.PrepareNamed(getUserDataScans)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	scans := []models.OrderScan{}
+	for rows.Next() {
+		var scan models.OrderScan
+		var order models.Order
+		if err := rows.Scan(
+			&scan.Created,
+			&order.ID,
+			&order.TransactionID,
+		); err != nil {
+			return nil, err
+		}
+
+		scan.Order = &order
+		scans = append(scans, scan)
+	}
+
+	return scans, nil
+}
+
+func (db *DB) getUserDataLoginAttempts(args map[string]interface{}) ([]models.LoginAttempt, error) {
+	stmt, err := db.PrepareNamed(getUserDataLoginAttempts)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attempts := []models.LoginAttempt{}
+	for rows.Next() {
+		var attempt models.LoginAttempt
+		if err := rows.Scan(
+			&attempt.ID,
+			&attempt.Email,
+			&attempt.IP,
+			&attempt.UserAgent,
+			&attempt.Success,
+			&attempt.Reason,
+			&attempt.Created,
+		); err != nil {
+			return nil, err
+		}
+
+		attempts = append(attempts, attempt)
+	}
+
+	return attempts, nil
+}
+
+const (
+	getUserEmailForUpdate = `
+	SELECT
+		user.email
+	FROM
+		user
+	WHERE
+		user.id = :user_id
+	FOR UPDATE
+	`
+
+	eraseUser = `
+	UPDATE
+		user
+	SET
+		firstname = :firstname,
+		lastname = '',
+		email = :email,
+		password = NULL,
+		remember_token = NULL,
+		remember_token_expires = NULL,
+		unlock_token = NULL,
+		locked_until = NULL,
+		email_verification_token = NULL,
+		email_verification_expires = NULL,
+		pending_email = NULL,
+		email_change_token = NULL,
+		email_change_expires = NULL,
+		two_factor_enabled = false,
+		two_factor_secret = NULL,
+		two_factor_challenge = NULL,
+		two_factor_challenge_expires = NULL,
+		active = false,
+		deleted_at = COALESCE(deleted_at, current_timestamp()),
+		sessions_revoked_at = current_timestamp(),
+		erased_at = current_timestamp()
+	WHERE
+		id = :user_id
+	`
+
+	eraseUserAdditional = `
+	UPDATE
+		user_additional
+	SET
+		dni = '',
+		phone = '',
+		updated = current_timestamp()
+	WHERE
+		user_id = :user_id
+	`
+
+	eraseUserLoginAttempts = `
+	UPDATE
+		login_attempt
+	SET
+		email = :email,
+		ip = '',
+		user_agent = ''
+	WHERE
+		user_id = :user_id OR
+		email = :previous_email
+	`
+
+	eraseUserSeasonPasses = `
+	UPDATE
+		season_pass
+	SET
+		dni = ''
+	WHERE
+		user_id = :user_id
+	`
+
+	eraseUserPasswordResetRequests = `
+	DELETE FROM
+		password_reset_request
+	WHERE
+		email = :previous_email
+	`
+
+	eraseUserEmailVerificationRequests = `
+	DELETE FROM
+		email_verification_request
+	WHERE
+		email = :previous_email
+	`
+)
+
+// EraseUserData anonymizes the personal data of a user. Orders, payments and
+// campings are kept untouched, still pointing to the anonymized user, so the
+// accounting records stay consistent.
+func (db *DB) EraseUserData(userID int, actorID int, reason string) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	args := map[string]interface{}{
+		"user_id":   userID,
+		"actor_id":  actorID,
+		"firstname": "Anonimizado",
+		"email":     fmt.Sprintf("anonimizado-%d@anonimizado.invalid", userID),
+		"status":    ConstUserStatuses.Erased,
+		"reason":    reason,
+	}
+
+	stmt, err := tx.PrepareNamed(getUserEmailForUpdate)
+	if err != nil {
+		return err
+	}
+
+	var previousEmail string
+	if err = stmt.QueryRow(args).Scan(&previousEmail); err != nil {
+		return err
+	}
+	args["previous_email"] = previousEmail
+
+	for _, query := range []string{
+		eraseUser,
+		eraseUserAdditional,
+		eraseUserLoginAttempts,
+		eraseUserSeasonPasses,
+		eraseUserPasswordResetRequests,
+		eraseUserEmailVerificationRequests,
+		deleteTwoFactorRecoveryCodes,
+		insertUserStatusChange,
+	} {
+		stmt, err = tx.PrepareNamed(query)
+		if err != nil {
+			return err
+		}
+
+		if _, err = stmt.Exec(args); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,1018 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type ResellerStorage interface {
+	InsertResellerAllotment(opts *models.InsertResellerAllotmentOpts) (int, error)
+	GetResellerAllotment(userID int, eventID int) (*models.ResellerAllotment, error)
+	GetResellerAllotments(opts *models.GetResellerAllotmentsOpts) (*models.GetResellerAllotmentsStruct, error)
+	InsertResellerOrder(userID int, clientID int, allotment *models.ResellerAllotment, tickets int) (*models.Order, error)
+	InsertResellerSettlement(userID int, dateFrom string, dateTo string) (*models.ResellerSettlement, error)
+	GetResellerSettlementByID(settlementID int) (*models.ResellerSettlement, error)
+	GetResellerSettlements(opts *models.GetResellerSettlementsOpts) (*models.GetResellerSettlementsStruct, error)
+	GetResellerSettlementSales(settlementID int) ([]models.ResellerSale, error)
+	UpdateResellerSettlementPaid(settlementID int) error
+}
+
+var (
+	ErrResellerAllotmentExceeded = errors.New("reseller allotment exceeded")
+	// ErrResellerAllotmentBelowSold is returned when an allotment is updated
+	// to fewer tickets than the reseller already sold.
+	ErrResellerAllotmentBelowSold = errors.New("reseller allotment below the tickets sold")
+)
+
+const (
+	insertResellerAllotment = `
+	INSERT
+		reseller_allotment
+	SET
+		user_id = :user_id,
+		event_id = :event_id,
+		tickets = :tickets,
+		price = :price,
+		commission = :commission
+	ON DUPLICATE KEY UPDATE
+		tickets = :tickets,
+		price = :price,
+		commission = :commission,
+		active = true,
+		updated = current_timestamp()
+	`
+
+	getResellerAllotment = `
+	SELECT
+		reseller_allotment.id,
+		reseller_allotment.tickets,
+		reseller_allotment.price,
+		reseller_allotment.commission,
+		reseller_allotment.created,
+		reseller_allotment.updated,
+		(
+			SELECT
+				COALESCE(SUM(orders.tickets), 0)
+			FROM
+				reseller_sale
+			INNER JOIN
+				orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+			WHERE
+				reseller_sale.allotment_id = reseller_allotment.id
+		),
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		event.price
+	FROM
+		reseller_allotment
+	INNER JOIN
+		user ON (user.id = reseller_allotment.user_id)
+	INNER JOIN
+		event ON (event.id = reseller_allotment.event_id AND event.active = true)
+	WHERE
+		reseller_allotment.active = true AND
+		reseller_allotment.user_id = :user_id AND
+		reseller_allotment.event_id = :event_id
+	`
+
+	getResellerAllotments = `
+	SELECT
+		reseller_allotment.id,
+		reseller_allotment.tickets,
+		reseller_allotment.price,
+		reseller_allotment.commission,
+		reseller_allotment.created,
+		reseller_allotment.updated,
+		(
+			SELECT
+				COALESCE(SUM(orders.tickets), 0)
+			FROM
+				reseller_sale
+			INNER JOIN
+				orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+			WHERE
+				reseller_sale.allotment_id = reseller_allotment.id
+		),
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		event.price
+	FROM
+		reseller_allotment
+	INNER JOIN
+		user ON (user.id = reseller_allotment.user_id)
+	INNER JOIN
+		event ON (event.id = reseller_allotment.event_id AND event.active = true)
+	WHERE
+		reseller_allotment.active = true
+		#FILTERS#
+	ORDER BY
+		event.start_date_time DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countResellerAllotments = `
+	SELECT
+		COUNT(reseller_allotment.id)
+	FROM
+		reseller_allotment
+	INNER JOIN
+		event ON (event.id = reseller_allotment.event_id AND event.active = true)
+	WHERE
+		reseller_allotment.active = true
+		#FILTERS#
+	`
+
+	// resellerAllotmentPaidTickets are the tickets of the allotment already
+	// counted as sold for the event, the rest of the allotment is held.
+	resellerAllotmentPaidTickets = `
+		SELECT
+			COALESCE(SUM(orders.tickets), 0)
+		FROM
+			reseller_sale
+		INNER JOIN
+			orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+		WHERE
+			reseller_sale.allotment_id = reseller_allotment.id AND
+			EXISTS(
+				SELECT
+					payment.id
+				FROM
+					payment
+				WHERE
+					payment.order_id = orders.id AND
+					payment.status_id = ? AND
+					payment.active = true
+			)
+	`
+
+	// getEventAllotmentHeldTickets counts the tickets the resellers can still
+	// sell, they're not available to the public.
+	getEventAllotmentHeldTickets = `
+	SELECT
+		COALESCE(SUM(GREATEST(reseller_allotment.tickets - (` + resellerAllotmentPaidTickets + `), 0)), 0)
+	FROM
+		reseller_allotment
+	WHERE
+		reseller_allotment.event_id = ? AND
+		reseller_allotment.id <> ? AND
+		reseller_allotment.active = true
+	`
+
+	getResellerAllotmentForUpdate = `
+	SELECT
+		reseller_allotment.id,
+		(
+			SELECT
+				COALESCE(SUM(orders.tickets), 0)
+			FROM
+				reseller_sale
+			INNER JOIN
+				orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+			WHERE
+				reseller_sale.allotment_id = reseller_allotment.id
+		),
+		(` + resellerAllotmentPaidTickets + `)
+	FROM
+		reseller_allotment
+	WHERE
+		reseller_allotment.user_id = ? AND
+		reseller_allotment.event_id = ?
+	FOR UPDATE
+	`
+
+	lockResellerAllotment = `
+	SELECT
+		reseller_allotment.tickets - (
+			SELECT
+				COALESCE(SUM(orders.tickets), 0)
+			FROM
+				reseller_sale
+			INNER JOIN
+				orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+			WHERE
+				reseller_sale.allotment_id = reseller_allotment.id
+		)
+	FROM
+		reseller_allotment
+	WHERE
+		reseller_allotment.id = :allotment_id AND
+		reseller_allotment.active = true
+	FOR UPDATE
+	`
+
+	insertResellerSale = `
+	INSERT
+		reseller_sale
+	SET
+		user_id = :user_id,
+		allotment_id = :allotment_id,
+		order_id = :order_id,
+		price = :price,
+		commission = :commission
+	`
+
+	// An order can be scanned more than once, so the uses are checked with
+	// EXISTS instead of joined.
+	getResellerSettlementTotals = `
+	SELECT
+		COALESCE(SUM(orders.tickets), 0),
+		COALESCE(SUM(IF(EXISTS(SELECT 1 FROM order_use WHERE order_use.order_id = orders.id), orders.tickets, 0)), 0),
+		COALESCE(SUM(reseller_sale.price), 0),
+		COALESCE(SUM(reseller_sale.commission), 0)
+	FROM
+		reseller_sale
+	INNER JOIN
+		orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+	WHERE
+		reseller_sale.user_id = :user_id AND
+		reseller_sale.settlement_id IS NULL AND
+		DATE(CONVERT_TZ(reseller_sale.created, 'UTC', 'America/Santiago')) BETWEEN :date_from AND :date_to AND
+		COALESCE((SELECT true FROM payment WHERE payment.order_id = orders.id AND payment.status_id = :status_id ORDER BY payment.id DESC LIMIT 1), false)
+	`
+
+	insertResellerSettlement = `
+	INSERT
+		reseller_settlement
+	SET
+		user_id = :user_id,
+		date_from = :date_from,
+		date_to = :date_to,
+		tickets_sold = :tickets_sold,
+		tickets_used = :tickets_used,
+		total_sales = :total_sales,
+		commission = :commission,
+		owed = :owed
+	`
+
+	updateResellerSalesSettlement = `
+	UPDATE
+		reseller_sale
+	INNER JOIN
+		orders ON (orders.id = reseller_sale.order_id AND orders.active = true)
+	SET
+		reseller_sale.settlement_id = :settlement_id
+	WHERE
+		reseller_sale.user_id = :user_id AND
+		reseller_sale.settlement_id IS NULL AND
+		DATE(CONVERT_TZ(reseller_sale.created, 'UTC', 'America/Santiago')) BETWEEN :date_from AND :date_to AND
+		COALESCE((SELECT true FROM payment WHERE payment.order_id = orders.id AND payment.status_id = :status_id ORDER BY payment.id DESC LIMIT 1), false)
+	`
+
+	getResellerSettlementByID = `
+	SELECT
+		reseller_settlement.id,
+		DATE_FORMAT(reseller_settlement.date_from, '%Y-%m-%d'),
+		DATE_FORMAT(reseller_settlement.date_to, '%Y-%m-%d'),
+		reseller_settlement.tickets_sold,
+		reseller_settlement.tickets_used,
+		reseller_settlement.total_sales,
+		reseller_settlement.commission,
+		reseller_settlement.owed,
+		reseller_settlement.paid,
+		reseller_settlement.paid_at,
+		reseller_settlement.created,
+		reseller_settlement.updated,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		reseller_settlement
+	INNER JOIN
+		user ON (user.id = reseller_settlement.user_id)
+	WHERE
+		reseller_settlement.id = :settlement_id
+	`
+
+	getResellerSettlements = `
+	SELECT
+		reseller_settlement.id,
+		DATE_FORMAT(reseller_settlement.date_from, '%Y-%m-%d'),
+		DATE_FORMAT(reseller_settlement.date_to, '%Y-%m-%d'),
+		reseller_settlement.tickets_sold,
+		reseller_settlement.tickets_used,
+		reseller_settlement.total_sales,
+		reseller_settlement.commission,
+		reseller_settlement.owed,
+		reseller_settlement.paid,
+		reseller_settlement.paid_at,
+		reseller_settlement.created,
+		reseller_settlement.updated,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		reseller_settlement
+	INNER JOIN
+		user ON (user.id = reseller_settlement.user_id)
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		reseller_settlement.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countResellerSettlements = `
+	SELECT
+		COUNT(reseller_settlement.id)
+	FROM
+		reseller_settlement
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	getResellerSettlementSales = `
+	SELECT
+		orders.id,
+		orders.transaction_id,
+		orders.tickets,
+		client.id,
+		client.firstname,
+		client.lastname,
+		client.email,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		reseller_sale.price,
+		reseller_sale.commission,
+		EXISTS(SELECT 1 FROM order_use WHERE order_use.order_id = orders.id),
+		reseller_sale.created
+	FROM
+		reseller_sale
+	INNER JOIN
+		orders ON (orders.id = reseller_sale.order_id)
+	INNER JOIN
+		user AS client ON (client.id = orders.client_id)
+	INNER JOIN
+		event ON (event.id = orders.event_id)
+	WHERE
+		reseller_sale.settlement_id = :settlement_id
+	ORDER BY
+		reseller_sale.created ASC
+	`
+
+	updateResellerSettlementPaid = `
+	UPDATE
+		reseller_settlement
+	SET
+		paid = true,
+		paid_at = current_timestamp(),
+		updated = current_timestamp()
+	WHERE
+		id = :settlement_id AND
+		paid = false
+	`
+)
+
+// InsertResellerAllotment creates or updates the allotment of the reseller
+// for the event. The tickets not sold yet are held from the public, so the
+// event is locked and the allotment can't take more than is available. It
+// returns ErrResellerAllotmentBelowSold when the reseller already sold more
+// tickets, and ErrEventSoldOut when the event can't hold them.
+func (db *DB) InsertResellerAllotment(opts *models.InsertResellerAllotmentOpts) (int, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	var capacity int
+	if err = tx.QueryRow(getEventCapacityForUpdate, opts.EventID).Scan(&capacity); err != nil {
+		return 0, err
+	}
+
+	var allotmentID, sold, paid int
+	err = tx.QueryRow(getResellerAllotmentForUpdate, ConstPaymentStatuses.Approved.ID, opts.UserID, opts.EventID).Scan(
+		&allotmentID,
+		&sold,
+		&paid,
+	)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, err
+	}
+	err = nil
+
+	if opts.Tickets < sold {
+		err = ErrResellerAllotmentBelowSold
+		return 0, err
+	}
+
+	if capacity > 0 {
+		var available int
+		available, err = eventAvailableTickets(tx, opts.EventID, capacity, 0, allotmentID, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		if opts.Tickets-paid > available {
+			err = ErrEventSoldOut
+			return 0, err
+		}
+	}
+
+	stmt, err := tx.PrepareNamed(insertResellerAllotment)
+	if err != nil {
+		return 0, err
+	}
+
+	args := map[string]interface{}{
+		"user_id":    opts.UserID,
+		"event_id":   opts.EventID,
+		"tickets":    opts.Tickets,
+		"price":      opts.Price,
+		"commission": opts.Commission,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (db *DB) GetResellerAllotment(userID int, eventID int) (*models.ResellerAllotment, error) {
+	stmt, err := db.PrepareNamed(getResellerAllotment)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"user_id":  userID,
+		"event_id": eventID,
+	}
+
+	var allotment models.ResellerAllotment
+	var user models.User
+	var event models.Event
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&allotment.ID,
+		&allotment.Tickets,
+		&allotment.Price,
+		&allotment.Commission,
+		&allotment.Created,
+		&allotment.Updated,
+		&allotment.Sold,
+		&user.ID,
+		&user.Firstname,
+		&user.Lastname,
+		&user.Email,
+		&event.ID,
+		&event.Name,
+		&event.StartDateTime,
+		&event.EndDateTime,
+		&event.Price,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	allotment.User = &user
+	allotment.Event = &event
+
+	return &allotment, nil
+}
+
+func (db *DB) GetResellerAllotments(opts *models.GetResellerAllotmentsOpts) (*models.GetResellerAllotmentsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.UserID != 0 {
+		filters += " AND reseller_allotment.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.EventID != 0 {
+		filters += " AND reseller_allotment.event_id = :event_id "
+		args["event_id"] = opts.EventID
+	}
+	if opts.EventFrom != "" {
+		filters += " AND event.start_date_time >= :event_from "
+		args["event_from"] = opts.EventFrom + " 00:00:00"
+	}
+	if opts.EventTo != "" {
+		filters += " AND event.start_date_time <= :event_to "
+		args["event_to"] = opts.EventTo + " 23:59:59"
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countResellerAllotments(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ReplaceAll(getResellerAllotments, "#FILTERS#", filters)
+
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	allotments := models.GetResellerAllotmentsStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		var allotment models.ResellerAllotment
+		var user models.User
+		var event models.Event
+		if err := rows.Scan(
+			&allotment.ID,
+			&allotment.Tickets,
+			&allotment.Price,
+			&allotment.Commission,
+			&allotment.Created,
+			&allotment.Updated,
+			&allotment.Sold,
+			&user.ID,
+			&user.Firstname,
+			&user.Lastname,
+			&user.Email,
+			&event.ID,
+			&event.Name,
+			&event.StartDateTime,
+			&event.EndDateTime,
+			&event.Price,
+		); err != nil {
+			return nil, err
+		}
+
+		allotment.User = &user
+		allotment.Event = &event
+
+		allotments.Allotments = append(allotments.Allotments, allotment)
+	}
+
+	return &allotments, nil
+}
+
+func (db *DB) countResellerAllotments(filters string, args map[string]interface{}) (int, error) {
+	query := strings.ReplaceAll(countResellerAllotments, "#FILTERS#", filters)
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (db *DB) InsertResellerOrder(userID int, clientID int, allotment *models.ResellerAllotment, tickets int) (*models.Order, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	available, err := db.lockResellerAllotmentTx(tx, allotment.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if available < tickets {
+		err = ErrResellerAllotmentExceeded
+		return nil, err
+	}
+
+	if err = checkEventCapacityTx(tx, allotment.Event.ID, tickets, 0, allotment.ID, 0); err != nil {
+		return nil, err
+	}
+
+	transactionID := GenerateTicketUUID()
+
+	price := allotment.Price * tickets
+	orderID, err := db.insertOrderTx(tx, userID, clientID, allotment.Event.ID, transactionID, tickets, price, 0, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.insertResellerSaleTx(tx, userID, allotment.ID, orderID, price, price*allotment.Commission/100)
+	if err != nil {
+		return nil, err
+	}
+
+	order := models.Order{
+		ID: orderID,
+		User: &models.User{
+			ID: userID,
+		},
+		Client: &models.User{
+			ID: clientID,
+		},
+		Tickets:       tickets,
+		Price:         price,
+		TransactionID: transactionID,
+	}
+
+	return &order, nil
+}
+
+func (db *DB) lockResellerAllotmentTx(tx Tx, allotmentID int) (int, error) {
+	stmt, err := tx.PrepareNamed(lockResellerAllotment)
+	if err != nil {
+		return 0, err
+	}
+
+	args := map[string]interface{}{
+		"allotment_id": allotmentID,
+	}
+
+	var available int
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&available,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return available, nil
+}
+
+func (db *DB) insertResellerSaleTx(tx Tx, userID int, allotmentID int, orderID int, price int, commission int) error {
+	stmt, err := tx.PrepareNamed(insertResellerSale)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id":      userID,
+		"allotment_id": allotmentID,
+		"order_id":     orderID,
+		"price":        price,
+		"commission":   commission,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if int(rowsAffected) != 1 {
+		return errors.Errorf("expected %d and inserted %d", 1, rowsAffected)
+	}
+
+	return nil
+}
+
+func (db *DB) InsertResellerSettlement(userID int, dateFrom string, dateTo string) (*models.ResellerSettlement, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	args := map[string]interface{}{
+		"user_id":   userID,
+		"date_from": dateFrom,
+		"date_to":   dateTo,
+		"status_id": ConstPaymentStatuses.Approved.ID,
+	}
+
+	settlement := models.ResellerSettlement{
+		User: &models.User{
+			ID: userID,
+		},
+		DateFrom: dateFrom,
+		DateTo:   dateTo,
+	}
+
+	stmt, err := tx.PrepareNamed(getResellerSettlementTotals)
+	if err != nil {
+		return nil, err
+	}
+
+	row := stmt.QueryRow(args)
+	if err = row.Scan(
+		&settlement.TicketsSold,
+		&settlement.TicketsUsed,
+		&settlement.TotalSales,
+		&settlement.Commission,
+	); err != nil {
+		return nil, err
+	}
+	settlement.Owed = settlement.TotalSales - settlement.Commission
+
+	stmt, err = tx.PrepareNamed(insertResellerSettlement)
+	if err != nil {
+		return nil, err
+	}
+
+	args["tickets_sold"] = settlement.TicketsSold
+	args["tickets_used"] = settlement.TicketsUsed
+	args["total_sales"] = settlement.TotalSales
+	args["commission"] = settlement.Commission
+	args["owed"] = settlement.Owed
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	settlement.ID = int(id)
+
+	stmt, err = tx.PrepareNamed(updateResellerSalesSettlement)
+	if err != nil {
+		return nil, err
+	}
+
+	args["settlement_id"] = settlement.ID
+	if _, err = stmt.Exec(args); err != nil {
+		return nil, err
+	}
+
+	return &settlement, nil
+}
+
+func (db *DB) GetResellerSettlementByID(settlementID int) (*models.ResellerSettlement, error) {
+	stmt, err := db.PrepareNamed(getResellerSettlementByID)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"settlement_id": settlementID,
+	}
+
+	var settlement models.ResellerSettlement
+	var user models.User
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&settlement.ID,
+		&settlement.DateFrom,
+		&settlement.DateTo,
+		&settlement.TicketsSold,
+		&settlement.TicketsUsed,
+		&settlement.TotalSales,
+		&settlement.Commission,
+		&settlement.Owed,
+		&settlement.Paid,
+		&settlement.PaidAt,
+		&settlement.Created,
+		&settlement.Updated,
+		&user.ID,
+		&user.Firstname,
+		&user.Lastname,
+		&user.Email,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	settlement.User = &user
+
+	return &settlement, nil
+}
+
+func (db *DB) GetResellerSettlements(opts *models.GetResellerSettlementsOpts) (*models.GetResellerSettlementsStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.UserID != 0 {
+		filters += " AND reseller_settlement.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.Paid != nil {
+		filters += " AND reseller_settlement.paid = :paid "
+		args["paid"] = opts.Paid
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countResellerSettlements(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	query := strings.ReplaceAll(getResellerSettlements, "#FILTERS#", filters)
+
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	settlements := models.GetResellerSettlementsStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		var settlement models.ResellerSettlement
+		var user models.User
+		if err := rows.Scan(
+			&settlement.ID,
+			&settlement.DateFrom,
+			&settlement.DateTo,
+			&settlement.TicketsSold,
+			&settlement.TicketsUsed,
+			&settlement.TotalSales,
+			&settlement.Commission,
+			&settlement.Owed,
+			&settlement.Paid,
+			&settlement.PaidAt,
+			&settlement.Created,
+			&settlement.Updated,
+			&user.ID,
+			&user.Firstname,
+			&user.Lastname,
+			&user.Email,
+		); err != nil {
+			return nil, err
+		}
+
+		settlement.User = &user
+
+		settlements.Settlements = append(settlements.Settlements, settlement)
+	}
+
+	return &settlements, nil
+}
+
+func (db *DB) countResellerSettlements(filters string, args map[string]interface{}) (int, error) {
+	query := strings.ReplaceAll(countResellerSettlements, "#FILTERS#", filters)
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (db *DB) GetResellerSettlementSales(settlementID int) ([]models.ResellerSale, error) {
+	stmt, err := db.PrepareNamed(getResellerSettlementSales)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"settlement_id": settlementID,
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var sales []models.ResellerSale
+	for rows.Next() {
+		var sale models.ResellerSale
+		var order models.Order
+		var client models.User
+		var event models.Event
+		if err := rows.Scan(
+			&order.ID,
+			&order.TransactionID,
+			&order.Tickets,
+			&client.ID,
+			&client.Firstname,
+			&client.Lastname,
+			&client.Email,
+			&event.ID,
+			&event.Name,
+			&event.StartDateTime,
+			&event.EndDateTime,
+			&sale.Price,
+			&sale.Commission,
+			&sale.Used,
+			&sale.Created,
+		); err != nil {
+			return nil, err
+		}
+
+		order.Client = &client
+		order.Event = &event
+		sale.Order = &order
+
+		sales = append(sales, sale)
+	}
+
+	return sales, nil
+}
+
+func (db *DB) UpdateResellerSettlementPaid(settlementID int) error {
+	stmt, err := db.PrepareNamed(updateResellerSettlementPaid)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"settlement_id": settlementID,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if int(rowsAffected) != 1 {
+		return errors.Errorf("expected %d and updated %d", 1, rowsAffected)
+	}
+
+	return nil
+}
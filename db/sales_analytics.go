@@ -0,0 +1,231 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type SalesAnalyticsStorage interface {
+	GetSalesAnalytics(opts *models.GetSalesAnalyticsOpts) ([]models.SalesAnalyticsRow, error)
+}
+
+var ConstSalesChannels = struct {
+	Web      string
+	Cashier  string
+	Reseller string
+	Group    string
+}{
+	Web:      "web",
+	Cashier:  "cashier",
+	Reseller: "reseller",
+	Group:    "group",
+}
+
+const (
+	// salesLocalCreated is the creation time of the sale in the park
+	// timezone, dates are grouped by it.
+	salesLocalCreated = "sale.created"
+
+	// getSalesAnalytics sums the orders whose last payment is approved, so
+	// refunded orders are left out, and the season passes with an approved
+	// payment. Passes have no tickets nor event and are grouped as their own
+	// event and event type.
+	getSalesAnalytics = `
+	SELECT
+		%[1]s AS sales_key,
+		%[2]s AS sales_label,
+		COUNT(sale.id),
+		COALESCE(SUM(sale.tickets), 0),
+		COALESCE(SUM(sale.tickets_used), 0),
+		COALESCE(SUM(sale.price), 0),
+		COALESCE(SUM(sale.net_amount), 0),
+		COALESCE(SUM(sale.tax_amount), 0)
+	FROM
+		(
+			SELECT
+				orders.id AS id,
+				CONVERT_TZ(orders.created, 'UTC', 'America/Santiago') AS created,
+				orders.tickets AS tickets,
+				IF(order_use.id IS NULL, 0, orders.tickets) AS tickets_used,
+				orders.price AS price,
+				orders.net_amount AS net_amount,
+				orders.tax_amount AS tax_amount,
+				payment.method_id AS method_id,
+				orders.user_id AS user_id,
+				event.id AS event_id,
+				event.name AS event_name,
+				event.start_date_time AS event_start_date_time,
+				event_type.id AS event_type_id,
+				event_type.name AS event_type_name
+			FROM
+				orders
+			INNER JOIN
+				payment ON (payment.id = (SELECT id FROM payment WHERE payment.order_id = orders.id AND payment.active = true ORDER BY payment.id DESC LIMIT 1))
+			INNER JOIN
+				event ON (event.id = orders.event_id)
+			INNER JOIN
+				event_type ON (event_type.id = event.event_type_id)
+			LEFT JOIN
+				order_use ON (order_use.id = (SELECT id FROM order_use WHERE order_use.order_id = orders.id LIMIT 1))
+			WHERE
+				orders.active = true AND
+				payment.status_id = :status_id AND
+				orders.created >= CONVERT_TZ(:date_from, 'America/Santiago', 'UTC') AND
+				orders.created < CONVERT_TZ(DATE_ADD(:date_to, INTERVAL 1 DAY), 'America/Santiago', 'UTC')
+				#FILTERS#
+			UNION ALL
+			SELECT
+				season_pass.id,
+				CONVERT_TZ(season_pass.created, 'UTC', 'America/Santiago'),
+				0,
+				0,
+				season_pass.price,
+				season_pass.net_amount,
+				season_pass.tax_amount,
+				payment.method_id,
+				IF(payment.method_id = :web_method_id, :web_user_id, payment.user_id),
+				0,
+				:pass_label,
+				NULL,
+				0,
+				:pass_label
+			FROM
+				season_pass
+			INNER JOIN
+				payment ON (payment.season_pass_id = season_pass.id)
+			WHERE
+				payment.active = true AND
+				payment.status_id = :status_id AND
+				season_pass.created >= CONVERT_TZ(:date_from, 'America/Santiago', 'UTC') AND
+				season_pass.created < CONVERT_TZ(DATE_ADD(:date_to, INTERVAL 1 DAY), 'America/Santiago', 'UTC')
+				#PASS_FILTERS#
+		) AS sale
+	INNER JOIN
+		payment_method ON (payment_method.id = sale.method_id)
+	INNER JOIN
+		user ON (user.id = sale.user_id)
+	GROUP BY
+		sales_key,
+		sales_label
+	ORDER BY
+		%[3]s
+	`
+)
+
+// salesPassLabel groups the season passes in the event and event type
+// groupings.
+const salesPassLabel = "Pases de temporada"
+
+// salesGroups has the key, label and order of each grouping of the sales
+// analytics.
+var salesGroups = map[string][3]string{
+	"day": {
+		"DATE(" + salesLocalCreated + ")",
+		"DATE_FORMAT(" + salesLocalCreated + ", '%d-%m-%Y')",
+		"sales_key ASC",
+	},
+	"week": {
+		"DATE(DATE_SUB(" + salesLocalCreated + ", INTERVAL WEEKDAY(" + salesLocalCreated + ") DAY))",
+		"DATE_FORMAT(DATE_SUB(" + salesLocalCreated + ", INTERVAL WEEKDAY(" + salesLocalCreated + ") DAY), '%d-%m-%Y')",
+		"sales_key ASC",
+	},
+	"month": {
+		"DATE_FORMAT(" + salesLocalCreated + ", '%Y-%m')",
+		"DATE_FORMAT(" + salesLocalCreated + ", '%m-%Y')",
+		"sales_key ASC",
+	},
+	"event": {
+		"sale.event_id",
+		"CONCAT_WS(' ', sale.event_name, DATE_FORMAT(sale.event_start_date_time, '%d-%m-%Y %H:%i'))",
+		"MIN(sale.event_start_date_time) ASC",
+	},
+	"event_type": {
+		"sale.event_type_id",
+		"sale.event_type_name",
+		"sales_label ASC",
+	},
+	"payment_method": {
+		"payment_method.id",
+		"payment_method.name",
+		"sales_key ASC",
+	},
+	"cashier": {
+		"user.id",
+		"CONCAT(user.firstname, ' ', user.lastname)",
+		"sales_label ASC",
+	},
+	"channel": {
+		salesChannel,
+		salesChannel,
+		"sales_key ASC",
+	},
+}
+
+var salesChannel = fmt.Sprintf(
+	"CASE WHEN sale.method_id = %d THEN '%s' WHEN sale.method_id = %d THEN '%s' WHEN sale.user_id = %d THEN '%s' ELSE '%s' END",
+	ConstPaymentMethods.Reseller.ID, ConstSalesChannels.Reseller,
+	ConstPaymentMethods.BankTransfer.ID, ConstSalesChannels.Group,
+	ConstWebUserID, ConstSalesChannels.Web,
+	ConstSalesChannels.Cashier,
+)
+
+func (db *DB) GetSalesAnalytics(opts *models.GetSalesAnalyticsOpts) ([]models.SalesAnalyticsRow, error) {
+	group, ok := salesGroups[opts.GroupBy]
+	if !ok {
+		return nil, errors.Errorf("unknown sales group %q", opts.GroupBy)
+	}
+
+	var filters, passFilters string
+	args := map[string]interface{}{
+		"status_id":     ConstPaymentStatuses.Approved.ID,
+		"date_from":     opts.DateFrom,
+		"date_to":       opts.DateTo,
+		"web_method_id": ConstPaymentMethods.MercadoPago.ID,
+		"web_user_id":   ConstWebUserID,
+		"pass_label":    salesPassLabel,
+	}
+	if opts.EventTypeID != 0 {
+		filters += " AND event.event_type_id = :event_type_id "
+		passFilters += " AND false "
+		args["event_type_id"] = opts.EventTypeID
+	}
+
+	query := fmt.Sprintf(getSalesAnalytics, group[0], group[1], group[2])
+	query = strings.ReplaceAll(query, "#FILTERS#", filters)
+	query = strings.ReplaceAll(query, "#PASS_FILTERS#", passFilters)
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	salesRows := []models.SalesAnalyticsRow{}
+	for rows.Next() {
+		var row models.SalesAnalyticsRow
+		if err := rows.Scan(
+			&row.Key,
+			&row.Label,
+			&row.Orders,
+			&row.TicketsSold,
+			&row.TicketsUsed,
+			&row.Revenue,
+			&row.Net,
+			&row.Tax,
+		); err != nil {
+			return nil, err
+		}
+
+		salesRows = append(salesRows, row)
+	}
+
+	return salesRows, nil
+}
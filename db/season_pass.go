@@ -0,0 +1,864 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type SeasonPassStorage interface {
+	InsertPassProduct(opts *models.InsertPassProductOpts) (int, error)
+	GetPassProductByID(productID int) (*models.PassProduct, error)
+	GetPassProducts(all bool) ([]models.PassProduct, error)
+	DeactivatePassProduct(productID int) error
+	InsertSeasonPass(pass *models.SeasonPass, payment *InsertPaymentOpts) (int, error)
+	GetSeasonPassByID(passID int) (*models.SeasonPass, error)
+	GetSeasonPassByCode(code string) (*models.SeasonPass, error)
+	GetSeasonPassByPreferenceID(preferenceID string) (*models.SeasonPass, error)
+	GetActiveSeasonPassesByDNI(dni string) ([]models.SeasonPass, error)
+	GetSeasonPasses(opts *models.GetSeasonPassesOpts) (*models.SeasonPassesStruct, error)
+	UpdateSeasonPassPayment(passID int, preferenceID string, statusID int) error
+	InsertPassVisit(pass *models.SeasonPass, eventID int, userID int, gate string, date time.Time) (*models.PassVisit, error)
+	GetPassVisits(passID int) ([]models.PassVisit, error)
+}
+
+var (
+	// ErrPassVisitLimit is returned when the pass already used all the visits
+	// allowed for the day or the week.
+	ErrPassVisitLimit = errors.New("pass visit limit reached")
+)
+
+var ConstSeasonPassStatuses = struct {
+	Pending   string
+	Active    string
+	Cancelled string
+}{
+	Pending:   "pending",
+	Active:    "active",
+	Cancelled: "cancelled",
+}
+
+const (
+	insertPassProduct = `
+	INSERT
+		pass_product
+	SET
+		name = :name,
+		price = :price,
+		validity_days = :validity_days,
+		max_visits_per_day = :max_visits_per_day,
+		max_visits_per_week = :max_visits_per_week,
+		tax_rate = :tax_rate
+	`
+
+	insertPassProductEventTypes = `
+	INSERT INTO
+		pass_product_event_type (pass_product_id, event_type_id)
+	VALUES
+		%s
+	`
+
+	insertPassProductBlackoutDates = `
+	INSERT INTO
+		pass_product_blackout_date (pass_product_id, date)
+	VALUES
+		%s
+	`
+
+	selectPassProduct = `
+	SELECT
+		pass_product.id,
+		pass_product.name,
+		pass_product.price,
+		pass_product.validity_days,
+		pass_product.max_visits_per_day,
+		pass_product.max_visits_per_week,
+		pass_product.tax_rate,
+		pass_product.active,
+		pass_product.created
+	FROM
+		pass_product
+	`
+
+	getPassProductByID = selectPassProduct + `
+	WHERE
+		pass_product.id = ?
+	`
+
+	getPassProducts = selectPassProduct + `
+	WHERE
+		(pass_product.active = true OR ?)
+	ORDER BY
+		pass_product.price ASC
+	`
+
+	getPassProductEventTypes = `
+	SELECT
+		event_type.id,
+		event_type.name
+	FROM
+		pass_product_event_type
+	INNER JOIN
+		event_type ON (event_type.id = pass_product_event_type.event_type_id)
+	WHERE
+		pass_product_event_type.pass_product_id = ?
+	`
+
+	getPassProductBlackoutDates = `
+	SELECT
+		pass_product_blackout_date.date
+	FROM
+		pass_product_blackout_date
+	WHERE
+		pass_product_blackout_date.pass_product_id = ?
+	ORDER BY
+		pass_product_blackout_date.date ASC
+	`
+
+	deactivatePassProduct = `
+	UPDATE
+		pass_product
+	SET
+		active = false
+	WHERE
+		id = ?
+	`
+
+	insertSeasonPass = `
+	INSERT
+		season_pass
+	SET
+		pass_product_id = :pass_product_id,
+		user_id = :user_id,
+		dni = :dni,
+		code = :code,
+		valid_from = :valid_from,
+		valid_to = :valid_to,
+		price = :price,
+		tax_rate = :tax_rate,
+		net_amount = :net_amount,
+		tax_amount = :tax_amount,
+		status = :status,
+		preference_id = :preference_id
+	`
+
+	selectSeasonPass = `
+	SELECT
+		season_pass.id,
+		season_pass.pass_product_id,
+		season_pass.dni,
+		season_pass.code,
+		season_pass.valid_from,
+		season_pass.valid_to,
+		season_pass.price,
+		season_pass.tax_rate,
+		season_pass.net_amount,
+		season_pass.tax_amount,
+		season_pass.status,
+		COALESCE(season_pass.preference_id, ''),
+		season_pass.created,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		season_pass
+	INNER JOIN
+		user ON (user.id = season_pass.user_id)
+	`
+
+	getSeasonPassByID = selectSeasonPass + `
+	WHERE
+		season_pass.id = :id
+	`
+
+	getSeasonPassByCode = selectSeasonPass + `
+	WHERE
+		season_pass.code = :code
+	`
+
+	getSeasonPassByPreferenceID = selectSeasonPass + `
+	WHERE
+		season_pass.preference_id = :preference_id
+	`
+
+	getActiveSeasonPassesByDNI = selectSeasonPass + `
+	WHERE
+		season_pass.dni = :dni AND
+		season_pass.status = :active AND
+		season_pass.valid_to >= DATE(CONVERT_TZ(current_timestamp(), 'UTC', 'America/Santiago'))
+	ORDER BY
+		season_pass.valid_to ASC
+	`
+
+	getSeasonPasses = selectSeasonPass + `
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		season_pass.id DESC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countSeasonPasses = `
+	SELECT
+		COUNT(season_pass.id)
+	FROM
+		season_pass
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	updateSeasonPassStatus = `
+	UPDATE
+		season_pass
+	SET
+		status = ?
+	WHERE
+		id = ? AND
+		status = ?
+	`
+
+	lockSeasonPass = `
+	SELECT
+		season_pass.id
+	FROM
+		season_pass
+	WHERE
+		season_pass.id = ?
+	FOR UPDATE
+	`
+
+	countPassVisits = `
+	SELECT
+		COUNT(pass_visit.id)
+	FROM
+		pass_visit
+	WHERE
+		pass_visit.season_pass_id = ? AND
+		pass_visit.visit_date BETWEEN ? AND ?
+	`
+
+	insertPassVisit = `
+	INSERT
+		pass_visit
+	SET
+		season_pass_id = :season_pass_id,
+		event_id = :event_id,
+		user_id = :user_id,
+		gate = :gate,
+		visit_date = :visit_date
+	`
+
+	getPassVisits = `
+	SELECT
+		pass_visit.id,
+		pass_visit.created,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		event_type.id,
+		event_type.name
+	FROM
+		pass_visit
+	INNER JOIN
+		event ON (event.id = pass_visit.event_id)
+	INNER JOIN
+		event_type ON (event_type.id = event.event_type_id)
+	WHERE
+		pass_visit.season_pass_id = ?
+	ORDER BY
+		pass_visit.id DESC
+	`
+)
+
+func (db *DB) InsertPassProduct(opts *models.InsertPassProductOpts) (int, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	taxRate := models.ConstDefaultTaxRate
+	if opts.TaxRate != nil {
+		taxRate = *opts.TaxRate
+	}
+
+	stmt, err := tx.PrepareNamed(insertPassProduct)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"name":                opts.Name,
+		"price":               opts.Price,
+		"validity_days":       opts.ValidityDays,
+		"max_visits_per_day":  opts.MaxVisitsPerDay,
+		"max_visits_per_week": opts.MaxVisitsPerWeek,
+		"tax_rate":            taxRate,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	productID := int(id)
+
+	var paramsArr []string
+	var argsArr []interface{}
+	for _, eventTypeID := range opts.EventTypeIDs {
+		paramsArr = append(paramsArr, "(?, ?)")
+		argsArr = append(argsArr, productID, eventTypeID)
+	}
+
+	_, err = tx.Exec(fmt.Sprintf(insertPassProductEventTypes, strings.Join(paramsArr, ",")), argsArr...)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(opts.BlackoutDates) > 0 {
+		paramsArr = nil
+		argsArr = nil
+		for _, date := range opts.BlackoutDates {
+			paramsArr = append(paramsArr, "(?, ?)")
+			argsArr = append(argsArr, productID, date)
+		}
+
+		_, err = tx.Exec(fmt.Sprintf(insertPassProductBlackoutDates, strings.Join(paramsArr, ",")), argsArr...)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return productID, nil
+}
+
+func (db *DB) GetPassProductByID(productID int) (*models.PassProduct, error) {
+	product, err := scanPassProduct(db.QueryRow(getPassProductByID, productID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.loadPassProductDetails(product); err != nil {
+		return nil, err
+	}
+
+	return product, nil
+}
+
+func (db *DB) GetPassProducts(all bool) ([]models.PassProduct, error) {
+	rows, err := db.Query(getPassProducts, all)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var products []models.PassProduct
+	for rows.Next() {
+		product, err := scanPassProduct(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		products = append(products, *product)
+	}
+
+	for i := range products {
+		if err := db.loadPassProductDetails(&products[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return products, nil
+}
+
+func scanPassProduct(row rowScanner) (*models.PassProduct, error) {
+	var product models.PassProduct
+	if err := row.Scan(
+		&product.ID,
+		&product.Name,
+		&product.Price,
+		&product.ValidityDays,
+		&product.MaxVisitsPerDay,
+		&product.MaxVisitsPerWeek,
+		&product.TaxRate,
+		&product.Active,
+		&product.Created,
+	); err != nil {
+		return nil, err
+	}
+
+	return &product, nil
+}
+
+func (db *DB) loadPassProductDetails(product *models.PassProduct) error {
+	rows, err := db.Query(getPassProductEventTypes, product.ID)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var eventType models.EventType
+		if err := rows.Scan(
+			&eventType.ID,
+			&eventType.Name,
+		); err != nil {
+			return err
+		}
+
+		product.EventTypes = append(product.EventTypes, eventType)
+	}
+
+	dateRows, err := db.Query(getPassProductBlackoutDates, product.ID)
+	if err != nil {
+		return err
+	}
+
+	defer dateRows.Close()
+
+	product.BlackoutDates = []string{}
+	for dateRows.Next() {
+		var date time.Time
+		if err := dateRows.Scan(&date); err != nil {
+			return err
+		}
+
+		product.BlackoutDates = append(product.BlackoutDates, date.Format(isoLayout))
+	}
+
+	return nil
+}
+
+func (db *DB) DeactivatePassProduct(productID int) error {
+	_, err := db.Exec(deactivatePassProduct, productID)
+	return err
+}
+
+// InsertSeasonPass inserts the pass with its payment, if it isn't free.
+func (db *DB) InsertSeasonPass(pass *models.SeasonPass, payment *InsertPaymentOpts) (int, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(insertSeasonPass)
+	if err != nil {
+		return 0, err
+	}
+
+	var preferenceID interface{}
+	if pass.PreferenceID != "" {
+		preferenceID = pass.PreferenceID
+	}
+
+	taxes := models.NewTaxAmounts(pass.Price, pass.Product.TaxRate)
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"pass_product_id": pass.Product.ID,
+		"user_id":         pass.User.ID,
+		"dni":             pass.DNI,
+		"code":            pass.Code,
+		"valid_from":      pass.ValidFrom.Format(isoLayout),
+		"valid_to":        pass.ValidTo.Format(isoLayout),
+		"price":           pass.Price,
+		"tax_rate":        taxes.Rate,
+		"net_amount":      taxes.Net,
+		"tax_amount":      taxes.Tax,
+		"status":          pass.Status,
+		"preference_id":   preferenceID,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	if payment != nil {
+		payment.SeasonPassID = int(id)
+		if _, err = db.insertPaymentTx(tx, payment); err != nil {
+			return 0, err
+		}
+	}
+
+	pass.ID = int(id)
+	pass.TaxRate, pass.NetAmount, pass.TaxAmount = taxes.Rate, taxes.Net, taxes.Tax
+
+	return pass.ID, nil
+}
+
+func (db *DB) getSeasonPass(query string, args map[string]interface{}) (*models.SeasonPass, error) {
+	stmt, err := db.PrepareNamed(query)
+	if err != nil {
+		return nil, err
+	}
+
+	pass, err := scanSeasonPass(stmt.QueryRow(args))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	pass.Product, err = db.GetPassProductByID(pass.Product.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pass, nil
+}
+
+func (db *DB) GetSeasonPassByID(passID int) (*models.SeasonPass, error) {
+	return db.getSeasonPass(getSeasonPassByID, map[string]interface{}{
+		"id": passID,
+	})
+}
+
+func (db *DB) GetSeasonPassByCode(code string) (*models.SeasonPass, error) {
+	return db.getSeasonPass(getSeasonPassByCode, map[string]interface{}{
+		"code": code,
+	})
+}
+
+func (db *DB) GetSeasonPassByPreferenceID(preferenceID string) (*models.SeasonPass, error) {
+	return db.getSeasonPass(getSeasonPassByPreferenceID, map[string]interface{}{
+		"preference_id": preferenceID,
+	})
+}
+
+func (db *DB) GetActiveSeasonPassesByDNI(dni string) ([]models.SeasonPass, error) {
+	stmt, err := db.PrepareNamed(getActiveSeasonPassesByDNI)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"dni":    dni,
+		"active": ConstSeasonPassStatuses.Active,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	passes, err := scanSeasonPasses(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range passes {
+		passes[i].Product, err = db.GetPassProductByID(passes[i].Product.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return passes, nil
+}
+
+func (db *DB) GetSeasonPasses(opts *models.GetSeasonPassesOpts) (*models.SeasonPassesStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.UserID != 0 {
+		filters += " AND season_pass.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.DNI != "" {
+		filters += " AND season_pass.dni = :dni "
+		args["dni"] = opts.DNI
+	}
+	if opts.Status != "" {
+		filters += " AND season_pass.status = :status "
+		args["status"] = opts.Status
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countSeasonPasses(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(getSeasonPasses, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	passes, err := scanSeasonPasses(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	products := make(map[int]*models.PassProduct)
+	for i := range passes {
+		productID := passes[i].Product.ID
+		if _, ok := products[productID]; !ok {
+			products[productID], err = db.GetPassProductByID(productID)
+			if err != nil {
+				return nil, err
+			}
+		}
+		passes[i].Product = products[productID]
+	}
+
+	return &models.SeasonPassesStruct{
+		Passes: passes,
+		Total:  total,
+	}, nil
+}
+
+func (db *DB) countSeasonPasses(filters string, args map[string]interface{}) (int, error) {
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(countSeasonPasses, "#FILTERS#", filters))
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func scanSeasonPasses(rows *sql.Rows) ([]models.SeasonPass, error) {
+	var passes []models.SeasonPass
+	for rows.Next() {
+		pass, err := scanSeasonPass(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		passes = append(passes, *pass)
+	}
+
+	return passes, nil
+}
+
+func scanSeasonPass(row rowScanner) (*models.SeasonPass, error) {
+	pass := models.SeasonPass{
+		Product: &models.PassProduct{},
+		User:    &models.User{},
+	}
+
+	if err := row.Scan(
+		&pass.ID,
+		&pass.Product.ID,
+		&pass.DNI,
+		&pass.Code,
+		&pass.ValidFrom,
+		&pass.ValidTo,
+		&pass.Price,
+		&pass.TaxRate,
+		&pass.NetAmount,
+		&pass.TaxAmount,
+		&pass.Status,
+		&pass.PreferenceID,
+		&pass.Created,
+		&pass.User.ID,
+		&pass.User.Firstname,
+		&pass.User.Lastname,
+		&pass.User.Email,
+	); err != nil {
+		return nil, err
+	}
+
+	return &pass, nil
+}
+
+// UpdateSeasonPassPayment updates the status of the payment of a pass. An
+// approved payment activates the pending pass and a reversed one cancels it.
+func (db *DB) UpdateSeasonPassPayment(passID int, preferenceID string, statusID int) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	if err = db.updatePaymentStatusTx(tx, preferenceID, statusID); err != nil {
+		return err
+	}
+
+	switch statusID {
+	case ConstPaymentStatuses.Approved.ID:
+		_, err = tx.Exec(updateSeasonPassStatus, ConstSeasonPassStatuses.Active, passID, ConstSeasonPassStatuses.Pending)
+	case ConstPaymentStatuses.Reversed.ID:
+		_, err = tx.Exec(updateSeasonPassStatus, ConstSeasonPassStatuses.Cancelled, passID, ConstSeasonPassStatuses.Active)
+	}
+
+	return err
+}
+
+// InsertPassVisit records a visit of the pass on the given park date. The
+// pass row is locked while the visit limits of the day and of the week,
+// monday to sunday, are checked.
+func (db *DB) InsertPassVisit(pass *models.SeasonPass, eventID int, userID int, gate string, date time.Time) (*models.PassVisit, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	var id int
+	err = tx.QueryRow(lockSeasonPass, pass.ID).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+
+	if pass.Product.MaxVisitsPerDay > 0 {
+		var visits int
+		err = tx.QueryRow(countPassVisits, pass.ID, date.Format(isoLayout), date.Format(isoLayout)).Scan(&visits)
+		if err != nil {
+			return nil, err
+		}
+
+		if visits >= pass.Product.MaxVisitsPerDay {
+			err = ErrPassVisitLimit
+			return nil, err
+		}
+	}
+
+	if pass.Product.MaxVisitsPerWeek > 0 {
+		weekday := (int(date.Weekday()) + 6) % 7
+		monday := date.AddDate(0, 0, -weekday)
+		sunday := monday.AddDate(0, 0, 6)
+
+		var visits int
+		err = tx.QueryRow(countPassVisits, pass.ID, monday.Format(isoLayout), sunday.Format(isoLayout)).Scan(&visits)
+		if err != nil {
+			return nil, err
+		}
+
+		if visits >= pass.Product.MaxVisitsPerWeek {
+			err = ErrPassVisitLimit
+			return nil, err
+		}
+	}
+
+	stmt, err := tx.PrepareNamed(insertPassVisit)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := stmt.Exec(map[string]interface{}{
+		"season_pass_id": pass.ID,
+		"event_id":       eventID,
+		"user_id":        userID,
+		"gate":           gate,
+		"visit_date":     date.Format(isoLayout),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	visitID, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.PassVisit{
+		ID:      int(visitID),
+		Event:   &models.Event{ID: eventID},
+		User:    &models.User{ID: userID},
+		Gate:    gate,
+		Created: time.Now(),
+	}, nil
+}
+
+func (db *DB) GetPassVisits(passID int) ([]models.PassVisit, error) {
+	rows, err := db.Query(getPassVisits, passID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var visits []models.PassVisit
+	for rows.Next() {
+		visit := models.PassVisit{
+			Event: &models.Event{
+				Type: &models.EventType{},
+			},
+		}
+		if err := rows.Scan(
+			&visit.ID,
+			&visit.Created,
+			&visit.Event.ID,
+			&visit.Event.Name,
+			&visit.Event.StartDateTime,
+			&visit.Event.EndDateTime,
+			&visit.Event.Type.ID,
+			&visit.Event.Type.Name,
+		); err != nil {
+			return nil, err
+		}
+
+		visits = append(visits, visit)
+	}
+
+	return visits, nil
+}
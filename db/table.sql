@@ -91,4 +91,642 @@ CREATE TABLE `ticket` (
   KEY `fk_order_id` (`order_id`),
   CONSTRAINT `ticket_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
   CONSTRAINT `ticket_order_id` FOREIGN KEY (`order_id`) REFERENCES `order` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
-) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
\ No newline at end of file
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `reseller_allotment` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `event_id` int(11) NOT NULL,
+  `tickets` int(11) NOT NULL,
+  `price` int(11) NOT NULL,
+  `commission` int(11) NOT NULL DEFAULT 0,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  `active` tinyint(1) DEFAULT 1,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `user_event` (`user_id`, `event_id`),
+  KEY `fk_event_id` (`event_id`),
+  CONSTRAINT `reseller_allotment_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `reseller_allotment_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `reseller_settlement` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `date_from` date NOT NULL,
+  `date_to` date NOT NULL,
+  `tickets_sold` int(11) NOT NULL DEFAULT 0,
+  `tickets_used` int(11) NOT NULL DEFAULT 0,
+  `total_sales` int(11) NOT NULL DEFAULT 0,
+  `commission` int(11) NOT NULL DEFAULT 0,
+  `owed` int(11) NOT NULL DEFAULT 0,
+  `paid` tinyint(1) DEFAULT 0,
+  `paid_at` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `reseller_settlement_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `reseller_sale` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `allotment_id` int(11) NOT NULL,
+  `order_id` int(11) NOT NULL,
+  `settlement_id` int(11) DEFAULT NULL,
+  `price` int(11) NOT NULL,
+  `commission` int(11) NOT NULL DEFAULT 0,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `fk_user_id` (`user_id`),
+  KEY `fk_allotment_id` (`allotment_id`),
+  KEY `fk_order_id` (`order_id`),
+  KEY `fk_settlement_id` (`settlement_id`),
+  CONSTRAINT `reseller_sale_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `reseller_sale_allotment_id` FOREIGN KEY (`allotment_id`) REFERENCES `reseller_allotment` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `reseller_sale_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `reseller_sale_settlement_id` FOREIGN KEY (`settlement_id`) REFERENCES `reseller_settlement` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+INSERT INTO `payment_method` (`id`, `name`) VALUES (3, 'Revendedor');
+
+ALTER TABLE `user`
+  ADD COLUMN `locked_until` timestamp NULL DEFAULT NULL AFTER `remember_token`,
+  ADD COLUMN `unlock_token` varchar(255) DEFAULT NULL AFTER `locked_until`,
+  ADD KEY `unlock_token` (`unlock_token`);
+
+CREATE TABLE `login_attempt` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `email` varchar(255) NOT NULL,
+  `ip` varchar(64) NOT NULL,
+  `user_agent` varchar(512) DEFAULT NULL,
+  `user_id` int(11) DEFAULT NULL,
+  `success` tinyint(1) DEFAULT 0,
+  `reason` varchar(32) NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `email_created` (`email`, `created`),
+  KEY `ip_created` (`ip`, `created`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `login_attempt_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+ALTER TABLE `user`
+  ADD COLUMN `remember_token_expires` timestamp NULL DEFAULT NULL AFTER `remember_token`;
+
+UPDATE `user` SET `remember_token` = NULL;
+
+CREATE TABLE `password_reset_request` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `email` varchar(255) NOT NULL,
+  `ip` varchar(64) NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `email_created` (`email`, `created`),
+  KEY `ip_created` (`ip`, `created`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+ALTER TABLE `user`
+  ADD COLUMN `email_verified` tinyint(1) DEFAULT 1 AFTER `email`,
+  ADD COLUMN `email_verified_at` timestamp NULL DEFAULT NULL AFTER `email_verified`,
+  ADD COLUMN `email_verification_token` varchar(255) DEFAULT NULL AFTER `email_verified_at`,
+  ADD KEY `email_verification_token` (`email_verification_token`);
+
+ALTER TABLE `user`
+  ADD COLUMN `two_factor_enabled` tinyint(1) DEFAULT 0 AFTER `unlock_token`,
+  ADD COLUMN `two_factor_secret` varchar(64) DEFAULT NULL AFTER `two_factor_enabled`,
+  ADD COLUMN `two_factor_challenge` varchar(255) DEFAULT NULL AFTER `two_factor_secret`,
+  ADD COLUMN `two_factor_challenge_expires` timestamp NULL DEFAULT NULL AFTER `two_factor_challenge`,
+  ADD KEY `two_factor_challenge` (`two_factor_challenge`);
+
+CREATE TABLE `two_factor_recovery_code` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `code` varchar(255) NOT NULL,
+  `used_at` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `user_id` (`user_id`),
+  CONSTRAINT `two_factor_recovery_code_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE `user_additional`
+  ADD COLUMN `document_type` varchar(20) NOT NULL DEFAULT 'rut' AFTER `dni`;
+
+ALTER TABLE `user`
+  ADD COLUMN `deleted_at` timestamp NULL DEFAULT NULL AFTER `active`,
+  ADD COLUMN `sessions_revoked_at` timestamp NULL DEFAULT NULL AFTER `deleted_at`;
+
+CREATE TABLE `user_status_change` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `actor_id` int(11) NOT NULL,
+  `status` varchar(20) NOT NULL,
+  `reason` varchar(255) NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `user_id` (`user_id`),
+  KEY `actor_id` (`actor_id`),
+  CONSTRAINT `user_status_change_ibfk_1` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`),
+  CONSTRAINT `user_status_change_ibfk_2` FOREIGN KEY (`actor_id`) REFERENCES `user` (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE `user`
+  ADD COLUMN `pending_email` varchar(255) DEFAULT NULL AFTER `email_verification_token`,
+  ADD COLUMN `email_change_token` varchar(255) DEFAULT NULL AFTER `pending_email`,
+  ADD COLUMN `email_change_expires` timestamp NULL DEFAULT NULL AFTER `email_change_token`,
+  ADD KEY `email_change_token` (`email_change_token`);
+
+ALTER TABLE `user`
+  ADD COLUMN `erased_at` timestamp NULL DEFAULT NULL AFTER `deleted_at`;
+
+CREATE TABLE `audit_log` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `actor_id` int(11) NOT NULL,
+  `action` varchar(128) NOT NULL,
+  `entity` varchar(64) NOT NULL,
+  `entity_id` int(11) DEFAULT NULL,
+  `before_data` longtext DEFAULT NULL,
+  `after_data` longtext DEFAULT NULL,
+  `diff` longtext DEFAULT NULL,
+  `method` varchar(8) NOT NULL,
+  `path` varchar(255) NOT NULL,
+  `status_code` int(11) NOT NULL,
+  `ip` varchar(64) NOT NULL,
+  `user_agent` varchar(512) DEFAULT NULL,
+  `request_id` varchar(64) DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `actor_created` (`actor_id`, `created`),
+  KEY `entity_entity_id` (`entity`, `entity_id`),
+  KEY `action_created` (`action`, `created`),
+  KEY `request_id` (`request_id`),
+  CONSTRAINT `audit_log_actor_id` FOREIGN KEY (`actor_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TRIGGER `audit_log_no_update` BEFORE UPDATE ON `audit_log`
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TRIGGER `audit_log_no_delete` BEFORE DELETE ON `audit_log`
+  FOR EACH ROW SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TABLE `order_transfer` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `order_id` int(11) NOT NULL,
+  `from_client_id` int(11) NOT NULL,
+  `to_client_id` int(11) DEFAULT NULL,
+  `email` varchar(255) NOT NULL,
+  `token` varchar(255) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `expires` timestamp NULL DEFAULT NULL,
+  `accepted_at` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token` (`token`),
+  KEY `order_status` (`order_id`, `status`),
+  KEY `fk_from_client_id` (`from_client_id`),
+  KEY `fk_to_client_id` (`to_client_id`),
+  CONSTRAINT `order_transfer_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_transfer_from_client_id` FOREIGN KEY (`from_client_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_transfer_to_client_id` FOREIGN KEY (`to_client_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `order_history` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `order_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `action` varchar(32) NOT NULL,
+  `detail` varchar(512) NOT NULL DEFAULT '',
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `fk_order_id` (`order_id`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `order_history_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_history_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+ALTER TABLE `event`
+  ADD COLUMN `capacity` int(11) DEFAULT NULL AFTER `price`;
+
+CREATE TABLE `order_reschedule` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `order_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `from_event_id` int(11) NOT NULL,
+  `to_event_id` int(11) NOT NULL,
+  `price_difference` int(11) NOT NULL DEFAULT 0,
+  `fee` int(11) NOT NULL DEFAULT 0,
+  `amount` int(11) NOT NULL DEFAULT 0,
+  `status` varchar(16) NOT NULL,
+  `preference_id` varchar(255) DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `preference_id` (`preference_id`),
+  KEY `order_status` (`order_id`, `status`),
+  KEY `fk_user_id` (`user_id`),
+  KEY `fk_from_event_id` (`from_event_id`),
+  KEY `fk_to_event_id` (`to_event_id`),
+  CONSTRAINT `order_reschedule_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_reschedule_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_reschedule_from_event_id` FOREIGN KEY (`from_event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_reschedule_to_event_id` FOREIGN KEY (`to_event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `waitlist_entry` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `event_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `tickets` int(11) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `token` char(64) DEFAULT NULL,
+  `offer_expires` timestamp NULL DEFAULT NULL,
+  `order_id` int(11) DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `token` (`token`),
+  KEY `event_status` (`event_id`, `status`),
+  KEY `fk_user_id` (`user_id`),
+  KEY `fk_order_id` (`order_id`),
+  CONSTRAINT `waitlist_entry_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `waitlist_entry_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `waitlist_entry_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `pass_product` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `name` varchar(128) NOT NULL,
+  `price` int(11) NOT NULL,
+  `validity_days` int(11) NOT NULL,
+  `max_visits_per_day` int(11) NOT NULL DEFAULT 0,
+  `max_visits_per_week` int(11) NOT NULL DEFAULT 0,
+  `active` tinyint(1) NOT NULL DEFAULT 1,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `pass_product_event_type` (
+  `pass_product_id` int(11) NOT NULL,
+  `event_type_id` int(11) NOT NULL,
+  PRIMARY KEY (`pass_product_id`, `event_type_id`),
+  KEY `fk_event_type_id` (`event_type_id`),
+  CONSTRAINT `pass_product_event_type_pass_product_id` FOREIGN KEY (`pass_product_id`) REFERENCES `pass_product` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `pass_product_event_type_event_type_id` FOREIGN KEY (`event_type_id`) REFERENCES `event_type` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `pass_product_blackout_date` (
+  `pass_product_id` int(11) NOT NULL,
+  `date` date NOT NULL,
+  PRIMARY KEY (`pass_product_id`, `date`),
+  CONSTRAINT `pass_product_blackout_date_pass_product_id` FOREIGN KEY (`pass_product_id`) REFERENCES `pass_product` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+CREATE TABLE `season_pass` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `pass_product_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `dni` varchar(16) NOT NULL,
+  `code` varchar(64) NOT NULL,
+  `valid_from` date NOT NULL,
+  `valid_to` date NOT NULL,
+  `price` int(11) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `preference_id` varchar(255) DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `code` (`code`),
+  UNIQUE KEY `preference_id` (`preference_id`),
+  KEY `dni_status` (`dni`, `status`),
+  KEY `fk_pass_product_id` (`pass_product_id`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `season_pass_pass_product_id` FOREIGN KEY (`pass_product_id`) REFERENCES `pass_product` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `season_pass_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `pass_visit` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `season_pass_id` int(11) NOT NULL,
+  `event_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `visit_date` date NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `season_pass_date` (`season_pass_id`, `visit_date`),
+  KEY `fk_event_id` (`event_id`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `pass_visit_season_pass_id` FOREIGN KEY (`season_pass_id`) REFERENCES `season_pass` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `pass_visit_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `pass_visit_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `gift_voucher` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `code` varchar(32) NOT NULL,
+  `type` varchar(16) NOT NULL,
+  `amount` int(11) NOT NULL DEFAULT 0,
+  `balance` int(11) NOT NULL DEFAULT 0,
+  `tickets` int(11) NOT NULL DEFAULT 0,
+  `tickets_balance` int(11) NOT NULL DEFAULT 0,
+  `event_type_id` int(11) DEFAULT NULL,
+  `price` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `recipient_name` varchar(128) NOT NULL,
+  `recipient_email` varchar(255) NOT NULL,
+  `message` varchar(512) NOT NULL DEFAULT '',
+  `status` varchar(16) NOT NULL,
+  `preference_id` varchar(255) DEFAULT NULL,
+  `expires` date NOT NULL,
+  `activated_at` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `code` (`code`),
+  UNIQUE KEY `preference_id` (`preference_id`),
+  KEY `status_expires` (`status`, `expires`),
+  KEY `fk_event_type_id` (`event_type_id`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `gift_voucher_event_type_id` FOREIGN KEY (`event_type_id`) REFERENCES `event_type` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `gift_voucher_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `gift_voucher_redemption` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `gift_voucher_id` int(11) NOT NULL,
+  `order_id` int(11) NOT NULL,
+  `amount` int(11) NOT NULL,
+  `tickets` int(11) NOT NULL DEFAULT 0,
+  `value` int(11) NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `fk_gift_voucher_id` (`gift_voucher_id`),
+  KEY `fk_order_id` (`order_id`),
+  CONSTRAINT `gift_voucher_redemption_gift_voucher_id` FOREIGN KEY (`gift_voucher_id`) REFERENCES `gift_voucher` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `gift_voucher_redemption_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+INSERT INTO `payment_method` (`id`, `name`) VALUES (4, 'Vale de regalo');
+
+CREATE TABLE `group_booking` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `event_id` int(11) NOT NULL,
+  `tickets` int(11) NOT NULL,
+  `organization` varchar(255) NOT NULL,
+  `dni` varchar(16) NOT NULL,
+  `contact_name` varchar(255) NOT NULL,
+  `contact_email` varchar(255) NOT NULL,
+  `contact_phone` varchar(32) NOT NULL,
+  `notes` varchar(1024) NOT NULL DEFAULT '',
+  `status` varchar(16) NOT NULL,
+  `price` int(11) NOT NULL DEFAULT 0,
+  `due_date` date DEFAULT NULL,
+  `order_id` int(11) DEFAULT NULL,
+  `preference_id` varchar(255) DEFAULT NULL,
+  `proof_url` varchar(512) DEFAULT NULL,
+  `reason` varchar(512) NOT NULL DEFAULT '',
+  `approved_by` int(11) DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `preference_id` (`preference_id`),
+  KEY `status_due_date` (`status`, `due_date`),
+  KEY `fk_user_id` (`user_id`),
+  KEY `fk_event_id` (`event_id`),
+  KEY `fk_order_id` (`order_id`),
+  CONSTRAINT `group_booking_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `group_booking_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `group_booking_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `group_booking_participant` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `group_booking_id` int(11) NOT NULL,
+  `firstname` varchar(128) NOT NULL,
+  `lastname` varchar(128) NOT NULL,
+  `dni` varchar(16) NOT NULL DEFAULT '',
+  `email` varchar(255) NOT NULL DEFAULT '',
+  PRIMARY KEY (`id`),
+  KEY `fk_group_booking_id` (`group_booking_id`),
+  CONSTRAINT `group_booking_participant_group_booking_id` FOREIGN KEY (`group_booking_id`) REFERENCES `group_booking` (`id`) ON DELETE CASCADE ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+INSERT INTO `payment_method` (`id`, `name`) VALUES (5, 'Transferencia bancaria');
+
+ALTER TABLE `payment`
+  ADD COLUMN `tax_status` varchar(16) DEFAULT NULL,
+  ADD COLUMN `tax_document_type` int(11) DEFAULT NULL,
+  ADD COLUMN `tax_folio` int(11) DEFAULT NULL,
+  ADD COLUMN `tax_pdf_url` varchar(512) DEFAULT NULL,
+  ADD COLUMN `tax_issued` timestamp NULL DEFAULT NULL,
+  ADD COLUMN `credit_note_status` varchar(16) DEFAULT NULL,
+  ADD COLUMN `credit_note_folio` int(11) DEFAULT NULL,
+  ADD COLUMN `credit_note_pdf_url` varchar(512) DEFAULT NULL,
+  ADD COLUMN `tax_error` varchar(1024) DEFAULT NULL,
+  ADD KEY `status_tax_status` (`status_id`, `tax_status`);
+
+CREATE TABLE `order_invoice` (
+  `order_id` int(11) NOT NULL,
+  `dni` varchar(16) NOT NULL,
+  `business_name` varchar(255) NOT NULL,
+  `activity` varchar(255) NOT NULL,
+  `address` varchar(255) NOT NULL,
+  `commune` varchar(128) NOT NULL,
+  `email` varchar(255) NOT NULL DEFAULT '',
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`order_id`),
+  CONSTRAINT `order_invoice_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+ALTER TABLE `event_type`
+  ADD COLUMN `tax_rate` int(11) NOT NULL DEFAULT 1900;
+
+ALTER TABLE `orders`
+  ADD COLUMN `tax_rate` int(11) NOT NULL DEFAULT 1900,
+  ADD COLUMN `net_amount` int(11) NOT NULL DEFAULT 0,
+  ADD COLUMN `tax_amount` int(11) NOT NULL DEFAULT 0;
+
+UPDATE `orders` SET `net_amount` = ROUND(`price` * 10000 / (10000 + `tax_rate`)), `tax_amount` = `price` - `net_amount`;
+
+ALTER TABLE `pass_product`
+  ADD COLUMN `tax_rate` int(11) NOT NULL DEFAULT 1900 AFTER `max_visits_per_week`;
+
+ALTER TABLE `season_pass`
+  ADD COLUMN `tax_rate` int(11) NOT NULL DEFAULT 1900 AFTER `price`,
+  ADD COLUMN `net_amount` int(11) NOT NULL DEFAULT 0 AFTER `tax_rate`,
+  ADD COLUMN `tax_amount` int(11) NOT NULL DEFAULT 0 AFTER `net_amount`;
+
+UPDATE `season_pass` SET `net_amount` = ROUND(`price` * 10000 / (10000 + `tax_rate`)), `tax_amount` = `price` - `net_amount`;
+
+ALTER TABLE `order_use`
+  ADD COLUMN `gate` varchar(32) DEFAULT NULL;
+
+ALTER TABLE `pass_visit`
+  ADD COLUMN `gate` varchar(32) DEFAULT NULL AFTER `user_id`;
+
+CREATE TABLE `cashier_shift` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `user_id` int(11) NOT NULL,
+  `opened` timestamp NOT NULL DEFAULT current_timestamp(),
+  `closed` timestamp NULL DEFAULT NULL,
+  `closed_by` int(11) DEFAULT NULL,
+  `sales_total` int(11) NOT NULL DEFAULT 0,
+  `tickets_sold` int(11) NOT NULL DEFAULT 0,
+  `admissions` int(11) NOT NULL DEFAULT 0,
+  `pdf_url` varchar(512) DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `user_closed` (`user_id`, `closed`),
+  KEY `fk_closed_by` (`closed_by`),
+  CONSTRAINT `cashier_shift_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `cashier_shift_closed_by` FOREIGN KEY (`closed_by`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `order_reminder` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `order_id` int(11) NOT NULL,
+  `event_id` int(11) NOT NULL,
+  `status` varchar(16) NOT NULL,
+  `attempts` int(11) NOT NULL DEFAULT 0,
+  `last_error` varchar(512) DEFAULT NULL,
+  `sent` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `order_event` (`order_id`, `event_id`),
+  KEY `fk_event_id` (`event_id`),
+  CONSTRAINT `order_reminder_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `order_reminder_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `event_notice` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `event_id` int(11) NOT NULL,
+  `user_id` int(11) NOT NULL,
+  `kind` varchar(16) NOT NULL,
+  `message` text NOT NULL,
+  `expires` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `fk_event_id` (`event_id`),
+  KEY `fk_user_id` (`user_id`),
+  CONSTRAINT `event_notice_event_id` FOREIGN KEY (`event_id`) REFERENCES `event` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `event_notice_user_id` FOREIGN KEY (`user_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+CREATE TABLE `event_notice_recipient` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `event_notice_id` int(11) NOT NULL,
+  `order_id` int(11) NOT NULL,
+  `client_id` int(11) NOT NULL,
+  `email` varchar(255) NOT NULL,
+  `token` varchar(64) DEFAULT NULL,
+  `status` varchar(16) NOT NULL,
+  `attempts` int(11) NOT NULL DEFAULT 0,
+  `last_error` varchar(512) DEFAULT NULL,
+  `sent` timestamp NULL DEFAULT NULL,
+  `resolution` varchar(16) DEFAULT NULL,
+  `resolved_event_id` int(11) DEFAULT NULL,
+  `resolved` timestamp NULL DEFAULT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  `updated` timestamp NULL DEFAULT current_timestamp() ON UPDATE current_timestamp(),
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `notice_order` (`event_notice_id`, `order_id`),
+  UNIQUE KEY `token` (`token`),
+  KEY `fk_order_id` (`order_id`),
+  KEY `fk_client_id` (`client_id`),
+  CONSTRAINT `event_notice_recipient_notice_id` FOREIGN KEY (`event_notice_id`) REFERENCES `event_notice` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `event_notice_recipient_order_id` FOREIGN KEY (`order_id`) REFERENCES `orders` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION,
+  CONSTRAINT `event_notice_recipient_client_id` FOREIGN KEY (`client_id`) REFERENCES `user` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+UPDATE `payment`
+  INNER JOIN `audit_log` ON (`audit_log`.`entity` = 'order' AND `audit_log`.`entity_id` = `payment`.`order_id` AND `audit_log`.`action` = 'payment.cashier' AND `audit_log`.`actor_id` = `payment`.`user_id`)
+  SET `payment`.`method_id` = 1
+  WHERE `payment`.`method_id` = 2;
+
+ALTER TABLE `gift_voucher_redemption`
+  ADD COLUMN `status` varchar(16) NOT NULL DEFAULT 'confirmed' AFTER `value`,
+  ADD COLUMN `expires` timestamp NULL DEFAULT NULL AFTER `status`,
+  ADD KEY `status_expires` (`status`, `expires`);
+
+UPDATE `gift_voucher_redemption`
+  SET `status` = 'reserved', `expires` = current_timestamp()
+  WHERE NOT EXISTS (SELECT 1 FROM `payment` WHERE `payment`.`order_id` = `gift_voucher_redemption`.`order_id` AND `payment`.`status_id` = 3 AND `payment`.`active` = true);
+
+ALTER TABLE `order_reschedule`
+  ADD COLUMN `gift_voucher_id` int(11) DEFAULT NULL AFTER `preference_id`,
+  ADD CONSTRAINT `order_reschedule_gift_voucher_id` FOREIGN KEY (`gift_voucher_id`) REFERENCES `gift_voucher` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION;
+
+CREATE TABLE `daily_report` (
+  `date` date NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`date`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+ALTER TABLE `user`
+  ADD COLUMN `two_factor_last_step` bigint(20) DEFAULT NULL AFTER `two_factor_challenge_expires`;
+
+ALTER TABLE `orders`
+  ADD COLUMN `reserved_until` timestamp NULL DEFAULT NULL AFTER `paid`,
+  ADD KEY `event_reserved_until` (`event_id`, `reserved_until`);
+
+UPDATE `orders`
+  INNER JOIN (
+    SELECT `order_id`, SUM(`amount`) AS `amount`
+    FROM `gift_voucher_redemption`
+    WHERE `status` <> 'released'
+    GROUP BY `order_id`
+  ) AS `redeemed` ON (`redeemed`.`order_id` = `orders`.`id`)
+  SET
+    `orders`.`net_amount` = ROUND((`orders`.`price` + `redeemed`.`amount`) * 10000 / (10000 + `orders`.`tax_rate`)),
+    `orders`.`tax_amount` = `orders`.`price` + `redeemed`.`amount` - `orders`.`net_amount`;
+
+ALTER TABLE `payment`
+  MODIFY `order_id` int(11) DEFAULT NULL,
+  ADD COLUMN `season_pass_id` int(11) DEFAULT NULL AFTER `order_id`,
+  ADD KEY `fk_season_pass_id` (`season_pass_id`),
+  ADD CONSTRAINT `payment_season_pass_id` FOREIGN KEY (`season_pass_id`) REFERENCES `season_pass` (`id`) ON DELETE NO ACTION ON UPDATE NO ACTION;
+
+INSERT INTO `payment` (`method_id`, `amount`, `user_id`, `preference_id`, `season_pass_id`, `status_id`, `created`)
+  SELECT 2, `price`, `user_id`, `preference_id`, `id`, IF(`status` = 'pending', 1, 3), `created`
+  FROM `season_pass`
+  WHERE `preference_id` IS NOT NULL;
+
+INSERT INTO `payment` (`method_id`, `amount`, `user_id`, `preference_id`, `season_pass_id`, `status_id`, `created`)
+  SELECT 1, `season_pass`.`price`, COALESCE(`audit_log`.`actor_id`, `season_pass`.`user_id`), `season_pass`.`code`, `season_pass`.`id`, 3, `season_pass`.`created`
+  FROM `season_pass`
+  LEFT JOIN `audit_log` ON (`audit_log`.`entity` = 'season_pass' AND `audit_log`.`entity_id` = `season_pass`.`id` AND `audit_log`.`action` = 'season_pass.insert')
+  WHERE `season_pass`.`preference_id` IS NULL AND `season_pass`.`price` > 0;
+
+ALTER TABLE `user`
+  ADD COLUMN `email_verification_expires` timestamp NULL DEFAULT NULL AFTER `email_verification_token`;
+
+UPDATE `user`
+  SET `email_verification_expires` = DATE_ADD(current_timestamp(), INTERVAL 48 HOUR)
+  WHERE `email_verification_token` IS NOT NULL;
+
+CREATE TABLE `email_verification_request` (
+  `id` int(11) NOT NULL AUTO_INCREMENT,
+  `email` varchar(255) NOT NULL,
+  `ip` varchar(64) NOT NULL,
+  `created` timestamp NULL DEFAULT current_timestamp(),
+  PRIMARY KEY (`id`),
+  KEY `email_created` (`email`, `created`),
+  KEY `ip_created` (`ip`, `created`)
+) ENGINE=InnoDB AUTO_INCREMENT=1 DEFAULT CHARSET=utf8;
+
+ALTER TABLE `user`
+  ADD COLUMN `login_failures_reset_at` timestamp NULL DEFAULT NULL AFTER `unlock_token`;
+
+UPDATE `user` SET `unlock_token` = SHA2(`unlock_token`, 256) WHERE `unlock_token` IS NOT NULL;
+
+ALTER TABLE `audit_log`
+  ADD COLUMN `client_request_id` varchar(64) DEFAULT NULL AFTER `request_id`;
@@ -0,0 +1,365 @@
+package db
+
+import (
+	"database/sql"
+
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+type TaxDocumentStorage interface {
+	UpsertOrderInvoice(invoice *models.OrderInvoice) error
+	GetOrderInvoice(orderID int) (*models.OrderInvoice, error)
+	GetPaymentIDByPreferenceID(preferenceID string) (int, error)
+	GetTaxDocumentPayment(paymentID int) (*models.TaxDocumentPayment, error)
+	GetPendingTaxDocumentPaymentIDs() ([]int, error)
+	GetPendingCreditNotePaymentIDs() ([]int, error)
+	ClaimPaymentTaxDocument(paymentID int) (bool, error)
+	ClaimPaymentCreditNote(paymentID int) (bool, error)
+	UpdatePaymentTaxDocument(paymentID int, document *models.TaxDocument) error
+	UpdatePaymentCreditNote(paymentID int, document *models.TaxDocument) error
+	FailPaymentTaxDocument(paymentID int, reason string) error
+	FailPaymentCreditNote(paymentID int, reason string) error
+}
+
+// ConstTaxDocumentStatuses tracks the issuing of the documents of a payment.
+// A document is claimed as issuing before calling the provider so it isn't
+// issued twice, and failed ones are retried.
+var ConstTaxDocumentStatuses = struct {
+	Issuing string
+	Issued  string
+	Failed  string
+}{
+	Issuing: "issuing",
+	Issued:  "issued",
+	Failed:  "failed",
+}
+
+const (
+	upsertOrderInvoice = `
+	INSERT INTO
+		order_invoice (order_id, dni, business_name, activity, address, commune, email)
+	VALUES
+		(:order_id, :dni, :business_name, :activity, :address, :commune, :email)
+	ON DUPLICATE KEY UPDATE
+		dni = VALUES(dni),
+		business_name = VALUES(business_name),
+		activity = VALUES(activity),
+		address = VALUES(address),
+		commune = VALUES(commune),
+		email = VALUES(email)
+	`
+
+	getOrderInvoice = `
+	SELECT
+		order_invoice.order_id,
+		order_invoice.dni,
+		order_invoice.business_name,
+		order_invoice.activity,
+		order_invoice.address,
+		order_invoice.commune,
+		order_invoice.email
+	FROM
+		order_invoice
+	WHERE
+		order_invoice.order_id = ?
+	`
+
+	getPaymentIDByPreferenceID = `
+	SELECT
+		payment.id
+	FROM
+		payment
+	WHERE
+		payment.preference_id = ?
+	`
+
+	getTaxDocumentPayment = `
+	SELECT
+		payment.id,
+		payment.amount,
+		payment.status_id,
+		COALESCE(orders.id, 0),
+		COALESCE(season_pass.id, 0),
+		COALESCE(pass_product.name, ''),
+		COALESCE(orders.tickets, 0),
+		COALESCE(orders.tax_rate, season_pass.tax_rate),
+		COALESCE(event_type.name, ''),
+		COALESCE(event.start_date_time, season_pass.valid_from),
+		COALESCE(client.email, holder.email),
+		COALESCE(payment.tax_document_type, 0),
+		COALESCE(payment.tax_folio, 0),
+		payment.tax_issued,
+		COALESCE(payment.credit_note_folio, 0)
+	FROM
+		payment
+	LEFT JOIN
+		orders ON (orders.id = payment.order_id)
+	LEFT JOIN
+		event ON (event.id = orders.event_id)
+	LEFT JOIN
+		event_type ON (event_type.id = event.event_type_id)
+	LEFT JOIN
+		user client ON (client.id = orders.client_id)
+	LEFT JOIN
+		season_pass ON (season_pass.id = payment.season_pass_id)
+	LEFT JOIN
+		pass_product ON (pass_product.id = season_pass.pass_product_id)
+	LEFT JOIN
+		user holder ON (holder.id = season_pass.user_id)
+	WHERE
+		payment.id = ? AND
+		(orders.id IS NOT NULL OR season_pass.id IS NOT NULL)
+	`
+
+	getPendingTaxDocumentPaymentIDs = `
+	SELECT
+		payment.id
+	FROM
+		payment
+	WHERE
+		payment.status_id = ? AND
+		payment.amount > 0 AND
+		payment.active = true AND
+		(payment.tax_status IS NULL OR payment.tax_status = ?)
+	ORDER BY
+		payment.id ASC
+	`
+
+	getPendingCreditNotePaymentIDs = `
+	SELECT
+		payment.id
+	FROM
+		payment
+	WHERE
+		payment.status_id = ? AND
+		payment.tax_status = ? AND
+		(payment.credit_note_status IS NULL OR payment.credit_note_status = ?)
+	ORDER BY
+		payment.id ASC
+	`
+
+	claimPaymentTaxDocument = `
+	UPDATE
+		payment
+	SET
+		tax_status = ?
+	WHERE
+		id = ? AND
+		status_id = ? AND
+		amount > 0 AND
+		(tax_status IS NULL OR tax_status = ?)
+	`
+
+	claimPaymentCreditNote = `
+	UPDATE
+		payment
+	SET
+		credit_note_status = ?
+	WHERE
+		id = ? AND
+		status_id = ? AND
+		tax_status = ? AND
+		(credit_note_status IS NULL OR credit_note_status = ?)
+	`
+
+	updatePaymentTaxDocument = `
+	UPDATE
+		payment
+	SET
+		tax_status = ?,
+		tax_document_type = ?,
+		tax_folio = ?,
+		tax_pdf_url = ?,
+		tax_error = NULL,
+		tax_issued = current_timestamp()
+	WHERE
+		id = ?
+	`
+
+	updatePaymentCreditNote = `
+	UPDATE
+		payment
+	SET
+		credit_note_status = ?,
+		credit_note_folio = ?,
+		credit_note_pdf_url = ?,
+		tax_error = NULL
+	WHERE
+		id = ?
+	`
+
+	failPaymentTaxDocument = `
+	UPDATE
+		payment
+	SET
+		tax_status = ?,
+		tax_error = ?
+	WHERE
+		id = ?
+	`
+
+	failPaymentCreditNote = `
+	UPDATE
+		payment
+	SET
+		credit_note_status = ?,
+		tax_error = ?
+	WHERE
+		id = ?
+	`
+)
+
+func (db *DB) UpsertOrderInvoice(invoice *models.OrderInvoice) error {
+	_, err := db.NamedExec(upsertOrderInvoice, map[string]interface{}{
+		"order_id":      invoice.OrderID,
+		"dni":           invoice.DNI,
+		"business_name": invoice.BusinessName,
+		"activity":      invoice.Activity,
+		"address":       invoice.Address,
+		"commune":       invoice.Commune,
+		"email":         invoice.Email,
+	})
+
+	return err
+}
+
+func (db *DB) GetOrderInvoice(orderID int) (*models.OrderInvoice, error) {
+	var invoice models.OrderInvoice
+	if err := db.QueryRow(getOrderInvoice, orderID).Scan(
+		&invoice.OrderID,
+		&invoice.DNI,
+		&invoice.BusinessName,
+		&invoice.Activity,
+		&invoice.Address,
+		&invoice.Commune,
+		&invoice.Email,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return &invoice, nil
+}
+
+func (db *DB) GetPaymentIDByPreferenceID(preferenceID string) (int, error) {
+	var paymentID int
+	if err := db.QueryRow(getPaymentIDByPreferenceID, preferenceID).Scan(&paymentID); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	return paymentID, nil
+}
+
+func (db *DB) GetTaxDocumentPayment(paymentID int) (*models.TaxDocumentPayment, error) {
+	var payment models.TaxDocumentPayment
+	if err := db.QueryRow(getTaxDocumentPayment, paymentID).Scan(
+		&payment.PaymentID,
+		&payment.Amount,
+		&payment.StatusID,
+		&payment.OrderID,
+		&payment.SeasonPassID,
+		&payment.PassProduct,
+		&payment.Tickets,
+		&payment.TaxRate,
+		&payment.EventType,
+		&payment.EventDate,
+		&payment.ClientEmail,
+		&payment.TaxDocumentType,
+		&payment.TaxFolio,
+		&payment.TaxIssued,
+		&payment.CreditNoteFolio,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if payment.OrderID == 0 {
+		return &payment, nil
+	}
+
+	invoice, err := db.GetOrderInvoice(payment.OrderID)
+	if err != nil {
+		return nil, err
+	}
+	payment.Invoice = invoice
+
+	return &payment, nil
+}
+
+func (db *DB) GetPendingTaxDocumentPaymentIDs() ([]int, error) {
+	var ids []int
+	err := db.Select(&ids, getPendingTaxDocumentPaymentIDs, ConstPaymentStatuses.Approved.ID, ConstTaxDocumentStatuses.Failed)
+
+	return ids, err
+}
+
+func (db *DB) GetPendingCreditNotePaymentIDs() ([]int, error) {
+	var ids []int
+	err := db.Select(&ids, getPendingCreditNotePaymentIDs, ConstPaymentStatuses.Reversed.ID, ConstTaxDocumentStatuses.Issued, ConstTaxDocumentStatuses.Failed)
+
+	return ids, err
+}
+
+// ClaimPaymentTaxDocument marks the boleta or factura of an approved payment
+// as issuing. It returns false when there is nothing to issue or another
+// process is already issuing it.
+func (db *DB) ClaimPaymentTaxDocument(paymentID int) (bool, error) {
+	result, err := db.Exec(claimPaymentTaxDocument, ConstTaxDocumentStatuses.Issuing, paymentID, ConstPaymentStatuses.Approved.ID, ConstTaxDocumentStatuses.Failed)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+// ClaimPaymentCreditNote marks the credit note of a reversed payment as
+// issuing. Only payments with an issued document get one.
+func (db *DB) ClaimPaymentCreditNote(paymentID int) (bool, error) {
+	result, err := db.Exec(claimPaymentCreditNote, ConstTaxDocumentStatuses.Issuing, paymentID, ConstPaymentStatuses.Reversed.ID, ConstTaxDocumentStatuses.Issued, ConstTaxDocumentStatuses.Failed)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected == 1, nil
+}
+
+func (db *DB) UpdatePaymentTaxDocument(paymentID int, document *models.TaxDocument) error {
+	_, err := db.Exec(updatePaymentTaxDocument, ConstTaxDocumentStatuses.Issued, document.Type, document.Folio, document.PDFURL, paymentID)
+
+	return err
+}
+
+func (db *DB) UpdatePaymentCreditNote(paymentID int, document *models.TaxDocument) error {
+	_, err := db.Exec(updatePaymentCreditNote, ConstTaxDocumentStatuses.Issued, document.Folio, document.PDFURL, paymentID)
+
+	return err
+}
+
+func (db *DB) FailPaymentTaxDocument(paymentID int, reason string) error {
+	_, err := db.Exec(failPaymentTaxDocument, ConstTaxDocumentStatuses.Failed, reason, paymentID)
+
+	return err
+}
+
+func (db *DB) FailPaymentCreditNote(paymentID int, reason string) error {
+	_, err := db.Exec(failPaymentCreditNote, ConstTaxDocumentStatuses.Failed, reason, paymentID)
+
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"encoding/json"
 	"strings"
+	"time"
 
 	"bitbucket.org/parqueoasis/backend/models"
 	"github.com/jmoiron/sqlx"
@@ -18,8 +19,23 @@ type UserStorage interface {
 	GetUsers(*models.GetUsersOpts) (*models.UsersStruct, error)
 	UpdateUser(userID int, opts *models.UpdateUserOpts) error
 	GetRoles() ([]models.Role, error)
+	VerifyUserEmail(tokenHash string) (*models.User, error)
+	UpdateUserEmailVerificationToken(userID int, tokenHash string, expires time.Time) error
+	InsertEmailVerificationRequest(email string, ip string) error
+	CountEmailVerificationRequests(email string, ip string, since time.Time) (emailCounter int, ipCounter int, err error)
+	GetUserDNIs() ([]models.User, error)
+	UpdateUserAdditionalDNI(additionalID int, dni string) error
+	GetUserStatus(userID int) (*models.User, error)
+	UpdateUserStatus(userID int, actorID int, status string, reason string) error
+	GetUserStatusChanges(userID int) ([]models.UserStatusChange, error)
+	UpdateUserEmailChange(userID int, email string, tokenHash string, expires time.Time) error
+	ConfirmUserEmailChange(tokenHash string) (*models.User, error)
 }
 
+var ErrEmailExists = errors.New("email exists")
+
+var ErrLastActiveAdmin = errors.New("last active admin")
+
 const (
 	insertUser = `
 	INSERT
@@ -28,7 +44,10 @@ const (
 		email = :email,
 		password = :password,
 		firstname = :firstname,
-		lastname = :lastname
+		lastname = :lastname,
+		email_verified = :email_verified,
+		email_verification_token = :email_verification_token,
+		email_verification_expires = :email_verification_expires
 	`
 
 	insertUserAdditional = `
@@ -36,6 +55,7 @@ const (
 		user_additional
 	SET
 		dni = :dni,
+		document_type = :document_type,
 		phone = :phone,
 		user_id = :user_id
 	`
@@ -45,7 +65,8 @@ const (
 		user
 	SET
 		password = :password,
-		remember_token = NULL
+		remember_token = NULL,
+		remember_token_expires = NULL
 	WHERE
 		user.id = :user_id AND
 		user.active = 1
@@ -74,9 +95,11 @@ const (
 		user.created,
 		user.updated,
 		user.active,
+		user.email_verified,
 		user_additional.id,
 		user_additional.phone,
 		user_additional.dni,
+		user_additional.document_type,
 		COALESCE(CONCAT('[',GROUP_CONCAT(JSON_OBJECT('id', role.id, 'name', role.name)),']'))
 	FROM
 		user
@@ -103,9 +126,12 @@ const (
 		user.created,
 		user.updated,
 		user.active,
+		user.deleted_at,
+		user.email_verified,
 		user_additional.id,
 		user_additional.phone,
 		user_additional.dni,
+		user_additional.document_type,
 		COALESCE(CONCAT('[',GROUP_CONCAT(JSON_OBJECT('id', role.id, 'name', role.name)),']'))
 	FROM
 		user
@@ -116,7 +142,7 @@ const (
 	INNER JOIN
 		user_additional ON (user_additional.user_id = user.id)
 	WHERE
-		user.active = 1
+		true
 		#FILTERS#
 	GROUP BY
 		user.id
@@ -134,7 +160,7 @@ const (
 	INNER JOIN role ON (role.id = pivot_role_user.role_id AND role.active = 1)
 	INNER JOIN user_additional ON (user_additional.user_id = user.id)
 	WHERE
-		user.active = 1
+		true
 		#FILTERS#
 	`
 )
@@ -159,9 +185,10 @@ func (db *DB) InsertUser(opts *models.InsertAdminUserOpts) (int, error) {
 	}
 
 	userAdditional := models.UserAdditional{
-		ID:    userID,
-		DNI:   opts.DNI,
-		Phone: opts.Phone,
+		ID:           userID,
+		DNI:          opts.DNI,
+		DocumentType: opts.DocumentType,
+		Phone:        opts.Phone,
 	}
 
 	err = db.insertUserAdditionalTx(tx, userID, &userAdditional)
@@ -183,11 +210,22 @@ func (db *DB) insertUserTx(tx Tx, opts *models.InsertAdminUserOpts) (int, error)
 		return 0, err
 	}
 
+	var emailVerificationToken *string
+	var emailVerificationExpires *time.Time
+	if opts.EmailVerificationToken != "" {
+		emailVerificationToken = &opts.EmailVerificationToken
+		expires := opts.EmailVerificationExpires.UTC()
+		emailVerificationExpires = &expires
+	}
+
 	args := map[string]interface{}{
-		"email":     opts.Email,
-		"password":  opts.Password,
-		"firstname": opts.Firstname,
-		"lastname":  opts.Lastname,
+		"email":                      opts.Email,
+		"password":                   opts.Password,
+		"firstname":                  opts.Firstname,
+		"lastname":                   opts.Lastname,
+		"email_verified":             opts.EmailVerified,
+		"email_verification_token":   emailVerificationToken,
+		"email_verification_expires": emailVerificationExpires,
 	}
 
 	result, err := stmt.Exec(args)
@@ -219,9 +257,10 @@ func (db *DB) insertUserAdditionalTx(tx Tx, userID int, opts *models.UserAdditio
 	}
 
 	args := map[string]interface{}{
-		"dni":     opts.DNI,
-		"phone":   opts.Phone,
-		"user_id": userID,
+		"dni":           opts.DNI,
+		"document_type": opts.DocumentType,
+		"phone":         opts.Phone,
+		"user_id":       userID,
 	}
 
 	result, err := stmt.Exec(args)
@@ -349,9 +388,11 @@ func (db *DB) GetUserByID(userID int) (*models.User, error) {
 		&user.Created,
 		&user.Updated,
 		&user.Active,
+		&user.EmailVerified,
 		&additional.ID,
 		&additional.Phone,
 		&additional.DNI,
+		&additional.DocumentType,
 		&rolesBT,
 	); err != nil {
 		if err == sql.ErrNoRows {
@@ -370,6 +411,12 @@ func (db *DB) GetUserByID(userID int) (*models.User, error) {
 func (db *DB) GetUsers(opts *models.GetUsersOpts) (*models.UsersStruct, error) {
 	var filters string
 	args := make(map[string]interface{})
+	if opts.Active != nil {
+		filters += " AND user.active = :active "
+		args["active"] = *opts.Active
+	} else {
+		filters += " AND user.active = 1 "
+	}
 	if opts.CreatedFrom != "" {
 		filters += " AND DATE(CONVERT_TZ(user.created, 'UTC', 'America/Santiago')) >= :created_from "
 		args["created_from"] = opts.CreatedFrom
@@ -450,9 +497,12 @@ func (db *DB) GetUsers(opts *models.GetUsersOpts) (*models.UsersStruct, error) {
 			&user.Created,
 			&user.Updated,
 			&user.Active,
+			&user.DeletedAt,
+			&user.EmailVerified,
 			&additional.ID,
 			&additional.Phone,
 			&additional.DNI,
+			&additional.DocumentType,
 			&rolesBT,
 		); err != nil {
 			return nil, err
@@ -564,6 +614,7 @@ const (
 		user_additional
 	SET
 		dni = :dni,
+		document_type = :document_type,
 		phone = :phone,
 		updated = current_timestamp()
 	WHERE
@@ -578,9 +629,10 @@ func (db *DB) updateUserAdditionalTx(tx Tx, userID int, opts *models.UpdateUserO
 	}
 
 	args := map[string]interface{}{
-		"user_id": userID,
-		"dni":     opts.DNI,
-		"phone":   opts.Phone,
+		"user_id":       userID,
+		"dni":           opts.DNI,
+		"document_type": opts.DocumentType,
+		"phone":         opts.Phone,
 	}
 
 	result, err := stmt.Exec(args)
@@ -724,3 +776,660 @@ func (db *DB) GetRoles() ([]models.Role, error) {
 
 	return roles, nil
 }
+
+const (
+	getUserByEmailVerificationToken = `
+	SELECT
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		user
+	WHERE
+		user.active = 1 AND
+		user.email_verification_token = :token AND
+		user.email_verification_expires > current_timestamp()
+	`
+
+	verifyUserEmail = `
+	UPDATE
+		user
+	SET
+		email_verified = true,
+		email_verified_at = current_timestamp(),
+		email_verification_token = NULL,
+		email_verification_expires = NULL
+	WHERE
+		id = :user_id
+	`
+
+	updateUserEmailVerificationToken = `
+	UPDATE
+		user
+	SET
+		email_verification_token = :token,
+		email_verification_expires = :expires
+	WHERE
+		id = :user_id AND
+		email_verified = false
+	`
+
+	insertEmailVerificationRequest = `
+	INSERT
+		email_verification_request
+	SET
+		email = :email,
+		ip = :ip
+	`
+
+	countEmailVerificationRequests = `
+	SELECT
+		(
+			SELECT
+				COUNT(email_verification_request.id)
+			FROM
+				email_verification_request
+			WHERE
+				email_verification_request.email = :email AND
+				email_verification_request.created >= :since
+		) email_counter,
+		(
+			SELECT
+				COUNT(email_verification_request.id)
+			FROM
+				email_verification_request
+			WHERE
+				email_verification_request.ip = :ip AND
+				email_verification_request.created >= :since
+		) ip_counter
+	`
+)
+
+func (db *DB) VerifyUserEmail(tokenHash string) (*models.User, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(getUserByEmailVerificationToken)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"token": tokenHash,
+	}
+
+	var user models.User
+
+	row := stmt.QueryRow(args)
+	if err = row.Scan(
+		&user.ID,
+		&user.Firstname,
+		&user.Lastname,
+		&user.Email,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stmt, err = tx.PrepareNamed(verifyUserEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	args["user_id"] = user.ID
+	if _, err = stmt.Exec(args); err != nil {
+		return nil, err
+	}
+
+	user.EmailVerified = true
+
+	return &user, nil
+}
+
+func (db *DB) UpdateUserEmailVerificationToken(userID int, tokenHash string, expires time.Time) error {
+	stmt, err := db.PrepareNamed(updateUserEmailVerificationToken)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+		"token":   tokenHash,
+		"expires": expires.UTC(),
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) InsertEmailVerificationRequest(email string, ip string) error {
+	stmt, err := db.PrepareNamed(insertEmailVerificationRequest)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"email": email,
+		"ip":    ip,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) CountEmailVerificationRequests(email string, ip string, since time.Time) (emailCounter int, ipCounter int, err error) {
+	stmt, err := db.PrepareNamed(countEmailVerificationRequests)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	args := map[string]interface{}{
+		"email": email,
+		"ip":    ip,
+		"since": since.UTC(),
+	}
+
+	row := stmt.QueryRow(args)
+
+	if err := row.Scan(
+		&emailCounter,
+		&ipCounter,
+	); err != nil {
+		return 0, 0, err
+	}
+
+	return emailCounter, ipCounter, nil
+}
+
+const (
+	getUserDNIs = `
+	SELECT
+		user.id,
+		user.email,
+		user_additional.id,
+		user_additional.dni,
+		user_additional.document_type
+	FROM
+		user
+	INNER JOIN
+		user_additional ON (user_additional.user_id = user.id)
+	ORDER BY
+		user.id
+	`
+
+	updateUserAdditionalDNI = `
+	UPDATE
+		user_additional
+	SET
+		dni = :dni,
+		updated = current_timestamp()
+	WHERE
+		id = :id
+	`
+)
+
+func (db *DB) GetUserDNIs() ([]models.User, error) {
+	rows, err := db.Query(getUserDNIs)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var users []models.User
+	for rows.Next() {
+		var user models.User
+		var additional models.UserAdditional
+		if err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&additional.ID,
+			&additional.DNI,
+			&additional.DocumentType,
+		); err != nil {
+			return nil, err
+		}
+
+		user.Additional = &additional
+		users = append(users, user)
+	}
+
+	return users, nil
+}
+
+func (db *DB) UpdateUserAdditionalDNI(additionalID int, dni string) error {
+	stmt, err := db.PrepareNamed(updateUserAdditionalDNI)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"id":  additionalID,
+		"dni": dni,
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+const (
+	getUserStatus = `
+	SELECT
+		user.id,
+		user.email,
+		user.active,
+		user.deleted_at,
+		COALESCE(CONCAT('[',GROUP_CONCAT(JSON_OBJECT('id', role.id, 'name', role.name)),']'), '[]')
+	FROM
+		user
+	LEFT JOIN
+		pivot_role_user ON (pivot_role_user.user_id = user.id)
+	LEFT JOIN
+		role ON (role.id = pivot_role_user.role_id AND role.active = 1)
+	WHERE
+		user.id = :user_id
+	GROUP BY
+		user.id
+	`
+
+	lockUserStatus = `
+	SELECT
+		user.active,
+		EXISTS (
+			SELECT
+				pivot_role_user.user_id
+			FROM
+				pivot_role_user
+			WHERE
+				pivot_role_user.user_id = user.id AND
+				pivot_role_user.role_id = :admin_role_id
+		)
+	FROM
+		user
+	WHERE
+		user.id = :user_id
+	FOR UPDATE
+	`
+
+	countOtherActiveAdmins = `
+	SELECT
+		COUNT(DISTINCT user.id)
+	FROM
+		user
+	INNER JOIN
+		pivot_role_user ON (pivot_role_user.user_id = user.id)
+	WHERE
+		pivot_role_user.role_id = :admin_role_id AND
+		user.active = 1 AND
+		user.id != :user_id
+	FOR UPDATE
+	`
+
+	updateUserActive = `
+	UPDATE
+		user
+	SET
+		active = :active,
+		deleted_at = IF(:deleted, current_timestamp(), deleted_at),
+		sessions_revoked_at = IF(:active, sessions_revoked_at, current_timestamp())
+	WHERE
+		id = :user_id
+	`
+
+	insertUserStatusChange = `
+	INSERT
+		user_status_change
+	SET
+		user_id = :user_id,
+		actor_id = :actor_id,
+		status = :status,
+		reason = :reason
+	`
+
+	getUserStatusChanges = `
+	SELECT
+		user_status_change.id,
+		user_status_change.status,
+		user_status_change.reason,
+		user_status_change.created,
+		actor.id,
+		actor.firstname,
+		actor.lastname,
+		actor.email
+	FROM
+		user_status_change
+	INNER JOIN
+		user AS actor ON (actor.id = user_status_change.actor_id)
+	WHERE
+		user_status_change.user_id = :user_id
+	ORDER BY
+		user_status_change.id DESC
+	`
+)
+
+// GetUserStatus returns the user regardless of it being active or deleted.
+func (db *DB) GetUserStatus(userID int) (*models.User, error) {
+	stmt, err := db.PrepareNamed(getUserStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	var user models.User
+	var rolesBT []byte
+
+	row := stmt.QueryRow(args)
+	if err := row.Scan(
+		&user.ID,
+		&user.Email,
+		&user.Active,
+		&user.DeletedAt,
+		&rolesBT,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(rolesBT, &user.Roles); err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// UpdateUserStatus deactivates, reactivates or soft deletes a user and records
+// who did it and why. Deactivating or deleting revokes the user's sessions and
+// fails with ErrLastActiveAdmin if nobody else could administrate the park.
+func (db *DB) UpdateUserStatus(userID int, actorID int, status string, reason string) error {
+	tx, err := db.NewTx()
+	if err != nil {
+		return errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	active := status == ConstUserStatuses.Reactivated
+	args := map[string]interface{}{
+		"user_id":       userID,
+		"actor_id":      actorID,
+		"admin_role_id": ConstRoles.Admin,
+		"status":        status,
+		"reason":        reason,
+		"active":        active,
+		"deleted":       status == ConstUserStatuses.Deleted,
+	}
+
+	stmt, err := tx.PrepareNamed(lockUserStatus)
+	if err != nil {
+		return err
+	}
+
+	var wasActive, isAdmin bool
+	if err = stmt.QueryRow(args).Scan(&wasActive, &isAdmin); err != nil {
+		return err
+	}
+
+	if !active && wasActive && isAdmin {
+		stmt, err = tx.PrepareNamed(countOtherActiveAdmins)
+		if err != nil {
+			return err
+		}
+
+		var admins int
+		if err = stmt.QueryRow(args).Scan(&admins); err != nil {
+			return err
+		}
+
+		if admins == 0 {
+			err = ErrLastActiveAdmin
+			return err
+		}
+	}
+
+	stmt, err = tx.PrepareNamed(updateUserActive)
+	if err != nil {
+		return err
+	}
+
+	if _, err = stmt.Exec(args); err != nil {
+		return err
+	}
+
+	stmt, err = tx.PrepareNamed(insertUserStatusChange)
+	if err != nil {
+		return err
+	}
+
+	if _, err = stmt.Exec(args); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (db *DB) GetUserStatusChanges(userID int) ([]models.UserStatusChange, error) {
+	stmt, err := db.PrepareNamed(getUserStatusChanges)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var changes []models.UserStatusChange
+	for rows.Next() {
+		var change models.UserStatusChange
+		var actor models.User
+		if err := rows.Scan(
+			&change.ID,
+			&change.Status,
+			&change.Reason,
+			&change.Created,
+			&actor.ID,
+			&actor.Firstname,
+			&actor.Lastname,
+			&actor.Email,
+		); err != nil {
+			return nil, err
+		}
+
+		change.Actor = &actor
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+const (
+	updateUserEmailChange = `
+	UPDATE
+		user
+	SET
+		pending_email = :email,
+		email_change_token = :token,
+		email_change_expires = :expires
+	WHERE
+		id = :user_id
+	`
+
+	getUserByEmailChangeToken = `
+	SELECT
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email,
+		user.pending_email
+	FROM
+		user
+	WHERE
+		user.active = 1 AND
+		user.email_change_token = :token AND
+		user.email_change_expires > current_timestamp()
+	FOR UPDATE
+	`
+
+	countUsersByEmail = `
+	SELECT
+		COUNT(user.id)
+	FROM
+		user
+	WHERE
+		user.email = :email
+	`
+
+	confirmUserEmailChange = `
+	UPDATE
+		user
+	SET
+		email = pending_email,
+		email_verified = true,
+		email_verified_at = current_timestamp(),
+		pending_email = NULL,
+		email_change_token = NULL,
+		email_change_expires = NULL
+	WHERE
+		id = :user_id
+	`
+)
+
+func (db *DB) UpdateUserEmailChange(userID int, email string, tokenHash string, expires time.Time) error {
+	stmt, err := db.PrepareNamed(updateUserEmailChange)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"user_id": userID,
+		"email":   email,
+		"token":   tokenHash,
+		"expires": expires.UTC(),
+	}
+
+	_, err = stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ConfirmUserEmailChange replaces the user's email by the pending one. It
+// returns ErrEmailExists if somebody registered the address in the meantime.
+func (db *DB) ConfirmUserEmailChange(tokenHash string) (*models.User, error) {
+	tx, err := db.NewTx()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to start transaction")
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+
+		tx.Commit()
+	}()
+
+	stmt, err := tx.PrepareNamed(getUserByEmailChangeToken)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"token": tokenHash,
+	}
+
+	var user models.User
+	var pendingEmail string
+
+	row := stmt.QueryRow(args)
+	if err = row.Scan(
+		&user.ID,
+		&user.Firstname,
+		&user.Lastname,
+		&user.Email,
+		&pendingEmail,
+	); err != nil {
+		if err == sql.ErrNoRows {
+			err = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	stmt, err = tx.PrepareNamed(countUsersByEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	args["email"] = pendingEmail
+
+	var counter int
+	if err = stmt.QueryRow(args).Scan(&counter); err != nil {
+		return nil, err
+	}
+
+	if counter > 0 {
+		err = ErrEmailExists
+		return nil, err
+	}
+
+	stmt, err = tx.PrepareNamed(confirmUserEmailChange)
+	if err != nil {
+		return nil, err
+	}
+
+	args["user_id"] = user.ID
+	if _, err = stmt.Exec(args); err != nil {
+		return nil, err
+	}
+
+	user.Email = pendingEmail
+	user.EmailVerified = true
+
+	return &user, nil
+}
@@ -0,0 +1,621 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/pkg/errors"
+)
+
+type WaitlistStorage interface {
+	InsertWaitlistEntry(eventID int, userID int, tickets int) (int, error)
+	GetActiveWaitlistEntry(eventID int, userID int) (*models.WaitlistEntry, error)
+	GetWaitlistEntryByToken(token string) (*models.WaitlistEntry, error)
+	GetWaitlist(opts *models.GetWaitlistOpts) (*models.WaitlistStruct, error)
+	CancelWaitlistEntry(entryID int) error
+	ExpireWaitlistOffers() error
+	GetWaitlistEventIDs() ([]int, error)
+	GetWaitingEntries(eventID int) ([]models.WaitlistEntry, error)
+	OfferWaitlistEntry(entryID int, token string, expires time.Time) error
+	PurchaseWaitlistEntry(entryID int, orderID int) error
+	GetEventAvailableTickets(eventID int, excludeEntryID int) (int, error)
+}
+
+// ErrWaitlistEntryInvalid is returned when the entry changed status while
+// being updated, e.g. the offer expired.
+var ErrWaitlistEntryInvalid = errors.New("waitlist entry is no longer valid")
+
+var ConstWaitlistStatuses = struct {
+	Waiting   string
+	Offered   string
+	Purchased string
+	Expired   string
+	Cancelled string
+}{
+	Waiting:   "waiting",
+	Offered:   "offered",
+	Purchased: "purchased",
+	Expired:   "expired",
+	Cancelled: "cancelled",
+}
+
+const (
+	insertWaitlistEntry = `
+	INSERT
+		waitlist_entry
+	SET
+		event_id = :event_id,
+		user_id = :user_id,
+		tickets = :tickets,
+		status = :status
+	`
+
+	selectWaitlistEntry = `
+	SELECT
+		waitlist_entry.id,
+		waitlist_entry.tickets,
+		waitlist_entry.status,
+		waitlist_entry.offer_expires,
+		COALESCE(waitlist_entry.order_id, 0),
+		waitlist_entry.created,
+		event.id,
+		event.name,
+		event.start_date_time,
+		event.end_date_time,
+		event.price,
+		event_type.id,
+		event_type.name,
+		user.id,
+		user.firstname,
+		user.lastname,
+		user.email
+	FROM
+		waitlist_entry
+	INNER JOIN
+		event ON (event.id = waitlist_entry.event_id)
+	INNER JOIN
+		event_type ON (event_type.id = event.event_type_id)
+	INNER JOIN
+		user ON (user.id = waitlist_entry.user_id)
+	`
+
+	getActiveWaitlistEntry = selectWaitlistEntry + `
+	WHERE
+		waitlist_entry.event_id = :event_id AND
+		waitlist_entry.user_id = :user_id AND
+		waitlist_entry.status IN (:waiting, :offered)
+	LIMIT 1
+	`
+
+	getWaitlistEntryByToken = selectWaitlistEntry + `
+	WHERE
+		waitlist_entry.token = :token
+	`
+
+	getWaitlist = selectWaitlistEntry + `
+	WHERE
+		true
+		#FILTERS#
+	ORDER BY
+		waitlist_entry.id ASC
+	LIMIT :limit_to OFFSET :limit_from
+	`
+
+	countWaitlist = `
+	SELECT
+		COUNT(waitlist_entry.id)
+	FROM
+		waitlist_entry
+	WHERE
+		true
+		#FILTERS#
+	`
+
+	getWaitingEntries = selectWaitlistEntry + `
+	WHERE
+		waitlist_entry.event_id = :event_id AND
+		waitlist_entry.status = :waiting
+	ORDER BY
+		waitlist_entry.id ASC
+	`
+
+	getWaitlistEventIDs = `
+	SELECT DISTINCT
+		waitlist_entry.event_id
+	FROM
+		waitlist_entry
+	INNER JOIN
+		event ON (event.id = waitlist_entry.event_id)
+	WHERE
+		waitlist_entry.status = ? AND
+		event.active = 1 AND
+		event.start_date_time > CONVERT_TZ(UTC_TIMESTAMP(), 'UTC', 'America/Santiago')
+	`
+
+	updateWaitlistEntryStatus = `
+	UPDATE
+		waitlist_entry
+	SET
+		status = :status
+	WHERE
+		id = :id AND
+		status IN (:waiting, :offered)
+	`
+
+	expireWaitlistOffers = `
+	UPDATE
+		waitlist_entry
+	SET
+		status = ?
+	WHERE
+		status = ? AND
+		offer_expires <= current_timestamp()
+	`
+
+	// The event times are Santiago wall times, unlike the timestamps.
+	expireStartedWaitlistEntries = `
+	UPDATE
+		waitlist_entry
+	INNER JOIN
+		event ON (event.id = waitlist_entry.event_id)
+	SET
+		waitlist_entry.status = ?
+	WHERE
+		waitlist_entry.status IN (?, ?) AND
+		event.start_date_time <= CONVERT_TZ(UTC_TIMESTAMP(), 'UTC', 'America/Santiago')
+	`
+
+	offerWaitlistEntry = `
+	UPDATE
+		waitlist_entry
+	SET
+		status = :offered,
+		token = :token,
+		offer_expires = :offer_expires
+	WHERE
+		id = :id AND
+		status = :waiting
+	`
+
+	purchaseWaitlistEntry = `
+	UPDATE
+		waitlist_entry
+	SET
+		status = :purchased,
+		order_id = :order_id
+	WHERE
+		id = :id AND
+		status = :offered AND
+		offer_expires > current_timestamp()
+	`
+
+	// getEventHeldTickets counts the tickets reserved by waitlist offers. Once
+	// an offer is purchased, its order holds the tickets instead.
+	getEventHeldTickets = `
+	SELECT
+		COALESCE(SUM(waitlist_entry.tickets), 0)
+	FROM
+		waitlist_entry
+	WHERE
+		waitlist_entry.event_id = ? AND
+		waitlist_entry.id <> ? AND
+		waitlist_entry.offer_expires > current_timestamp() AND
+		waitlist_entry.status = ?
+	`
+)
+
+func (db *DB) InsertWaitlistEntry(eventID int, userID int, tickets int) (int, error) {
+	stmt, err := db.PrepareNamed(insertWaitlistEntry)
+	if err != nil {
+		return 0, err
+	}
+
+	args := map[string]interface{}{
+		"event_id": eventID,
+		"user_id":  userID,
+		"tickets":  tickets,
+		"status":   ConstWaitlistStatuses.Waiting,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
+
+func (db *DB) GetActiveWaitlistEntry(eventID int, userID int) (*models.WaitlistEntry, error) {
+	stmt, err := db.PrepareNamed(getActiveWaitlistEntry)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"event_id": eventID,
+		"user_id":  userID,
+		"waiting":  ConstWaitlistStatuses.Waiting,
+		"offered":  ConstWaitlistStatuses.Offered,
+	}
+
+	entry, err := scanWaitlistEntry(stmt.QueryRow(args))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return entry, err
+}
+
+func (db *DB) GetWaitlistEntryByToken(token string) (*models.WaitlistEntry, error) {
+	stmt, err := db.PrepareNamed(getWaitlistEntryByToken)
+	if err != nil {
+		return nil, err
+	}
+
+	args := map[string]interface{}{
+		"token": token,
+	}
+
+	entry, err := scanWaitlistEntry(stmt.QueryRow(args))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+
+	return entry, err
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanWaitlistEntry(row rowScanner) (*models.WaitlistEntry, error) {
+	entry := models.WaitlistEntry{
+		Event: &models.Event{
+			Type: &models.EventType{},
+		},
+		User: &models.User{},
+	}
+
+	var orderID int
+	if err := row.Scan(
+		&entry.ID,
+		&entry.Tickets,
+		&entry.Status,
+		&entry.OfferExpires,
+		&orderID,
+		&entry.Created,
+		&entry.Event.ID,
+		&entry.Event.Name,
+		&entry.Event.StartDateTime,
+		&entry.Event.EndDateTime,
+		&entry.Event.Price,
+		&entry.Event.Type.ID,
+		&entry.Event.Type.Name,
+		&entry.User.ID,
+		&entry.User.Firstname,
+		&entry.User.Lastname,
+		&entry.User.Email,
+	); err != nil {
+		return nil, err
+	}
+
+	if orderID != 0 {
+		entry.Order = &models.Order{
+			ID: orderID,
+		}
+	}
+
+	return &entry, nil
+}
+
+func (db *DB) GetWaitlist(opts *models.GetWaitlistOpts) (*models.WaitlistStruct, error) {
+	var filters string
+	args := make(map[string]interface{})
+	if opts.EventID != 0 {
+		filters += " AND waitlist_entry.event_id = :event_id "
+		args["event_id"] = opts.EventID
+	}
+	if opts.UserID != 0 {
+		filters += " AND waitlist_entry.user_id = :user_id "
+		args["user_id"] = opts.UserID
+	}
+	if opts.Status != "" {
+		filters += " AND waitlist_entry.status = :status "
+		args["status"] = opts.Status
+	}
+	if opts.LimitTo == 0 {
+		opts.LimitTo = 10
+	}
+	args["limit_to"] = opts.LimitTo
+	args["limit_from"] = opts.LimitFrom
+
+	total, err := db.countWaitlist(filters, args)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(getWaitlist, "#FILTERS#", filters))
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(args)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	waitlist := models.WaitlistStruct{
+		Total: total,
+	}
+
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		waitlist.Entries = append(waitlist.Entries, *entry)
+	}
+
+	return &waitlist, nil
+}
+
+func (db *DB) countWaitlist(filters string, args map[string]interface{}) (int, error) {
+	stmt, err := db.PrepareNamed(strings.ReplaceAll(countWaitlist, "#FILTERS#", filters))
+	if err != nil {
+		return 0, err
+	}
+
+	row := stmt.QueryRow(args)
+	var total int
+	if err := row.Scan(
+		&total,
+	); err != nil {
+		return 0, err
+	}
+
+	return total, nil
+}
+
+func (db *DB) CancelWaitlistEntry(entryID int) error {
+	stmt, err := db.PrepareNamed(updateWaitlistEntryStatus)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"id":      entryID,
+		"status":  ConstWaitlistStatuses.Cancelled,
+		"waiting": ConstWaitlistStatuses.Waiting,
+		"offered": ConstWaitlistStatuses.Offered,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrWaitlistEntryInvalid
+	}
+
+	return nil
+}
+
+// ExpireWaitlistOffers expires the offers not used in time and the entries
+// of the events that already started.
+func (db *DB) ExpireWaitlistOffers() error {
+	if _, err := db.Exec(expireWaitlistOffers, ConstWaitlistStatuses.Expired, ConstWaitlistStatuses.Offered); err != nil {
+		return err
+	}
+
+	_, err := db.Exec(expireStartedWaitlistEntries, ConstWaitlistStatuses.Expired, ConstWaitlistStatuses.Waiting, ConstWaitlistStatuses.Offered)
+	return err
+}
+
+func (db *DB) GetWaitlistEventIDs() ([]int, error) {
+	rows, err := db.Query(getWaitlistEventIDs, ConstWaitlistStatuses.Waiting)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var eventIDs []int
+	for rows.Next() {
+		var eventID int
+		if err := rows.Scan(&eventID); err != nil {
+			return nil, err
+		}
+		eventIDs = append(eventIDs, eventID)
+	}
+
+	return eventIDs, nil
+}
+
+func (db *DB) GetWaitingEntries(eventID int) ([]models.WaitlistEntry, error) {
+	stmt, err := db.PrepareNamed(getWaitingEntries)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := stmt.Query(map[string]interface{}{
+		"event_id": eventID,
+		"waiting":  ConstWaitlistStatuses.Waiting,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	var entries []models.WaitlistEntry
+	for rows.Next() {
+		entry, err := scanWaitlistEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		entries = append(entries, *entry)
+	}
+
+	return entries, nil
+}
+
+func (db *DB) OfferWaitlistEntry(entryID int, token string, expires time.Time) error {
+	stmt, err := db.PrepareNamed(offerWaitlistEntry)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"id":            entryID,
+		"token":         token,
+		"offer_expires": expires.UTC(),
+		"offered":       ConstWaitlistStatuses.Offered,
+		"waiting":       ConstWaitlistStatuses.Waiting,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrWaitlistEntryInvalid
+	}
+
+	return nil
+}
+
+func (db *DB) PurchaseWaitlistEntry(entryID int, orderID int) error {
+	stmt, err := db.PrepareNamed(purchaseWaitlistEntry)
+	if err != nil {
+		return err
+	}
+
+	args := map[string]interface{}{
+		"id":        entryID,
+		"order_id":  orderID,
+		"purchased": ConstWaitlistStatuses.Purchased,
+		"offered":   ConstWaitlistStatuses.Offered,
+	}
+
+	result, err := stmt.Exec(args)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return ErrWaitlistEntryInvalid
+	}
+
+	return nil
+}
+
+// GetEventAvailableTickets returns how many tickets can still be sold for
+// the event, discounting the paid orders, the waitlist holds and the tickets
+// left in the reseller allotments. The hold of excludeEntryID is not
+// discounted, so its owner can use it. Events without capacity return -1.
+func (db *DB) GetEventAvailableTickets(eventID int, excludeEntryID int) (int, error) {
+	event, err := db.GetEventByID(eventID)
+	if err != nil {
+		return 0, err
+	}
+
+	if event == nil || event.Capacity == 0 {
+		return -1, nil
+	}
+
+	return eventAvailableTickets(db, eventID, event.Capacity, excludeEntryID, 0, 0)
+}
+
+// lockEventAvailableTicketsTx is GetEventAvailableTickets locking the event
+// until the transaction ends, so concurrent sales check its capacity one
+// after the other. The tickets left in excludeAllotmentID are available too,
+// for its reseller, and so are the ones held by the unpaid excludeOrderID.
+func lockEventAvailableTicketsTx(tx Tx, eventID int, excludeEntryID int, excludeAllotmentID int, excludeOrderID int) (int, error) {
+	var capacity int
+	if err := tx.QueryRow(getEventCapacityForUpdate, eventID).Scan(&capacity); err != nil {
+		return 0, err
+	}
+
+	if capacity == 0 {
+		return -1, nil
+	}
+
+	return eventAvailableTickets(tx, eventID, capacity, excludeEntryID, excludeAllotmentID, excludeOrderID)
+}
+
+// checkEventCapacityTx locks the event and returns ErrEventSoldOut when it
+// can't take the tickets.
+func checkEventCapacityTx(tx Tx, eventID int, tickets int, excludeEntryID int, excludeAllotmentID int, excludeOrderID int) error {
+	available, err := lockEventAvailableTicketsTx(tx, eventID, excludeEntryID, excludeAllotmentID, excludeOrderID)
+	if err != nil {
+		return err
+	}
+
+	if available >= 0 && available < tickets {
+		return ErrEventSoldOut
+	}
+
+	return nil
+}
+
+// eventAvailableTickets is the capacity left after the paid orders and the
+// seats held by unpaid orders, waitlist offers and reseller allotments.
+func eventAvailableTickets(c conn, eventID int, capacity int, excludeEntryID int, excludeAllotmentID int, excludeOrderID int) (int, error) {
+	sold, err := eventTicketsSold(c, eventID)
+	if err != nil {
+		return 0, err
+	}
+
+	var pending int
+	row := c.QueryRow(getEventPendingTickets, eventID, excludeOrderID, ConstPaymentStatuses.Processing.ID, ConstPaymentStatuses.Approved.ID)
+	if err := row.Scan(&pending); err != nil {
+		return 0, err
+	}
+
+	var held int
+	row = c.QueryRow(getEventHeldTickets, eventID, excludeEntryID, ConstWaitlistStatuses.Offered)
+	if err := row.Scan(&held); err != nil {
+		return 0, err
+	}
+
+	var allotted int
+	row = c.QueryRow(getEventAllotmentHeldTickets, ConstPaymentStatuses.Approved.ID, eventID, excludeAllotmentID)
+	if err := row.Scan(&allotted); err != nil {
+		return 0, err
+	}
+
+	available := capacity - sold - pending - held - allotted
+	if available < 0 {
+		available = 0
+	}
+
+	return available, nil
+}
@@ -0,0 +1,170 @@
+package dte
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	io "io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dteContentType = `application/json`
+
+	// Document types as defined by the SII.
+	TypeFactura    = 33
+	TypeBoleta     = 39
+	TypeCreditNote = 61
+
+	// CodeVoidDocument is the reference code of a credit note that voids the
+	// whole referenced document.
+	CodeVoidDocument = 1
+)
+
+// Provider issues electronic tax documents (DTE) before the SII.
+type Provider interface {
+	Issue(document *Document) (*IssuedDocument, error)
+}
+
+type Document struct {
+	Type      int        `json:"type"`
+	Date      string     `json:"date"`
+	Receiver  *Receiver  `json:"receiver,omitempty"`
+	Items     []Item     `json:"items"`
+	Total     int        `json:"total"`
+	Reference *Reference `json:"reference,omitempty"`
+}
+
+// Receiver is the buyer of the document. Boletas may omit it, facturas
+// require the business details of the company.
+type Receiver struct {
+	RUT          string `json:"rut"`
+	BusinessName string `json:"business_name"`
+	Activity     string `json:"activity,omitempty"`
+	Address      string `json:"address,omitempty"`
+	Commune      string `json:"commune,omitempty"`
+	Email        string `json:"email,omitempty"`
+}
+
+// Item prices include taxes. Exempt items don't pay IVA.
+type Item struct {
+	Name      string `json:"name"`
+	Quantity  int    `json:"quantity"`
+	UnitPrice int    `json:"unit_price"`
+	Total     int    `json:"total"`
+	Exempt    bool   `json:"exempt,omitempty"`
+}
+
+type Reference struct {
+	Type   int    `json:"type"`
+	Folio  int    `json:"folio"`
+	Date   string `json:"date"`
+	Code   int    `json:"code"`
+	Reason string `json:"reason"`
+}
+
+type IssuedDocument struct {
+	Type    int    `json:"type"`
+	Folio   int    `json:"folio"`
+	PDFURL  string `json:"pdf_url"`
+	TrackID string `json:"track_id"`
+}
+
+// HTTPProvider issues the documents through the API of a DTE provider
+// authorized by the SII.
+type HTTPProvider struct {
+	BaseURL       string
+	Token         string
+	IssuerRUT     string
+	DocumentsPath string
+}
+
+func (p *HTTPProvider) Issue(document *Document) (*IssuedDocument, error) {
+	requestBody, err := json.Marshal(struct {
+		IssuerRUT string `json:"issuer_rut"`
+		*Document
+	}{
+		IssuerRUT: p.IssuerRUT,
+		Document:  document,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	request, err := http.NewRequest(http.MethodPost, fmt.Sprintf("%s%s", p.BaseURL, p.DocumentsPath), bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", dteContentType)
+	request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", p.Token))
+
+	client := http.Client{Timeout: 30 * time.Second}
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	responseBody, err := io.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode != http.StatusCreated && response.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("bad response %d: %s", response.StatusCode, responseBody)
+	}
+
+	var issued IssuedDocument
+	if err := json.Unmarshal(responseBody, &issued); err != nil {
+		return nil, err
+	}
+
+	if issued.Folio == 0 {
+		return nil, errors.New("DTE provider didn't return a folio")
+	}
+
+	if issued.Type == 0 {
+		issued.Type = document.Type
+	}
+
+	return &issued, nil
+}
+
+// FakeProvider issues documents locally with consecutive folios per type. It
+// is meant for development and tests, nothing is sent to the SII.
+type FakeProvider struct {
+	BaseURL string
+
+	mutex  sync.Mutex
+	folios map[int]int
+}
+
+func (p *FakeProvider) Issue(document *Document) (*IssuedDocument, error) {
+	if document.Type == TypeFactura && (document.Receiver == nil || document.Receiver.RUT == "") {
+		return nil, errors.New("facturas require the receiver RUT")
+	}
+
+	if document.Type == TypeCreditNote && document.Reference == nil {
+		return nil, errors.New("credit notes require a reference")
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.folios == nil {
+		p.folios = make(map[int]int)
+	}
+	p.folios[document.Type]++
+	folio := p.folios[document.Type]
+
+	return &IssuedDocument{
+		Type:    document.Type,
+		Folio:   folio,
+		PDFURL:  fmt.Sprintf("%s/dte/%d/%d.pdf", p.BaseURL, document.Type, folio),
+		TrackID: fmt.Sprintf("fake-%d-%d", document.Type, folio),
+	}, nil
+}
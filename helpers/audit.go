@@ -0,0 +1,75 @@
+package helpers
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+type auditChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// AuditSnapshot marshals an entity as it is stored in the audit log. A nil
+// entity is stored as NULL.
+func AuditSnapshot(entity interface{}) (json.RawMessage, error) {
+	if entity == nil || reflect.ValueOf(entity).Kind() == reflect.Ptr && reflect.ValueOf(entity).IsNil() {
+		return nil, nil
+	}
+	return json.Marshal(entity)
+}
+
+// AuditDiff compares two snapshots field by field and returns the changed
+// fields keyed by their dotted path, e.g. {"additional.dni": {"before": ...,
+// "after": ...}}. Arrays are compared as a whole.
+func AuditDiff(before json.RawMessage, after json.RawMessage) (json.RawMessage, error) {
+	if before == nil && after == nil {
+		return nil, nil
+	}
+
+	var beforeValue, afterValue interface{}
+	if before != nil {
+		if err := json.Unmarshal(before, &beforeValue); err != nil {
+			return nil, err
+		}
+	}
+	if after != nil {
+		if err := json.Unmarshal(after, &afterValue); err != nil {
+			return nil, err
+		}
+	}
+
+	changes := make(map[string]auditChange)
+	auditDiffValues("", beforeValue, afterValue, changes)
+	if len(changes) == 0 {
+		return nil, nil
+	}
+
+	return json.Marshal(changes)
+}
+
+func auditDiffValues(path string, before interface{}, after interface{}, changes map[string]auditChange) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	if (beforeIsMap || before == nil) && (afterIsMap || after == nil) && (beforeIsMap || afterIsMap) {
+		keys := make(map[string]bool)
+		for key := range beforeMap {
+			keys[key] = true
+		}
+		for key := range afterMap {
+			keys[key] = true
+		}
+		for key := range keys {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			auditDiffValues(childPath, beforeMap[key], afterMap[key], changes)
+		}
+		return
+	}
+
+	if !reflect.DeepEqual(before, after) {
+		changes[path] = auditChange{Before: before, After: after}
+	}
+}
@@ -0,0 +1,167 @@
+package helpers
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"io"
+)
+
+const (
+	ExportFormatCSV  = "csv"
+	ExportFormatXLSX = "xlsx"
+)
+
+// ExportWriter writes a table row by row, so big exports don't have to be
+// kept in memory. Cells can be strings or integers.
+type ExportWriter interface {
+	Write(row []interface{}) error
+	Close() error
+}
+
+func NewExportWriter(format string, w io.Writer) (ExportWriter, error) {
+	switch format {
+	case ExportFormatCSV:
+		return newCSVExportWriter(w)
+	case ExportFormatXLSX:
+		return newXLSXExportWriter(w)
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+func ExportContentType(format string) string {
+	if format == ExportFormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+type csvExportWriter struct {
+	writer *csv.Writer
+}
+
+func newCSVExportWriter(w io.Writer) (*csvExportWriter, error) {
+	// The byte order mark makes Excel read the accents as UTF-8.
+	if _, err := w.Write([]byte("\xEF\xBB\xBF")); err != nil {
+		return nil, err
+	}
+	return &csvExportWriter{writer: csv.NewWriter(w)}, nil
+}
+
+func (e *csvExportWriter) Write(row []interface{}) error {
+	record := make([]string, len(row))
+	for i, cell := range row {
+		record[i] = fmt.Sprint(cell)
+	}
+	return e.writer.Write(record)
+}
+
+func (e *csvExportWriter) Close() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// xlsxExportWriter writes a workbook with a single sheet. The sheet is the
+// last file of the zip, so its rows are streamed as they come.
+type xlsxExportWriter struct {
+	zip   *zip.Writer
+	sheet io.Writer
+	row   int
+}
+
+var xlsxFiles = []struct {
+	Name    string
+	Content string
+}{
+	{
+		Name: "[Content_Types].xml",
+		Content: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`,
+	},
+	{
+		Name: "_rels/.rels",
+		Content: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`,
+	},
+	{
+		Name: "xl/workbook.xml",
+		Content: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="Datos" sheetId="1" r:id="rId1"/></sheets></workbook>`,
+	},
+	{
+		Name: "xl/_rels/workbook.xml.rels",
+		Content: `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`,
+	},
+}
+
+func newXLSXExportWriter(w io.Writer) (*xlsxExportWriter, error) {
+	z := zip.NewWriter(w)
+	for _, file := range xlsxFiles {
+		f, err := z.Create(file.Name)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := io.WriteString(f, file.Content); err != nil {
+			return nil, err
+		}
+	}
+
+	sheet, err := z.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(sheet, `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`); err != nil {
+		return nil, err
+	}
+
+	return &xlsxExportWriter{zip: z, sheet: sheet}, nil
+}
+
+func (e *xlsxExportWriter) Write(row []interface{}) error {
+	e.row++
+	if _, err := fmt.Fprintf(e.sheet, `<row r="%d">`, e.row); err != nil {
+		return err
+	}
+
+	for _, cell := range row {
+		var err error
+		switch value := cell.(type) {
+		case int:
+			_, err = fmt.Fprintf(e.sheet, `<c t="n"><v>%d</v></c>`, value)
+		case int64:
+			_, err = fmt.Fprintf(e.sheet, `<c t="n"><v>%d</v></c>`, value)
+		default:
+			if _, err = io.WriteString(e.sheet, `<c t="inlineStr"><is><t xml:space="preserve">`); err != nil {
+				return err
+			}
+			if err = xml.EscapeText(e.sheet, []byte(fmt.Sprint(value))); err != nil {
+				return err
+			}
+			_, err = io.WriteString(e.sheet, `</t></is></c>`)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := io.WriteString(e.sheet, `</row>`)
+	return err
+}
+
+func (e *xlsxExportWriter) Close() error {
+	if _, err := io.WriteString(e.sheet, `</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	return e.zip.Close()
+}
+
+// ExportBool formats a flag the way the backoffice shows it.
+func ExportBool(value bool) string {
+	if value {
+		return "Sí"
+	}
+	return "No"
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"reflect"
 	"time"
+	"unicode"
 
 	"github.com/thedevsaddam/govalidator"
 )
@@ -92,6 +93,31 @@ func init() {
 		}
 		return nil
 	})
+	govalidator.AddCustomRule("password_policy", func(field string, rule string, message string, value interface{}) error {
+		rv := reflect.ValueOf(value)
+		if rv.Kind() == reflect.String {
+			password := value.(string)
+			if password == "" {
+				return nil
+			}
+			var hasLetter, hasDigit bool
+			for _, c := range password {
+				if unicode.IsLetter(c) {
+					hasLetter = true
+				}
+				if unicode.IsDigit(c) {
+					hasDigit = true
+				}
+			}
+			if len([]rune(password)) < 8 || !hasLetter || !hasDigit {
+				if message != "" {
+					return fmt.Errorf(message)
+				}
+				return fmt.Errorf("The %s field must have at least 8 characters including letters and numbers", field)
+			}
+		}
+		return nil
+	})
 	govalidator.AddCustomRule("hour_ISO8601", func(field string, rule string, message string, value interface{}) error {
 		dateTimeLayoutISO8601 := "15:04"
 		rv := reflect.ValueOf(value)
@@ -2,8 +2,13 @@ package helpers
 
 import (
 	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 	"unicode"
 
@@ -47,6 +52,114 @@ func Contains(a []int, x int) bool {
 	return false
 }
 
+// GetRequestIP returns the address of the client. The X-Forwarded-For and
+// X-Real-IP headers can be set by anyone, so they're only read when the
+// request comes from one of the configured trusted proxies. The client is
+// then the right-most X-Forwarded-For hop that isn't a trusted proxy.
+func GetRequestIP(ctx *config.AppContext, r *http.Request) string {
+	remoteIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		remoteIP = host
+	}
+
+	proxies := parseTrustedProxies(ctx.Config.TrustedProxies)
+	if !isTrustedProxy(proxies, remoteIP) {
+		return remoteIP
+	}
+
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		var hops []string
+		for _, hop := range strings.Split(strings.Join(forwarded, ","), ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+
+		for i := len(hops) - 1; i >= 0; i-- {
+			if !isTrustedProxy(proxies, hops[i]) {
+				return hops[i]
+			}
+		}
+
+		// Every hop is a trusted proxy, the first one got the request from
+		// the client.
+		if len(hops) > 0 {
+			return hops[0]
+		}
+	}
+
+	if realIP := strings.TrimSpace(r.Header.Get("X-Real-IP")); realIP != "" {
+		return realIP
+	}
+
+	return remoteIP
+}
+
+// parseTrustedProxies reads a comma separated list of addresses and CIDR
+// ranges. Invalid entries are ignored.
+func parseTrustedProxies(value string) []*net.IPNet {
+	var proxies []*net.IPNet
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				continue
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			continue
+		}
+
+		proxies = append(proxies, network)
+	}
+
+	return proxies
+}
+
+func isTrustedProxy(proxies []*net.IPNet, address string) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+
+	for _, proxy := range proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func GenerateRandomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
 func HashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
@@ -0,0 +1,97 @@
+package helpers
+
+import (
+	"net/http"
+	"testing"
+
+	"bitbucket.org/parqueoasis/backend/config"
+)
+
+func TestGetRequestIP(t *testing.T) {
+	ctx := &config.AppContext{
+		Config: config.Configuration{
+			TrustedProxies: "10.0.0.0/8, 192.168.1.10",
+		},
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		realIP     string
+		want       string
+	}{
+		{
+			name:       "untrusted client spoofing the headers",
+			remoteAddr: "203.0.113.7:51234",
+			forwarded:  []string{"1.2.3.4"},
+			realIP:     "1.2.3.4",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "trusted proxy",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "spoofed hop before the client",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"1.2.3.4, 203.0.113.7"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "chain of trusted proxies",
+			remoteAddr: "192.168.1.10:443",
+			forwarded:  []string{"1.2.3.4, 203.0.113.7", "10.1.2.3"},
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "only trusted hops",
+			remoteAddr: "10.0.0.5:443",
+			forwarded:  []string{"10.1.2.3, 10.0.0.6"},
+			want:       "10.1.2.3",
+		},
+		{
+			name:       "real ip from a trusted proxy",
+			remoteAddr: "10.0.0.5:443",
+			realIP:     "203.0.113.7",
+			want:       "203.0.113.7",
+		},
+		{
+			name:       "no headers",
+			remoteAddr: "10.0.0.5:443",
+			want:       "10.0.0.5",
+		},
+	}
+
+	for _, test := range tests {
+		r := &http.Request{
+			RemoteAddr: test.remoteAddr,
+			Header:     http.Header{},
+		}
+		for _, value := range test.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if test.realIP != "" {
+			r.Header.Set("X-Real-IP", test.realIP)
+		}
+
+		if got := GetRequestIP(ctx, r); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestGetRequestIPWithoutTrustedProxies(t *testing.T) {
+	r := &http.Request{
+		RemoteAddr: "10.0.0.5:443",
+		Header: http.Header{
+			"X-Forwarded-For": []string{"203.0.113.7"},
+		},
+	}
+
+	if got := GetRequestIP(&config.AppContext{}, r); got != "10.0.0.5" {
+		t.Errorf("got %q, want the remote address", got)
+	}
+}
@@ -82,6 +82,9 @@ func GenerateOrderPDF(order *models.Order) (*bytes.Buffer, error) {
 		Date:          order.Event.StartDateTime.Format("02-01-2006"),
 		EventType:     order.Event.Type.Name,
 		Price:         order.Price,
+		NetAmount:     order.NetAmount,
+		TaxAmount:     order.TaxAmount,
+		TaxRate:       models.TaxRateLabel(order.TaxRate),
 		Image:         base64,
 		TransactionID: order.TransactionID,
 		Tickets:       order.Tickets,
@@ -97,6 +100,62 @@ func GenerateOrderPDF(order *models.Order) (*bytes.Buffer, error) {
 	return mem, nil
 }
 
+func GenerateGiftVoucherPDF(voucher *models.GiftVoucher) (*bytes.Buffer, error) {
+	funcName := "GenerateGiftVoucherPDF"
+	r := RequestPdf{}
+
+	img, err := qrcode.New(voucher.Code, qrcode.Medium)
+	if err != nil {
+		return nil, errors.Wrap(err, funcName)
+	}
+
+	base64, err := EncodeImage(img.Image(256))
+	if err != nil {
+		return nil, errors.Wrap(err, funcName)
+	}
+
+	data := models.GiftVoucherPDFHTML{
+		Code:          voucher.Code,
+		RecipientName: voucher.RecipientName,
+		BuyerName:     voucher.User.Firstname,
+		Message:       voucher.Message,
+		Amount:        voucher.Amount,
+		Tickets:       voucher.Tickets,
+		Expires:       voucher.Expires.Format("02-01-2006"),
+		Image:         base64,
+	}
+	if voucher.EventType != nil {
+		data.EventType = voucher.EventType.Name
+	}
+
+	if err := r.ParseTemplate("./templates/pdf/gift_voucher.html", data); err != nil {
+		return nil, errors.Wrap(err, funcName)
+	}
+
+	mem, err := r.GeneratePDF()
+	if err != nil {
+		return nil, errors.Wrap(err, funcName)
+	}
+
+	return mem, nil
+}
+
+func GenerateCashierShiftPDF(data models.CashierShiftPDFHTML) (*bytes.Buffer, error) {
+	funcName := "GenerateCashierShiftPDF"
+	r := RequestPdf{}
+
+	if err := r.ParseTemplate("./templates/pdf/cashier_shift.html", data); err != nil {
+		return nil, errors.Wrap(err, funcName)
+	}
+
+	mem, err := r.GeneratePDF()
+	if err != nil {
+		return nil, errors.Wrap(err, funcName)
+	}
+
+	return mem, nil
+}
+
 func EncodeImage(m image.Image) (string, error) {
 	funcName := "EncodeImage"
 	var buf bytes.Buffer
@@ -105,3 +164,13 @@ func EncodeImage(m image.Image) (string, error) {
 	}
 	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
 }
+
+// QRCodeDataURI returns the content as a PNG QR code data URI, ready to be
+// used as the src of an img tag.
+func QRCodeDataURI(content string) (string, error) {
+	png, err := qrcode.Encode(content, qrcode.Medium, 256)
+	if err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png), nil
+}
@@ -0,0 +1,46 @@
+package helpers
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+// UserDataExportZip bundles a data export as a zip with one JSON file per
+// section.
+func UserDataExportZip(export *models.UserDataExport) (*bytes.Buffer, error) {
+	files := []struct {
+		Name string
+		Data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"orders.json", export.Orders},
+		{"payments.json", export.Payments},
+		{"campings.json", export.Campings},
+		{"scans.json", export.Scans},
+		{"login_attempts.json", export.LoginAttempts},
+	}
+
+	buf := new(bytes.Buffer)
+	zw := zip.NewWriter(buf)
+	for _, file := range files {
+		f, err := zw.Create(file.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.Data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
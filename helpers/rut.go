@@ -0,0 +1,101 @@
+package helpers
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+// rutCheckDigit computes the verifier digit of a RUT body using the modulo 11
+// algorithm.
+func rutCheckDigit(body string) string {
+	sum := 0
+	factor := 2
+	for i := len(body) - 1; i >= 0; i-- {
+		sum += int(body[i]-'0') * factor
+		factor++
+		if factor > 7 {
+			factor = 2
+		}
+	}
+
+	switch digit := 11 - sum%11; digit {
+	case 11:
+		return "0"
+	case 10:
+		return "K"
+	default:
+		return strconv.Itoa(digit)
+	}
+}
+
+// NormalizeRUT accepts a RUT with or without dots and hyphen, validates its
+// check digit and returns it in the canonical form 12345678-5.
+func NormalizeRUT(rut string) (string, bool) {
+	rut = strings.ToUpper(rut)
+	rut = strings.NewReplacer(".", "", "-", "", " ", "").Replace(rut)
+	if len(rut) < 2 {
+		return "", false
+	}
+
+	body := strings.TrimLeft(rut[:len(rut)-1], "0")
+	dv := rut[len(rut)-1:]
+	if body == "" || len(body) > 8 {
+		return "", false
+	}
+
+	for _, c := range body {
+		if !unicode.IsDigit(c) {
+			return "", false
+		}
+	}
+
+	if rutCheckDigit(body) != dv {
+		return "", false
+	}
+
+	return body + "-" + dv, true
+}
+
+// FormatRUT returns a canonical RUT with thousands separators, 12.345.678-5.
+func FormatRUT(rut string) string {
+	parts := strings.Split(rut, "-")
+	if len(parts) != 2 {
+		return rut
+	}
+
+	body := parts[0]
+	var formatted string
+	for len(body) > 3 {
+		formatted = "." + body[len(body)-3:] + formatted
+		body = body[:len(body)-3]
+	}
+
+	return body + formatted + "-" + parts[1]
+}
+
+// NormalizeDNI normalizes a document number according to its type. RUTs are
+// validated and stored canonically, other documents are only upper cased and
+// stripped of separators.
+func NormalizeDNI(documentType string, dni string) (string, bool) {
+	if documentType == "" || documentType == models.ConstDocumentTypes.RUT {
+		return NormalizeRUT(dni)
+	}
+
+	dni = strings.ToUpper(dni)
+	dni = strings.NewReplacer(".", "", "-", "", " ", "").Replace(dni)
+	if dni == "" {
+		return "", false
+	}
+
+	return dni, true
+}
+
+func FormatDNI(documentType string, dni string) string {
+	if documentType == "" || documentType == models.ConstDocumentTypes.RUT {
+		return FormatRUT(dni)
+	}
+	return dni
+}
@@ -0,0 +1,78 @@
+package helpers
+
+import (
+	"testing"
+
+	"bitbucket.org/parqueoasis/backend/models"
+)
+
+func TestNormalizeRUT(t *testing.T) {
+	tests := []struct {
+		rut   string
+		want  string
+		valid bool
+	}{
+		{rut: "12.345.678-5", want: "12345678-5", valid: true},
+		{rut: "12345678-5", want: "12345678-5", valid: true},
+		{rut: "123456785", want: "12345678-5", valid: true},
+		{rut: " 11.111.111-1 ", want: "11111111-1", valid: true},
+		{rut: "1.000.005-k", want: "1000005-K", valid: true},
+		{rut: "10.000.013-K", want: "10000013-K", valid: true},
+		{rut: "1.000.013-0", want: "1000013-0", valid: true},
+		{rut: "012.345.678-5", want: "12345678-5", valid: true},
+		{rut: "6-K", want: "6-K", valid: true},
+		{rut: "12.345.678-4"},
+		{rut: "1.000.005-0"},
+		{rut: "12.3A5.678-5"},
+		{rut: "123.456.789-2"},
+		{rut: "0-0"},
+		{rut: "5"},
+		{rut: ""},
+	}
+
+	for _, test := range tests {
+		got, valid := NormalizeRUT(test.rut)
+		if valid != test.valid || got != test.want {
+			t.Errorf("NormalizeRUT(%q) = %q, %v; want %q, %v", test.rut, got, valid, test.want, test.valid)
+		}
+	}
+}
+
+func TestFormatRUT(t *testing.T) {
+	tests := []struct {
+		rut  string
+		want string
+	}{
+		{rut: "12345678-5", want: "12.345.678-5"},
+		{rut: "1000005-K", want: "1.000.005-K"},
+		{rut: "999-3", want: "999-3"},
+		{rut: "invalid", want: "invalid"},
+	}
+
+	for _, test := range tests {
+		if got := FormatRUT(test.rut); got != test.want {
+			t.Errorf("FormatRUT(%q) = %q, want %q", test.rut, got, test.want)
+		}
+	}
+}
+
+func TestNormalizeDNI(t *testing.T) {
+	tests := []struct {
+		documentType string
+		dni          string
+		want         string
+		valid        bool
+	}{
+		{documentType: "", dni: "12.345.678-5", want: "12345678-5", valid: true},
+		{documentType: models.ConstDocumentTypes.RUT, dni: "12.345.678-4"},
+		{documentType: models.ConstDocumentTypes.Passport, dni: "ab-123 456", want: "AB123456", valid: true},
+		{documentType: models.ConstDocumentTypes.Passport, dni: " - "},
+	}
+
+	for _, test := range tests {
+		got, valid := NormalizeDNI(test.documentType, test.dni)
+		if valid != test.valid || got != test.want {
+			t.Errorf("NormalizeDNI(%q, %q) = %q, %v; want %q, %v", test.documentType, test.dni, got, valid, test.want, test.valid)
+		}
+	}
+}
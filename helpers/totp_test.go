@@ -0,0 +1,92 @@
+package helpers
+
+import (
+	"testing"
+	"time"
+)
+
+// totpTestSecret is the RFC 6238 SHA1 seed "12345678901234567890" in base32.
+const totpTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	// RFC 6238 vectors, truncated to 6 digits.
+	generated := time.Unix(1111111109, 0)
+	code := "081804"
+	step := int64(37037036)
+
+	tests := []struct {
+		name  string
+		code  string
+		now   time.Time
+		valid bool
+	}{
+		{name: "current step", code: code, now: generated, valid: true},
+		{name: "previous step", code: code, now: generated.Add(30 * time.Second), valid: true},
+		{name: "next step", code: code, now: generated.Add(-30 * time.Second), valid: true},
+		{name: "two steps late", code: code, now: generated.Add(60 * time.Second)},
+		{name: "two steps early", code: code, now: generated.Add(-60 * time.Second)},
+		{name: "surrounding spaces", code: " " + code + " ", now: generated, valid: true},
+		{name: "wrong code", code: "081805", now: generated},
+		{name: "short code", code: "81804", now: generated},
+		{name: "empty code", code: "", now: generated},
+	}
+
+	for _, test := range tests {
+		got, valid := ValidateTOTP(totpTestSecret, test.code, test.now)
+		if valid != test.valid {
+			t.Errorf("%s: got valid %v, want %v", test.name, valid, test.valid)
+			continue
+		}
+		if valid && got != step {
+			t.Errorf("%s: got step %d, want the step the code was generated at, %d", test.name, got, step)
+		}
+	}
+}
+
+func TestValidateTOTPVectors(t *testing.T) {
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, test := range tests {
+		if _, valid := ValidateTOTP(totpTestSecret, test.code, time.Unix(test.unix, 0)); !valid {
+			t.Errorf("code %s rejected at %d", test.code, test.unix)
+		}
+	}
+}
+
+func TestValidateTOTPSecret(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	if _, valid := ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "081804", now); !valid {
+		t.Error("lower case secret rejected")
+	}
+
+	if _, valid := ValidateTOTP("not base32!", "081804", now); valid {
+		t.Error("invalid secret accepted")
+	}
+}
+
+func TestGeneratedTOTPSecret(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	step := now.Unix() / totpPeriod
+	got, valid := ValidateTOTP(secret, totpCode(key, uint64(step)), now)
+	if !valid || got != step {
+		t.Errorf("got step %d, %v; want %d", got, valid, step)
+	}
+}
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"time"
 
 	"bitbucket.org/parqueoasis/backend/api"
+	"bitbucket.org/parqueoasis/backend/db"
+	"bitbucket.org/parqueoasis/backend/helpers"
 	"bitbucket.org/parqueoasis/backend/server"
 	"github.com/joho/godotenv"
 	"github.com/urfave/cli"
@@ -49,6 +54,98 @@ func main() {
 				return nil
 			},
 		},
+		{
+			Name:  "normalize-dni",
+			Usage: "This command normalizes the RUTs stored in user_additional and reports duplicates",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "only report, without updating the database",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return NormalizeUserDNIs(c.Bool("dry-run"))
+			},
+		},
+		{
+			Name:  "export-user-data",
+			Usage: "This command exports the personal data of a user as a zip file",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "id",
+					Usage: "user id",
+				},
+				cli.StringFlag{
+					Name:  "output",
+					Usage: "zip file path, defaults to datos-<id>.zip",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return ExportUserData(c.Int("id"), c.String("output"))
+			},
+		},
+		{
+			Name:  "erase-user-data",
+			Usage: "This command anonymizes the personal data of a client, keeping its financial records",
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "id",
+					Usage: "user id",
+				},
+				cli.IntFlag{
+					Name:  "actor",
+					Usage: "id of the admin user responsible for the erasure",
+				},
+				cli.StringFlag{
+					Name:  "reason",
+					Usage: "reason of the erasure",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return EraseUserData(c.Int("id"), c.Int("actor"), c.String("reason"))
+			},
+		},
+		{
+			Name:  "process-waitlist",
+			Usage: "This command expires the waitlist offers and offers the freed tickets, the server also processes them periodically",
+			Action: func(c *cli.Context) error {
+				return ProcessWaitlists()
+			},
+		},
+		{
+			Name:  "issue-tax-documents",
+			Usage: "This command issues the pending boletas, facturas and credit notes, meant to run periodically",
+			Action: func(c *cli.Context) error {
+				return IssueTaxDocuments()
+			},
+		},
+		{
+			Name:  "send-daily-report",
+			Usage: "This command emails the admins the attendance and sales of a day, the server also sends it every day at DAILY_REPORT_TIME",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "date",
+					Usage: "day of the report as YYYY-MM-DD, defaults to today",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return SendDailyReport(c.String("date"))
+			},
+		},
+		{
+			Name:  "release-voucher-reservations",
+			Usage: "This command gives back the gift voucher balance of the orders not paid in time, the server also releases it periodically",
+			Action: func(c *cli.Context) error {
+				return ReleaseVoucherReservations()
+			},
+		},
+		{
+			Name:  "send-visit-reminders",
+			Usage: "This command emails the reminders of the visits starting soon, the server also sends them periodically",
+			Action: func(c *cli.Context) error {
+				return SendVisitReminders()
+			},
+		},
 	}
 	err := app.Run(os.Args)
 	if err != nil {
@@ -61,7 +158,186 @@ func StartServer(routes []*server.Route) {
 	ctx.CreateMySQLConnection()
 	ctx.CreateSMTPConnection()
 	ctx.CreateMercadoPagoIntegration()
+	ctx.CreateDTEProvider()
+	ctx.CreateEventBroker()
 	ctx.CreateNewSessionS3()
 
+	if ctx.Context.Config.Reminder.Enabled {
+		go api.RunVisitReminders(ctx.Context)
+	}
+	if ctx.Context.Config.Waitlist.Enabled {
+		go api.RunWaitlists(ctx.Context)
+	}
+	if ctx.Context.Config.DailyReport.Enabled {
+		go api.RunDailyReports(ctx.Context)
+	}
+	go api.RunVoucherReleases(ctx.Context)
+
 	server.UpServer(routes, ctx)
 }
+
+// NormalizeUserDNIs rewrites every RUT in its canonical form. Values that are
+// not valid RUTs are left untouched and reported, as well as the document
+// numbers shared by more than one user once normalized.
+func NormalizeUserDNIs(dryRun bool) error {
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	users, err := ctx.Context.DB.GetUserDNIs()
+	if err != nil {
+		return err
+	}
+
+	var updated, invalid int
+	dnis := make(map[string][]int)
+	for _, user := range users {
+		dni, ok := helpers.NormalizeDNI(user.Additional.DocumentType, user.Additional.DNI)
+		if !ok {
+			invalid++
+			log.Printf("invalid dni %q for user %d (%s)", user.Additional.DNI, user.ID, user.Email)
+			dnis[user.Additional.DNI] = append(dnis[user.Additional.DNI], user.ID)
+			continue
+		}
+
+		dnis[dni] = append(dnis[dni], user.ID)
+
+		if dni == user.Additional.DNI {
+			continue
+		}
+
+		updated++
+		log.Printf("user %d: %q -> %q", user.ID, user.Additional.DNI, dni)
+		if dryRun {
+			continue
+		}
+
+		if err := ctx.Context.DB.UpdateUserAdditionalDNI(user.Additional.ID, dni); err != nil {
+			return err
+		}
+	}
+
+	var duplicates int
+	for dni, userIDs := range dnis {
+		if len(userIDs) > 1 {
+			duplicates++
+			log.Printf("duplicated dni %q for users %v", dni, userIDs)
+		}
+	}
+
+	log.Printf("users: %d, normalized: %d, invalid: %d, duplicated dnis: %d, dry run: %t", len(users), updated, invalid, duplicates, dryRun)
+
+	return nil
+}
+
+func ExportUserData(userID int, output string) error {
+	if userID == 0 {
+		return errors.New("the id flag is required")
+	}
+	if output == "" {
+		output = fmt.Sprintf("datos-%d.zip", userID)
+	}
+
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	export, err := ctx.Context.DB.GetUserDataExport(userID)
+	if err != nil {
+		return err
+	}
+
+	if export == nil {
+		return fmt.Errorf("user %d not found", userID)
+	}
+
+	buf, err := helpers.UserDataExportZip(export)
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(output, buf.Bytes(), 0600); err != nil {
+		return err
+	}
+
+	log.Printf("user %d data exported to %s", userID, output)
+
+	return nil
+}
+
+func EraseUserData(userID int, actorID int, reason string) error {
+	if userID == 0 || actorID == 0 || reason == "" {
+		return errors.New("the id, actor and reason flags are required")
+	}
+
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	user, err := ctx.Context.DB.GetUserStatus(userID)
+	if err != nil {
+		return err
+	}
+
+	if user == nil {
+		return fmt.Errorf("user %d not found", userID)
+	}
+
+	for _, role := range user.Roles {
+		if role.ID != db.ConstRoles.Client {
+			return fmt.Errorf("user %d is not a client, only clients can be erased", userID)
+		}
+	}
+
+	if err := ctx.Context.DB.EraseUserData(userID, actorID, reason); err != nil {
+		return err
+	}
+
+	log.Printf("user %d data erased", userID)
+
+	return nil
+}
+
+func ProcessWaitlists() error {
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	ctx.CreateSMTPConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	return api.ProcessWaitlists(ctx.Context)
+}
+
+func IssueTaxDocuments() error {
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	ctx.CreateDTEProvider()
+	defer ctx.Context.SQLConn.Close()
+
+	return api.IssuePendingTaxDocuments(ctx.Context)
+}
+
+func SendDailyReport(date string) error {
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	ctx.CreateSMTPConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	return api.SendDailyReport(ctx.Context, date)
+}
+
+func SendVisitReminders() error {
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	ctx.CreateSMTPConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	return api.SendVisitReminders(ctx.Context)
+}
+
+func ReleaseVoucherReservations() error {
+	ctx := server.GetAppContext()
+	ctx.CreateMySQLConnection()
+	defer ctx.Context.SQLConn.Close()
+
+	return api.ReleaseVoucherReservations(ctx.Context)
+}
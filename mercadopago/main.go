@@ -7,6 +7,7 @@ import (
 	io "io/ioutil"
 	"net/http"
 	"strconv"
+	"time"
 
 	"bitbucket.org/parqueoasis/backend/models"
 	shortuuid "github.com/lithammer/shortuuid/v3"
@@ -34,6 +35,8 @@ type MPCreatePreferenceRequest struct {
 	ExternalReference string               `json:"external_reference"`
 	Items             []MPPreferenceItem   `json:"items"`
 	BackUrls          MPPreferenceBackUrls `json:"back_urls"`
+	Expires           bool                 `json:"expires,omitempty"`
+	ExpirationDateTo  string               `json:"expiration_date_to,omitempty"`
 }
 
 type MPPreferenceBackUrls struct {
@@ -59,7 +62,42 @@ type MPGetPaymentReponse struct {
 	ExternalReference string `json:"external_reference"`
 }
 
-func (mp *MP) MPCreatePreference(order *models.Order, baseURL string) (*MPCreatePreferenceResponse, error) {
+// MPCreatePreference creates the preference to pay an order. It can't be paid
+// after expires, when the seats of the order are no longer held.
+func (mp *MP) MPCreatePreference(order *models.Order, baseURL string, expires time.Time) (*MPCreatePreferenceResponse, error) {
+	item := MPPreferenceItem{
+		ID:          strconv.Itoa(order.ID),
+		Title:       "Entrada Parque",
+		Description: fmt.Sprintf("%s-%s", order.Event.StartDateTime.String(), order.Event.EndDateTime.String()),
+		Quantity:    order.Tickets,
+		UnitPrice:   order.Event.Price,
+	}
+
+	// Orders partially paid with a gift voucher are charged their remaining
+	// price as a single item.
+	if order.Price != order.Event.Price*order.Tickets {
+		item.Quantity = 1
+		item.UnitPrice = order.Price
+	}
+
+	return mp.createPreference(item, baseURL, expires)
+}
+
+// MPCreateChargePreference creates a preference for a single amount that is
+// not the price of an order, like the difference charged when rescheduling.
+func (mp *MP) MPCreateChargePreference(id string, title string, description string, amount int, baseURL string) (*MPCreatePreferenceResponse, error) {
+	item := MPPreferenceItem{
+		ID:          id,
+		Title:       title,
+		Description: description,
+		Quantity:    1,
+		UnitPrice:   amount,
+	}
+
+	return mp.createPreference(item, baseURL, time.Time{})
+}
+
+func (mp *MP) createPreference(item MPPreferenceItem, baseURL string, expires time.Time) (*MPCreatePreferenceResponse, error) {
 	requestBody := MPCreatePreferenceRequest{
 		NotificationURL:   fmt.Sprintf("%s%s", baseURL, mp.NotificationPath),
 		ExternalReference: shortuuid.New(),
@@ -69,16 +107,13 @@ func (mp *MP) MPCreatePreference(order *models.Order, baseURL string) (*MPCreate
 		},
 	}
 
-	item := MPPreferenceItem{
-		ID:          strconv.Itoa(order.ID),
-		Title:       "Entrada Parque",
-		Description: fmt.Sprintf("%s-%s", order.Event.StartDateTime.String(), order.Event.EndDateTime.String()),
-		Quantity:    order.Tickets,
-		UnitPrice:   order.Event.Price,
-	}
-
 	requestBody.Items = append(requestBody.Items, item)
 
+	if !expires.IsZero() {
+		requestBody.Expires = true
+		requestBody.ExpirationDateTo = expires.Format("2006-01-02T15:04:05.000-07:00")
+	}
+
 	responseBody, err := mpPost(fmt.Sprintf("%s%s?access_token=%s", mp.BaseURL, mp.PathPreferences, mp.Token), &requestBody)
 	if err != nil {
 		return nil, err
@@ -114,6 +149,12 @@ func (mp *MP) MPGetPayment(id string) (*MPGetPaymentReponse, error) {
 	return &response, nil
 }
 
+// MPRefundPayment refunds the whole amount of a payment.
+func (mp *MP) MPRefundPayment(id string) error {
+	_, err := mpPost(fmt.Sprintf("%s%s/refunds?access_token=%s", mp.GetPaymentURL, id, mp.Token), struct{}{})
+	return err
+}
+
 func mpPost(url string, body interface{}) ([]byte, error) {
 	requestBody, err := json.Marshal(body)
 	if err != nil {
@@ -0,0 +1,109 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"bitbucket.org/parqueoasis/backend/config"
+	"bitbucket.org/parqueoasis/backend/helpers"
+	"bitbucket.org/parqueoasis/backend/models"
+	"github.com/gorilla/mux"
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/negroni"
+)
+
+type auditEntry struct {
+	action   string
+	entity   string
+	entityID int
+	before   interface{}
+	after    interface{}
+}
+
+// Audit describes the change made by the handler, so that the audit log
+// records a meaningful action and the state of the entity before and after
+// it. Handlers that don't call it are still logged with the route as action.
+func (r *ResponseWriter) Audit(action string, entity string, entityID int, before interface{}, after interface{}) {
+	r.audit = &auditEntry{
+		action:   action,
+		entity:   entity,
+		entityID: entityID,
+		before:   before,
+		after:    after,
+	}
+}
+
+// AuditRequest appends an entry to the audit log for every mutating request
+// of an authenticated user. It runs after the handler, so failed requests
+// are recorded too along with their status code.
+func AuditRequest(appCtx *config.AppContext, w *ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+		return
+	}
+
+	userInfo := models.InfoUser{}
+	mapstructure.Decode(r.Context().Value("user"), &userInfo)
+	if userInfo.ID == 0 {
+		return
+	}
+
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+
+	entry := models.AuditLog{
+		Actor:           &models.User{ID: userInfo.ID},
+		Action:          r.Method + " " + path,
+		Entity:          strings.Split(strings.TrimPrefix(path, "/"), "/")[0],
+		Method:          r.Method,
+		Path:            r.URL.Path,
+		IP:              helpers.GetRequestIP(appCtx, r),
+		UserAgent:       r.UserAgent(),
+		RequestID:       RequestID(r),
+		ClientRequestID: r.Header.Get("X-Request-ID"),
+	}
+	if len(entry.ClientRequestID) > 64 {
+		entry.ClientRequestID = entry.ClientRequestID[:64]
+	}
+
+	if rw, ok := w.Writer.(negroni.ResponseWriter); ok {
+		entry.StatusCode = rw.Status()
+	}
+
+	vars := mux.Vars(r)
+	for _, key := range []string{"id", "order_id"} {
+		if id, err := strconv.Atoi(vars[key]); err == nil {
+			entry.EntityID = id
+			break
+		}
+	}
+
+	var err error
+	if w.audit != nil {
+		entry.Action = w.audit.action
+		entry.Entity = w.audit.entity
+		entry.EntityID = w.audit.entityID
+		if entry.Before, err = helpers.AuditSnapshot(w.audit.before); err == nil {
+			if entry.After, err = helpers.AuditSnapshot(w.audit.after); err == nil {
+				entry.Diff, err = helpers.AuditDiff(entry.Before, entry.After)
+			}
+		}
+		if err != nil {
+			config.GetLogger().WithFields(log.Fields{
+				"error": err.Error(),
+			}).Error("failed serializing audit entry")
+		}
+	}
+
+	if err := appCtx.DB.InsertAuditLog(&entry); err != nil {
+		config.GetLogger().WithFields(log.Fields{
+			"error":  err.Error(),
+			"action": entry.Action,
+		}).Error("failed inserting audit log")
+	}
+}
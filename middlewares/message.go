@@ -9,6 +9,18 @@ var Responses = struct {
 	EndTimeBeforeStartTime *NewRM
 	EndTimeBeforeNow       *NewRM
 	EventNotFound          *NewRM
+	TooManyLoginAttempts   *NewRM
+	AccountLocked          *NewRM
+	InvalidUnlockToken     *NewRM
+	TooManyRequests        *NewRM
+	InvalidRememberToken   *NewRM
+	InvalidTwoFactorToken  *NewRM
+	InvalidTwoFactorCode   *NewRM
+	TwoFactorNotSetUp      *NewRM
+	TwoFactorEnabled       *NewRM
+	TwoFactorNotEnabled    *NewRM
+	TwoFactorRequired      *NewRM
+	InvalidPassword        *NewRM
 }{
 	FailedValidations: &NewRM{
 		Language.English: "Failed field validations",
@@ -43,6 +55,54 @@ var Responses = struct {
 		Language.English: "EventNotFound",
 		Language.Spanish: "El evento no existe",
 	},
+	TooManyLoginAttempts: &NewRM{
+		Language.English: "Too many login attempts, try again later",
+		Language.Spanish: "Demasiados intentos de inicio de sesión, inténtalo más tarde",
+	},
+	AccountLocked: &NewRM{
+		Language.English: "Account temporarily locked, check your email to unlock it",
+		Language.Spanish: "La cuenta está bloqueada temporalmente, revisa tu correo para desbloquearla",
+	},
+	InvalidUnlockToken: &NewRM{
+		Language.English: "Invalid unlock token",
+		Language.Spanish: "El enlace de desbloqueo no es válido",
+	},
+	TooManyRequests: &NewRM{
+		Language.English: "Too many requests, try again later",
+		Language.Spanish: "Demasiadas solicitudes, inténtalo más tarde",
+	},
+	InvalidRememberToken: &NewRM{
+		Language.English: "The password reset link is invalid or has expired",
+		Language.Spanish: "El enlace para recuperar la contraseña no es válido o ha expirado",
+	},
+	InvalidTwoFactorToken: &NewRM{
+		Language.English: "The login session is invalid or has expired, log in again",
+		Language.Spanish: "La sesión de inicio no es válida o ha expirado, vuelve a iniciar sesión",
+	},
+	InvalidTwoFactorCode: &NewRM{
+		Language.English: "Invalid verification code",
+		Language.Spanish: "El código de verificación no es válido",
+	},
+	TwoFactorNotSetUp: &NewRM{
+		Language.English: "Two-factor authentication has not been set up",
+		Language.Spanish: "La verificación en dos pasos no ha sido configurada",
+	},
+	TwoFactorEnabled: &NewRM{
+		Language.English: "Two-factor authentication is already enabled",
+		Language.Spanish: "La verificación en dos pasos ya está activada",
+	},
+	TwoFactorNotEnabled: &NewRM{
+		Language.English: "Two-factor authentication is not enabled",
+		Language.Spanish: "La verificación en dos pasos no está activada",
+	},
+	TwoFactorRequired: &NewRM{
+		Language.English: "Two-factor authentication is required for your role",
+		Language.Spanish: "La verificación en dos pasos es obligatoria para tu rol",
+	},
+	InvalidPassword: &NewRM{
+		Language.English: "Invalid password",
+		Language.Spanish: "La contraseña no es válida",
+	},
 }
 
 type NewRM map[string]string
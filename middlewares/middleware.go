@@ -9,6 +9,7 @@ import (
 	"bitbucket.org/parqueoasis/backend/helpers"
 	"bitbucket.org/parqueoasis/backend/models"
 	"github.com/dgrijalva/jwt-go"
+	"github.com/google/uuid"
 	jwtmiddleware "github.com/mfuentesg/go-jwtmiddleware"
 	"github.com/mitchellh/mapstructure"
 	log "github.com/sirupsen/logrus"
@@ -36,11 +37,25 @@ func NewJWTMiddleware(secret []byte) *jwtmiddleware.Middleware {
 	)
 }
 
+// LoggerRequest tags the request with an ID generated by the server, returned
+// in the X-Request-ID header, so the logs and the audit log can be trusted to
+// correlate it. The ID sent by the client, if any, is logged apart.
 func LoggerRequest(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-	requestLogger := log.WithFields(log.Fields{"request_id": r.Header.Get("X-Request-ID"), "query": r.URL.Query(), "host": r.Host, "url": r.URL.Path, "headers": r.Header})
+	requestID := uuid.New().String()
+	rw.Header().Set("X-Request-ID", requestID)
+
+	requestLogger := log.WithFields(log.Fields{"request_id": requestID, "client_request_id": r.Header.Get("X-Request-ID"), "query": r.URL.Query(), "host": r.Host, "url": r.URL.Path, "headers": r.Header})
 	requestLogger.Info("logger_request")
 	config.SetLogger(requestLogger)
-	next(rw, r)
+
+	ctx := context.WithValue(r.Context(), string("request_id"), requestID)
+	next(rw, r.WithContext(ctx))
+}
+
+// RequestID returns the ID given to the request by LoggerRequest.
+func RequestID(r *http.Request) string {
+	requestID, _ := r.Context().Value("request_id").(string)
+	return requestID
 }
 
 func UserMiddleware() negroni.HandlerFunc {
@@ -114,3 +129,43 @@ func UserMiddleware() negroni.HandlerFunc {
 		next(rw, r)
 	})
 }
+
+// SessionMiddleware rejects tokens of users that have been deactivated or
+// whose sessions were revoked after the token was issued. It must run after
+// the JWT middleware, which verifies the token signature.
+func SessionMiddleware(appCtx *config.AppContext) negroni.HandlerFunc {
+	return negroni.HandlerFunc(func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+		userInfo := models.InfoUser{}
+		mapstructure.Decode(r.Context().Value("user"), &userInfo)
+		if userInfo.ID == 0 {
+			next(rw, r)
+			return
+		}
+
+		user, err := appCtx.DB.GetUserSession(userInfo.ID)
+		if err != nil {
+			a := &ResponseWriter{Writer: rw}
+			a.Error(http.StatusInternalServerError, "internal server error")
+			return
+		}
+
+		if user == nil || !user.Active {
+			a := &ResponseWriter{Writer: rw}
+			a.Error(http.StatusUnauthorized, "unauthorized", WithErrorScope("token"))
+			return
+		}
+
+		if user.SessionsRevokedAt != nil {
+			token := strings.Split(r.Header.Get("Authorization"), " ")
+			data, ok := helpers.ParserTokenUnverified(token[len(token)-1])
+			issuedAt, _ := data["iat"].(float64)
+			if !ok || int64(issuedAt) < user.SessionsRevokedAt.Unix() {
+				a := &ResponseWriter{Writer: rw}
+				a.Error(http.StatusUnauthorized, "unauthorized", WithErrorScope("token"))
+				return
+			}
+		}
+
+		next(rw, r)
+	})
+}
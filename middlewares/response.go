@@ -14,6 +14,8 @@ type ResponseWriter struct {
 	Writer   http.ResponseWriter
 	Logger   *log.Entry
 	Language string
+
+	audit *auditEntry
 }
 
 func NewResponseWriter(w http.ResponseWriter) *ResponseWriter {
@@ -0,0 +1,110 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// streamWriteTimeout bounds each write, so a stuck client doesn't block the
+// stream forever.
+const streamWriteTimeout = 10 * time.Second
+
+// ResponseController keeps the controller of the server's own response
+// writer in the request context. The writers wrapping it further down, like
+// negroni's, don't expose it, and streams need it to extend the server's
+// write timeout.
+func ResponseController(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), string("response_controller"), rc)))
+	})
+}
+
+// EventStream writes Server-Sent Events.
+type EventStream struct {
+	writer     http.ResponseWriter
+	flusher    http.Flusher
+	controller *http.ResponseController
+	done       <-chan struct{}
+}
+
+// EventStream starts a Server-Sent Events response for the request, keeping
+// the headers already set, like the CORS ones.
+func (r *ResponseWriter) EventStream(req *http.Request) (*EventStream, error) {
+	flusher, ok := r.Writer.(http.Flusher)
+	if !ok {
+		return nil, errors.New("response writer doesn't support flushing")
+	}
+
+	controller, ok := req.Context().Value(string("response_controller")).(*http.ResponseController)
+	if !ok {
+		return nil, errors.New("missing response controller")
+	}
+
+	header := r.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+
+	stream := &EventStream{
+		writer:     r.Writer,
+		flusher:    flusher,
+		controller: controller,
+		done:       req.Context().Done(),
+	}
+
+	if err := stream.write(func(w io.Writer) error {
+		r.Writer.WriteHeader(http.StatusOK)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	return stream, nil
+}
+
+// Send writes an event with its data as JSON.
+func (s *EventStream) Send(event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return s.write(func(w io.Writer) error {
+		_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+		return err
+	})
+}
+
+// Heartbeat writes a comment, which keeps proxies from closing an idle
+// stream.
+func (s *EventStream) Heartbeat() error {
+	return s.write(func(w io.Writer) error {
+		_, err := io.WriteString(w, ": heartbeat\n\n")
+		return err
+	})
+}
+
+// Done is closed once the client goes away.
+func (s *EventStream) Done() <-chan struct{} {
+	return s.done
+}
+
+// write moves the write deadline forward before each write, the server's
+// write timeout is meant for regular responses and would cut the stream.
+func (s *EventStream) write(fn func(w io.Writer) error) error {
+	if err := s.controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout)); err != nil {
+		return errors.Wrap(err, "failed setting write deadline")
+	}
+	if err := fn(s.writer); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type GetAuditLogsOpts struct {
+	ActorIDs  []int    `schema:"actor_ids"`
+	Actions   []string `schema:"action"`
+	Entities  []string `schema:"entity"`
+	EntityID  int      `schema:"entity_id"`
+	RequestID string   `schema:"request_id"`
+	IP        string   `schema:"ip"`
+	DateFrom  string   `schema:"date_from"`
+	DateTo    string   `schema:"date_to"`
+	LimitFrom int      `schema:"limit_from"`
+	LimitTo   int      `schema:"limit_to"`
+}
+
+var GetAuditLogsRules = govalidator.MapData{
+	"actor_ids":  []string{"array_int"},
+	"action":     []string{"array_string"},
+	"entity":     []string{"array_string"},
+	"entity_id":  []string{"numeric"},
+	"request_id": []string{},
+	"ip":         []string{},
+	"date_from":  []string{"date_ISO8601"},
+	"date_to":    []string{"date_ISO8601"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+// AuditLog is an entry of the audit log. RequestID is generated by the server,
+// while ClientRequestID is the X-Request-ID sent by the client, if any.
+type AuditLog struct {
+	ID              int             `json:"id,omitempty"`
+	Actor           *User           `json:"actor,omitempty"`
+	Action          string          `json:"action"`
+	Entity          string          `json:"entity"`
+	EntityID        int             `json:"entity_id,omitempty"`
+	Before          json.RawMessage `json:"before,omitempty"`
+	After           json.RawMessage `json:"after,omitempty"`
+	Diff            json.RawMessage `json:"diff,omitempty"`
+	Method          string          `json:"method"`
+	Path            string          `json:"path"`
+	StatusCode      int             `json:"status_code"`
+	IP              string          `json:"ip"`
+	UserAgent       string          `json:"user_agent"`
+	RequestID       string          `json:"request_id"`
+	ClientRequestID string          `json:"client_request_id,omitempty"`
+	Created         time.Time       `json:"created"`
+}
+
+type AuditLogsStruct struct {
+	Logs  []AuditLog `json:"logs,omitempty"`
+	Total int        `json:"total"`
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"time"
+
 	"github.com/thedevsaddam/govalidator"
 )
 
@@ -18,18 +20,84 @@ type SendRememberTokenOpts struct {
 	Email string `json:"email"`
 }
 
+type UnlockUserOpts struct {
+	Token string `json:"token"`
+}
+
+type LoginTwoFactorOpts struct {
+	Token        string `json:"token"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+type SetupTwoFactorOpts struct {
+	Token string `json:"token"`
+}
+
+type EnableTwoFactorOpts struct {
+	Code string `json:"code"`
+}
+
+type DisableTwoFactorOpts struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
 var LoginRules = govalidator.MapData{
 	"email":    []string{"required", "email"},
 	"password": []string{"required"},
 }
 
+var LoginTwoFactorRules = govalidator.MapData{
+	"token":         []string{"required"},
+	"code":          []string{"digits:6"},
+	"recovery_code": []string{},
+}
+
+var SetupTwoFactorRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+var EnableTwoFactorRules = govalidator.MapData{
+	"code": []string{"required", "digits:6"},
+}
+
+var DisableTwoFactorRules = govalidator.MapData{
+	"password": []string{"required"},
+	"code":     []string{"required", "digits:6"},
+}
+
 var UpdateUserPasswordRules = govalidator.MapData{
 	"token":    []string{"required"},
-	"password": []string{"required"},
+	"password": []string{"required", "password_policy"},
 }
 
 var SendRememberTokenRules = govalidator.MapData{
-	"email": []string{"required"},
+	"email": []string{"required", "email"},
+}
+
+var UnlockUserRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+type GetLoginAttemptsOpts struct {
+	Email     string `schema:"email"`
+	IP        string `schema:"ip"`
+	Success   *bool  `schema:"success"`
+	DateFrom  string `schema:"date_from"`
+	DateTo    string `schema:"date_to"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetLoginAttemptsRules = govalidator.MapData{
+	"email":      []string{},
+	"ip":         []string{},
+	"success":    []string{"bool"},
+	"date_from":  []string{"date_ISO8601"},
+	"date_to":    []string{"date_ISO8601"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
 }
 
 type PasswordRecoverHTML struct {
@@ -37,3 +105,49 @@ type PasswordRecoverHTML struct {
 	Lastname  string
 	URL       string
 }
+
+type AccountLockedHTML struct {
+	Firstname   string
+	Lastname    string
+	LockedUntil string
+	URL         string
+}
+
+type LoginAttempt struct {
+	ID        int       `json:"id,omitempty"`
+	Email     string    `json:"email"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+	User      *User     `json:"user,omitempty"`
+	Success   bool      `json:"success"`
+	Reason    string    `json:"reason"`
+	Created   time.Time `json:"created"`
+}
+
+type LoginAttemptsStruct struct {
+	Attempts []LoginAttempt `json:"attempts,omitempty"`
+	Total    int            `json:"total"`
+}
+
+type LoginAttemptsSummary struct {
+	EmailFailures int
+	IPFailures    int
+	LastFailure   *time.Time
+}
+
+type TwoFactorChallenge struct {
+	TwoFactorRequired  bool      `json:"two_factor_required"`
+	EnrollmentRequired bool      `json:"enrollment_required"`
+	Token              string    `json:"token"`
+	Expires            time.Time `json:"expires"`
+}
+
+type TwoFactorSetup struct {
+	Secret string `json:"secret"`
+	URL    string `json:"url"`
+	QRCode string `json:"qr_code"`
+}
+
+type TwoFactorRecoveryCodes struct {
+	Codes []string `json:"codes"`
+}
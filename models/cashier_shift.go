@@ -0,0 +1,132 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type GetCashierShiftsOpts struct {
+	UserID    int    `schema:"user_id"`
+	DateFrom  string `schema:"date_from"`
+	DateTo    string `schema:"date_to"`
+	Open      *bool  `schema:"open"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetCashierShiftsRules = govalidator.MapData{
+	"user_id":    []string{"numeric"},
+	"date_from":  []string{"date_ISO8601"},
+	"date_to":    []string{"date_ISO8601"},
+	"open":       []string{"bool"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+// CashierShift is the period a cashier works at the register, from its
+// opening until it's closed with its report.
+type CashierShift struct {
+	ID          int        `json:"id,omitempty"`
+	User        *User      `json:"user,omitempty"`
+	Opened      time.Time  `json:"opened"`
+	Closed      *time.Time `json:"closed,omitempty"`
+	ClosedBy    *User      `json:"closed_by,omitempty"`
+	SalesTotal  int64      `json:"sales_total"`
+	TicketsSold int64      `json:"tickets_sold"`
+	Admissions  int64      `json:"admissions"`
+	PDFURL      string     `json:"pdf_url,omitempty"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+}
+
+type CashierShiftsStruct struct {
+	Shifts []CashierShift `json:"shifts,omitempty"`
+	Total  int            `json:"total"`
+}
+
+// CashierShiftSale is an order created by the cashier or a season pass they
+// sold.
+type CashierShiftSale struct {
+	Order         *Order         `json:"order,omitempty"`
+	SeasonPass    *SeasonPass    `json:"season_pass,omitempty"`
+	PaymentMethod *PaymentMethod `json:"payment_method,omitempty"`
+	PaymentStatus *PaymentStatus `json:"payment_status,omitempty"`
+}
+
+// CashierShiftAdmission is an order used or a season pass visit registered by
+// the cashier.
+type CashierShiftAdmission struct {
+	Type      string    `json:"type"`
+	Reference string    `json:"reference"`
+	Event     *Event    `json:"event"`
+	Tickets   int64     `json:"tickets"`
+	Gate      string    `json:"gate,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+type CashierShiftPayments struct {
+	Method   *PaymentMethod `json:"method"`
+	Payments int64          `json:"payments"`
+	Amount   int64          `json:"amount"`
+}
+
+type CashierShiftRefund struct {
+	Payment *Payment `json:"payment"`
+}
+
+// CashierShiftReport lists what the cashier did during the shift. Voids are
+// the sales left without an approved payment and refunds the payments
+// reversed while the shift was open.
+type CashierShiftReport struct {
+	Shift        *CashierShift           `json:"shift"`
+	Sales        []CashierShiftSale      `json:"sales"`
+	Voids        []CashierShiftSale      `json:"voids"`
+	Admissions   []CashierShiftAdmission `json:"admissions"`
+	Payments     []CashierShiftPayments  `json:"payments"`
+	Refunds      []CashierShiftRefund    `json:"refunds"`
+	RefundsTotal int64                   `json:"refunds_total"`
+}
+
+type CashierShiftPDFHTML struct {
+	ID           int
+	Cashier      string
+	Opened       string
+	Closed       string
+	SalesTotal   int64
+	TicketsSold  int64
+	Admissions   int64
+	RefundsTotal int64
+	Sales        []CashierShiftPDFSale
+	Voids        []CashierShiftPDFSale
+	Entries      []CashierShiftPDFAdmission
+	Payments     []CashierShiftPayments
+	Refunds      []CashierShiftPDFRefund
+}
+
+type CashierShiftPDFSale struct {
+	Time          string
+	TransactionID string
+	Client        string
+	Event         string
+	Tickets       int
+	Price         int
+	PaymentMethod string
+	PaymentStatus string
+}
+
+type CashierShiftPDFAdmission struct {
+	Time      string
+	Type      string
+	Reference string
+	Event     string
+	Tickets   int64
+	Gate      string
+}
+
+type CashierShiftPDFRefund struct {
+	Time          string
+	TransactionID string
+	PaymentMethod string
+	Amount        int
+}
@@ -26,6 +26,7 @@ type InsertEventDateTimeOpts struct {
 	StartTime string `json:"start_time"`
 	EndTime   string `json:"end_time"`
 	Price     int    `json:"price"`
+	Capacity  int    `json:"capacity"`
 }
 
 type GetEventsOpts struct {
@@ -49,13 +50,23 @@ type Event struct {
 	StartDateTime time.Time  `json:"start_date_time"`
 	EndDateTime   time.Time  `json:"end_date_time"`
 	Price         int        `json:"price"`
+	Capacity      int        `json:"capacity"`
 	Created       time.Time  `json:"created"`
 	Updated       time.Time  `json:"updated"`
 }
 
 type EventType struct {
-	ID   int    `json:"id,omitempty"`
-	Name string `json:"name,omitempty"`
+	ID      int    `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	TaxRate *int   `json:"tax_rate,omitempty"`
+}
+
+type UpdateEventTypeOpts struct {
+	TaxRate *int `json:"tax_rate"`
+}
+
+var UpdateEventTypeRules = govalidator.MapData{
+	"tax_rate": []string{"numeric_between:0,10000"},
 }
 
 type EventsStruct struct {
@@ -0,0 +1,120 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type InsertEventNoticeOpts struct {
+	Kind    string `json:"kind"`
+	Message string `json:"message"`
+}
+
+var InsertEventNoticeRules = govalidator.MapData{
+	"kind":    []string{"required", "in:cancelled,rescheduled"},
+	"message": []string{"required", "max:2000"},
+}
+
+// EventNotice is the email sent to every paid client of an event that was
+// cancelled or rescheduled by the park, with the progress of its delivery
+// and of the clients' answers.
+type EventNotice struct {
+	ID          int       `json:"id,omitempty"`
+	Event       *Event    `json:"event,omitempty"`
+	User        *User     `json:"user,omitempty"`
+	Kind        string    `json:"kind"`
+	Message     string    `json:"message"`
+	Expires     time.Time `json:"expires"`
+	Recipients  int       `json:"recipients"`
+	Pending     int       `json:"pending"`
+	Sent        int       `json:"sent"`
+	Failed      int       `json:"failed"`
+	Rescheduled int       `json:"rescheduled"`
+	Refunds     int       `json:"refunds"`
+	Created     time.Time `json:"created"`
+	Updated     time.Time `json:"updated"`
+}
+
+type EventNoticesStruct struct {
+	Notices []EventNotice `json:"notices"`
+}
+
+type GetEventNoticeRecipientsOpts struct {
+	Status     string `schema:"status"`
+	Resolution string `schema:"resolution"`
+	LimitFrom  int    `schema:"limit_from"`
+	LimitTo    int    `schema:"limit_to"`
+}
+
+var GetEventNoticeRecipientsRules = govalidator.MapData{
+	"status":     []string{"in:pending,sent,failed"},
+	"resolution": []string{"in:rescheduled,refund"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type EventNoticeRecipient struct {
+	ID            int        `json:"id,omitempty"`
+	NoticeID      int        `json:"notice_id"`
+	Order         *Order     `json:"order,omitempty"`
+	Client        *User      `json:"client,omitempty"`
+	Email         string     `json:"email"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	Sent          *time.Time `json:"sent,omitempty"`
+	Resolution    string     `json:"resolution,omitempty"`
+	ResolvedEvent *Event     `json:"resolved_event,omitempty"`
+	Resolved      *time.Time `json:"resolved,omitempty"`
+	Created       time.Time  `json:"created"`
+	Updated       time.Time  `json:"updated"`
+}
+
+type EventNoticeRecipientsStruct struct {
+	Recipients []EventNoticeRecipient `json:"recipients"`
+	Total      int                    `json:"total"`
+}
+
+type GetEventNoticeResponseOpts struct {
+	Token string `schema:"token"`
+}
+
+var GetEventNoticeResponseRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+// EventNoticeResponse is what the client sees when following the link of the
+// notice: the order, the events it can be moved to and its answer, if any.
+type EventNoticeResponse struct {
+	Kind       string  `json:"kind"`
+	Message    string  `json:"message"`
+	Order      *Order  `json:"order"`
+	Events     []Event `json:"events"`
+	Resolution string  `json:"resolution,omitempty"`
+}
+
+type RespondEventNoticeOpts struct {
+	Token   string `json:"token"`
+	Action  string `json:"action"`
+	EventID int    `json:"event_id"`
+}
+
+var RespondEventNoticeRules = govalidator.MapData{
+	"token":    []string{"required"},
+	"action":   []string{"required", "in:reschedule,refund"},
+	"event_id": []string{"numeric"},
+}
+
+type EventNoticeHTML struct {
+	Firstname     string
+	Lastname      string
+	EventType     string
+	Date          string
+	Tickets       int
+	TransactionID string
+	Cancelled     bool
+	Message       string
+	URL           string
+	Expires       string
+}
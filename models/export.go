@@ -0,0 +1,18 @@
+package models
+
+import "github.com/thedevsaddam/govalidator"
+
+// ExportOpts is read next to the filters of the list being exported.
+type ExportOpts struct {
+	Format string `schema:"format"`
+}
+
+var ExportRules = govalidator.MapData{
+	"format": []string{"required", "in:csv,xlsx"},
+}
+
+type ExportReadyHTML struct {
+	Firstname string
+	Name      string
+	URL       string
+}
@@ -0,0 +1,130 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type InsertGiftVoucherOpts struct {
+	Type           string `json:"type"`
+	Amount         int    `json:"amount"`
+	Tickets        int    `json:"tickets"`
+	EventTypeID    int    `json:"event_type_id"`
+	RecipientName  string `json:"recipient_name"`
+	RecipientEmail string `json:"recipient_email"`
+	Message        string `json:"message"`
+}
+
+var InsertGiftVoucherRules = govalidator.MapData{
+	"type":            []string{"required", "in:amount,tickets"},
+	"amount":          []string{"numeric"},
+	"tickets":         []string{"numeric_between:0,100"},
+	"event_type_id":   []string{"numeric"},
+	"recipient_name":  []string{"required", "max:128"},
+	"recipient_email": []string{"required", "email"},
+	"message":         []string{"max:500"},
+}
+
+type GetGiftVouchersOpts struct {
+	Code      string `schema:"code"`
+	Status    string `schema:"status"`
+	UserID    int    `schema:"user_id"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetGiftVouchersRules = govalidator.MapData{
+	"status":     []string{"in:pending,active,redeemed,cancelled"},
+	"user_id":    []string{"numeric"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type GetGiftVoucherLiabilityOpts struct {
+	DateFrom string `schema:"date_from"`
+	DateTo   string `schema:"date_to"`
+}
+
+var GetGiftVoucherLiabilityRules = govalidator.MapData{
+	"date_from": []string{"required", "date_ISO8601"},
+	"date_to":   []string{"required", "date_ISO8601"},
+}
+
+// GiftVoucher is either worth an amount of money or a number of tickets.
+// Balance and TicketsBalance hold what is left to redeem.
+type GiftVoucher struct {
+	ID             int        `json:"id,omitempty"`
+	Code           string     `json:"code,omitempty"`
+	Type           string     `json:"type"`
+	Amount         int        `json:"amount"`
+	Balance        int        `json:"balance"`
+	Tickets        int        `json:"tickets"`
+	TicketsBalance int        `json:"tickets_balance"`
+	EventType      *EventType `json:"event_type,omitempty"`
+	Price          int        `json:"price"`
+	User           *User      `json:"user,omitempty"`
+	RecipientName  string     `json:"recipient_name"`
+	RecipientEmail string     `json:"recipient_email"`
+	Message        string     `json:"message,omitempty"`
+	Status         string     `json:"status"`
+	PreferenceID   string     `json:"-"`
+	Expires        time.Time  `json:"expires"`
+	Created        time.Time  `json:"created"`
+}
+
+// Expired tells if the voucher can't be redeemed anymore because of its
+// date. Vouchers can be used until the end of their expiry day.
+func (v *GiftVoucher) Expired() bool {
+	return time.Now().After(v.Expires.AddDate(0, 0, 1))
+}
+
+type GiftVouchersStruct struct {
+	Vouchers []GiftVoucher `json:"vouchers,omitempty"`
+	Total    int           `json:"total"`
+}
+
+type GiftVoucherResult struct {
+	Voucher *GiftVoucher `json:"voucher"`
+	Payment interface{}  `json:"payment,omitempty"`
+}
+
+type GiftVoucherRedemption struct {
+	ID      int          `json:"id,omitempty"`
+	Voucher *GiftVoucher `json:"voucher,omitempty"`
+	Order   *Order       `json:"order,omitempty"`
+	Amount  int          `json:"amount"`
+	Tickets int          `json:"tickets"`
+	Value   int          `json:"value"`
+	Created time.Time    `json:"created"`
+}
+
+// GiftVoucherLiability sums, in money, the vouchers sold, redeemed and expired
+// in a period, and what is still owed to the holders of the active ones.
+type GiftVoucherLiability struct {
+	DateFrom    string `json:"date_from"`
+	DateTo      string `json:"date_to"`
+	Sold        int64  `json:"sold"`
+	Redeemed    int64  `json:"redeemed"`
+	Expired     int64  `json:"expired"`
+	Outstanding int64  `json:"outstanding"`
+}
+
+type GiftVoucherPDFHTML struct {
+	Code          string
+	RecipientName string
+	BuyerName     string
+	Message       string
+	Amount        int
+	Tickets       int
+	EventType     string
+	Expires       string
+	Image         string
+}
+
+type GiftVoucherHTML struct {
+	RecipientName string
+	BuyerName     string
+	Code          string
+	Expires       string
+}
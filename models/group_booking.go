@@ -0,0 +1,124 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type InsertGroupBookingOpts struct {
+	EventID      int    `json:"event_id"`
+	Tickets      int    `json:"tickets"`
+	Organization string `json:"organization"`
+	DNI          string `json:"dni"`
+	ContactName  string `json:"contact_name"`
+	ContactEmail string `json:"contact_email"`
+	ContactPhone string `json:"contact_phone"`
+	Notes        string `json:"notes"`
+}
+
+var InsertGroupBookingRules = govalidator.MapData{
+	"event_id":      []string{"required", "numeric"},
+	"tickets":       []string{"required", "numeric"},
+	"organization":  []string{"required", "max:255"},
+	"dni":           []string{"required", "max:16"},
+	"contact_name":  []string{"required", "max:255"},
+	"contact_email": []string{"required", "email"},
+	"contact_phone": []string{"required", "max:32"},
+	"notes":         []string{"max:1000"},
+}
+
+type ApproveGroupBookingOpts struct {
+	Price   int    `json:"price"`
+	DueDate string `json:"due_date"`
+}
+
+var ApproveGroupBookingRules = govalidator.MapData{
+	"price":    []string{"required", "numeric"},
+	"due_date": []string{"required", "date_ISO8601"},
+}
+
+type RejectGroupBookingOpts struct {
+	Reason string `json:"reason"`
+}
+
+var RejectGroupBookingRules = govalidator.MapData{
+	"reason": []string{"required", "max:500"},
+}
+
+type UpdateGroupBookingPaymentOpts struct {
+	Approved bool `json:"approved"`
+}
+
+var UpdateGroupBookingPaymentRules = govalidator.MapData{
+	"approved": []string{"bool"},
+}
+
+type GetGroupBookingsOpts struct {
+	Status    string `schema:"status"`
+	EventID   int    `schema:"event_id"`
+	Overdue   bool   `schema:"overdue"`
+	UserID    int    `schema:"-"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetGroupBookingsRules = govalidator.MapData{
+	"status":     []string{"in:requested,approved,proof_uploaded,paid,rejected,cancelled"},
+	"event_id":   []string{"numeric"},
+	"overdue":    []string{"bool"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type GroupBooking struct {
+	ID           int        `json:"id,omitempty"`
+	User         *User      `json:"user,omitempty"`
+	Event        *Event     `json:"event,omitempty"`
+	Tickets      int        `json:"tickets"`
+	Organization string     `json:"organization"`
+	DNI          string     `json:"dni"`
+	ContactName  string     `json:"contact_name"`
+	ContactEmail string     `json:"contact_email"`
+	ContactPhone string     `json:"contact_phone"`
+	Notes        string     `json:"notes,omitempty"`
+	Status       string     `json:"status"`
+	Price        int        `json:"price"`
+	DueDate      *time.Time `json:"due_date,omitempty"`
+	Order        *Order     `json:"order,omitempty"`
+	ProofURL     string     `json:"proof_url,omitempty"`
+	Reason       string     `json:"reason,omitempty"`
+	Participants int        `json:"participants"`
+	Created      time.Time  `json:"created"`
+	Updated      time.Time  `json:"updated"`
+}
+
+type GroupBookingsStruct struct {
+	Bookings []GroupBooking `json:"bookings,omitempty"`
+	Total    int            `json:"total"`
+}
+
+type GroupBookingParticipant struct {
+	ID        int    `json:"id,omitempty"`
+	Firstname string `json:"firstname"`
+	Lastname  string `json:"lastname"`
+	DNI       string `json:"dni,omitempty"`
+	Email     string `json:"email,omitempty"`
+}
+
+type GroupBookingApprovedHTML struct {
+	ContactName  string
+	Organization string
+	EventType    string
+	Date         string
+	Tickets      int
+	Price        int
+	DueDate      string
+	BankDetails  string
+}
+
+type GroupBookingRejectedHTML struct {
+	ContactName  string
+	Organization string
+	Reason       string
+}
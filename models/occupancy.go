@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type GetOccupancyReportOpts struct {
+	Date        string `schema:"date"`
+	EventTypeID int    `schema:"event_type_id"`
+}
+
+var GetOccupancyReportRules = govalidator.MapData{
+	"date":          []string{"required", "date_ISO8601"},
+	"event_type_id": []string{"numeric"},
+}
+
+// OccupancySlot compares the tickets sold for an event with the ones
+// admitted at the gate. No-shows are only counted once the event ended.
+type OccupancySlot struct {
+	Event           *Event           `json:"event"`
+	Ended           bool             `json:"ended"`
+	TicketsSold     int64            `json:"tickets_sold"`
+	TicketsAdmitted int64            `json:"tickets_admitted"`
+	NoShows         int64            `json:"no_shows"`
+	Occupancy       float64          `json:"occupancy"`
+	Arrivals        []HourlyArrivals `json:"arrivals"`
+}
+
+// HourlyArrivals counts the tickets admitted in an hour of the park.
+type HourlyArrivals struct {
+	Hour    int   `json:"hour"`
+	Tickets int64 `json:"tickets"`
+}
+
+type EventArrivals struct {
+	EventID int
+	HourlyArrivals
+}
+
+type OccupancyReport struct {
+	Date            string           `json:"date"`
+	TicketsSold     int64            `json:"tickets_sold"`
+	TicketsAdmitted int64            `json:"tickets_admitted"`
+	NoShows         int64            `json:"no_shows"`
+	Slots           []OccupancySlot  `json:"slots"`
+	Arrivals        []HourlyArrivals `json:"arrivals"`
+}
+
+type DailyReportHTML struct {
+	Firstname       string
+	Date            string
+	TicketsSold     int64
+	TicketsAdmitted int64
+	NoShows         int64
+	Revenue         int64
+	Orders          int64
+	Slots           []DailyReportSlotHTML
+	PaymentMethods  []DailyReportSalesHTML
+	Cashiers        []DailyReportSalesHTML
+}
+
+type DailyReportSlotHTML struct {
+	Name            string
+	Time            string
+	TicketsSold     int64
+	TicketsAdmitted int64
+	NoShows         int64
+	Occupancy       string
+}
+
+type DailyReportSalesHTML struct {
+	Name    string
+	Orders  int64
+	Tickets int64
+	Revenue int64
+}
+
+// GateAdmission is the tickets admitted to an event through a gate. It's
+// also the message published on every admission.
+type GateAdmission struct {
+	EventID int    `json:"event_id"`
+	Gate    string `json:"gate"`
+	Tickets int64  `json:"tickets"`
+}
+
+type GateOccupancy struct {
+	Gate            string `json:"gate"`
+	TicketsAdmitted int64  `json:"tickets_admitted"`
+}
+
+// LiveEventOccupancy counts the visitors inside an event, admitted with
+// orders or season passes, against its capacity.
+type LiveEventOccupancy struct {
+	Event           *Event          `json:"event"`
+	TicketsSold     int64           `json:"tickets_sold"`
+	TicketsAdmitted int64           `json:"tickets_admitted"`
+	Occupancy       float64         `json:"occupancy"`
+	Gates           []GateOccupancy `json:"gates"`
+}
+
+type LiveOccupancy struct {
+	Events  []LiveEventOccupancy `json:"events"`
+	Updated time.Time            `json:"updated"`
+}
+
+type LiveOccupancyUpdate struct {
+	Admission GateAdmission       `json:"admission"`
+	Event     *LiveEventOccupancy `json:"event"`
+}
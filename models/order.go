@@ -7,9 +7,11 @@ import (
 )
 
 type InsertOrdersOpts struct {
-	UserID  int `json:"user_id"`
-	EventID int `json:"event_id"`
-	Tickets int `json:"tickets"`
+	UserID        int    `json:"user_id"`
+	EventID       int    `json:"event_id"`
+	Tickets       int    `json:"tickets"`
+	WaitlistToken string `json:"waitlist_token"`
+	VoucherCode   string `json:"voucher_code"`
 }
 
 var InsertOrdersRules = govalidator.MapData{
@@ -50,6 +52,16 @@ var GetOrdersRules = govalidator.MapData{
 	"user_id":        []string{"numeric"},
 }
 
+// UseOrderOpts is read from the query string, the gate is the name of the
+// entrance scanning the order.
+type UseOrderOpts struct {
+	Gate string `schema:"gate"`
+}
+
+var UseOrderRules = govalidator.MapData{
+	"gate": []string{"max:32"},
+}
+
 type GetCashierSummaryOpts struct {
 	DateFrom   string `schema:"date_from"`
 	DateTo     string `schema:"date_to"`
@@ -70,6 +82,9 @@ type Order struct {
 	TransactionID string    `json:"transaction_id"`
 	Tickets       int       `json:"tickets"`
 	Price         int       `json:"price"`
+	TaxRate       int       `json:"tax_rate"`
+	NetAmount     int       `json:"net_amount"`
+	TaxAmount     int       `json:"tax_amount"`
 	Payment       *Payment  `json:"payment,omitempty"`
 	Paid          *bool     `json:"paid,omitempty"`
 	Used          *bool     `json:"used,omitempty"`
@@ -84,6 +99,9 @@ type OrderPDFHTML struct {
 	Date          string
 	EventType     string
 	Price         int
+	NetAmount     int
+	TaxAmount     int
+	TaxRate       string
 	Image         string
 	TransactionID string
 	Tickets       int
@@ -99,6 +117,9 @@ type OrderHTML struct {
 	Lastname      string
 	PaymentMethod string
 	OrderPrice    int
+	NetAmount     int
+	TaxAmount     int
+	TaxRate       string
 	TransactionID string
 	Tickets       int
 	Date          string
@@ -111,21 +132,32 @@ type GetOrdersStruct struct {
 
 type SalesSummary struct {
 	CurrentDay         int64                       `json:"current_day"`
+	CurrentDayNet      int64                       `json:"current_day_net"`
+	CurrentDayTax      int64                       `json:"current_day_tax"`
 	CurrentMonth       int64                       `json:"current_month"`
+	CurrentMonthNet    int64                       `json:"current_month_net"`
+	CurrentMonthTax    int64                       `json:"current_month_tax"`
 	CurrentYear        int64                       `json:"current_year"`
+	CurrentYearNet     int64                       `json:"current_year_net"`
+	CurrentYearTax     int64                       `json:"current_year_tax"`
 	MonthlyCurrentYear []MonthlySalesSummaryDetail `json:"monthly_current_year"`
 	MonthlyLastYear    []MonthlySalesSummaryDetail `json:"monthly_last_year"`
+	VoucherLiability   int64                       `json:"voucher_liability"`
 }
 
 type MonthlySalesSummaryDetail struct {
 	Month string `json:"month"`
 	Year  int    `json:"year"`
 	Total int64  `json:"total"`
+	Net   int64  `json:"net,omitempty"`
+	Tax   int64  `json:"tax,omitempty"`
 }
 
 type DailySales struct {
 	Date  time.Time
 	Total int64
+	Net   int64
+	Tax   int64
 }
 
 type CashierSummary struct {
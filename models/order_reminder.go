@@ -0,0 +1,13 @@
+package models
+
+type VisitReminderHTML struct {
+	Firstname          string
+	Lastname           string
+	EventType          string
+	Date               string
+	Time               string
+	Tickets            int
+	TransactionID      string
+	RescheduleURL      string
+	RescheduleDeadline string
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type RescheduleOrderOpts struct {
+	EventID int `json:"event_id"`
+}
+
+var RescheduleOrderRules = govalidator.MapData{
+	"event_id": []string{"required", "numeric"},
+}
+
+type GetOrderRescheduleQuoteOpts struct {
+	EventID int `schema:"event_id"`
+}
+
+var GetOrderRescheduleQuoteRules = govalidator.MapData{
+	"event_id": []string{"required", "numeric"},
+}
+
+// OrderReschedule is a change of event requested by a client. Amount is what
+// the client pays, the price difference plus the fee; when it's negative it
+// is credited to the client.
+type OrderReschedule struct {
+	ID              int          `json:"id,omitempty"`
+	Order           *Order       `json:"order,omitempty"`
+	User            *User        `json:"user,omitempty"`
+	FromEvent       *Event       `json:"from_event,omitempty"`
+	ToEvent         *Event       `json:"to_event,omitempty"`
+	PriceDifference int          `json:"price_difference"`
+	Fee             int          `json:"fee"`
+	Amount          int          `json:"amount"`
+	Status          string       `json:"status"`
+	PreferenceID    string       `json:"-"`
+	CreditVoucher   *GiftVoucher `json:"credit_voucher,omitempty"`
+	Created         time.Time    `json:"created"`
+}
+
+type OrderRescheduleResult struct {
+	Reschedule *OrderReschedule `json:"reschedule"`
+	Payment    interface{}      `json:"payment,omitempty"`
+}
+
+type OrderRescheduledHTML struct {
+	Firstname     string
+	Lastname      string
+	EventType     string
+	Date          string
+	Tickets       int
+	TransactionID string
+	Amount        int
+	Credit        int
+	CreditCode    string
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type InsertOrderTransferOpts struct {
+	Email string `json:"email"`
+}
+
+var InsertOrderTransferRules = govalidator.MapData{
+	"email": []string{"required", "email"},
+}
+
+type GetOrderTransferOpts struct {
+	Token string `schema:"token"`
+}
+
+var GetOrderTransferRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+// AcceptOrderTransferOpts carries the data needed to create the recipient's
+// account. It's ignored when the recipient is already registered.
+type AcceptOrderTransferOpts struct {
+	Token        string `json:"token"`
+	Password     string `json:"password"`
+	Firstname    string `json:"firstname"`
+	Lastname     string `json:"lastname"`
+	DNI          string `json:"dni"`
+	DocumentType string `json:"document_type"`
+	Phone        string `json:"phone"`
+}
+
+var AcceptOrderTransferRules = govalidator.MapData{
+	"token":         []string{"required"},
+	"password":      []string{"password_policy"},
+	"firstname":     []string{},
+	"lastname":      []string{},
+	"dni":           []string{},
+	"document_type": []string{"in:rut,passport,foreign"},
+	"phone":         []string{},
+}
+
+type OrderTransfer struct {
+	ID              int        `json:"id,omitempty"`
+	Order           *Order     `json:"order,omitempty"`
+	From            *User      `json:"from,omitempty"`
+	To              *User      `json:"to,omitempty"`
+	Email           string     `json:"email"`
+	Status          string     `json:"status"`
+	AccountExists   bool       `json:"account_exists"`
+	AccountInactive bool       `json:"account_inactive"`
+	Expires         time.Time  `json:"expires"`
+	AcceptedAt      *time.Time `json:"accepted_at,omitempty"`
+	Created         time.Time  `json:"created"`
+}
+
+type OrderHistory struct {
+	ID      int       `json:"id,omitempty"`
+	User    *User     `json:"user,omitempty"`
+	Action  string    `json:"action"`
+	Detail  string    `json:"detail"`
+	Created time.Time `json:"created"`
+}
+
+type OrderTransferHTML struct {
+	Firstname string
+	Lastname  string
+	EventType string
+	Date      string
+	Tickets   int
+	Expires   string
+	URL       string
+}
+
+type OrderTransferredHTML struct {
+	Firstname     string
+	Lastname      string
+	EventType     string
+	Date          string
+	Tickets       int
+	TransactionID string
+}
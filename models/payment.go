@@ -1,6 +1,10 @@
 package models
 
-import "time"
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
 
 type Payment struct {
 	ID           int            `json:"id,omitempty"`
@@ -9,7 +13,10 @@ type Payment struct {
 	User         *User          `json:"user,omitempty"`
 	PreferenceID string         `json:"preference_id,omitempty"`
 	Order        *Order         `json:"order,omitempty"`
+	SeasonPass   *SeasonPass    `json:"season_pass,omitempty"`
 	Status       *PaymentStatus `json:"status,omitempty"`
+	TaxDocument  *TaxDocument   `json:"tax_document,omitempty"`
+	CreditNote   *TaxDocument   `json:"credit_note,omitempty"`
 	Created      time.Time      `json:"created"`
 	Updated      time.Time      `json:"updated"`
 }
@@ -23,3 +30,28 @@ type PaymentStatus struct {
 	ID   int    `json:"id,omitempty"`
 	Name string `json:"name,omitempty"`
 }
+
+type GetPaymentsOpts struct {
+	DateFrom  string `schema:"date_from"`
+	DateTo    string `schema:"date_to"`
+	MethodID  int    `schema:"method_id"`
+	StatusID  int    `schema:"status_id"`
+	OrderID   int    `schema:"order_id"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetPaymentsRules = govalidator.MapData{
+	"date_from":  []string{"date_ISO8601"},
+	"date_to":    []string{"date_ISO8601"},
+	"method_id":  []string{"numeric"},
+	"status_id":  []string{"numeric"},
+	"order_id":   []string{"numeric"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type PaymentsStruct struct {
+	Payments []Payment `json:"payments,omitempty"`
+	Total    int       `json:"total"`
+}
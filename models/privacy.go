@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type ExportUserDataOpts struct {
+	Format string `schema:"format"`
+}
+
+var ExportUserDataRules = govalidator.MapData{
+	"format": []string{"in:json,zip"},
+}
+
+type EraseUserDataOpts struct {
+	Reason string `json:"reason"`
+}
+
+var EraseUserDataRules = govalidator.MapData{
+	"reason": []string{"required", "max:255"},
+}
+
+type UserDataExport struct {
+	Generated     time.Time      `json:"generated"`
+	Profile       *User          `json:"profile"`
+	Orders        []Order        `json:"orders"`
+	Payments      []Payment      `json:"payments"`
+	Campings      []Camping      `json:"campings"`
+	Scans         []OrderScan    `json:"scans"`
+	LoginAttempts []LoginAttempt `json:"login_attempts"`
+}
+
+type OrderScan struct {
+	Order   *Order    `json:"order,omitempty"`
+	Created time.Time `json:"created"`
+}
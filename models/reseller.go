@@ -0,0 +1,115 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type InsertResellerAllotmentOpts struct {
+	UserID     int `json:"user_id"`
+	EventID    int `json:"event_id"`
+	Tickets    int `json:"tickets"`
+	Price      int `json:"price"`
+	Commission int `json:"commission"`
+}
+
+var InsertResellerAllotmentRules = govalidator.MapData{
+	"user_id":    []string{"required", "numeric"},
+	"event_id":   []string{"required", "numeric"},
+	"tickets":    []string{"required", "numeric"},
+	"price":      []string{"required", "numeric"},
+	"commission": []string{"numeric", "numeric_between:0,100"},
+}
+
+type GetResellerAllotmentsOpts struct {
+	UserID    int    `schema:"user_id"`
+	EventID   int    `schema:"event_id"`
+	EventFrom string `schema:"event_from"`
+	EventTo   string `schema:"event_to"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetResellerAllotmentsRules = govalidator.MapData{
+	"user_id":    []string{"numeric"},
+	"event_id":   []string{"numeric"},
+	"event_from": []string{"date_ISO8601"},
+	"event_to":   []string{"date_ISO8601"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type InsertResellerSettlementOpts struct {
+	DateFrom string `json:"date_from"`
+	DateTo   string `json:"date_to"`
+}
+
+var InsertResellerSettlementRules = govalidator.MapData{
+	"date_from": []string{"required", "date_ISO8601"},
+	"date_to":   []string{"required", "date_ISO8601"},
+}
+
+type GetResellerSettlementsOpts struct {
+	UserID    int   `schema:"user_id"`
+	Paid      *bool `schema:"paid"`
+	LimitFrom int   `schema:"limit_from"`
+	LimitTo   int   `schema:"limit_to"`
+}
+
+var GetResellerSettlementsRules = govalidator.MapData{
+	"user_id":    []string{"numeric"},
+	"paid":       []string{"bool"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type ResellerAllotment struct {
+	ID         int       `json:"id,omitempty"`
+	User       *User     `json:"user,omitempty"`
+	Event      *Event    `json:"event,omitempty"`
+	Tickets    int       `json:"tickets"`
+	Sold       int       `json:"sold"`
+	Price      int       `json:"price"`
+	Commission int       `json:"commission"`
+	Created    time.Time `json:"created"`
+	Updated    time.Time `json:"updated"`
+}
+
+func (allotment *ResellerAllotment) Available() int {
+	return allotment.Tickets - allotment.Sold
+}
+
+type GetResellerAllotmentsStruct struct {
+	Allotments []ResellerAllotment `json:"allotments,omitempty"`
+	Total      int                 `json:"total"`
+}
+
+type ResellerSettlement struct {
+	ID          int        `json:"id,omitempty"`
+	User        *User      `json:"user,omitempty"`
+	DateFrom    string     `json:"date_from"`
+	DateTo      string     `json:"date_to"`
+	TicketsSold int        `json:"tickets_sold"`
+	TicketsUsed int        `json:"tickets_used"`
+	TotalSales  int        `json:"total_sales"`
+	Commission  int        `json:"commission"`
+	Owed        int        `json:"owed"`
+	Paid        bool       `json:"paid"`
+	PaidAt      *time.Time `json:"paid_at,omitempty"`
+	Created     time.Time  `json:"created"`
+	Updated     time.Time  `json:"updated"`
+}
+
+type GetResellerSettlementsStruct struct {
+	Settlements []ResellerSettlement `json:"settlements,omitempty"`
+	Total       int                  `json:"total"`
+}
+
+type ResellerSale struct {
+	Order      *Order    `json:"order,omitempty"`
+	Price      int       `json:"price"`
+	Commission int       `json:"commission"`
+	Used       bool      `json:"used"`
+	Created    time.Time `json:"created"`
+}
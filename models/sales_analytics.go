@@ -0,0 +1,40 @@
+package models
+
+import "github.com/thedevsaddam/govalidator"
+
+type GetSalesAnalyticsOpts struct {
+	DateFrom    string `schema:"date_from"`
+	DateTo      string `schema:"date_to"`
+	GroupBy     string `schema:"group_by"`
+	EventTypeID int    `schema:"event_type_id"`
+}
+
+var GetSalesAnalyticsRules = govalidator.MapData{
+	"date_from":     []string{"required", "date_ISO8601"},
+	"date_to":       []string{"required", "date_ISO8601"},
+	"group_by":      []string{"required", "in:day,week,month,event,event_type,payment_method,cashier,channel"},
+	"event_type_id": []string{"numeric"},
+}
+
+// SalesAnalyticsRow sums the paid orders of a group, counting each season pass
+// sold as an order without tickets. Amounts are in pesos and include taxes,
+// with their net and IVA split.
+type SalesAnalyticsRow struct {
+	Key               string `json:"key"`
+	Label             string `json:"label"`
+	Orders            int64  `json:"orders"`
+	TicketsSold       int64  `json:"tickets_sold"`
+	TicketsUsed       int64  `json:"tickets_used"`
+	Revenue           int64  `json:"revenue"`
+	Net               int64  `json:"net"`
+	Tax               int64  `json:"tax"`
+	AverageOrderValue int64  `json:"average_order_value"`
+}
+
+type SalesAnalytics struct {
+	DateFrom string              `json:"date_from"`
+	DateTo   string              `json:"date_to"`
+	GroupBy  string              `json:"group_by"`
+	Totals   SalesAnalyticsRow   `json:"totals"`
+	Rows     []SalesAnalyticsRow `json:"rows"`
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type InsertPassProductOpts struct {
+	Name             string   `json:"name"`
+	Price            int      `json:"price"`
+	ValidityDays     int      `json:"validity_days"`
+	EventTypeIDs     []int    `json:"event_type_ids"`
+	MaxVisitsPerDay  int      `json:"max_visits_per_day"`
+	MaxVisitsPerWeek int      `json:"max_visits_per_week"`
+	BlackoutDates    []string `json:"blackout_dates"`
+	TaxRate          *int     `json:"tax_rate"`
+}
+
+var InsertPassProductRules = govalidator.MapData{
+	"name":                []string{"required", "between:1,128"},
+	"price":               []string{"required", "numeric"},
+	"validity_days":       []string{"required", "numeric_between:1,3660"},
+	"event_type_ids":      []string{"required", "array_int"},
+	"max_visits_per_day":  []string{"numeric"},
+	"max_visits_per_week": []string{"numeric"},
+	"blackout_dates":      []string{"array_string"},
+	"tax_rate":            []string{"numeric_between:0,10000"},
+}
+
+type GetPassProductsOpts struct {
+	All bool `schema:"all"`
+}
+
+var GetPassProductsRules = govalidator.MapData{
+	"all": []string{"bool"},
+}
+
+// PassProduct is a season pass or membership on sale. Zero visit limits mean
+// unlimited visits.
+type PassProduct struct {
+	ID               int         `json:"id,omitempty"`
+	Name             string      `json:"name,omitempty"`
+	Price            int         `json:"price"`
+	ValidityDays     int         `json:"validity_days"`
+	EventTypes       []EventType `json:"event_types"`
+	MaxVisitsPerDay  int         `json:"max_visits_per_day"`
+	MaxVisitsPerWeek int         `json:"max_visits_per_week"`
+	BlackoutDates    []string    `json:"blackout_dates"`
+	TaxRate          int         `json:"tax_rate"`
+	Active           bool        `json:"active"`
+	Created          time.Time   `json:"created"`
+}
+
+type InsertSeasonPassOpts struct {
+	ProductID int    `json:"product_id"`
+	UserID    int    `json:"user_id"`
+	DNI       string `json:"dni"`
+	ValidFrom string `json:"valid_from"`
+}
+
+var InsertSeasonPassRules = govalidator.MapData{
+	"product_id": []string{"required", "numeric"},
+	"user_id":    []string{"numeric"},
+	"valid_from": []string{"date_ISO8601"},
+}
+
+type GetSeasonPassesOpts struct {
+	UserID    int    `schema:"user_id"`
+	DNI       string `schema:"dni"`
+	Status    string `schema:"status"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetSeasonPassesRules = govalidator.MapData{
+	"user_id":    []string{"numeric"},
+	"status":     []string{"in:pending,active,cancelled"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type ScanSeasonPassOpts struct {
+	Code    string `json:"code"`
+	DNI     string `json:"dni"`
+	EventID int    `json:"event_id"`
+	Gate    string `json:"gate"`
+}
+
+var ScanSeasonPassRules = govalidator.MapData{
+	"code":     []string{"max:64"},
+	"dni":      []string{"max:16"},
+	"event_id": []string{"numeric"},
+	"gate":     []string{"max:32"},
+}
+
+type SeasonPass struct {
+	ID           int          `json:"id,omitempty"`
+	Product      *PassProduct `json:"product,omitempty"`
+	User         *User        `json:"user,omitempty"`
+	DNI          string       `json:"dni,omitempty"`
+	Code         string       `json:"code,omitempty"`
+	QRCode       string       `json:"qr_code,omitempty"`
+	ValidFrom    time.Time    `json:"valid_from"`
+	ValidTo      time.Time    `json:"valid_to"`
+	Price        int          `json:"price"`
+	TaxRate      int          `json:"tax_rate"`
+	NetAmount    int          `json:"net_amount"`
+	TaxAmount    int          `json:"tax_amount"`
+	Status       string       `json:"status"`
+	PreferenceID string       `json:"-"`
+	Visits       []PassVisit  `json:"visits,omitempty"`
+	Created      time.Time    `json:"created"`
+}
+
+type SeasonPassesStruct struct {
+	Passes []SeasonPass `json:"passes,omitempty"`
+	Total  int          `json:"total"`
+}
+
+type SeasonPassResult struct {
+	Pass    *SeasonPass `json:"pass"`
+	Payment interface{} `json:"payment,omitempty"`
+}
+
+type PassVisit struct {
+	ID      int       `json:"id,omitempty"`
+	Event   *Event    `json:"event,omitempty"`
+	User    *User     `json:"user,omitempty"`
+	Gate    string    `json:"gate,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+type PassVisitResult struct {
+	Pass  *SeasonPass `json:"pass"`
+	Visit *PassVisit  `json:"visit"`
+}
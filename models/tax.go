@@ -0,0 +1,39 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ConstDefaultTaxRate is the chilean IVA. Tax rates are stored in basis
+// points, so 1900 is 19% and 0 means the product is exempt.
+const ConstDefaultTaxRate = 1900
+
+// TaxAmounts splits a gross price, which already includes taxes, into its net
+// and tax amounts.
+type TaxAmounts struct {
+	Rate int `json:"tax_rate"`
+	Net  int `json:"net_amount"`
+	Tax  int `json:"tax_amount"`
+}
+
+func NewTaxAmounts(gross int, rate int) TaxAmounts {
+	net := int(math.Round(float64(gross) * 10000 / float64(10000+rate)))
+
+	return TaxAmounts{
+		Rate: rate,
+		Net:  net,
+		Tax:  gross - net,
+	}
+}
+
+// TaxRateLabel formats a rate in basis points as a percentage, like 19% or
+// 9,5%.
+func TaxRateLabel(rate int) string {
+	if rate%100 == 0 {
+		return fmt.Sprintf("%d%%", rate/100)
+	}
+
+	return strings.TrimRight(strings.Replace(fmt.Sprintf("%.2f", float64(rate)/100), ".", ",", 1), "0") + "%"
+}
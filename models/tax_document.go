@@ -0,0 +1,65 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type UpdateOrderInvoiceOpts struct {
+	DNI          string `json:"dni"`
+	BusinessName string `json:"business_name"`
+	Activity     string `json:"activity"`
+	Address      string `json:"address"`
+	Commune      string `json:"commune"`
+	Email        string `json:"email"`
+}
+
+var UpdateOrderInvoiceRules = govalidator.MapData{
+	"dni":           []string{"required", "max:16"},
+	"business_name": []string{"required", "max:255"},
+	"activity":      []string{"required", "max:255"},
+	"address":       []string{"required", "max:255"},
+	"commune":       []string{"required", "max:128"},
+	"email":         []string{"email"},
+}
+
+// OrderInvoice holds the business details of a company buying an order. When
+// present, the payment of the order gets a factura instead of a boleta.
+type OrderInvoice struct {
+	OrderID      int    `json:"order_id"`
+	DNI          string `json:"dni"`
+	BusinessName string `json:"business_name"`
+	Activity     string `json:"activity"`
+	Address      string `json:"address"`
+	Commune      string `json:"commune"`
+	Email        string `json:"email,omitempty"`
+}
+
+// TaxDocumentPayment has what is needed to issue the tax documents of a
+// payment. Payments of a season pass have no order, and their event date is
+// the first day of the pass.
+type TaxDocumentPayment struct {
+	PaymentID       int
+	Amount          int
+	StatusID        int
+	OrderID         int
+	SeasonPassID    int
+	PassProduct     string
+	Tickets         int
+	TaxRate         int
+	EventType       string
+	EventDate       time.Time
+	ClientEmail     string
+	Invoice         *OrderInvoice
+	TaxDocumentType int
+	TaxFolio        int
+	TaxIssued       *time.Time
+	CreditNoteFolio int
+}
+
+type TaxDocument struct {
+	Type   int    `json:"type"`
+	Folio  int    `json:"folio"`
+	PDFURL string `json:"pdf_url"`
+}
@@ -6,42 +6,62 @@ import (
 	"github.com/thedevsaddam/govalidator"
 )
 
+// ConstDocumentTypes are the identity documents accepted for users. Only
+// RUTs are validated, the others are stored as given.
+var ConstDocumentTypes = struct {
+	RUT      string
+	Passport string
+	Foreign  string
+}{
+	RUT:      "rut",
+	Passport: "passport",
+	Foreign:  "foreign",
+}
+
 type InsertAdminUserOpts struct {
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-	DNI       string `json:"dni"`
-	Phone     string `json:"phone"`
-	Roles     []int  `json:"roles"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	Firstname    string `json:"firstname"`
+	Lastname     string `json:"lastname"`
+	DNI          string `json:"dni"`
+	DocumentType string `json:"document_type"`
+	Phone        string `json:"phone"`
+	Roles        []int  `json:"roles"`
+
+	EmailVerified            bool      `json:"-"`
+	EmailVerificationToken   string    `json:"-"`
+	EmailVerificationExpires time.Time `json:"-"`
 }
 
 var InsertAdminUserRules = govalidator.MapData{
-	"email":     []string{"required", "email"},
-	"password":  []string{"required"},
-	"firstname": []string{"required"},
-	"lastname":  []string{"required"},
-	"dni":       []string{"required"},
-	"phone":     []string{"required"},
-	"roles":     []string{"required", "array_int"},
+	"email":         []string{"required", "email"},
+	"password":      []string{"required", "password_policy"},
+	"firstname":     []string{"required"},
+	"lastname":      []string{"required"},
+	"dni":           []string{"required"},
+	"document_type": []string{"in:rut,passport,foreign"},
+	"phone":         []string{"required"},
+	"roles":         []string{"required", "array_int"},
 }
 
 type InsertUserOpts struct {
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-	DNI       string `json:"dni"`
-	Phone     string `json:"phone"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	Firstname    string `json:"firstname"`
+	Lastname     string `json:"lastname"`
+	DNI          string `json:"dni"`
+	DocumentType string `json:"document_type"`
+	Phone        string `json:"phone"`
 }
 
 var InsertUserRules = govalidator.MapData{
-	"email":     []string{"required", "email"},
-	"password":  []string{"required"},
-	"firstname": []string{"required"},
-	"lastname":  []string{"required"},
-	"dni":       []string{"required"},
-	"phone":     []string{"required"},
+	"email":         []string{"required", "email"},
+	"password":      []string{"required", "password_policy"},
+	"firstname":     []string{"required"},
+	"lastname":      []string{"required"},
+	"dni":           []string{"required"},
+	"document_type": []string{"in:rut,passport,foreign"},
+	"phone":         []string{"required"},
 }
 
 type GetUsersOpts struct {
@@ -54,6 +74,7 @@ type GetUsersOpts struct {
 	Lastnames   []string `schema:"lastname"`
 	Phones      []string `schema:"phone"`
 	DNIs        []string `schema:"dni"`
+	Active      *bool    `schema:"active"`
 	LimitFrom   int      `schema:"limit_from"`
 	LimitTo     int      `schema:"limit_to"`
 }
@@ -68,28 +89,120 @@ var GetUsersRules = govalidator.MapData{
 	"lastname":     []string{"array_string"},
 	"phones":       []string{"array_string"},
 	"dnis":         []string{"array_string"},
+	"active":       []string{"bool"},
 	"limit_from":   []string{"numeric"},
 	"limit_to":     []string{"numeric"},
 }
 
 type UpdateUserOpts struct {
-	Email     string `json:"email"`
-	Password  string `json:"password"`
-	Firstname string `json:"firstname"`
-	Lastname  string `json:"lastname"`
-	DNI       string `json:"dni"`
-	Phone     string `json:"phone"`
-	Roles     []int  `json:"roles"`
+	Email        string `json:"email"`
+	Password     string `json:"password"`
+	Firstname    string `json:"firstname"`
+	Lastname     string `json:"lastname"`
+	DNI          string `json:"dni"`
+	DocumentType string `json:"document_type"`
+	Phone        string `json:"phone"`
+	Roles        []int  `json:"roles"`
 }
 
 var UpdateUserRules = govalidator.MapData{
-	"email":     []string{"required", "email"},
-	"password":  []string{},
-	"firstname": []string{"required"},
-	"lastname":  []string{"required"},
-	"dni":       []string{"required"},
-	"phone":     []string{"required"},
-	"roles":     []string{"required", "array_int"},
+	"email":         []string{"required", "email"},
+	"password":      []string{"password_policy"},
+	"firstname":     []string{"required"},
+	"lastname":      []string{"required"},
+	"dni":           []string{"required"},
+	"document_type": []string{"in:rut,passport,foreign"},
+	"phone":         []string{"required"},
+	"roles":         []string{"required", "array_int"},
+}
+
+type VerifyEmailOpts struct {
+	Token string `json:"token"`
+}
+
+var VerifyEmailRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+type ResendEmailVerificationOpts struct {
+	Email string `json:"email"`
+}
+
+var ResendEmailVerificationRules = govalidator.MapData{
+	"email": []string{"required", "email"},
+}
+
+type UpdateMeOpts struct {
+	Firstname    string `json:"firstname"`
+	Lastname     string `json:"lastname"`
+	DNI          string `json:"dni"`
+	DocumentType string `json:"document_type"`
+	Phone        string `json:"phone"`
+}
+
+var UpdateMeRules = govalidator.MapData{
+	"firstname":     []string{"required"},
+	"lastname":      []string{"required"},
+	"dni":           []string{"required"},
+	"document_type": []string{"in:rut,passport,foreign"},
+	"phone":         []string{"required"},
+}
+
+type UpdateMePasswordOpts struct {
+	CurrentPassword string `json:"current_password"`
+	Password        string `json:"password"`
+}
+
+var UpdateMePasswordRules = govalidator.MapData{
+	"current_password": []string{"required"},
+	"password":         []string{"required", "password_policy"},
+}
+
+type UpdateMeEmailOpts struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+var UpdateMeEmailRules = govalidator.MapData{
+	"email":    []string{"required", "email"},
+	"password": []string{"required"},
+}
+
+type ConfirmEmailChangeOpts struct {
+	Token string `json:"token"`
+}
+
+var ConfirmEmailChangeRules = govalidator.MapData{
+	"token": []string{"required"},
+}
+
+type EmailChangeHTML struct {
+	Firstname string
+	Lastname  string
+	Email     string
+	URL       string
+}
+
+type UpdateUserStatusOpts struct {
+	Reason string `json:"reason"`
+}
+
+var UpdateUserStatusRules = govalidator.MapData{
+	"reason": []string{"required", "max:255"},
+}
+
+type UserStatusChange struct {
+	ID      int       `json:"id,omitempty"`
+	Actor   *User     `json:"actor,omitempty"`
+	Status  string    `json:"status"`
+	Reason  string    `json:"reason"`
+	Created time.Time `json:"created"`
+}
+
+type EmailVerificationHTML struct {
+	Firstname string
+	Lastname  string
+	URL       string
 }
 
 type InfoUser struct {
@@ -115,6 +228,17 @@ type User struct {
 	Updated time.Time `json:"updated,omitempty"`
 	Active  bool      `json:"active"`
 
+	DeletedAt         *time.Time `json:"deleted_at,omitempty"`
+	SessionsRevokedAt *time.Time `json:"-"`
+
+	EmailVerified bool `json:"email_verified"`
+
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+
+	TwoFactorEnabled       bool     `json:"two_factor_enabled"`
+	TwoFactorSecret        string   `json:"-"`
+	TwoFactorRecoveryCodes []string `json:"two_factor_recovery_codes,omitempty"`
+
 	Token         string          `json:"token,omitempty"`
 	RememberToken string          `json:"remember_token,omitempty"`
 	Roles         []Role          `json:"role,omitempty"`
@@ -135,9 +259,11 @@ type Role struct {
 	Name string `json:"name,omitempty"`
 }
 type UserAdditional struct {
-	ID    int    `json:"id,omitempty"`
-	DNI   string `json:"dni,omitempty"`
-	Phone string `json:"phone,omitempty"`
+	ID           int    `json:"id,omitempty"`
+	DNI          string `json:"dni,omitempty"`
+	DNIFormatted string `json:"dni_formatted,omitempty"`
+	DocumentType string `json:"document_type,omitempty"`
+	Phone        string `json:"phone,omitempty"`
 }
 
 type UsersStruct struct {
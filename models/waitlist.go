@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/thedevsaddam/govalidator"
+)
+
+type JoinWaitlistOpts struct {
+	Tickets int `json:"tickets"`
+}
+
+var JoinWaitlistRules = govalidator.MapData{
+	"tickets": []string{"required", "numeric_between:1,100"},
+}
+
+type GetWaitlistOpts struct {
+	EventID   int    `schema:"event_id"`
+	Status    string `schema:"status"`
+	UserID    int    `schema:"-"`
+	LimitFrom int    `schema:"limit_from"`
+	LimitTo   int    `schema:"limit_to"`
+}
+
+var GetWaitlistRules = govalidator.MapData{
+	"event_id":   []string{"numeric"},
+	"status":     []string{"in:waiting,offered,purchased,expired,cancelled"},
+	"limit_from": []string{"numeric"},
+	"limit_to":   []string{"numeric"},
+}
+
+type WaitlistEntry struct {
+	ID           int        `json:"id,omitempty"`
+	Event        *Event     `json:"event,omitempty"`
+	User         *User      `json:"user,omitempty"`
+	Tickets      int        `json:"tickets"`
+	Status       string     `json:"status"`
+	OfferExpires *time.Time `json:"offer_expires,omitempty"`
+	Order        *Order     `json:"order,omitempty"`
+	Created      time.Time  `json:"created"`
+}
+
+type WaitlistStruct struct {
+	Entries []WaitlistEntry `json:"entries,omitempty"`
+	Total   int             `json:"total"`
+}
+
+type WaitlistOfferHTML struct {
+	Firstname string
+	Lastname  string
+	EventType string
+	Date      string
+	Tickets   int
+	Expires   string
+	URL       string
+}
@@ -0,0 +1,60 @@
+package pubsub
+
+import "sync"
+
+// subscriberBuffer is how many messages a subscriber may fall behind before
+// new messages are dropped for it.
+const subscriberBuffer = 64
+
+// Broker publishes messages to the subscribers of a topic. Messages only reach
+// the subscribers of the same process, so it fits a single node.
+type Broker struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan interface{}]struct{}
+}
+
+func NewBroker() *Broker {
+	return &Broker{
+		subscribers: make(map[string]map[chan interface{}]struct{}),
+	}
+}
+
+// Subscribe returns the channel receiving the messages of the topic and the
+// function that cancels the subscription and closes it.
+func (b *Broker) Subscribe(topic string) (<-chan interface{}, func()) {
+	ch := make(chan interface{}, subscriberBuffer)
+
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[chan interface{}]struct{})
+	}
+	b.subscribers[topic][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[topic], ch)
+			if len(b.subscribers[topic]) == 0 {
+				delete(b.subscribers, topic)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+// Publish sends the message to the subscribers of the topic without
+// blocking. Slow subscribers miss the message.
+func (b *Broker) Publish(topic string, message interface{}) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[topic] {
+		select {
+		case ch <- message:
+		default:
+		}
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"bitbucket.org/parqueoasis/backend/config"
 	"bitbucket.org/parqueoasis/backend/db"
 	"bitbucket.org/parqueoasis/backend/middlewares"
+	"bitbucket.org/parqueoasis/backend/pubsub"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
 	"github.com/joeshaw/envdecode"
@@ -34,10 +35,15 @@ type AppHandlerFunc func(*config.AppContext, *middlewares.ResponseWriter, *http.
 type AppHandler struct {
 	Context     *config.AppContext
 	HandlerFunc AppHandlerFunc
+	IsAudited   bool
 }
 
 func (a *AppHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	a.HandlerFunc(a.Context, &middlewares.ResponseWriter{Writer: w}, r)
+	rw := &middlewares.ResponseWriter{Writer: w}
+	a.HandlerFunc(a.Context, rw, r)
+	if a.IsAudited {
+		middlewares.AuditRequest(a.Context, rw, r)
+	}
 }
 
 type Route struct {
@@ -51,10 +57,11 @@ type Route struct {
 func NewRouter(ctx *config.AppContext, routes []*Route) *mux.Router {
 	router := mux.NewRouter()
 	for _, r := range routes {
-		handler := &AppHandler{Context: ctx, HandlerFunc: r.Handler}
+		handler := &AppHandler{Context: ctx, HandlerFunc: r.Handler, IsAudited: r.IsProtected}
 		if r.IsProtected {
 			go router.Handle(r.Path, negroni.New(
 				negroni.HandlerFunc(middlewares.NewJWTMiddleware([]byte(ctx.Config.JWTSecret)).HandlerNext),
+				middlewares.SessionMiddleware(ctx),
 				negroni.Wrap(handler),
 			)).Methods(r.Methods...)
 		}
@@ -118,6 +125,14 @@ func (wrapper *ContextWrapper) CreateMercadoPagoIntegration() {
 	wrapper.Context.MercadoPago = mp
 }
 
+func (wrapper *ContextWrapper) CreateDTEProvider() {
+	wrapper.Context.DTE = config.CreateDTEProvider(wrapper.Context.Config.DTE)
+}
+
+func (wrapper *ContextWrapper) CreateEventBroker() {
+	wrapper.Context.Events = pubsub.NewBroker()
+}
+
 func (wrapper *ContextWrapper) CreateNewSessionS3() {
 	session, err := config.CreateNewSessionS3(wrapper.Context.Config.AwsS3)
 	if err != nil {
@@ -162,6 +177,6 @@ func createServer(context *config.AppContext, routes []*Route) (*http.Server, er
 		Addr:         fmt.Sprintf(":%d", context.Config.Port),
 		ReadTimeout:  time.Duration(context.Config.Timeout) * time.Second,
 		WriteTimeout: time.Duration(context.Config.Timeout) * time.Second,
-		Handler:      n,
+		Handler:      middlewares.ResponseController(n),
 	}, nil
 }